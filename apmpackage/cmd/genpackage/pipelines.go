@@ -128,8 +128,11 @@ var processPpidPipeline = []map[string]interface{}{{
 	},
 }}
 
+// clientGeoIPPipeline sets client.geo.* from client.ip, unless APM Server
+// has already done so using a local GeoIP database.
 var clientGeoIPPipeline = []map[string]interface{}{{
 	"geoip": map[string]interface{}{
+		"if":             "ctx.client?.geo == null",
 		"field":          "client.ip",
 		"target_field":   "client.geo",
 		"ignore_missing": true,
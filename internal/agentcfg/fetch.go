@@ -28,6 +28,17 @@ import (
 // configuration to the Jaeger remote sampler protocol.
 const TransactionSamplingRateKey = "transaction_sample_rate"
 
+const (
+	// JaegerOperationSamplingRatesKey is the agent configuration key for
+	// per-operation sampling rates, used by the Jaeger handler. The value
+	// is a comma-separated list of "<operation>=<rate>" pairs.
+	JaegerOperationSamplingRatesKey = "jaeger_operation_sample_rates"
+
+	// JaegerMaxTracesPerSecondKey is the agent configuration key for the
+	// maximum number of traces per second sampled by Jaeger clients.
+	JaegerMaxTracesPerSecondKey = "jaeger_max_traces_per_second"
+)
+
 // Fetcher defines a common interface to retrieving agent config.
 type Fetcher interface {
 	Fetch(context.Context, Query) (Result, error)
@@ -69,20 +69,22 @@ Check the Elastic Security API documentation for details.
 
 func createApikeyCmd() *cobra.Command {
 	var keyName, expiration string
+	var services []string
 	var ingest, sourcemap, agentConfig, json bool
 	short := "Create an API Key with the specified privilege(s)"
 	create := &cobra.Command{
 		Use:   "create",
 		Short: short,
 		Long: short + `.
-If no privilege(s) are specified, the API Key will be valid for all.`,
+If no privilege(s) are specified, the API Key will be valid for all.
+If no service(s) are specified, the privilege(s) will be granted for all services.`,
 		Run: makeAPIKeyRun(&json, func(client *es.Client, config *config.Config, args []string) error {
 			privileges := booleansToPrivileges(ingest, sourcemap, agentConfig)
 			if len(privileges) == 0 {
 				// No privileges specified, grant all.
 				privileges = auth.AllPrivilegeActions()
 			}
-			return createAPIKey(client, keyName, expiration, privileges, json)
+			return createAPIKey(client, keyName, expiration, privileges, services, json)
 		}),
 	}
 	create.Flags().StringVar(&keyName, "name", "apm-key", "API Key name")
@@ -96,6 +98,8 @@ If no privilege(s) are specified, the API Key will be valid for all.`,
 	create.Flags().BoolVar(&agentConfig, "agent-config", false,
 		fmt.Sprintf("give the %v privilege to this key, required for agents to read configuration remotely",
 			auth.PrivilegeAgentConfigRead))
+	create.Flags().StringArrayVar(&services, "service", nil,
+		"restrict the privileges of this key to the given service name; may be specified multiple times")
 	create.Flags().BoolVar(&json, "json", false,
 		"prints the output of this command as JSON")
 	// this actually means "preserve sorting given in code" and not reorder them alphabetically
@@ -160,7 +164,7 @@ If neither of them are, an error will be returned.`,
 }
 
 func verifyApikeyCmd() *cobra.Command {
-	var credentials string
+	var credentials, service string
 	var ingest, sourcemap, agentConfig, json bool
 	short := `Check if a "credentials" string has the given privilege(s)`
 	long := short + `.
@@ -174,7 +178,7 @@ If no privilege(s) are specified, the credentials will be queried for all.`
 			if len(privileges) == 0 {
 				privileges = auth.AllPrivilegeActions()
 			}
-			return verifyAPIKey(config, privileges, credentials, json)
+			return verifyAPIKey(config, privileges, credentials, service, json)
 		}),
 	}
 	verify.Flags().StringVar(&credentials, "credentials", "", `credentials for which check privileges (required)`)
@@ -186,6 +190,8 @@ If no privilege(s) are specified, the credentials will be queried for all.`
 	verify.Flags().BoolVar(&agentConfig, "agent-config", false,
 		fmt.Sprintf("ask for the %v privilege, required for agents to read configuration remotely",
 			auth.PrivilegeAgentConfigRead))
+	verify.Flags().StringVar(&service, "service", "",
+		"ask for the privilege(s) for the given service name")
 	verify.Flags().BoolVar(&json, "json", false,
 		"prints the output of this command as JSON")
 	verify.MarkFlagRequired("credentials")
@@ -257,7 +263,19 @@ func booleansToPrivileges(ingest, sourcemap, agentConfig bool) []es.PrivilegeAct
 	return privileges
 }
 
-func createAPIKey(client *es.Client, keyName, expiry string, privileges []es.PrivilegeAction, asJSON bool) error {
+func createAPIKey(client *es.Client, keyName, expiry string, privileges []es.PrivilegeAction, services []string, asJSON bool) error {
+
+	// API Keys restricted to services must also have privileges for the internal
+	// resource, which is checked when authenticating requests.
+	resources := []es.Resource{"*"}
+	checkResources := []es.Resource{auth.ResourceInternal}
+	if len(services) > 0 {
+		resources = []es.Resource{auth.ResourceInternal}
+		for _, service := range services {
+			resources = append(resources, auth.ResourceService(service))
+		}
+		checkResources = resources
+	}
 
 	// Elasticsearch will allow a user without the right apm privileges to create API keys, but the keys won't validate
 	// check first whether the user has the right privileges, and bail out early if not
@@ -267,7 +285,7 @@ func createAPIKey(client *es.Client, keyName, expiry string, privileges []es.Pri
 			{
 				Name:       auth.Application,
 				Privileges: privileges,
-				Resources:  []es.Resource{auth.ResourceInternal},
+				Resources:  checkResources,
 			},
 		},
 	}, "")
@@ -276,9 +294,15 @@ func createAPIKey(client *es.Client, keyName, expiry string, privileges []es.Pri
 	}
 	if !hasPrivileges.HasAll {
 		var missingPrivileges []string
-		for action, hasPrivilege := range hasPrivileges.Application[auth.Application][auth.ResourceInternal] {
-			if !hasPrivilege {
-				missingPrivileges = append(missingPrivileges, string(action))
+		for _, resource := range checkResources {
+			for action, hasPrivilege := range hasPrivileges.Application[auth.Application][resource] {
+				if !hasPrivilege {
+					missing := string(action)
+					if resource != auth.ResourceInternal {
+						missing = fmt.Sprintf("%s (%s)", action, resource)
+					}
+					missingPrivileges = append(missingPrivileges, missing)
+				}
 			}
 		}
 		return fmt.Errorf(`%s is missing the following requested privilege(s): %s.
@@ -306,7 +330,7 @@ PUT /_security/role/my_role {
 					{
 						Name:       auth.Application,
 						Privileges: privileges,
-						Resources:  []es.Resource{"*"},
+						Resources:  resources,
 					},
 				},
 			},
@@ -404,7 +428,7 @@ func invalidateAPIKey(client *es.Client, id string, name string, asJSON bool) er
 	return nil
 }
 
-func verifyAPIKey(config *config.Config, privileges []es.PrivilegeAction, credentials string, asJSON bool) error {
+func verifyAPIKey(config *config.Config, privileges []es.PrivilegeAction, credentials, service string, asJSON bool) error {
 	authenticator, err := auth.NewAuthenticator(config.AgentAuth)
 	if err != nil {
 		return err
@@ -427,7 +451,7 @@ func verifyAPIKey(config *config.Config, privileges []es.PrivilegeAction, creden
 		}
 
 		authorized := true
-		if err := authz.Authorize(context.Background(), action, auth.Resource{}); err != nil {
+		if err := authz.Authorize(context.Background(), action, auth.Resource{ServiceName: service}); err != nil {
 			if errors.Is(err, auth.ErrUnauthorized) {
 				authorized = false
 			} else {
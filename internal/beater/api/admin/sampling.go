@@ -0,0 +1,215 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+// TraceIDVar is the name of the route variable holding the trace ID
+// in tail-sampling admin API paths.
+const TraceIDVar = "id"
+
+// Tail-sampling decisions reported in TraceStatus.
+const (
+	DecisionSampled   = "sampled"
+	DecisionUnsampled = "unsampled"
+	DecisionPending   = "pending"
+)
+
+var (
+	// MonitoringMap holds a mapping for request.IDs to monitoring counters
+	MonitoringMap = request.DefaultMonitoringMapForRegistry(registry)
+	registry      = monitoring.Default.NewRegistry("apm-server.admin")
+)
+
+// TailSampler provides administrative access to tail-based sampling.
+type TailSampler interface {
+	// TraceStatus returns the locally stored tail-sampling status of
+	// the trace with the given ID.
+	TraceStatus(traceID string) (TraceStatus, error)
+
+	// KeepTrace forces the trace with the given ID to be sampled.
+	// The sampling decision is shared with other APM Servers, and
+	// the trace's buffered events are published.
+	KeepTrace(ctx context.Context, traceID string) error
+
+	// GroupStats returns statistics for each tail-sampling trace group.
+	GroupStats() []GroupStats
+}
+
+// TraceStatus holds the tail-sampling status of a trace.
+type TraceStatus struct {
+	// Decision holds the sampling decision for the trace: DecisionSampled,
+	// DecisionUnsampled, or DecisionPending if no decision has been made.
+	Decision string `json:"decision"`
+
+	// BufferedEvents holds the number of the trace's events held
+	// in local storage.
+	BufferedEvents int `json:"buffered_events"`
+}
+
+// GroupStats holds statistics for a tail-sampling trace group.
+type GroupStats struct {
+	// Policy holds the index of the policy for the trace group,
+	// in the order policies are configured.
+	Policy int `json:"policy"`
+
+	// ServiceName holds the service name of a trace group created
+	// dynamically for a policy that does not specify a service name.
+	ServiceName string `json:"service_name,omitempty"`
+
+	// SampleRate holds the sample rate configured for the policy.
+	SampleRate float64 `json:"sample_rate"`
+
+	// ReservoirSize holds the current size of the group's reservoir.
+	ReservoirSize int `json:"reservoir_size"`
+
+	// IngestRate holds the exponentially weighted moving average of the
+	// number of root transactions observed for the group per interval.
+	IngestRate float64 `json:"ingest_rate"`
+}
+
+// TraceHandler returns a request.Handler for reporting the tail-sampling
+// status of the trace identified by the TraceIDVar route variable.
+func TraceHandler(sampler TailSampler) request.Handler {
+	return func(c *request.Context) {
+		if !checkRequest(c, http.MethodGet) {
+			return
+		}
+		traceID, ok := traceIDVar(c)
+		if !ok {
+			return
+		}
+		status, err := sampler.TraceStatus(traceID)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsServiceUnavailable, err)
+			c.WriteResult()
+			return
+		}
+		c.Result.SetDefault(request.IDResponseValidOK)
+		c.Result.Body = map[string]interface{}{
+			"trace_id":        traceID,
+			"decision":        status.Decision,
+			"buffered_events": status.BufferedEvents,
+		}
+		c.WriteResult()
+	}
+}
+
+// KeepTraceHandler returns a request.Handler for forcing the trace
+// identified by the TraceIDVar route variable to be sampled.
+func KeepTraceHandler(sampler TailSampler) request.Handler {
+	return func(c *request.Context) {
+		if !checkRequest(c, http.MethodPost) {
+			return
+		}
+		traceID, ok := traceIDVar(c)
+		if !ok {
+			return
+		}
+		if err := sampler.KeepTrace(c.Request.Context(), traceID); err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsServiceUnavailable, err)
+			c.WriteResult()
+			return
+		}
+		c.Result.SetDefault(request.IDResponseValidAccepted)
+		c.WriteResult()
+	}
+}
+
+// GroupsHandler returns a request.Handler for reporting statistics
+// for each tail-sampling trace group.
+func GroupsHandler(sampler TailSampler) request.Handler {
+	return func(c *request.Context) {
+		if !checkRequest(c, http.MethodGet) {
+			return
+		}
+		groups := sampler.GroupStats()
+		if groups == nil {
+			groups = []GroupStats{}
+		}
+		c.Result.SetDefault(request.IDResponseValidOK)
+		c.Result.Body = map[string]interface{}{"groups": groups}
+		c.WriteResult()
+	}
+}
+
+// checkRequest checks the request method and that the client is authorized
+// to access the admin API, writing an error result and returning false if not.
+func checkRequest(c *request.Context, method string) bool {
+	if c.Request.Method != method {
+		c.Result.SetDefault(request.IDResponseErrorsMethodNotAllowed)
+		c.WriteResult()
+		return false
+	}
+	if err := auth.Authorize(c.Request.Context(), auth.ActionAdmin, auth.Resource{}); err != nil {
+		if errors.Is(err, auth.ErrUnauthorized) {
+			id := request.IDResponseErrorsForbidden
+			status := request.MapResultIDToStatus[id]
+			c.Result.Set(id, status.Code, err.Error(), nil, nil)
+		} else {
+			c.Result.SetDefault(request.IDResponseErrorsServiceUnavailable)
+			c.Result.Err = err
+		}
+		c.WriteResult()
+		return false
+	}
+	return true
+}
+
+// traceIDVar returns the trace ID from the TraceIDVar route variable,
+// writing an error result and returning false if it is not a valid trace
+// ID. Trace IDs are used as local storage keys, so only hex-encoded IDs
+// of up to 16 bytes are accepted.
+func traceIDVar(c *request.Context) (string, bool) {
+	traceID := mux.Vars(c.Request)[TraceIDVar]
+	if !validTraceID(traceID) {
+		c.Result.SetWithError(
+			request.IDResponseErrorsValidate,
+			fmt.Errorf("invalid trace ID %q: expected up to 32 hex characters", traceID),
+		)
+		c.WriteResult()
+		return "", false
+	}
+	return traceID, true
+}
+
+func validTraceID(traceID string) bool {
+	if traceID == "" || len(traceID) > 32 {
+		return false
+	}
+	for _, r := range traceID {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'f', r >= 'A' && r <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,203 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+const testTraceID = "0af7651916cd43dd8448eb211c80319c"
+
+func TestTraceHandler(t *testing.T) {
+	sampler := &fakeTailSampler{
+		statuses: map[string]TraceStatus{
+			testTraceID: {Decision: DecisionPending, BufferedEvents: 3},
+		},
+	}
+	w, c := handle(t, TraceHandler(sampler), http.MethodGet, testTraceID, nil)
+	assert.Equal(t, request.IDResponseValidOK, c.Result.ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, map[string]interface{}{
+		"trace_id":        testTraceID,
+		"decision":        "pending",
+		"buffered_events": 3.0,
+	}, body)
+
+	sampler.err = errors.New("boom")
+	_, c = handle(t, TraceHandler(sampler), http.MethodGet, testTraceID, nil)
+	assert.Equal(t, request.IDResponseErrorsServiceUnavailable, c.Result.ID)
+}
+
+func TestKeepTraceHandler(t *testing.T) {
+	sampler := &fakeTailSampler{}
+	w, c := handle(t, KeepTraceHandler(sampler), http.MethodPost, testTraceID, nil)
+	assert.Equal(t, request.IDResponseValidAccepted, c.Result.ID)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, []string{testTraceID}, sampler.kept)
+
+	sampler.err = errors.New("boom")
+	_, c = handle(t, KeepTraceHandler(sampler), http.MethodPost, testTraceID, nil)
+	assert.Equal(t, request.IDResponseErrorsServiceUnavailable, c.Result.ID)
+}
+
+func TestGroupsHandler(t *testing.T) {
+	sampler := &fakeTailSampler{}
+	w, c := handle(t, GroupsHandler(sampler), http.MethodGet, "", nil)
+	assert.Equal(t, request.IDResponseValidOK, c.Result.ID)
+	assert.JSONEq(t, `{"groups":[]}`, w.Body.String())
+
+	sampler.groups = []GroupStats{
+		{Policy: 0, ServiceName: "opbeans", SampleRate: 0.5, ReservoirSize: 100, IngestRate: 12.5},
+		{Policy: 1, SampleRate: 0.1, ReservoirSize: 1000, IngestRate: 0},
+	}
+	w, _ = handle(t, GroupsHandler(sampler), http.MethodGet, "", nil)
+	assert.JSONEq(t, `{"groups":[
+		{"policy":0,"service_name":"opbeans","sample_rate":0.5,"reservoir_size":100,"ingest_rate":12.5},
+		{"policy":1,"sample_rate":0.1,"reservoir_size":1000,"ingest_rate":0}
+	]}`, w.Body.String())
+}
+
+func TestHandlersMethodNotAllowed(t *testing.T) {
+	sampler := &fakeTailSampler{}
+	for name, tc := range map[string]struct {
+		handler request.Handler
+		method  string
+	}{
+		"trace":  {handler: TraceHandler(sampler), method: http.MethodPost},
+		"keep":   {handler: KeepTraceHandler(sampler), method: http.MethodGet},
+		"groups": {handler: GroupsHandler(sampler), method: http.MethodDelete},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, c := handle(t, tc.handler, tc.method, testTraceID, nil)
+			assert.Equal(t, request.IDResponseErrorsMethodNotAllowed, c.Result.ID)
+		})
+	}
+	assert.Empty(t, sampler.kept)
+}
+
+func TestHandlersInvalidTraceID(t *testing.T) {
+	sampler := &fakeTailSampler{}
+	for _, traceID := range []string{
+		"",
+		"trace_id",
+		"../0af7651916cd43dd",
+		"0af7651916cd43dd8448eb211c80319c00",
+	} {
+		_, c := handle(t, TraceHandler(sampler), http.MethodGet, traceID, nil)
+		assert.Equal(t, request.IDResponseErrorsValidate, c.Result.ID, traceID)
+		_, c = handle(t, KeepTraceHandler(sampler), http.MethodPost, traceID, nil)
+		assert.Equal(t, request.IDResponseErrorsValidate, c.Result.ID, traceID)
+	}
+	assert.Empty(t, sampler.kept)
+}
+
+func TestHandlersAuthorization(t *testing.T) {
+	for name, tc := range map[string]struct {
+		authorizer authorizerFunc
+		expectedID request.ResultID
+	}{
+		"unauthorized": {
+			authorizer: func(context.Context, auth.Action, auth.Resource) error {
+				return fmt.Errorf("%w: nope", auth.ErrUnauthorized)
+			},
+			expectedID: request.IDResponseErrorsForbidden,
+		},
+		"authorizer_error": {
+			authorizer: func(context.Context, auth.Action, auth.Resource) error {
+				return errors.New("boom")
+			},
+			expectedID: request.IDResponseErrorsServiceUnavailable,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sampler := &fakeTailSampler{}
+			_, c := handle(t, KeepTraceHandler(sampler), http.MethodPost, testTraceID, tc.authorizer)
+			assert.Equal(t, tc.expectedID, c.Result.ID)
+			assert.Empty(t, sampler.kept)
+		})
+	}
+}
+
+func handle(
+	t testing.TB,
+	h request.Handler,
+	method, traceID string,
+	authorizer authorizerFunc,
+) (*httptest.ResponseRecorder, *request.Context) {
+	if authorizer == nil {
+		authorizer = func(_ context.Context, action auth.Action, resource auth.Resource) error {
+			assert.Equal(t, auth.ActionAdmin, action)
+			assert.Equal(t, auth.Resource{}, resource)
+			return nil
+		}
+	}
+	r := httptest.NewRequest(method, "/admin/sampling", nil)
+	r = r.WithContext(auth.ContextWithAuthorizer(r.Context(), authorizer))
+	r = mux.SetURLVars(r, map[string]string{TraceIDVar: traceID})
+
+	w := httptest.NewRecorder()
+	c := request.NewContext()
+	c.Reset(w, r)
+	h(c)
+	return w, c
+}
+
+type fakeTailSampler struct {
+	statuses map[string]TraceStatus
+	groups   []GroupStats
+	kept     []string
+	err      error
+}
+
+func (s *fakeTailSampler) TraceStatus(traceID string) (TraceStatus, error) {
+	return s.statuses[traceID], s.err
+}
+
+func (s *fakeTailSampler) KeepTrace(ctx context.Context, traceID string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.kept = append(s.kept, traceID)
+	return nil
+}
+
+func (s *fakeTailSampler) GroupStats() []GroupStats {
+	return s.groups
+}
+
+type authorizerFunc func(context.Context, auth.Action, auth.Resource) error
+
+func (f authorizerFunc) Authorize(ctx context.Context, action auth.Action, resource auth.Resource) error {
+	return f(ctx, action, resource)
+}
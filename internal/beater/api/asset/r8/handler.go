@@ -0,0 +1,80 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/api/asset"
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/r8"
+)
+
+const (
+	// MaxMappingSize is the maximum size of a mapping file upload request body.
+	MaxMappingSize = 200 * 1024 * 1024
+
+	serviceNameField    = "service_name"
+	serviceVersionField = "service_version"
+	mappingFileField    = "mapping_file"
+)
+
+var (
+	// MonitoringMap holds a mapping for request.IDs to monitoring counters
+	MonitoringMap = request.DefaultMonitoringMapForRegistry(registry)
+	registry      = monitoring.Default.NewRegistry("apm-server.r8")
+)
+
+// Handler returns a request.Handler for uploading R8/ProGuard mapping files.
+//
+// Requests must be multipart forms with the fields service_name and
+// service_version, and the mapping file content in the mapping_file
+// file field. Uploaded mapping files are validated and then stored
+// using uploader.
+func Handler(uploader r8.Uploader) request.Handler {
+	return asset.UploadHandler(asset.UploadHandlerConfig{
+		MaxSize:      MaxMappingSize,
+		Action:       auth.ActionMappingUpload,
+		ErrMalformed: r8.ErrMalformedMapping,
+		Parse: func(r *http.Request) (asset.Upload, error) {
+			return parseForm(r, uploader)
+		},
+	})
+}
+
+func parseForm(r *http.Request, uploader r8.Uploader) (asset.Upload, error) {
+	values, err := asset.FormValues(r, serviceNameField, serviceVersionField)
+	if err != nil {
+		return asset.Upload{}, err
+	}
+	content, err := asset.FormFile(r, mappingFileField)
+	if err != nil {
+		return asset.Upload{}, err
+	}
+	name, version := values[0], values[1]
+	return asset.Upload{
+		ServiceName: name,
+		Store: func(ctx context.Context) error {
+			return uploader.Upload(ctx, name, version, content)
+		},
+	}, nil
+}
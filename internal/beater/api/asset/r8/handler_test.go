@@ -0,0 +1,142 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/r8"
+)
+
+func TestHandler(t *testing.T) {
+	validFields := map[string]string{
+		"service_name":    "opbeans-android",
+		"service_version": "1.0.0",
+	}
+
+	for name, tc := range map[string]struct {
+		method      string
+		fields      map[string]string
+		mappingFile []byte
+		uploadErr   error
+
+		expectedID       request.ResultID
+		expectedUploaded bool
+	}{
+		"method_not_allowed": {
+			method:     http.MethodGet,
+			expectedID: request.IDResponseErrorsMethodNotAllowed,
+		},
+		"missing_service_version": {
+			fields:      map[string]string{"service_name": "opbeans-android"},
+			mappingFile: []byte("a -> b:"),
+			expectedID:  request.IDResponseErrorsValidate,
+		},
+		"missing_mapping_file": {
+			fields:     validFields,
+			expectedID: request.IDResponseErrorsValidate,
+		},
+		"malformed": {
+			fields:           validFields,
+			mappingFile:      []byte("a -> b:"),
+			uploadErr:        fmt.Errorf("%w: invalid", r8.ErrMalformedMapping),
+			expectedID:       request.IDResponseErrorsValidate,
+			expectedUploaded: true,
+		},
+		"accepted": {
+			fields:           validFields,
+			mappingFile:      []byte("a -> b:"),
+			expectedID:       request.IDResponseValidAccepted,
+			expectedUploaded: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var uploaded bool
+			uploader := uploaderFunc(func(ctx context.Context, name, version string, content []byte) error {
+				uploaded = true
+				assert.Equal(t, validFields["service_name"], name)
+				assert.Equal(t, validFields["service_version"], version)
+				assert.Equal(t, tc.mappingFile, content)
+				return tc.uploadErr
+			})
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			r := newUploadRequest(t, method, tc.fields, tc.mappingFile)
+			authorizer := authorizerFunc(func(_ context.Context, action auth.Action, resource auth.Resource) error {
+				assert.Equal(t, auth.ActionMappingUpload, action)
+				assert.Equal(t, auth.Resource{ServiceName: validFields["service_name"]}, resource)
+				return nil
+			})
+			r = r.WithContext(auth.ContextWithAuthorizer(r.Context(), authorizer))
+
+			w := httptest.NewRecorder()
+			c := request.NewContext()
+			c.Reset(w, r)
+			Handler(uploader)(c)
+
+			assert.Equal(t, tc.expectedID, c.Result.ID)
+			assert.Equal(t, request.MapResultIDToStatus[tc.expectedID].Code, w.Code)
+			assert.Equal(t, tc.expectedUploaded, uploaded)
+		})
+	}
+}
+
+func newUploadRequest(t testing.TB, method string, fields map[string]string, mappingFile []byte) *http.Request {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		require.NoError(t, mw.WriteField(k, v))
+	}
+	if mappingFile != nil {
+		fw, err := mw.CreateFormFile("mapping_file", "mapping.txt")
+		require.NoError(t, err)
+		_, err = fw.Write(mappingFile)
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+
+	r := httptest.NewRequest(method, "/assets/v1/r8_mappings", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+type uploaderFunc func(ctx context.Context, name, version string, content []byte) error
+
+func (f uploaderFunc) Upload(ctx context.Context, name, version string, content []byte) error {
+	return f(ctx, name, version, content)
+}
+
+type authorizerFunc func(context.Context, auth.Action, auth.Resource) error
+
+func (f authorizerFunc) Authorize(ctx context.Context, action auth.Action, resource auth.Resource) error {
+	return f(ctx, action, resource)
+}
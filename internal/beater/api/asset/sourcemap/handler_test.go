@@ -0,0 +1,176 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sourcemap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/sourcemap"
+)
+
+func TestHandler(t *testing.T) {
+	validFields := map[string]string{
+		"service_name":    "opbeans-rum",
+		"service_version": "1.0.0",
+		"bundle_filepath": "http://localhost/bundle.js",
+	}
+
+	for name, tc := range map[string]struct {
+		method     string
+		fields     map[string]string
+		sourcemap  []byte
+		authorizer authorizerFunc
+		uploadErr  error
+
+		expectedID       request.ResultID
+		expectedUploaded bool
+	}{
+		"method_not_allowed": {
+			method:     http.MethodGet,
+			expectedID: request.IDResponseErrorsMethodNotAllowed,
+		},
+		"missing_service_name": {
+			fields: map[string]string{
+				"service_version": "1.0.0",
+				"bundle_filepath": "http://localhost/bundle.js",
+			},
+			sourcemap:  []byte("{}"),
+			expectedID: request.IDResponseErrorsValidate,
+		},
+		"missing_sourcemap": {
+			fields:     validFields,
+			expectedID: request.IDResponseErrorsValidate,
+		},
+		"unauthorized": {
+			fields:    validFields,
+			sourcemap: []byte("{}"),
+			authorizer: func(context.Context, auth.Action, auth.Resource) error {
+				return fmt.Errorf("%w: nope", auth.ErrUnauthorized)
+			},
+			expectedID: request.IDResponseErrorsForbidden,
+		},
+		"authorizer_error": {
+			fields:    validFields,
+			sourcemap: []byte("{}"),
+			authorizer: func(context.Context, auth.Action, auth.Resource) error {
+				return errors.New("boom")
+			},
+			expectedID: request.IDResponseErrorsServiceUnavailable,
+		},
+		"malformed": {
+			fields:           validFields,
+			sourcemap:        []byte("{}"),
+			uploadErr:        fmt.Errorf("%w: invalid", sourcemap.ErrMalformedSourcemap),
+			expectedID:       request.IDResponseErrorsValidate,
+			expectedUploaded: true,
+		},
+		"upload_error": {
+			fields:           validFields,
+			sourcemap:        []byte("{}"),
+			uploadErr:        errors.New("boom"),
+			expectedID:       request.IDResponseErrorsServiceUnavailable,
+			expectedUploaded: true,
+		},
+		"accepted": {
+			fields:           validFields,
+			sourcemap:        []byte("{}"),
+			expectedID:       request.IDResponseValidAccepted,
+			expectedUploaded: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var uploaded bool
+			uploader := uploaderFunc(func(ctx context.Context, name, version, path string, content []byte) error {
+				uploaded = true
+				assert.Equal(t, validFields["service_name"], name)
+				assert.Equal(t, validFields["service_version"], version)
+				assert.Equal(t, validFields["bundle_filepath"], path)
+				assert.Equal(t, tc.sourcemap, content)
+				return tc.uploadErr
+			})
+
+			authorizer := tc.authorizer
+			if authorizer == nil {
+				authorizer = func(_ context.Context, action auth.Action, resource auth.Resource) error {
+					assert.Equal(t, auth.ActionSourcemapUpload, action)
+					assert.Equal(t, auth.Resource{ServiceName: validFields["service_name"]}, resource)
+					return nil
+				}
+			}
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			r := newUploadRequest(t, method, tc.fields, tc.sourcemap)
+			r = r.WithContext(auth.ContextWithAuthorizer(r.Context(), authorizer))
+
+			w := httptest.NewRecorder()
+			c := request.NewContext()
+			c.Reset(w, r)
+			Handler(uploader)(c)
+
+			assert.Equal(t, tc.expectedID, c.Result.ID)
+			assert.Equal(t, request.MapResultIDToStatus[tc.expectedID].Code, w.Code)
+			assert.Equal(t, tc.expectedUploaded, uploaded)
+		})
+	}
+}
+
+func newUploadRequest(t testing.TB, method string, fields map[string]string, sourcemap []byte) *http.Request {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		require.NoError(t, mw.WriteField(k, v))
+	}
+	if sourcemap != nil {
+		fw, err := mw.CreateFormFile("sourcemap", "bundle.js.map")
+		require.NoError(t, err)
+		_, err = fw.Write(sourcemap)
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+
+	r := httptest.NewRequest(method, "/assets/v1/sourcemaps", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+type uploaderFunc func(ctx context.Context, name, version, path string, content []byte) error
+
+func (f uploaderFunc) Upload(ctx context.Context, name, version, path string, content []byte) error {
+	return f(ctx, name, version, path, content)
+}
+
+type authorizerFunc func(context.Context, auth.Action, auth.Resource) error
+
+func (f authorizerFunc) Authorize(ctx context.Context, action auth.Action, resource auth.Resource) error {
+	return f(ctx, action, resource)
+}
@@ -0,0 +1,154 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package asset provides functionality shared by the handlers for
+// uploading assets, such as source maps and R8 mapping files, used
+// for enriching events.
+package asset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+// maxMemory is the maximum number of bytes of a multipart form that
+// will be held in memory; the remainder is stored in temporary files.
+const maxMemory = 32 * 1024 * 1024
+
+// Upload describes an asset parsed from an upload request.
+type Upload struct {
+	// ServiceName holds the name of the service to which the asset
+	// belongs, used for authorizing the upload.
+	ServiceName string
+
+	// Store stores the asset.
+	Store func(context.Context) error
+}
+
+// ParseFunc parses an asset upload request, whose multipart form
+// has already been parsed.
+type ParseFunc func(*http.Request) (Upload, error)
+
+// UploadHandlerConfig holds configuration for UploadHandler.
+type UploadHandlerConfig struct {
+	// MaxSize holds the maximum size of an upload request body.
+	MaxSize int64
+
+	// Action holds the auth.Action to authorize for the upload.
+	Action auth.Action
+
+	// Parse parses the upload request.
+	Parse ParseFunc
+
+	// ErrMalformed holds the error returned, possibly wrapped, by
+	// Upload.Store when the asset is invalid.
+	ErrMalformed error
+}
+
+// UploadHandler returns a request.Handler for uploading assets.
+//
+// Requests must be POST requests with a multipart form body. The
+// form is parsed using cfg.Parse, and the client must be authorized
+// for cfg.Action on the asset's service before it is stored.
+func UploadHandler(cfg UploadHandlerConfig) request.Handler {
+	return func(c *request.Context) {
+		if c.Request.Method != http.MethodPost {
+			c.Result.SetDefault(request.IDResponseErrorsMethodNotAllowed)
+			c.WriteResult()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.ResponseWriter, c.Request.Body, cfg.MaxSize)
+		upload, err := parseUpload(c.Request, cfg.Parse)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.Result.SetWithError(request.IDResponseErrorsRequestTooLarge, err)
+			} else {
+				c.Result.SetWithError(request.IDResponseErrorsValidate, err)
+			}
+			c.WriteResult()
+			return
+		}
+
+		authResource := auth.Resource{ServiceName: upload.ServiceName}
+		if err := auth.Authorize(c.Request.Context(), cfg.Action, authResource); err != nil {
+			if errors.Is(err, auth.ErrUnauthorized) {
+				id := request.IDResponseErrorsForbidden
+				status := request.MapResultIDToStatus[id]
+				c.Result.Set(id, status.Code, err.Error(), nil, nil)
+			} else {
+				c.Result.SetDefault(request.IDResponseErrorsServiceUnavailable)
+				c.Result.Err = err
+			}
+			c.WriteResult()
+			return
+		}
+
+		if err := upload.Store(c.Request.Context()); err != nil {
+			if errors.Is(err, cfg.ErrMalformed) {
+				c.Result.SetWithError(request.IDResponseErrorsValidate, err)
+			} else {
+				c.Result.SetWithError(request.IDResponseErrorsServiceUnavailable, err)
+			}
+			c.WriteResult()
+			return
+		}
+		c.Result.SetDefault(request.IDResponseValidAccepted)
+		c.WriteResult()
+	}
+}
+
+func parseUpload(r *http.Request, parse ParseFunc) (Upload, error) {
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		return Upload{}, fmt.Errorf("failed to parse multipart form: %w", err)
+	}
+	return parse(r)
+}
+
+// FormValues returns the values of the named form fields, returning an
+// error if any of them is missing or empty.
+func FormValues(r *http.Request, fields ...string) ([]string, error) {
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = r.FormValue(field)
+		if values[i] == "" {
+			return nil, fmt.Errorf("%s must be specified", field)
+		}
+	}
+	return values, nil
+}
+
+// FormFile returns the content of the named form file field.
+func FormFile(r *http.Request, field string) ([]byte, error) {
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s file: %w", field, err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s file: %w", field, err)
+	}
+	return content, nil
+}
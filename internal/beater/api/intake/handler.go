@@ -152,6 +152,7 @@ func writeStreamResult(c *request.Context, streamResult elasticapm.Result, strea
 	id := request.IDResponseValidAccepted
 	jsonResult := jsonResult{Accepted: streamResult.Accepted}
 	var errorMessages []string
+	var retryAfterSeconds int
 
 	if n := len(streamResult.Errors); n > 0 {
 		if streamErr != nil {
@@ -170,6 +171,10 @@ func writeStreamResult(c *request.Context, streamResult elasticapm.Result, strea
 			statusCode = errStatusCode
 			id = errID
 		}
+		var quotaErr *ratelimit.QuotaExceededError
+		if errors.As(err, &quotaErr) && quotaErr.RetryAfterSeconds() > retryAfterSeconds {
+			retryAfterSeconds = quotaErr.RetryAfterSeconds()
+		}
 	}
 	for _, err := range streamResult.Errors {
 		processError(err)
@@ -182,6 +187,9 @@ func writeStreamResult(c *request.Context, streamResult elasticapm.Result, strea
 	if len(errorMessages) > 0 {
 		err = errors.New(strings.Join(errorMessages, ", "))
 	}
+	if retryAfterSeconds > 0 {
+		c.ResponseWriter.Header().Set(headers.RetryAfter, strconv.Itoa(retryAfterSeconds))
+	}
 	writeResult(c, id, statusCode, &jsonResult, err)
 }
 
@@ -29,6 +29,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -37,6 +38,7 @@ import (
 	"github.com/elastic/apm-data/model"
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/publish"
@@ -168,6 +170,31 @@ func TestIntakeHandler(t *testing.T) {
 	}
 }
 
+func TestIntakeHandlerQuotaExceeded(t *testing.T) {
+	tc := testcaseIntakeHandler{
+		path: "errors.ndjson",
+		batchProcessor: model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+			return &ratelimit.QuotaExceededError{
+				Kind:       ratelimit.QuotaKindService,
+				Value:      "opbeans-go",
+				RetryAfter: 1500 * time.Millisecond,
+			}
+		}),
+	}
+	tc.setup(t)
+
+	h := Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor)
+	h(tc.c)
+
+	assert.Equal(t, request.IDResponseErrorsRateLimit, tc.c.Result.ID)
+	assert.Equal(t, http.StatusTooManyRequests, tc.w.Code)
+	assert.Equal(t, "2", tc.w.Header().Get(headers.RetryAfter))
+	assert.JSONEq(t,
+		`{"accepted":0,"errors":[{"message":"rate limit exceeded: service quota exceeded for \"opbeans-go\""}]}`,
+		tc.w.Body.String(),
+	)
+}
+
 func TestIntakeHandlerMonitoring(t *testing.T) {
 	eventsAccepted.Set(1)
 	eventsInvalid.Set(2)
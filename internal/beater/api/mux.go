@@ -35,17 +35,24 @@ import (
 	"github.com/elastic/apm-data/model"
 	"github.com/elastic/apm-data/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/beater/api/admin"
+	assetr8 "github.com/elastic/apm-server/internal/beater/api/asset/r8"
+	assetsourcemap "github.com/elastic/apm-server/internal/beater/api/asset/sourcemap"
 	"github.com/elastic/apm-server/internal/beater/api/config/agent"
 	"github.com/elastic/apm-server/internal/beater/api/intake"
+	"github.com/elastic/apm-server/internal/beater/api/prometheus"
 	"github.com/elastic/apm-server/internal/beater/api/root"
+	"github.com/elastic/apm-server/internal/beater/api/zipkin"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/jaeger"
 	"github.com/elastic/apm-server/internal/beater/middleware"
 	"github.com/elastic/apm-server/internal/beater/otlp"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/logs"
 	srvmodelprocessor "github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/apm-server/internal/r8"
 	"github.com/elastic/apm-server/internal/sourcemap"
 	"github.com/elastic/apm-server/internal/version"
 )
@@ -60,6 +67,10 @@ const (
 	AgentConfigPath = "/config/v1/agents"
 	// IntakePath defines the path to ingest monitored events
 	IntakePath = "/intake/v2/events"
+	// SourcemapUploadPath defines the path to upload source maps
+	SourcemapUploadPath = "/assets/v1/sourcemaps"
+	// R8MappingUploadPath defines the path to upload R8/ProGuard mapping files
+	R8MappingUploadPath = "/assets/v1/r8_mappings"
 
 	// RUM routes
 
@@ -76,6 +87,26 @@ const (
 	OTLPMetricsIntakePath = "/v1/metrics"
 	// OTLPLogsIntakePath defines the path to ingest OpenTelemetry logs (HTTP Collector)
 	OTLPLogsIntakePath = "/v1/logs"
+
+	// ZipkinSpansIntakePath defines the path to ingest Zipkin v2 spans
+	ZipkinSpansIntakePath = "/api/v2/spans"
+
+	// JaegerTracesIntakePath defines the path to ingest Jaeger Thrift batches (HTTP Collector)
+	JaegerTracesIntakePath = "/api/traces"
+	// JaegerSamplingPath defines the path to query Jaeger sampling strategies (HTTP Agent)
+	JaegerSamplingPath = "/sampling"
+
+	// PrometheusRemoteWritePath defines the path to ingest Prometheus remote write requests
+	PrometheusRemoteWritePath = "/api/v1/write"
+
+	// Admin routes
+
+	// AdminSamplingTracePath defines the path to query the tail-sampling status of a trace
+	AdminSamplingTracePath = "/admin/sampling/traces/{" + admin.TraceIDVar + "}"
+	// AdminSamplingKeepTracePath defines the path to force a trace to be tail-sampled
+	AdminSamplingKeepTracePath = AdminSamplingTracePath + "/keep"
+	// AdminSamplingGroupsPath defines the path to query tail-sampling trace group statistics
+	AdminSamplingGroupsPath = "/admin/sampling/groups"
 )
 
 // NewMux creates a new gorilla/mux router, with routes registered for handling the
@@ -87,9 +118,12 @@ func NewMux(
 	fetcher agentcfg.Fetcher,
 	ratelimitStore *ratelimit.Store,
 	sourcemapFetcher sourcemap.Fetcher,
+	sourcemapUploader sourcemap.Uploader,
+	r8Uploader r8.Uploader,
+	tailSampler admin.TailSampler,
 	publishReady func() bool,
 ) (*mux.Router, error) {
-	pool := request.NewContextPool()
+	pool := request.NewContextPool(beaterConfig.TrustedProxyPrefixes)
 	logger := logp.NewLogger(logs.Handler)
 	router := mux.NewRouter()
 	router.NotFoundHandler = pool.HTTPHandler(notFoundHandler)
@@ -124,9 +158,18 @@ func NewMux(
 		{IntakeRUMPath, rumIntakeHandler},
 		{IntakeRUMV3Path, rumIntakeHandler},
 		{IntakePath, builder.backendIntakeHandler},
+		{SourcemapUploadPath, builder.sourcemapUploadHandler(sourcemapUploader)},
+		{R8MappingUploadPath, builder.r8MappingUploadHandler(r8Uploader)},
 		{OTLPTracesIntakePath, builder.otlpHandler(otlpHandlers.HandleTraces, otlp.HTTPTracesMonitoringMap)},
 		{OTLPMetricsIntakePath, builder.otlpHandler(otlpHandlers.HandleMetrics, otlp.HTTPMetricsMonitoringMap)},
 		{OTLPLogsIntakePath, builder.otlpHandler(otlpHandlers.HandleLogs, otlp.HTTPLogsMonitoringMap)},
+		{ZipkinSpansIntakePath, builder.zipkinIntakeHandler(zapLogger)},
+		{JaegerTracesIntakePath, builder.jaegerIntakeHandler(zapLogger)},
+		{JaegerSamplingPath, builder.jaegerSamplingHandler(fetcher)},
+		{PrometheusRemoteWritePath, builder.prometheusRemoteWriteHandler},
+		{AdminSamplingTracePath, builder.adminSamplingHandler(admin.TraceHandler, tailSampler)},
+		{AdminSamplingKeepTracePath, builder.adminSamplingHandler(admin.KeepTraceHandler, tailSampler)},
+		{AdminSamplingGroupsPath, builder.adminSamplingHandler(admin.GroupsHandler, tailSampler)},
 	}
 
 	for _, route := range routeMap {
@@ -174,6 +217,41 @@ func (r *routeBuilder) backendIntakeHandler() (request.Handler, error) {
 	return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, intake.MonitoringMap)...)
 }
 
+func (r *routeBuilder) sourcemapUploadHandler(uploader sourcemap.Uploader) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		msg := "Sourcemap upload endpoint is disabled. " +
+			"Configure the `apm-server.rum` section in apm-server.yml to enable sourcemap uploads. " +
+			"If you are not using the RUM agent, you can safely ignore this error."
+		h := assetsourcemap.Handler(uploader)
+		mw := killSwitchBackendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, assetsourcemap.MonitoringMap, uploader != nil, msg)
+		return middleware.Wrap(h, mw...)
+	}
+}
+
+func (r *routeBuilder) r8MappingUploadHandler(uploader r8.Uploader) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		msg := "Mapping file upload endpoint is disabled. " +
+			"Configure the `apm-server.deobfuscation` section in apm-server.yml to enable mapping file uploads. " +
+			"If you are not using the Android agent, you can safely ignore this error."
+		h := assetr8.Handler(uploader)
+		mw := killSwitchBackendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, assetr8.MonitoringMap, uploader != nil, msg)
+		return middleware.Wrap(h, mw...)
+	}
+}
+
+func (r *routeBuilder) adminSamplingHandler(
+	handler func(admin.TailSampler) request.Handler,
+	sampler admin.TailSampler,
+) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		msg := "Tail-sampling admin endpoint is disabled. " +
+			"Configure the `apm-server.sampling.tail` section in apm-server.yml to enable tail-based sampling."
+		h := handler(sampler)
+		mw := killSwitchBackendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, admin.MonitoringMap, sampler != nil, msg)
+		return middleware.Wrap(h, mw...)
+	}
+}
+
 func (r *routeBuilder) otlpHandler(handler http.HandlerFunc, monitoringMap map[request.ResultID]*monitoring.Int) func() (request.Handler, error) {
 	return func() (request.Handler, error) {
 		h := func(c *request.Context) {
@@ -183,6 +261,38 @@ func (r *routeBuilder) otlpHandler(handler http.HandlerFunc, monitoringMap map[r
 	}
 }
 
+func (r *routeBuilder) zipkinIntakeHandler(logger *zap.Logger) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := zipkin.Handler(logger, r.batchProcessor)
+		return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, zipkin.MonitoringMap)...)
+	}
+}
+
+func (r *routeBuilder) jaegerIntakeHandler(logger *zap.Logger) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := jaeger.NewHTTPCollectorHandler(logger, r.batchProcessor)
+		return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, jaeger.HTTPCollectorMonitoringMap)...)
+	}
+}
+
+func (r *routeBuilder) prometheusRemoteWriteHandler() (request.Handler, error) {
+	h := prometheus.Handler(r.batchProcessor, r.cfg.MaxRemoteWriteSize)
+	return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, prometheus.MonitoringMap)...)
+}
+
+func (r *routeBuilder) jaegerSamplingHandler(f agentcfg.Fetcher) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := jaeger.NewHTTPSamplingHandler(f)
+		return middleware.Wrap(h,
+			append(apmMiddleware(jaeger.HTTPSamplingMonitoringMap),
+				middleware.ResponseHeadersMiddleware(r.cfg.ResponseHeaders),
+				middleware.AuthMiddleware(jaeger.SamplingAuthenticator(r.authenticator), true),
+				middleware.AnonymousRateLimitMiddleware(r.ratelimitStore),
+			)...,
+		)
+	}
+}
+
 func (r *routeBuilder) rumIntakeHandler() func() (request.Handler, error) {
 	return func() (request.Handler, error) {
 		var batchProcessors modelprocessor.Chained
@@ -286,6 +396,19 @@ func rumMiddleware(cfg *config.Config, authenticator *auth.Authenticator, rateli
 	return append(rumMiddleware, middleware.KillSwitchMiddleware(cfg.RumConfig.Enabled, msg))
 }
 
+func killSwitchBackendMiddleware(
+	cfg *config.Config,
+	authenticator *auth.Authenticator,
+	ratelimitStore *ratelimit.Store,
+	m map[request.ResultID]*monitoring.Int,
+	enabled bool,
+	disabledMsg string,
+) []middleware.Middleware {
+	return append(backendMiddleware(cfg, authenticator, ratelimitStore, m),
+		middleware.KillSwitchMiddleware(enabled, disabledMsg),
+	)
+}
+
 func rootMiddleware(cfg *config.Config, authenticator *auth.Authenticator) []middleware.Middleware {
 	return append(apmMiddleware(root.MonitoringMap),
 		middleware.ResponseHeadersMiddleware(cfg.ResponseHeaders),
@@ -0,0 +1,140 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/api/admin"
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/monitoringtest"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+const adminSamplingTestTracePath = "/admin/sampling/traces/abc123"
+
+func TestAdminSamplingHandler_AuthorizationMiddleware(t *testing.T) {
+	t.Run("Unauthorized", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.AgentAuth.SecretToken = "1234"
+		rec := requestToAdminSamplingMuxer(t, cfg, http.MethodGet, adminSamplingTestTracePath, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.AgentAuth.SecretToken = "1234"
+		cfg.AgentAuth.Anonymous.Enabled = true
+		rec := requestToAdminSamplingMuxer(t, cfg, http.MethodGet, adminSamplingTestTracePath, nil)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("SecretToken", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.AgentAuth.SecretToken = "1234"
+		h := map[string]string{headers.Authorization: "Bearer 1234"}
+		rec := requestToAdminSamplingMuxer(t, cfg, http.MethodGet, adminSamplingTestTracePath, h)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("NoAuth", func(t *testing.T) {
+		rec := requestToAdminSamplingMuxer(t, config.DefaultConfig(), http.MethodGet, adminSamplingTestTracePath, nil)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Authorized", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.AgentAuth.SecretToken = "1234"
+		cfg.AgentAuth.AdminToken = "5678"
+		h := map[string]string{headers.Authorization: "Bearer 5678"}
+		rec := requestToAdminSamplingMuxer(t, cfg, http.MethodGet, adminSamplingTestTracePath, h)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"trace_id":"abc123","decision":"sampled","buffered_events":1}`, rec.Body.String())
+	})
+}
+
+func TestAdminSamplingHandler_Routes(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, adminSamplingTestTracePath, http.StatusOK},
+		{http.MethodPost, adminSamplingTestTracePath + "/keep", http.StatusAccepted},
+		{http.MethodGet, "/admin/sampling/groups", http.StatusOK},
+	} {
+		cfg := config.DefaultConfig()
+		cfg.AgentAuth.AdminToken = "5678"
+		h := map[string]string{headers.Authorization: "Bearer 5678"}
+		rec := requestToAdminSamplingMuxer(t, cfg, tc.method, tc.path, h)
+		assert.Equal(t, tc.code, rec.Code, tc.path)
+	}
+}
+
+func TestAdminSamplingHandler_KillSwitchMiddleware(t *testing.T) {
+	rec, err := requestToMuxerWithHeader(config.DefaultConfig(), adminSamplingTestTracePath, http.MethodGet, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Tail-sampling admin endpoint is disabled")
+}
+
+func TestAdminSamplingHandler_PanicMiddleware(t *testing.T) {
+	testPanicMiddleware(t, AdminSamplingGroupsPath)
+}
+
+func TestAdminSamplingHandler_MonitoringMiddleware(t *testing.T) {
+	monitoringtest.ClearRegistry(admin.MonitoringMap)
+	requestToAdminSamplingMuxer(t, config.DefaultConfig(), http.MethodPost, AdminSamplingGroupsPath, nil)
+	equal, result := monitoringtest.CompareMonitoringInt(map[request.ResultID]int{
+		request.IDRequestCount:                   1,
+		request.IDResponseCount:                  1,
+		request.IDResponseErrorsCount:            1,
+		request.IDResponseErrorsMethodNotAllowed: 1,
+	}, admin.MonitoringMap)
+	assert.True(t, equal, result)
+}
+
+func requestToAdminSamplingMuxer(t *testing.T, cfg *config.Config, method, path string, header map[string]string) *httptest.ResponseRecorder {
+	mux, err := muxBuilder{TailSampler: stubTailSampler{}}.build(cfg)
+	require.NoError(t, err)
+	r := requestWithHeader(httptest.NewRequest(method, path, nil), header)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+type stubTailSampler struct{}
+
+func (stubTailSampler) TraceStatus(string) (admin.TraceStatus, error) {
+	return admin.TraceStatus{Decision: admin.DecisionSampled, BufferedEvents: 1}, nil
+}
+
+func (stubTailSampler) KeepTrace(context.Context, string) error {
+	return nil
+}
+
+func (stubTailSampler) GroupStats() []admin.GroupStats {
+	return nil
+}
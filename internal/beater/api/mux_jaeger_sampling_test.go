@@ -0,0 +1,67 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/jaeger"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+func TestJaegerSamplingHandler_AuthorizationMiddleware(t *testing.T) {
+	t.Run("Unauthenticated", func(t *testing.T) {
+		// Sampling strategy queries do not require authentication.
+		cfg := config.DefaultConfig()
+		cfg.AgentAuth.SecretToken = "1234"
+		rec, err := requestToMuxerWithHeaderAndQueryString(
+			cfg, JaegerSamplingPath, http.MethodGet, nil,
+			map[string]string{"service": "foo"},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.JSONEq(t, `{"error":"no sampling rate available, check server logs for more details"}`, rec.Body.String())
+	})
+
+	t.Run("MissingService", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.AgentAuth.SecretToken = "1234"
+		rec, err := requestToMuxerWithHeader(cfg, JaegerSamplingPath, http.MethodGet, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestJaegerSamplingHandler_PanicMiddleware(t *testing.T) {
+	testPanicMiddleware(t, JaegerSamplingPath)
+}
+
+func TestJaegerSamplingHandler_MonitoringMiddleware(t *testing.T) {
+	// send GET request without service resulting in 400 InvalidQuery error
+	testMonitoringMiddleware(t, JaegerSamplingPath, jaeger.HTTPSamplingMonitoringMap, map[request.ResultID]int{
+		request.IDRequestCount:               1,
+		request.IDResponseCount:              1,
+		request.IDResponseErrorsCount:        1,
+		request.IDResponseErrorsInvalidQuery: 1,
+	})
+}
@@ -0,0 +1,99 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	assetsourcemap "github.com/elastic/apm-server/internal/beater/api/asset/sourcemap"
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/monitoringtest"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+func TestSourcemapUploadHandler_AuthorizationMiddleware(t *testing.T) {
+	t.Run("Unauthorized", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.AgentAuth.SecretToken = "1234"
+		rec, err := requestToMuxerWithPattern(cfg, SourcemapUploadPath)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Authorized", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.AgentAuth.SecretToken = "1234"
+		h := map[string]string{headers.Authorization: "Bearer 1234"}
+		rec := requestToSourcemapUploadMuxer(t, cfg, http.MethodGet, h)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
+
+func TestSourcemapUploadHandler_KillSwitchMiddleware(t *testing.T) {
+	t.Run("Off", func(t *testing.T) {
+		rec, err := requestToMuxerWithPattern(config.DefaultConfig(), SourcemapUploadPath)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Sourcemap upload endpoint is disabled")
+	})
+
+	t.Run("On", func(t *testing.T) {
+		rec := requestToSourcemapUploadMuxer(t, config.DefaultConfig(), http.MethodPost, nil)
+		// The request is not a valid multipart form.
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestSourcemapUploadHandler_PanicMiddleware(t *testing.T) {
+	testPanicMiddleware(t, SourcemapUploadPath)
+}
+
+func TestSourcemapUploadHandler_MonitoringMiddleware(t *testing.T) {
+	monitoringtest.ClearRegistry(assetsourcemap.MonitoringMap)
+	requestToSourcemapUploadMuxer(t, config.DefaultConfig(), http.MethodGet, nil)
+	equal, result := monitoringtest.CompareMonitoringInt(map[request.ResultID]int{
+		request.IDRequestCount:                   1,
+		request.IDResponseCount:                  1,
+		request.IDResponseErrorsCount:            1,
+		request.IDResponseErrorsMethodNotAllowed: 1,
+	}, assetsourcemap.MonitoringMap)
+	assert.True(t, equal, result)
+}
+
+func requestToSourcemapUploadMuxer(t *testing.T, cfg *config.Config, method string, header map[string]string) *httptest.ResponseRecorder {
+	var uploader nopUploader
+	mux, err := muxBuilder{SourcemapUploader: uploader}.build(cfg)
+	require.NoError(t, err)
+	r := requestWithHeader(httptest.NewRequest(method, SourcemapUploadPath, nil), header)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+type nopUploader struct{}
+
+func (nopUploader) Upload(context.Context, string, string, string, []byte) error {
+	return nil
+}
@@ -32,11 +32,13 @@ import (
 
 	"github.com/elastic/apm-data/model"
 	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/beater/api/admin"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/monitoringtest"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/r8"
 	"github.com/elastic/apm-server/internal/sourcemap"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 )
@@ -150,8 +152,11 @@ func newTestMux(t *testing.T, cfg *config.Config) http.Handler {
 }
 
 type muxBuilder struct {
-	SourcemapFetcher sourcemap.Fetcher
-	Managed          bool
+	SourcemapFetcher  sourcemap.Fetcher
+	SourcemapUploader sourcemap.Uploader
+	R8Uploader        r8.Uploader
+	TailSampler       admin.TailSampler
+	Managed           bool
 }
 
 func (m muxBuilder) build(cfg *config.Config) (http.Handler, error) {
@@ -165,6 +170,9 @@ func (m muxBuilder) build(cfg *config.Config) (http.Handler, error) {
 		agentcfg.NewDirectFetcher(nil),
 		ratelimitStore,
 		m.SourcemapFetcher,
+		m.SourcemapUploader,
+		m.R8Uploader,
+		m.TailSampler,
 		func() bool { return true },
 	)
 }
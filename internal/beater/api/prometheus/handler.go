@@ -0,0 +1,151 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/golang/snappy"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+const (
+	contentTypeProtobuf   = "application/x-protobuf"
+	contentEncodingSnappy = "snappy"
+)
+
+var (
+	// MonitoringMap holds a mapping for request.IDs to monitoring counters.
+	MonitoringMap = request.MonitoringMapForRegistry(registry, append(request.DefaultResultIDs,
+		request.IDEventReceivedCount,
+		request.IDResponseValidAccepted,
+		request.IDResponseErrorsDecode,
+		request.IDResponseErrorsRequestTooLarge,
+		request.IDResponseErrorsValidate,
+		request.IDResponseErrorsUnsupportedMediaType,
+		request.IDResponseErrorsMethodNotAllowed,
+		request.IDResponseErrorsRateLimit,
+		request.IDResponseErrorsTimeout,
+		request.IDResponseErrorsForbidden,
+		request.IDResponseErrorsUnauthorized,
+		request.IDResponseErrorsFullQueue,
+		request.IDResponseErrorsShuttingDown,
+		request.IDResponseErrorsInternal,
+	))
+	registry = monitoring.Default.NewRegistry("apm-server.prometheus.remote_write")
+)
+
+// Handler returns a request.Handler for receiving Prometheus remote write
+// requests.
+//
+// Requests must be POSTs with a snappy-compressed, protobuf-encoded
+// WriteRequest body, as sent by Prometheus and compatible agents. Samples
+// are translated into metricset events, and then processed by processor.
+//
+// Requests whose decompressed body would exceed maxSize bytes are rejected
+// before decompression, based on the length recorded in the snappy header.
+func Handler(processor model.BatchProcessor, maxSize int) request.Handler {
+	maxCompressedSize := snappy.MaxEncodedLen(maxSize)
+	return func(c *request.Context) {
+		if c.Request.Method != http.MethodPost {
+			c.Result.SetDefault(request.IDResponseErrorsMethodNotAllowed)
+			c.WriteResult()
+			return
+		}
+
+		contentType := c.Request.Header.Get(headers.ContentType)
+		if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != contentTypeProtobuf {
+			c.Result.SetWithError(request.IDResponseErrorsUnsupportedMediaType, fmt.Errorf(
+				"invalid content type %q, expected %q", contentType, contentTypeProtobuf,
+			))
+			c.WriteResult()
+			return
+		}
+		if contentEncoding := c.Request.Header.Get(headers.ContentEncoding); contentEncoding != contentEncodingSnappy {
+			c.Result.SetWithError(request.IDResponseErrorsUnsupportedMediaType, fmt.Errorf(
+				"invalid content encoding %q, expected %q", contentEncoding, contentEncodingSnappy,
+			))
+			c.WriteResult()
+			return
+		}
+
+		compressed, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(maxCompressedSize)+1))
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsDecode, fmt.Errorf("failed to read request body: %w", err))
+			c.WriteResult()
+			return
+		}
+		if len(compressed) > maxCompressedSize {
+			c.Result.SetWithError(request.IDResponseErrorsRequestTooLarge, fmt.Errorf(
+				"request body exceeds maximum compressed size %d", maxCompressedSize,
+			))
+			c.WriteResult()
+			return
+		}
+		decodedLen, err := snappy.DecodedLen(compressed)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsDecode, fmt.Errorf("failed to decompress request body: %w", err))
+			c.WriteResult()
+			return
+		}
+		if decodedLen > maxSize {
+			c.Result.SetWithError(request.IDResponseErrorsRequestTooLarge, fmt.Errorf(
+				"decompressed request body size %d exceeds maximum %d", decodedLen, maxSize,
+			))
+			c.WriteResult()
+			return
+		}
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsDecode, fmt.Errorf("failed to decompress request body: %w", err))
+			c.WriteResult()
+			return
+		}
+		req, err := decodeWriteRequest(body)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsDecode, fmt.Errorf("failed to decode write request: %w", err))
+			c.WriteResult()
+			return
+		}
+		batch, err := toBatch(req)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsValidate, fmt.Errorf("invalid write request: %w", err))
+			c.WriteResult()
+			return
+		}
+
+		MonitoringMap[request.IDEventReceivedCount].Add(int64(len(batch)))
+		if len(batch) > 0 {
+			if err := processor.ProcessBatch(c.Request.Context(), &batch); err != nil {
+				c.SetConsumeError(err)
+				c.WriteResult()
+				return
+			}
+		}
+		c.Result.SetDefault(request.IDResponseValidAccepted)
+		c.WriteResult()
+	}
+}
@@ -0,0 +1,201 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+const testMaxSize = 1024 * 1024
+
+func TestHandler(t *testing.T) {
+	body := snappy.Encode(nil, encodeTestWriteRequest(t))
+
+	for name, tc := range map[string]struct {
+		method          string
+		contentType     string
+		contentEncoding string
+		body            []byte
+		processErr      error
+		expectedID      request.ResultID
+		expectBatch     bool
+	}{
+		"valid": {
+			body:        body,
+			expectedID:  request.IDResponseValidAccepted,
+			expectBatch: true,
+		},
+		"empty": {
+			body:       snappy.Encode(nil, nil),
+			expectedID: request.IDResponseValidAccepted,
+		},
+		"method_not_allowed": {
+			method:     http.MethodGet,
+			expectedID: request.IDResponseErrorsMethodNotAllowed,
+		},
+		"invalid_content_type": {
+			contentType: "application/json",
+			body:        body,
+			expectedID:  request.IDResponseErrorsUnsupportedMediaType,
+		},
+		"invalid_content_encoding": {
+			contentEncoding: "identity",
+			body:            body,
+			expectedID:      request.IDResponseErrorsUnsupportedMediaType,
+		},
+		"invalid_snappy": {
+			body:       []byte{0xff, 0xff, 0xff},
+			expectedID: request.IDResponseErrorsDecode,
+		},
+		"invalid_protobuf": {
+			body:       snappy.Encode(nil, []byte{0xff}),
+			expectedID: request.IDResponseErrorsDecode,
+		},
+		"missing_metric_name": {
+			body: snappy.Encode(nil, appendBytes(nil, 1, encodeSample(
+				encodeLabels(nil, "job", "frontend"), 1, 1000,
+			))),
+			expectedID: request.IDResponseErrorsValidate,
+		},
+		"rate_limited": {
+			body:        body,
+			processErr:  ratelimit.ErrRateLimitExceeded,
+			expectedID:  request.IDResponseErrorsRateLimit,
+			expectBatch: true,
+		},
+		"forbidden": {
+			body:        body,
+			processErr:  auth.ErrUnauthorized,
+			expectedID:  request.IDResponseErrorsForbidden,
+			expectBatch: true,
+		},
+		"internal": {
+			body:        body,
+			processErr:  errors.New("boom"),
+			expectedID:  request.IDResponseErrorsInternal,
+			expectBatch: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var batches []model.Batch
+			processor := model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+				batches = append(batches, *batch)
+				return tc.processErr
+			})
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			contentType := tc.contentType
+			if contentType == "" {
+				contentType = "application/x-protobuf"
+			}
+			contentEncoding := tc.contentEncoding
+			if contentEncoding == "" {
+				contentEncoding = "snappy"
+			}
+			r := httptest.NewRequest(method, "/api/v1/write", bytes.NewReader(tc.body))
+			r.Header.Set("Content-Type", contentType)
+			r.Header.Set("Content-Encoding", contentEncoding)
+			w := httptest.NewRecorder()
+			c := request.NewContext()
+			c.Reset(w, r)
+			Handler(processor, testMaxSize)(c)
+
+			assert.Equal(t, tc.expectedID, c.Result.ID)
+			assert.Equal(t, request.MapResultIDToStatus[tc.expectedID].Code, w.Code)
+			if !tc.expectBatch {
+				assert.Empty(t, batches)
+				return
+			}
+			require.Len(t, batches, 1)
+			require.Len(t, batches[0], 3)
+			event := batches[0][0]
+			assert.Equal(t, "frontend", event.Service.Name)
+			assert.Equal(t, "localhost:9090", event.Service.Node.Name)
+			require.NotNil(t, event.Metricset)
+			assert.Equal(t, "http_requests_total", event.Metricset.Samples[0].Name)
+		})
+	}
+}
+
+func TestHandlerQuotaExceeded(t *testing.T) {
+	processor := model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		return &ratelimit.QuotaExceededError{
+			Kind:       ratelimit.QuotaKindService,
+			Value:      "frontend",
+			RetryAfter: 1500 * time.Millisecond,
+		}
+	})
+	body := snappy.Encode(nil, encodeTestWriteRequest(t))
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	r.Header.Set("Content-Encoding", "snappy")
+	w := httptest.NewRecorder()
+	c := request.NewContext()
+	c.Reset(w, r)
+	Handler(processor, testMaxSize)(c)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
+
+func TestHandlerRequestTooLarge(t *testing.T) {
+	const maxSize = 100
+	processor := model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		t.Fatal("unexpected call to ProcessBatch")
+		return nil
+	})
+	for name, body := range map[string][]byte{
+		// The snappy header records a decompressed length of 1GB,
+		// but the body holds no data: it must not be decompressed.
+		"oversized_length_header": binary.AppendUvarint(nil, 1<<30),
+		"decompressed_too_large":  snappy.Encode(nil, make([]byte, maxSize+1)),
+		"compressed_too_large":    make([]byte, snappy.MaxEncodedLen(maxSize)+1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/x-protobuf")
+			r.Header.Set("Content-Encoding", "snappy")
+			w := httptest.NewRecorder()
+			c := request.NewContext()
+			c.Reset(w, r)
+			Handler(processor, maxSize)(c)
+
+			assert.Equal(t, request.IDResponseErrorsRequestTooLarge, c.Result.ID)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		})
+	}
+}
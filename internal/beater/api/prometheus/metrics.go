@@ -0,0 +1,271 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/apm-data/model"
+)
+
+const (
+	// agentName is the agent name set for events received through
+	// Prometheus remote write.
+	agentName = "prometheus"
+
+	metricNameLabel = "__name__"
+	jobLabel        = "job"
+	instanceLabel   = "instance"
+
+	// minSchema and maxSchema define the range of supported
+	// native histogram schemas, with exponential bucket boundaries.
+	minSchema = -4
+	maxSchema = 8
+)
+
+var serviceNameInvalidRegexp = regexp.MustCompile("[^a-zA-Z0-9 _-]")
+
+// toBatch translates a remote write request into metricset events.
+//
+// Samples with the same timestamp, job, instance, and other labels are
+// grouped into a single metricset event. The "job" and "instance" labels
+// are mapped to service.name and service.node.name, and other labels
+// (excluding the metric name) are recorded as event labels. Native
+// histograms are converted to histogram metrics. Non-finite sample values,
+// such as staleness markers, are dropped.
+func toBatch(req writeRequest) (model.Batch, error) {
+	metricTypes := make(map[string]model.MetricType)
+	for _, m := range req.metadata {
+		switch m.typ {
+		case metricTypeCounter:
+			metricTypes[m.metricFamilyName] = model.MetricTypeCounter
+		case metricTypeGauge:
+			metricTypes[m.metricFamilyName] = model.MetricTypeGauge
+		}
+	}
+
+	var batch model.Batch
+	metricsets := make(map[metricsetKey]int) // index into batch
+	for _, ts := range req.timeseries {
+		var name, job, instance string
+		var labels []label
+		for _, l := range ts.labels {
+			switch l.name {
+			case metricNameLabel:
+				name = l.value
+			case jobLabel:
+				job = l.value
+			case instanceLabel:
+				instance = l.value
+			default:
+				labels = append(labels, l)
+			}
+		}
+		if name == "" {
+			return nil, errors.New("time series missing metric name label")
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		key := metricsetKey{job: job, instance: instance, labels: labelsKey(labels)}
+
+		addSample := func(timestamp int64, s model.MetricsetSample) {
+			key.timestamp = timestamp
+			i, ok := metricsets[key]
+			if !ok {
+				i = len(batch)
+				metricsets[key] = i
+				batch = append(batch, newMetricsetEvent(timestamp, job, instance, labels))
+			}
+			ms := batch[i].Metricset
+			ms.Samples = append(ms.Samples, s)
+		}
+		metricType := metricTypes[name]
+		if metricType == "" {
+			// OpenMetrics counter families are named without the "_total" suffix.
+			metricType = metricTypes[strings.TrimSuffix(name, "_total")]
+		}
+		for _, s := range ts.samples {
+			if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+				continue
+			}
+			addSample(s.timestamp, model.MetricsetSample{
+				Name:  name,
+				Type:  metricType,
+				Value: s.value,
+			})
+		}
+		for _, h := range ts.histograms {
+			hist, err := convertHistogram(h)
+			if err != nil {
+				return nil, fmt.Errorf("invalid native histogram %q: %w", name, err)
+			}
+			addSample(h.timestamp, model.MetricsetSample{
+				Name:      name,
+				Type:      model.MetricTypeHistogram,
+				Histogram: hist,
+			})
+		}
+	}
+	return batch, nil
+}
+
+type metricsetKey struct {
+	timestamp int64
+	job       string
+	instance  string
+	labels    string
+}
+
+// labelsKey returns a string encoding of sorted labels, for use in a metricsetKey.
+func labelsKey(labels []label) string {
+	var sb strings.Builder
+	for _, l := range labels {
+		fmt.Fprintf(&sb, "%d:%s%d:%s", len(l.name), l.name, len(l.value), l.value)
+	}
+	return sb.String()
+}
+
+func newMetricsetEvent(timestamp int64, job, instance string, labels []label) model.APMEvent {
+	event := model.APMEvent{
+		Timestamp: time.UnixMilli(timestamp).UTC(),
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{Name: "app"},
+		Agent:     model.Agent{Name: agentName, Version: "unknown"},
+	}
+	event.Service.Name = serviceNameInvalidRegexp.ReplaceAllString(job, "_")
+	if event.Service.Name == "" {
+		// service.name is a required field.
+		event.Service.Name = "unknown"
+	}
+	event.Service.Node.Name = instance
+	if len(labels) > 0 {
+		event.Labels = make(model.Labels, len(labels))
+		for _, l := range labels {
+			event.Labels.Set(l.name, l.value)
+		}
+	}
+	return event
+}
+
+// convertHistogram converts a native histogram with exponential buckets
+// into a model.Histogram. Each bucket is represented by the midpoint of its
+// boundaries; the zero bucket is represented by zero.
+func convertHistogram(h histogram) (model.Histogram, error) {
+	if h.schema < minSchema || h.schema > maxSchema {
+		return model.Histogram{}, fmt.Errorf("unsupported schema %d", h.schema)
+	}
+	negative, err := bucketCounts(h.isFloat, h.negativeSpans, h.negativeDeltas, h.negativeCounts)
+	if err != nil {
+		return model.Histogram{}, fmt.Errorf("invalid negative buckets: %w", err)
+	}
+	positive, err := bucketCounts(h.isFloat, h.positiveSpans, h.positiveDeltas, h.positiveCounts)
+	if err != nil {
+		return model.Histogram{}, fmt.Errorf("invalid positive buckets: %w", err)
+	}
+	zeroCount := int64(h.zeroCountInt)
+	if h.isFloat {
+		zeroCount, err = roundCount(h.zeroCountFloat)
+		if err != nil {
+			return model.Histogram{}, fmt.Errorf("invalid zero count: %w", err)
+		}
+	}
+
+	var out model.Histogram
+	// Negative buckets are ordered by increasing absolute value,
+	// so iterate in reverse to produce ascending values.
+	for i := len(negative) - 1; i >= 0; i-- {
+		out.Values = append(out.Values, -bucketMidpoint(h.schema, negative[i].index))
+		out.Counts = append(out.Counts, negative[i].count)
+	}
+	if zeroCount > 0 {
+		out.Values = append(out.Values, 0)
+		out.Counts = append(out.Counts, zeroCount)
+	}
+	for _, b := range positive {
+		out.Values = append(out.Values, bucketMidpoint(h.schema, b.index))
+		out.Counts = append(out.Counts, b.count)
+	}
+	return out, nil
+}
+
+type bucket struct {
+	index int32
+	count int64
+}
+
+// bucketCounts returns the non-empty buckets described by spans and either
+// deltas (integer histograms) or counts (float histograms), in index order.
+func bucketCounts(isFloat bool, spans []bucketSpan, deltas []int64, counts []float64) ([]bucket, error) {
+	var n int
+	for _, span := range spans {
+		n += int(span.length)
+	}
+	if isFloat && len(counts) != n || !isFloat && len(deltas) != n {
+		return nil, fmt.Errorf("expected %d bucket counts", n)
+	}
+
+	var buckets []bucket
+	var index int32
+	var count int64
+	var i int
+	for _, span := range spans {
+		index += span.offset
+		for j := uint32(0); j < span.length; j++ {
+			if isFloat {
+				c, err := roundCount(counts[i])
+				if err != nil {
+					return nil, err
+				}
+				count = c
+			} else {
+				count += deltas[i]
+				if count < 0 {
+					return nil, fmt.Errorf("negative bucket count %d", count)
+				}
+			}
+			if count > 0 {
+				buckets = append(buckets, bucket{index: index, count: count})
+			}
+			index++
+			i++
+		}
+	}
+	return buckets, nil
+}
+
+func roundCount(f float64) (int64, error) {
+	if !(f >= 0 && f <= math.MaxInt64) {
+		return 0, fmt.Errorf("invalid bucket count %v", f)
+	}
+	return int64(math.Round(f)), nil
+}
+
+// bucketMidpoint returns the midpoint of the exponential bucket with the
+// given schema and index, which has the boundaries (base^(index-1), base^index]
+// where base is 2^(2^-schema).
+func bucketMidpoint(schema, index int32) float64 {
+	factor := math.Exp2(-float64(schema))
+	upper := math.Exp2(float64(index) * factor)
+	lower := math.Exp2(float64(index-1) * factor)
+	return lower + (upper-lower)/2
+}
@@ -0,0 +1,190 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package prometheus
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-data/model"
+)
+
+func TestToBatch(t *testing.T) {
+	req, err := decodeWriteRequest(encodeTestWriteRequest(t))
+	require.NoError(t, err)
+	batch, err := toBatch(req)
+	require.NoError(t, err)
+
+	newEvent := func(timestamp int64, labels model.Labels, samples ...model.MetricsetSample) model.APMEvent {
+		return model.APMEvent{
+			Timestamp: time.UnixMilli(timestamp).UTC(),
+			Processor: model.MetricsetProcessor,
+			Agent:     model.Agent{Name: "prometheus", Version: "unknown"},
+			Service: model.Service{
+				Name: "frontend",
+				Node: model.ServiceNode{Name: "localhost:9090"},
+			},
+			Labels:    labels,
+			Metricset: &model.Metricset{Name: "app", Samples: samples},
+		}
+	}
+	methodLabels := model.Labels{"method": {Value: "GET"}}
+	assert.Equal(t, model.Batch{
+		newEvent(1000, methodLabels, model.MetricsetSample{
+			Name: "http_requests_total", Type: model.MetricTypeCounter, Value: 10,
+		}),
+		newEvent(2000, methodLabels, model.MetricsetSample{
+			Name: "http_requests_total", Type: model.MetricTypeCounter, Value: 12,
+		}),
+		newEvent(1000, nil, model.MetricsetSample{
+			Name: "memory_bytes", Value: 1024,
+		}, model.MetricsetSample{
+			Name: "request_duration_seconds",
+			Type: model.MetricTypeHistogram,
+			Histogram: model.Histogram{
+				Values: []float64{-1.5, 0, 1.5, 3},
+				Counts: []int64{4, 1, 2, 3},
+			},
+		}),
+	}, batch)
+}
+
+func TestToBatchServiceName(t *testing.T) {
+	for name, tc := range map[string]struct {
+		job      string
+		expected string
+	}{
+		"missing":       {job: "", expected: "unknown"},
+		"invalid_chars": {job: "kubernetes/pods:web", expected: "kubernetes_pods_web"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			labels := []label{{name: "__name__", value: "up"}}
+			if tc.job != "" {
+				labels = append(labels, label{name: "job", value: tc.job})
+			}
+			batch, err := toBatch(writeRequest{timeseries: []timeSeries{{
+				labels:  labels,
+				samples: []sample{{value: 1, timestamp: 1000}},
+			}}})
+			require.NoError(t, err)
+			require.Len(t, batch, 1)
+			assert.Equal(t, tc.expected, batch[0].Service.Name)
+		})
+	}
+}
+
+func TestToBatchNonFiniteSamples(t *testing.T) {
+	batch, err := toBatch(writeRequest{timeseries: []timeSeries{{
+		labels: []label{{name: "__name__", value: "up"}},
+		samples: []sample{
+			{value: math.NaN(), timestamp: 1000},
+			{value: math.Inf(1), timestamp: 2000},
+			{value: 1, timestamp: 3000},
+		},
+	}}})
+	require.NoError(t, err)
+	require.Len(t, batch, 1)
+	assert.Equal(t, time.UnixMilli(3000).UTC(), batch[0].Timestamp)
+}
+
+func TestToBatchMissingMetricName(t *testing.T) {
+	_, err := toBatch(writeRequest{timeseries: []timeSeries{{
+		labels:  []label{{name: "job", value: "frontend"}},
+		samples: []sample{{value: 1, timestamp: 1000}},
+	}}})
+	assert.EqualError(t, err, "time series missing metric name label")
+}
+
+func TestConvertHistogram(t *testing.T) {
+	for name, tc := range map[string]struct {
+		histogram histogram
+		expected  model.Histogram
+		err       string
+	}{
+		"float": {
+			histogram: histogram{
+				isFloat:        true,
+				schema:         1,
+				zeroCountFloat: 0,
+				positiveSpans:  []bucketSpan{{offset: 0, length: 1}, {offset: 1, length: 1}},
+				positiveCounts: []float64{1.4, 2},
+			},
+			// Schema 1 has base sqrt(2): bucket 0 is (2^-0.5, 1],
+			// and bucket 2 is (2^0.5, 2].
+			expected: model.Histogram{
+				Values: []float64{(math.Sqrt2/2 + 1) / 2, (math.Sqrt2 + 2) / 2},
+				Counts: []int64{1, 2},
+			},
+		},
+		"empty_buckets_skipped": {
+			histogram: histogram{
+				schema:         0,
+				positiveSpans:  []bucketSpan{{offset: 0, length: 3}},
+				positiveDeltas: []int64{1, -1, 2},
+			},
+			expected: model.Histogram{
+				Values: []float64{0.75, 3},
+				Counts: []int64{1, 2},
+			},
+		},
+		"unsupported_schema": {
+			histogram: histogram{schema: -53},
+			err:       "unsupported schema -53",
+		},
+		"mismatched_counts": {
+			histogram: histogram{
+				positiveSpans:  []bucketSpan{{offset: 0, length: 2}},
+				positiveDeltas: []int64{1},
+			},
+			err: "invalid positive buckets: expected 2 bucket counts",
+		},
+		"negative_count": {
+			histogram: histogram{
+				negativeSpans:  []bucketSpan{{offset: 0, length: 1}},
+				negativeDeltas: []int64{-1},
+			},
+			err: "invalid negative buckets: negative bucket count -1",
+		},
+		"invalid_float_count": {
+			histogram: histogram{
+				isFloat:        true,
+				positiveSpans:  []bucketSpan{{offset: 0, length: 1}},
+				positiveCounts: []float64{math.NaN()},
+			},
+			err: "invalid positive buckets: invalid bucket count NaN",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out, err := convertHistogram(tc.histogram)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, out.Values, len(tc.expected.Values))
+			for i, v := range tc.expected.Values {
+				assert.InDelta(t, v, out.Values[i], 1e-9)
+			}
+			assert.Equal(t, tc.expected.Counts, out.Counts)
+		})
+	}
+}
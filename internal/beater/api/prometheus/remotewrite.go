@@ -0,0 +1,290 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// writeRequest holds a decoded Prometheus remote write request.
+//
+// See https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto
+// and https://github.com/prometheus/prometheus/blob/main/prompb/types.proto.
+type writeRequest struct {
+	timeseries []timeSeries
+	metadata   []metricMetadata
+}
+
+type timeSeries struct {
+	labels     []label
+	samples    []sample
+	histograms []histogram
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	value     float64
+	timestamp int64 // milliseconds since the Unix epoch
+}
+
+// histogram holds a native histogram. Integer histograms have bucket
+// counts encoded as deltas; float histograms have absolute bucket counts.
+type histogram struct {
+	isFloat        bool
+	schema         int32
+	zeroThreshold  float64
+	zeroCountInt   uint64
+	zeroCountFloat float64
+	negativeSpans  []bucketSpan
+	negativeDeltas []int64
+	negativeCounts []float64
+	positiveSpans  []bucketSpan
+	positiveDeltas []int64
+	positiveCounts []float64
+	timestamp      int64 // milliseconds since the Unix epoch
+}
+
+type bucketSpan struct {
+	offset int32
+	length uint32
+}
+
+// metricType holds the Prometheus metric type of a metric family.
+type metricType uint64
+
+const (
+	metricTypeCounter metricType = 1
+	metricTypeGauge   metricType = 2
+)
+
+type metricMetadata struct {
+	typ              metricType
+	metricFamilyName string
+}
+
+// decodeWriteRequest decodes a protobuf-encoded, uncompressed WriteRequest.
+func decodeWriteRequest(data []byte) (writeRequest, error) {
+	var req writeRequest
+	err := decodeProtobufFields(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1: // timeseries
+			var ts timeSeries
+			if err := decodeTimeSeries(v, &ts); err != nil {
+				return err
+			}
+			req.timeseries = append(req.timeseries, ts)
+		case 3: // metadata
+			var m metricMetadata
+			if err := decodeProtobufFields(v, func(num protowire.Number, _ protowire.Type, v []byte, x uint64) error {
+				switch num {
+				case 1: // type
+					m.typ = metricType(x)
+				case 2: // metric_family_name
+					m.metricFamilyName = string(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			req.metadata = append(req.metadata, m)
+		}
+		return nil
+	})
+	return req, err
+}
+
+func decodeTimeSeries(data []byte, ts *timeSeries) error {
+	return decodeProtobufFields(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1: // labels
+			var l label
+			if err := decodeProtobufFields(v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+				switch num {
+				case 1:
+					l.name = string(v)
+				case 2:
+					l.value = string(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			ts.labels = append(ts.labels, l)
+		case 2: // samples
+			var s sample
+			if err := decodeProtobufFields(v, func(num protowire.Number, _ protowire.Type, _ []byte, x uint64) error {
+				switch num {
+				case 1:
+					s.value = math.Float64frombits(x)
+				case 2:
+					s.timestamp = int64(x)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			ts.samples = append(ts.samples, s)
+		case 4: // histograms
+			var h histogram
+			if err := decodeHistogram(v, &h); err != nil {
+				return err
+			}
+			ts.histograms = append(ts.histograms, h)
+		}
+		return nil
+	})
+}
+
+func decodeHistogram(data []byte, h *histogram) error {
+	return decodeProtobufFields(data, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+		var err error
+		switch num {
+		case 2: // count_float
+			h.isFloat = true
+		case 4: // schema
+			h.schema = int32(protowire.DecodeZigZag(x))
+		case 5: // zero_threshold
+			h.zeroThreshold = math.Float64frombits(x)
+		case 6: // zero_count_int
+			h.zeroCountInt = x
+		case 7: // zero_count_float
+			h.isFloat = true
+			h.zeroCountFloat = math.Float64frombits(x)
+		case 8: // negative_spans
+			h.negativeSpans, err = appendBucketSpan(h.negativeSpans, v)
+		case 9: // negative_deltas
+			h.negativeDeltas, err = appendSint64s(h.negativeDeltas, typ, v, x)
+		case 10: // negative_counts
+			h.isFloat = true
+			h.negativeCounts, err = appendDoubles(h.negativeCounts, typ, v, x)
+		case 11: // positive_spans
+			h.positiveSpans, err = appendBucketSpan(h.positiveSpans, v)
+		case 12: // positive_deltas
+			h.positiveDeltas, err = appendSint64s(h.positiveDeltas, typ, v, x)
+		case 13: // positive_counts
+			h.isFloat = true
+			h.positiveCounts, err = appendDoubles(h.positiveCounts, typ, v, x)
+		case 15: // timestamp
+			h.timestamp = int64(x)
+		}
+		return err
+	})
+}
+
+func appendBucketSpan(spans []bucketSpan, data []byte) ([]bucketSpan, error) {
+	var span bucketSpan
+	if err := decodeProtobufFields(data, func(num protowire.Number, _ protowire.Type, _ []byte, x uint64) error {
+		switch num {
+		case 1:
+			span.offset = int32(protowire.DecodeZigZag(x))
+		case 2:
+			span.length = uint32(x)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return append(spans, span), nil
+}
+
+// appendSint64s appends the values of a repeated sint64 field, which may
+// be packed (typ is BytesType) or not (typ is VarintType).
+func appendSint64s(out []int64, typ protowire.Type, v []byte, x uint64) ([]int64, error) {
+	if typ != protowire.BytesType {
+		return append(out, protowire.DecodeZigZag(x)), nil
+	}
+	for len(v) > 0 {
+		x, n := protowire.ConsumeVarint(v)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		v = v[n:]
+		out = append(out, protowire.DecodeZigZag(x))
+	}
+	return out, nil
+}
+
+// appendDoubles appends the values of a repeated double field, which may
+// be packed (typ is BytesType) or not (typ is Fixed64Type).
+func appendDoubles(out []float64, typ protowire.Type, v []byte, x uint64) ([]float64, error) {
+	if typ != protowire.BytesType {
+		return append(out, math.Float64frombits(x)), nil
+	}
+	if len(v)%8 != 0 {
+		return nil, fmt.Errorf("invalid packed double field length %d", len(v))
+	}
+	for len(v) > 0 {
+		x, n := protowire.ConsumeFixed64(v)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		v = v[n:]
+		out = append(out, math.Float64frombits(x))
+	}
+	return out, nil
+}
+
+// decodeProtobufFields calls f for each field in the protobuf-encoded message.
+// For length-delimited fields v holds the field's bytes; for varint and fixed
+// width fields x holds the field's value.
+func decodeProtobufFields(data []byte, f func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			x, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var x32 uint32
+			x32, n = protowire.ConsumeFixed32(data)
+			x = uint64(x32)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := f(num, typ, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package prometheus
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func encodeLabels(b []byte, labels ...string) []byte {
+	for i := 0; i+1 < len(labels); i += 2 {
+		var l []byte
+		l = appendBytes(l, 1, []byte(labels[i]))
+		l = appendBytes(l, 2, []byte(labels[i+1]))
+		b = appendBytes(b, 1, l)
+	}
+	return b
+}
+
+func encodeSample(b []byte, value float64, timestamp int64) []byte {
+	var s []byte
+	s = appendDouble(s, 1, value)
+	s = appendVarint(s, 2, uint64(timestamp))
+	return appendBytes(b, 2, s)
+}
+
+func encodeBucketSpan(b []byte, num protowire.Number, offset int32, length uint32) []byte {
+	var span []byte
+	span = appendVarint(span, 1, protowire.EncodeZigZag(int64(offset)))
+	span = appendVarint(span, 2, uint64(length))
+	return appendBytes(b, num, span)
+}
+
+// encodeTestWriteRequest returns a protobuf-encoded WriteRequest with a
+// counter, a gauge, an integer native histogram, and metadata.
+func encodeTestWriteRequest(t testing.TB) []byte {
+	var counter []byte
+	counter = encodeLabels(counter,
+		"__name__", "http_requests_total",
+		"instance", "localhost:9090",
+		"job", "frontend",
+		"method", "GET",
+	)
+	counter = encodeSample(counter, 10, 1000)
+	counter = encodeSample(counter, 12, 2000)
+
+	var gauge []byte
+	gauge = encodeLabels(gauge,
+		"__name__", "memory_bytes",
+		"instance", "localhost:9090",
+		"job", "frontend",
+	)
+	gauge = encodeSample(gauge, 1024, 1000)
+
+	// Integer histogram with schema 0 (base 2), zero bucket count 1,
+	// positive buckets 1 and 2 with counts 2 and 3, and negative
+	// bucket 1 with count 4. Deltas are packed.
+	var hist []byte
+	hist = appendVarint(hist, 1, 10) // count_int
+	hist = appendDouble(hist, 3, 12.5)
+	hist = appendVarint(hist, 4, protowire.EncodeZigZag(0))
+	hist = appendDouble(hist, 5, 0.001)
+	hist = appendVarint(hist, 6, 1)
+	hist = encodeBucketSpan(hist, 8, 1, 1)
+	hist = appendBytes(hist, 9, protowire.AppendVarint(nil, protowire.EncodeZigZag(4)))
+	hist = encodeBucketSpan(hist, 11, 1, 2)
+	var deltas []byte
+	deltas = protowire.AppendVarint(deltas, protowire.EncodeZigZag(2))
+	deltas = protowire.AppendVarint(deltas, protowire.EncodeZigZag(1))
+	hist = appendBytes(hist, 12, deltas)
+	hist = appendVarint(hist, 15, 1000)
+
+	var histogramSeries []byte
+	histogramSeries = encodeLabels(histogramSeries,
+		"__name__", "request_duration_seconds",
+		"instance", "localhost:9090",
+		"job", "frontend",
+	)
+	histogramSeries = appendBytes(histogramSeries, 4, hist)
+
+	var metadata []byte
+	metadata = appendVarint(metadata, 1, uint64(metricTypeCounter))
+	metadata = appendBytes(metadata, 2, []byte("http_requests"))
+
+	var req []byte
+	req = appendBytes(req, 1, counter)
+	req = appendBytes(req, 1, gauge)
+	req = appendBytes(req, 1, histogramSeries)
+	req = appendBytes(req, 3, metadata)
+	return req
+}
+
+func TestDecodeWriteRequest(t *testing.T) {
+	req, err := decodeWriteRequest(encodeTestWriteRequest(t))
+	require.NoError(t, err)
+	assert.Equal(t, writeRequest{
+		timeseries: []timeSeries{{
+			labels: []label{
+				{name: "__name__", value: "http_requests_total"},
+				{name: "instance", value: "localhost:9090"},
+				{name: "job", value: "frontend"},
+				{name: "method", value: "GET"},
+			},
+			samples: []sample{{value: 10, timestamp: 1000}, {value: 12, timestamp: 2000}},
+		}, {
+			labels: []label{
+				{name: "__name__", value: "memory_bytes"},
+				{name: "instance", value: "localhost:9090"},
+				{name: "job", value: "frontend"},
+			},
+			samples: []sample{{value: 1024, timestamp: 1000}},
+		}, {
+			labels: []label{
+				{name: "__name__", value: "request_duration_seconds"},
+				{name: "instance", value: "localhost:9090"},
+				{name: "job", value: "frontend"},
+			},
+			histograms: []histogram{{
+				zeroThreshold:  0.001,
+				zeroCountInt:   1,
+				negativeSpans:  []bucketSpan{{offset: 1, length: 1}},
+				negativeDeltas: []int64{4},
+				positiveSpans:  []bucketSpan{{offset: 1, length: 2}},
+				positiveDeltas: []int64{2, 1},
+				timestamp:      1000,
+			}},
+		}},
+		metadata: []metricMetadata{{typ: metricTypeCounter, metricFamilyName: "http_requests"}},
+	}, req)
+}
+
+func TestDecodeWriteRequestUnpacked(t *testing.T) {
+	var hist []byte
+	hist = appendDouble(hist, 2, 3) // count_float
+	hist = encodeBucketSpan(hist, 11, 0, 2)
+	hist = appendDouble(hist, 13, 1)
+	hist = appendDouble(hist, 13, 2)
+	hist = appendVarint(hist, 12, protowire.EncodeZigZag(-1))
+
+	var series []byte
+	series = encodeLabels(series, "__name__", "h")
+	series = appendBytes(series, 4, hist)
+	req, err := decodeWriteRequest(appendBytes(nil, 1, series))
+	require.NoError(t, err)
+	require.Len(t, req.timeseries, 1)
+	assert.Equal(t, []histogram{{
+		isFloat:        true,
+		positiveSpans:  []bucketSpan{{offset: 0, length: 2}},
+		positiveCounts: []float64{1, 2},
+		positiveDeltas: []int64{-1},
+	}}, req.timeseries[0].histograms)
+}
+
+func TestDecodeWriteRequestInvalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"truncated":            {0x0a, 0x05, 0x01},
+		"invalid_tag":          {0xff},
+		"truncated_timeseries": appendBytes(nil, 1, []byte{0x12, 0x03}),
+		"invalid_packed_doubles": appendBytes(nil, 1, appendBytes(nil, 4,
+			appendBytes(nil, 13, []byte{1, 2, 3}),
+		)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeWriteRequest(data)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package zipkin
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-data/input/otlp"
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+var (
+	// MonitoringMap holds a mapping for request.IDs to monitoring counters.
+	MonitoringMap = request.MonitoringMapForRegistry(registry, append(request.DefaultResultIDs,
+		request.IDEventReceivedCount,
+		request.IDResponseValidAccepted,
+		request.IDResponseErrorsDecode,
+		request.IDResponseErrorsValidate,
+		request.IDResponseErrorsUnsupportedMediaType,
+		request.IDResponseErrorsMethodNotAllowed,
+		request.IDResponseErrorsRateLimit,
+		request.IDResponseErrorsTimeout,
+		request.IDResponseErrorsForbidden,
+		request.IDResponseErrorsUnauthorized,
+		request.IDResponseErrorsFullQueue,
+		request.IDResponseErrorsShuttingDown,
+		request.IDResponseErrorsInternal,
+	))
+	registry = monitoring.Default.NewRegistry("apm-server.zipkin.http")
+)
+
+// Handler returns a request.Handler for receiving Zipkin v2 spans.
+//
+// Requests must be POSTs with a body holding a list of Zipkin v2 spans,
+// encoded as JSON or protobuf according to the Content-Type header; JSON
+// is assumed if no Content-Type is specified. Spans are translated into
+// OpenTelemetry traces, and then processed with an OTLP consumer.
+func Handler(logger *zap.Logger, processor model.BatchProcessor) request.Handler {
+	consumer := otlp.NewConsumer(otlp.ConsumerConfig{
+		Processor: processor,
+		Logger:    logger,
+	})
+	return func(c *request.Context) {
+		if c.Request.Method != http.MethodPost {
+			c.Result.SetDefault(request.IDResponseErrorsMethodNotAllowed)
+			c.WriteResult()
+			return
+		}
+
+		var decode func([]byte) ([]span, error)
+		contentType := c.Request.Header.Get(headers.ContentType)
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch mediaType {
+		case "", contentTypeJSON:
+			decode = decodeJSON
+		case contentTypeProtobuf:
+			decode = decodeProtobuf
+		default:
+			c.Result.SetWithError(request.IDResponseErrorsUnsupportedMediaType, fmt.Errorf(
+				"invalid content type %q, expected %q or %q",
+				contentType, contentTypeJSON, contentTypeProtobuf,
+			))
+			c.WriteResult()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsDecode, fmt.Errorf("failed to read request body: %w", err))
+			c.WriteResult()
+			return
+		}
+		spans, err := decode(body)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsDecode, fmt.Errorf("failed to decode spans: %w", err))
+			c.WriteResult()
+			return
+		}
+		traces, err := toTraces(spans, c.Timestamp)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsValidate, fmt.Errorf("invalid spans: %w", err))
+			c.WriteResult()
+			return
+		}
+
+		MonitoringMap[request.IDEventReceivedCount].Add(int64(traces.SpanCount()))
+		if err := consumer.ConsumeTraces(c.Request.Context(), traces); err != nil {
+			c.SetConsumeError(err)
+			c.WriteResult()
+			return
+		}
+		c.Result.SetDefault(request.IDResponseValidAccepted)
+		c.WriteResult()
+	}
+}
@@ -0,0 +1,176 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package zipkin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+const testSpansJSON = `[{
+	"traceId": "5982fe77008310cc80f1da5e10147517",
+	"id": "bd7a977555f6b982",
+	"name": "get /api",
+	"timestamp": 1472470996199000,
+	"duration": 207000,
+	"kind": "SERVER",
+	"localEndpoint": {"serviceName": "frontend", "ipv4": "127.0.0.1"},
+	"tags": {"http.method": "GET", "http.path": "/api"}
+}]`
+
+func TestHandler(t *testing.T) {
+	testSpansProtobuf := encodeTestSpanProtobuf(t)
+
+	for name, tc := range map[string]struct {
+		method      string
+		contentType string
+		body        []byte
+		processErr  error
+		expectedID  request.ResultID
+		expectBatch bool
+	}{
+		"json": {
+			contentType: "application/json; charset=utf-8",
+			body:        []byte(testSpansJSON),
+			expectedID:  request.IDResponseValidAccepted,
+			expectBatch: true,
+		},
+		"json_default": {
+			body:        []byte(testSpansJSON),
+			expectedID:  request.IDResponseValidAccepted,
+			expectBatch: true,
+		},
+		"protobuf": {
+			contentType: "application/x-protobuf",
+			body:        testSpansProtobuf,
+			expectedID:  request.IDResponseValidAccepted,
+			expectBatch: true,
+		},
+		"method_not_allowed": {
+			method:     http.MethodGet,
+			expectedID: request.IDResponseErrorsMethodNotAllowed,
+		},
+		"invalid_content_type": {
+			contentType: "text/plain",
+			body:        []byte(testSpansJSON),
+			expectedID:  request.IDResponseErrorsUnsupportedMediaType,
+		},
+		"invalid_json": {
+			contentType: "application/json",
+			body:        []byte(`{`),
+			expectedID:  request.IDResponseErrorsDecode,
+		},
+		"invalid_protobuf": {
+			contentType: "application/x-protobuf",
+			body:        []byte{0xff},
+			expectedID:  request.IDResponseErrorsDecode,
+		},
+		"missing_id": {
+			body:       []byte(`[{"traceId": "5982fe77008310cc80f1da5e10147517"}]`),
+			expectedID: request.IDResponseErrorsDecode,
+		},
+		"zero_id": {
+			body:       []byte(`[{"traceId": "5982fe77008310cc80f1da5e10147517", "id": "0000000000000000"}]`),
+			expectedID: request.IDResponseErrorsValidate,
+		},
+		"rate_limited": {
+			body:        []byte(testSpansJSON),
+			processErr:  ratelimit.ErrRateLimitExceeded,
+			expectedID:  request.IDResponseErrorsRateLimit,
+			expectBatch: true,
+		},
+		"forbidden": {
+			body:        []byte(testSpansJSON),
+			processErr:  auth.ErrUnauthorized,
+			expectedID:  request.IDResponseErrorsForbidden,
+			expectBatch: true,
+		},
+		"internal": {
+			body:        []byte(testSpansJSON),
+			processErr:  errors.New("boom"),
+			expectedID:  request.IDResponseErrorsInternal,
+			expectBatch: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var batches []model.Batch
+			processor := model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+				batches = append(batches, *batch)
+				return tc.processErr
+			})
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, "/api/v2/spans", bytes.NewReader(tc.body))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			w := httptest.NewRecorder()
+			c := request.NewContext()
+			c.Reset(w, r)
+			Handler(zap.NewNop(), processor)(c)
+
+			assert.Equal(t, tc.expectedID, c.Result.ID)
+			assert.Equal(t, request.MapResultIDToStatus[tc.expectedID].Code, w.Code)
+			if !tc.expectBatch {
+				assert.Empty(t, batches)
+				return
+			}
+			require.Len(t, batches, 1)
+			require.Len(t, batches[0], 1)
+			event := batches[0][0]
+			assert.Equal(t, "frontend", event.Service.Name)
+			require.NotNil(t, event.Transaction)
+			assert.Equal(t, "get /api", event.Transaction.Name)
+		})
+	}
+}
+
+func TestHandlerQuotaExceeded(t *testing.T) {
+	processor := model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		return &ratelimit.QuotaExceededError{
+			Kind:       ratelimit.QuotaKindService,
+			Value:      "frontend",
+			RetryAfter: 1500 * time.Millisecond,
+		}
+	})
+	r := httptest.NewRequest(http.MethodPost, "/api/v2/spans", bytes.NewReader([]byte(testSpansJSON)))
+	w := httptest.NewRecorder()
+	c := request.NewContext()
+	c.Reset(w, r)
+	Handler(zap.NewNop(), processor)(c)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,441 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package zipkin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	semconv "go.opentelemetry.io/collector/semconv/v1.5.0"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// span holds the fields of a Zipkin v2 span that are translated into
+// OpenTelemetry spans, independent of the wire encoding.
+//
+// See https://zipkin.io/zipkin-api/#/default/post_spans for the model.
+type span struct {
+	traceID        pcommon.TraceID
+	id             pcommon.SpanID
+	parentID       pcommon.SpanID
+	kind           ptrace.SpanKind
+	name           string
+	timestamp      uint64 // microseconds since the Unix epoch, or zero if unknown
+	duration       uint64 // microseconds
+	localEndpoint  endpoint
+	remoteEndpoint endpoint
+	annotations    []annotation
+	tags           map[string]string
+}
+
+type endpoint struct {
+	serviceName string
+	ip          netip.Addr
+	port        int32
+}
+
+type annotation struct {
+	timestamp uint64 // microseconds since the Unix epoch
+	value     string
+}
+
+// decodeJSON decodes a JSON-encoded list of Zipkin v2 spans.
+func decodeJSON(data []byte) ([]span, error) {
+	var jsonSpans []jsonSpan
+	if err := json.Unmarshal(data, &jsonSpans); err != nil {
+		return nil, err
+	}
+	spans := make([]span, len(jsonSpans))
+	for i, in := range jsonSpans {
+		out := &spans[i]
+		if err := decodeHexID(out.traceID[:], in.TraceID, true); err != nil {
+			return nil, fmt.Errorf("invalid traceId: %w", err)
+		}
+		if err := decodeHexID(out.id[:], in.ID, false); err != nil {
+			return nil, fmt.Errorf("invalid id: %w", err)
+		}
+		if in.ParentID != "" {
+			if err := decodeHexID(out.parentID[:], in.ParentID, false); err != nil {
+				return nil, fmt.Errorf("invalid parentId: %w", err)
+			}
+		}
+		switch in.Kind {
+		case "":
+			out.kind = ptrace.SpanKindUnspecified
+		case "CLIENT":
+			out.kind = ptrace.SpanKindClient
+		case "SERVER":
+			out.kind = ptrace.SpanKindServer
+		case "PRODUCER":
+			out.kind = ptrace.SpanKindProducer
+		case "CONSUMER":
+			out.kind = ptrace.SpanKindConsumer
+		default:
+			return nil, fmt.Errorf("invalid kind %q", in.Kind)
+		}
+		out.name = in.Name
+		out.timestamp = in.Timestamp
+		out.duration = in.Duration
+		var err error
+		if out.localEndpoint, err = in.LocalEndpoint.endpoint(); err != nil {
+			return nil, fmt.Errorf("invalid localEndpoint: %w", err)
+		}
+		if out.remoteEndpoint, err = in.RemoteEndpoint.endpoint(); err != nil {
+			return nil, fmt.Errorf("invalid remoteEndpoint: %w", err)
+		}
+		if len(in.Annotations) > 0 {
+			out.annotations = make([]annotation, len(in.Annotations))
+			for j, a := range in.Annotations {
+				out.annotations[j] = annotation{timestamp: a.Timestamp, value: a.Value}
+			}
+		}
+		out.tags = in.Tags
+	}
+	return spans, nil
+}
+
+type jsonSpan struct {
+	TraceID        string        `json:"traceId"`
+	ID             string        `json:"id"`
+	ParentID       string        `json:"parentId"`
+	Kind           string        `json:"kind"`
+	Name           string        `json:"name"`
+	Timestamp      uint64        `json:"timestamp"`
+	Duration       uint64        `json:"duration"`
+	LocalEndpoint  *jsonEndpoint `json:"localEndpoint"`
+	RemoteEndpoint *jsonEndpoint `json:"remoteEndpoint"`
+	Annotations    []struct {
+		Timestamp uint64 `json:"timestamp"`
+		Value     string `json:"value"`
+	} `json:"annotations"`
+	Tags map[string]string `json:"tags"`
+}
+
+type jsonEndpoint struct {
+	ServiceName string `json:"serviceName"`
+	IPv4        string `json:"ipv4"`
+	IPv6        string `json:"ipv6"`
+	Port        int32  `json:"port"`
+}
+
+func (e *jsonEndpoint) endpoint() (endpoint, error) {
+	if e == nil {
+		return endpoint{}, nil
+	}
+	out := endpoint{serviceName: e.ServiceName, port: e.Port}
+	// Prefer IPv4 if both addresses are specified, consistent with the
+	// protobuf encoding which has separate fields for each.
+	for _, s := range []string{e.IPv4, e.IPv6} {
+		if s == "" || out.ip.IsValid() {
+			continue
+		}
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return endpoint{}, err
+		}
+		out.ip = ip
+	}
+	return out, nil
+}
+
+// decodeHexID decodes a lower-hex encoded ID into out. If leftPad is true,
+// the ID may be shorter than out, in which case it is left-padded with zeroes;
+// this is used for 64-bit trace IDs, and for trace IDs whose leading zeroes
+// have been dropped, leaving an odd number of hex characters.
+func decodeHexID(out []byte, s string, leftPad bool) error {
+	if leftPad && len(s)%2 != 0 {
+		s = "0" + s
+	}
+	n := hex.DecodedLen(len(s))
+	if len(s)%2 != 0 || n == 0 || n > len(out) || (!leftPad && n != len(out)) {
+		return fmt.Errorf("expected %d hex characters, got %q", 2*len(out), s)
+	}
+	_, err := hex.Decode(out[len(out)-n:], []byte(s))
+	return err
+}
+
+// decodeProtobuf decodes a protobuf-encoded zipkin.proto3.ListOfSpans.
+//
+// See https://github.com/openzipkin/zipkin-api/blob/master/zipkin.proto
+func decodeProtobuf(data []byte) ([]span, error) {
+	var spans []span
+	err := decodeProtobufFields(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		var s span
+		if err := decodeProtobufSpan(v, &s); err != nil {
+			return err
+		}
+		spans = append(spans, s)
+		return nil
+	})
+	return spans, err
+}
+
+func decodeProtobufSpan(data []byte, s *span) error {
+	return decodeProtobufFields(data, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+		switch num {
+		case 1: // trace_id
+			if len(v) != 8 && len(v) != 16 {
+				return fmt.Errorf("invalid trace_id length %d", len(v))
+			}
+			copy(s.traceID[16-len(v):], v)
+		case 2: // parent_id
+			if len(v) != 8 {
+				return fmt.Errorf("invalid parent_id length %d", len(v))
+			}
+			copy(s.parentID[:], v)
+		case 3: // id
+			if len(v) != 8 {
+				return fmt.Errorf("invalid id length %d", len(v))
+			}
+			copy(s.id[:], v)
+		case 4: // kind
+			switch x {
+			case 0:
+				s.kind = ptrace.SpanKindUnspecified
+			case 1:
+				s.kind = ptrace.SpanKindClient
+			case 2:
+				s.kind = ptrace.SpanKindServer
+			case 3:
+				s.kind = ptrace.SpanKindProducer
+			case 4:
+				s.kind = ptrace.SpanKindConsumer
+			default:
+				return fmt.Errorf("invalid kind %d", x)
+			}
+		case 5: // name
+			s.name = string(v)
+		case 6: // timestamp
+			s.timestamp = x
+		case 7: // duration
+			s.duration = x
+		case 8: // local_endpoint
+			return decodeProtobufEndpoint(v, &s.localEndpoint)
+		case 9: // remote_endpoint
+			return decodeProtobufEndpoint(v, &s.remoteEndpoint)
+		case 10: // annotations
+			var a annotation
+			if err := decodeProtobufFields(v, func(num protowire.Number, _ protowire.Type, v []byte, x uint64) error {
+				switch num {
+				case 1:
+					a.timestamp = x
+				case 2:
+					a.value = string(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			s.annotations = append(s.annotations, a)
+		case 11: // tags
+			var key, value string
+			if err := decodeProtobufFields(v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+				switch num {
+				case 1:
+					key = string(v)
+				case 2:
+					value = string(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if s.tags == nil {
+				s.tags = make(map[string]string)
+			}
+			s.tags[key] = value
+		}
+		return nil
+	})
+}
+
+func decodeProtobufEndpoint(data []byte, e *endpoint) error {
+	return decodeProtobufFields(data, func(num protowire.Number, _ protowire.Type, v []byte, x uint64) error {
+		switch num {
+		case 1: // service_name
+			e.serviceName = string(v)
+		case 2, 3: // ipv4, ipv6
+			if len(v) == 0 || e.ip.IsValid() {
+				return nil
+			}
+			ip, ok := netip.AddrFromSlice(v)
+			if !ok {
+				return fmt.Errorf("invalid IP address length %d", len(v))
+			}
+			e.ip = ip.Unmap()
+		case 4: // port
+			e.port = int32(x)
+		}
+		return nil
+	})
+}
+
+// decodeProtobufFields calls f for each field in the protobuf-encoded message.
+// For length-delimited fields v holds the field's bytes; for varint and fixed
+// width fields x holds the field's value.
+func decodeProtobufFields(data []byte, f func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			x, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var x32 uint32
+			x32, n = protowire.ConsumeFixed32(data)
+			x = uint64(x32)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := f(num, typ, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toTraces translates Zipkin v2 spans into OpenTelemetry traces, grouping
+// them into resources by their local endpoint's service name.
+//
+// Zipkin spans may omit their timestamp, for example when the start of the
+// span was not recorded. The start time of such spans is taken from their
+// earliest annotation, or otherwise from received, the time at which the
+// spans were received.
+func toTraces(spans []span, received time.Time) (ptrace.Traces, error) {
+	traces := ptrace.NewTraces()
+	scopeSpans := make(map[string]ptrace.SpanSlice)
+	for i := range spans {
+		s := &spans[i]
+		if s.traceID.IsEmpty() {
+			return ptrace.Traces{}, errors.New("missing trace ID")
+		}
+		if s.id.IsEmpty() {
+			return ptrace.Traces{}, errors.New("missing span ID")
+		}
+		serviceName := s.localEndpoint.serviceName
+		spanSlice, ok := scopeSpans[serviceName]
+		if !ok {
+			rs := traces.ResourceSpans().AppendEmpty()
+			if serviceName != "" {
+				rs.Resource().Attributes().PutStr(semconv.AttributeServiceName, serviceName)
+			}
+			spanSlice = rs.ScopeSpans().AppendEmpty().Spans()
+			scopeSpans[serviceName] = spanSlice
+		}
+		if s.timestamp == 0 {
+			s.timestamp = missingTimestamp(s, received)
+		}
+		populateSpan(s, spanSlice.AppendEmpty())
+	}
+	return traces, nil
+}
+
+func populateSpan(in *span, out ptrace.Span) {
+	out.SetTraceID(in.traceID)
+	out.SetSpanID(in.id)
+	out.SetParentSpanID(in.parentID)
+	out.SetKind(in.kind)
+	out.SetName(in.name)
+	out.SetStartTimestamp(microsToTimestamp(in.timestamp))
+	out.SetEndTimestamp(microsToTimestamp(in.timestamp + in.duration))
+
+	attrs := out.Attributes()
+	if in.localEndpoint.ip.IsValid() {
+		attrs.PutStr(semconv.AttributeNetHostIP, in.localEndpoint.ip.String())
+	}
+	if in.localEndpoint.port != 0 {
+		attrs.PutInt(semconv.AttributeNetHostPort, int64(in.localEndpoint.port))
+	}
+	if in.remoteEndpoint.serviceName != "" {
+		attrs.PutStr(semconv.AttributePeerService, in.remoteEndpoint.serviceName)
+	}
+	if in.remoteEndpoint.ip.IsValid() {
+		attrs.PutStr(semconv.AttributeNetPeerIP, in.remoteEndpoint.ip.String())
+	}
+	if in.remoteEndpoint.port != 0 {
+		attrs.PutInt(semconv.AttributeNetPeerPort, int64(in.remoteEndpoint.port))
+	}
+
+	// Sort tags for deterministic attribute ordering.
+	keys := make([]string, 0, len(in.tags))
+	for k := range in.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := in.tags[k]
+		if k == "error" {
+			// Zipkin indicates failed spans with an "error" tag,
+			// whose value is an error message or empty.
+			out.Status().SetCode(ptrace.StatusCodeError)
+			if v != "" && v != "true" {
+				out.Status().SetMessage(v)
+			}
+			continue
+		}
+		attrs.PutStr(k, v)
+	}
+
+	for _, a := range in.annotations {
+		event := out.Events().AppendEmpty()
+		event.SetTimestamp(microsToTimestamp(a.timestamp))
+		event.SetName(a.value)
+	}
+}
+
+// missingTimestamp returns the timestamp to use for a span with
+// no timestamp, in microseconds since the Unix epoch.
+func missingTimestamp(s *span, received time.Time) uint64 {
+	var timestamp uint64
+	for _, a := range s.annotations {
+		if a.timestamp != 0 && (timestamp == 0 || a.timestamp < timestamp) {
+			timestamp = a.timestamp
+		}
+	}
+	if timestamp == 0 {
+		timestamp = uint64(received.UnixMicro())
+	}
+	return timestamp
+}
+
+func microsToTimestamp(us uint64) pcommon.Timestamp {
+	return pcommon.Timestamp(us * 1000)
+}
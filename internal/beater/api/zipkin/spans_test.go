@@ -0,0 +1,227 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package zipkin
+
+import (
+	"encoding/hex"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// encodeTestSpanProtobuf returns testSpansJSON encoded as a
+// zipkin.proto3.ListOfSpans protobuf message.
+func encodeTestSpanProtobuf(t testing.TB) []byte {
+	traceID, err := hex.DecodeString("5982fe77008310cc80f1da5e10147517")
+	require.NoError(t, err)
+	spanID, err := hex.DecodeString("bd7a977555f6b982")
+	require.NoError(t, err)
+
+	appendBytes := func(b []byte, num protowire.Number, v []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, v)
+	}
+	appendTag := func(b []byte, k, v string) []byte {
+		var entry []byte
+		entry = appendBytes(entry, 1, []byte(k))
+		entry = appendBytes(entry, 2, []byte(v))
+		return appendBytes(b, 11, entry)
+	}
+
+	var endpoint []byte
+	endpoint = appendBytes(endpoint, 1, []byte("frontend"))
+	endpoint = appendBytes(endpoint, 2, []byte{127, 0, 0, 1})
+
+	var span []byte
+	span = appendBytes(span, 1, traceID)
+	span = appendBytes(span, 3, spanID)
+	span = protowire.AppendTag(span, 4, protowire.VarintType)
+	span = protowire.AppendVarint(span, 2) // SERVER
+	span = appendBytes(span, 5, []byte("get /api"))
+	span = protowire.AppendTag(span, 6, protowire.Fixed64Type)
+	span = protowire.AppendFixed64(span, 1472470996199000)
+	span = protowire.AppendTag(span, 7, protowire.VarintType)
+	span = protowire.AppendVarint(span, 207000)
+	span = appendBytes(span, 8, endpoint)
+	span = appendTag(span, "http.method", "GET")
+	span = appendTag(span, "http.path", "/api")
+	return appendBytes(nil, 1, span)
+}
+
+func TestDecodeJSONProtobufEquivalent(t *testing.T) {
+	jsonSpans, err := decodeJSON([]byte(testSpansJSON))
+	require.NoError(t, err)
+	protobufSpans, err := decodeProtobuf(encodeTestSpanProtobuf(t))
+	require.NoError(t, err)
+	assert.Equal(t, jsonSpans, protobufSpans)
+
+	require.Len(t, jsonSpans, 1)
+	assert.Equal(t, span{
+		traceID:       pcommon.TraceID{0x59, 0x82, 0xfe, 0x77, 0x00, 0x83, 0x10, 0xcc, 0x80, 0xf1, 0xda, 0x5e, 0x10, 0x14, 0x75, 0x17},
+		id:            pcommon.SpanID{0xbd, 0x7a, 0x97, 0x75, 0x55, 0xf6, 0xb9, 0x82},
+		kind:          ptrace.SpanKindServer,
+		name:          "get /api",
+		timestamp:     1472470996199000,
+		duration:      207000,
+		localEndpoint: endpoint{serviceName: "frontend", ip: netip.MustParseAddr("127.0.0.1")},
+		tags:          map[string]string{"http.method": "GET", "http.path": "/api"},
+	}, jsonSpans[0])
+}
+
+func TestDecodeJSONInvalid(t *testing.T) {
+	for name, input := range map[string]string{
+		"trace_id_long":   `[{"traceId": "5982fe77008310cc80f1da5e1014751700", "id": "bd7a977555f6b982"}]`,
+		"trace_id_odd":    `[{"traceId": "5982fe77008310cc80f1da5e101475170", "id": "bd7a977555f6b982"}]`,
+		"trace_id_hex":    `[{"traceId": "zz82fe77008310cc", "id": "bd7a977555f6b982"}]`,
+		"span_id_short":   `[{"traceId": "5982fe77008310cc", "id": "bd7a9775"}]`,
+		"parent_id_short": `[{"traceId": "5982fe77008310cc", "id": "bd7a977555f6b982", "parentId": "01"}]`,
+		"kind":            `[{"traceId": "5982fe77008310cc", "id": "bd7a977555f6b982", "kind": "INTERNAL"}]`,
+		"ip":              `[{"traceId": "5982fe77008310cc", "id": "bd7a977555f6b982", "remoteEndpoint": {"ipv4": "x"}}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeJSON([]byte(input))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestToTraces(t *testing.T) {
+	spans, err := decodeJSON([]byte(`[{
+		"traceId": "80f1da5e10147517",
+		"id": "bd7a977555f6b982",
+		"parentId": "0102030405060708",
+		"name": "get",
+		"kind": "CLIENT",
+		"timestamp": 1000,
+		"duration": 500,
+		"localEndpoint": {"serviceName": "frontend", "ipv6": "::1", "port": 8080},
+		"remoteEndpoint": {"serviceName": "backend", "ipv4": "10.0.0.1", "port": 9000},
+		"annotations": [{"timestamp": 1200, "value": "ws"}],
+		"tags": {"error": "connection refused", "peer.hostname": "backend.local"}
+	}, {
+		"traceId": "80f1da5e10147517",
+		"id": "0102030405060708",
+		"name": "root",
+		"timestamp": 900,
+		"duration": 1000
+	}, {
+		"traceId": "80f1da5e10147517",
+		"id": "0807060504030201",
+		"parentId": "bd7a977555f6b982",
+		"timestamp": 1100,
+		"duration": 10,
+		"localEndpoint": {"serviceName": "frontend"},
+		"tags": {"error": ""}
+	}]`))
+	require.NoError(t, err)
+	traces, err := toTraces(spans, time.Now())
+	require.NoError(t, err)
+
+	require.Equal(t, 2, traces.ResourceSpans().Len())
+	frontend := traces.ResourceSpans().At(0)
+	serviceName, _ := frontend.Resource().Attributes().Get("service.name")
+	assert.Equal(t, "frontend", serviceName.Str())
+	unnamed := traces.ResourceSpans().At(1)
+	assert.Equal(t, 0, unnamed.Resource().Attributes().Len())
+	assert.Equal(t, 1, unnamed.ScopeSpans().At(0).Spans().Len())
+
+	frontendSpans := frontend.ScopeSpans().At(0).Spans()
+	require.Equal(t, 2, frontendSpans.Len())
+	s := frontendSpans.At(0)
+	assert.Equal(t, pcommon.TraceID{8: 0x80, 9: 0xf1, 10: 0xda, 11: 0x5e, 12: 0x10, 13: 0x14, 14: 0x75, 15: 0x17}, s.TraceID())
+	assert.Equal(t, pcommon.SpanID{1, 2, 3, 4, 5, 6, 7, 8}, s.ParentSpanID())
+	assert.Equal(t, ptrace.SpanKindClient, s.Kind())
+	assert.Equal(t, pcommon.Timestamp(1000000), s.StartTimestamp())
+	assert.Equal(t, pcommon.Timestamp(1500000), s.EndTimestamp())
+	assert.Equal(t, ptrace.StatusCodeError, s.Status().Code())
+	assert.Equal(t, "connection refused", s.Status().Message())
+	assert.Equal(t, map[string]any{
+		"net.host.ip":   "::1",
+		"net.host.port": int64(8080),
+		"peer.service":  "backend",
+		"net.peer.ip":   "10.0.0.1",
+		"net.peer.port": int64(9000),
+		"peer.hostname": "backend.local",
+	}, s.Attributes().AsRaw())
+	require.Equal(t, 1, s.Events().Len())
+	assert.Equal(t, "ws", s.Events().At(0).Name())
+	assert.Equal(t, pcommon.Timestamp(1200000), s.Events().At(0).Timestamp())
+
+	s = frontendSpans.At(1)
+	assert.Equal(t, ptrace.StatusCodeError, s.Status().Code())
+	assert.Equal(t, "", s.Status().Message())
+}
+
+func TestDecodeJSONOddLengthTraceID(t *testing.T) {
+	spans, err := decodeJSON([]byte(`[
+		{"traceId": "982fe77008310cc80f1da5e10147517", "id": "bd7a977555f6b982"},
+		{"traceId": "1", "id": "bd7a977555f6b982"}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, spans, 2)
+	assert.Equal(t, pcommon.TraceID{0x09, 0x82, 0xfe, 0x77, 0x00, 0x83, 0x10, 0xcc, 0x80, 0xf1, 0xda, 0x5e, 0x10, 0x14, 0x75, 0x17}, spans[0].traceID)
+	assert.Equal(t, pcommon.TraceID{15: 0x01}, spans[1].traceID)
+}
+
+func TestToTracesMissingTimestamp(t *testing.T) {
+	spans, err := decodeJSON([]byte(`[{
+		"traceId": "80f1da5e10147517",
+		"id": "bd7a977555f6b982",
+		"duration": 500,
+		"annotations": [{"timestamp": 1200, "value": "ws"}, {"timestamp": 1100, "value": "wr"}]
+	}, {
+		"traceId": "80f1da5e10147517",
+		"id": "0102030405060708",
+		"duration": 1000
+	}]`))
+	require.NoError(t, err)
+	received := time.Unix(1472470996, 199000000)
+	traces, err := toTraces(spans, received)
+	require.NoError(t, err)
+
+	spanSlice := traces.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	require.Equal(t, 2, spanSlice.Len())
+
+	// The earliest annotation is used for the start time.
+	s := spanSlice.At(0)
+	assert.Equal(t, pcommon.Timestamp(1100000), s.StartTimestamp())
+	assert.Equal(t, pcommon.Timestamp(1600000), s.EndTimestamp())
+
+	// With no annotations, the receive time is used for the start time.
+	s = spanSlice.At(1)
+	assert.Equal(t, pcommon.NewTimestampFromTime(received), s.StartTimestamp())
+	assert.Equal(t, pcommon.NewTimestampFromTime(received.Add(time.Millisecond)), s.EndTimestamp())
+}
+
+func TestDecodeProtobufInvalid(t *testing.T) {
+	for name, input := range map[string][]byte{
+		"truncated":     {0x0a, 0x05, 0x0a},
+		"trace_id_size": protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), []byte{0x0a, 0x01, 0x01}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeProtobuf(input)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -19,12 +19,32 @@ package auth
 
 import (
 	"context"
+	"fmt"
 )
 
-// allowAuth implements the Authorizer interface.
+// allowAuth implements the Authorizer interface, for clients with
+// unrestricted agent privileges.
 type allowAuth struct{}
 
-// Authorize always returns nil, indicating the request is authorized.
-func (allowAuth) Authorize(context.Context, Action, Resource) error {
+// Authorize returns nil, indicating the request is authorized, for all
+// actions except ActionAdmin, which requires the admin token.
+func (allowAuth) Authorize(_ context.Context, action Action, _ Resource) error {
+	if action == ActionAdmin {
+		return fmt.Errorf("%w: admin API requires the admin token", ErrUnauthorized)
+	}
+	return nil
+}
+
+// adminAuth implements the Authorizer interface, for clients
+// authenticated with the admin token.
+type adminAuth struct{}
+
+// Authorize returns nil, indicating the request is authorized, only for
+// ActionAdmin. The admin token is not an agent credential, so it does not
+// permit ingesting events, uploading assets, or any other agent action.
+func (adminAuth) Authorize(_ context.Context, action Action, _ Resource) error {
+	if action != ActionAdmin {
+		return fmt.Errorf("%w: admin token only permits access to the admin API", ErrUnauthorized)
+	}
 	return nil
 }
@@ -29,4 +29,27 @@ func TestAllowAuth(t *testing.T) {
 
 	err := handler.Authorize(context.Background(), "", Resource{})
 	assert.NoError(t, err)
+
+	err = handler.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.EqualError(t, err, "unauthorized: admin API requires the admin token")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestAdminAuth(t *testing.T) {
+	handler := adminAuth{}
+
+	err := handler.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.NoError(t, err)
+
+	for _, action := range []Action{
+		ActionAgentConfig,
+		ActionEventIngest,
+		ActionSourcemapUpload,
+		ActionMappingUpload,
+		ActionSampledTracesPublish,
+	} {
+		err := handler.Authorize(context.Background(), action, Resource{})
+		assert.EqualError(t, err, "unauthorized: admin token only permits access to the admin API")
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	}
 }
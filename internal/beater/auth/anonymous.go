@@ -74,6 +74,12 @@ func (a *anonymousAuth) Authorize(ctx context.Context, action Action, resource R
 		return nil
 	case ActionSourcemapUpload:
 		return fmt.Errorf("%w: anonymous access not permitted for sourcemap uploads", ErrUnauthorized)
+	case ActionMappingUpload:
+		return fmt.Errorf("%w: anonymous access not permitted for mapping file uploads", ErrUnauthorized)
+	case ActionSampledTracesPublish:
+		return fmt.Errorf("%w: anonymous access not permitted for sampled trace IDs", ErrUnauthorized)
+	case ActionAdmin:
+		return fmt.Errorf("%w: anonymous access not permitted for admin API", ErrUnauthorized)
 	default:
 		return fmt.Errorf("unknown action %q", action)
 	}
@@ -45,6 +45,27 @@ func TestAnonymousAuthorizer(t *testing.T) {
 			resource:     auth.Resource{AgentName: "iOS/swift", ServiceName: "opbeans-ios"},
 			expectErr:    fmt.Errorf(`%w: anonymous access not permitted for sourcemap uploads`, auth.ErrUnauthorized),
 		},
+		"deny_mapping_upload": {
+			allowAgent:   nil,
+			allowService: nil,
+			action:       auth.ActionMappingUpload,
+			resource:     auth.Resource{AgentName: "android/java", ServiceName: "opbeans-android"},
+			expectErr:    fmt.Errorf(`%w: anonymous access not permitted for mapping file uploads`, auth.ErrUnauthorized),
+		},
+		"deny_sampled_traces_publish": {
+			allowAgent:   nil,
+			allowService: nil,
+			action:       auth.ActionSampledTracesPublish,
+			resource:     auth.Resource{},
+			expectErr:    fmt.Errorf(`%w: anonymous access not permitted for sampled trace IDs`, auth.ErrUnauthorized),
+		},
+		"deny_admin": {
+			allowAgent:   nil,
+			allowService: nil,
+			action:       auth.ActionAdmin,
+			resource:     auth.Resource{},
+			expectErr:    fmt.Errorf(`%w: anonymous access not permitted for admin API`, auth.ErrUnauthorized),
+		},
 		"deny_unknown_action": {
 			allowAgent:   nil,
 			allowService: nil,
@@ -41,8 +41,22 @@ const (
 	// ResourceInternal is only valid for first authorization of a request.
 	// The API Key needs to grant privileges to additional resources for successful processing of requests.
 	ResourceInternal = es.Resource("-")
+
+	// resourceServicePrefix is the prefix of application resources which
+	// restrict an API Key's privileges to a specific service name.
+	resourceServicePrefix = "service:"
+
+	// resourceWildcard is the application resource which grants an API Key
+	// privileges for all services.
+	resourceWildcard = es.Resource("*")
 )
 
+// ResourceService returns the Elasticsearch application resource which
+// restricts API Key privileges to the named service.
+func ResourceService(serviceName string) es.Resource {
+	return es.Resource(resourceServicePrefix + serviceName)
+}
+
 var (
 	// PrivilegeAgentConfigRead identifies the Elasticsearch API Key privilege
 	// required for authorizing agent config queries.
@@ -72,7 +86,14 @@ type apikeyAuth struct {
 }
 
 type apikeyAuthorizer struct {
+	auth        *apikeyAuth
+	id          string
+	credentials string
 	permissions es.Permissions
+
+	// wildcardPermissions holds the privileges granted for all services,
+	// checked before querying the privileges for a specific service.
+	wildcardPermissions es.Permissions
 }
 
 func newApikeyAuth(client *es.Client, cache *privilegesCache) *apikeyAuth {
@@ -91,7 +112,9 @@ func (a *apikeyAuth) authenticate(ctx context.Context, credentials string) (*API
 	id := string(decoded[:colon])
 
 	// Check that the user has any privileges for the internal resource.
-	response, err := a.hasPrivileges(ctx, id, credentials, ResourceInternal)
+	// The wildcard resource is queried at the same time, so API Keys with
+	// privileges for all services do not require per-service lookups.
+	response, err := a.hasPrivileges(ctx, id, credentials, ResourceInternal, resourceWildcard)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -107,11 +130,20 @@ func (a *apikeyAuth) authenticate(ctx context.Context, credentials string) (*API
 		return nil, nil, ErrAuthFailed
 	}
 	details := &APIKeyAuthenticationDetails{ID: id, Username: response.Username}
-	return details, &apikeyAuthorizer{permissions}, nil
+	return details, &apikeyAuthorizer{
+		auth:                a,
+		id:                  id,
+		credentials:         credentials,
+		permissions:         permissions,
+		wildcardPermissions: response.Application[Application][resourceWildcard],
+	}, nil
 }
 
-func (a *apikeyAuth) hasPrivileges(ctx context.Context, id, credentials string, resource es.Resource) (*es.HasPrivilegesResponse, error) {
-	cacheKey := id + "_" + string(resource)
+func (a *apikeyAuth) hasPrivileges(ctx context.Context, id, credentials string, resources ...es.Resource) (*es.HasPrivilegesResponse, error) {
+	cacheKey := id
+	for _, resource := range resources {
+		cacheKey += "_" + string(resource)
+	}
 	if response, ok := a.cache.get(cacheKey); ok {
 		if response == nil {
 			return nil, ErrAuthFailed
@@ -132,7 +164,7 @@ func (a *apikeyAuth) hasPrivileges(ctx context.Context, id, credentials string,
 			// it is important to query all privilege actions because they are cached by api key+resources
 			// querying a.anyOfPrivileges would result in an incomplete cache entry
 			Privileges: AllPrivilegeActions(),
-			Resources:  []es.Resource{resource},
+			Resources:  resources,
 		}},
 	}
 	info, err := es.HasPrivileges(ctx, a.esClient, request, credentials)
@@ -154,26 +186,48 @@ func (a *apikeyAuth) hasPrivileges(ctx context.Context, id, credentials string,
 // An API Key is considered to be authorized when the API Key has the configured privileges
 // for the requested resource. Permissions are fetched from Elasticsearch and then cached in
 // a global cache.
-func (a *apikeyAuthorizer) Authorize(ctx context.Context, action Action, _ Resource) error {
-	// TODO if resource is non-zero, map to different application resources in the privilege queries.
-	//
-	// For now, having any valid "apm" application API Key grants access to any agent and service.
-	// In the future, it should be possible to have API Keys that can be restricted to a set of agent
-	// and service names.
+//
+// If resource.ServiceName is non-empty, the API Key must have the privileges for the
+// application resource "service:<name>", which is also granted by the wildcard resource "*".
+// Privileges for the wildcard resource are checked during authentication, so only API Keys
+// restricted to specific services require a lookup per service. Otherwise the privileges
+// for the internal resource, checked during authentication, are used.
+func (a *apikeyAuthorizer) Authorize(ctx context.Context, action Action, resource Resource) error {
 	var apikeyPrivilegeAction es.PrivilegeAction
 	switch action {
 	case ActionAgentConfig:
 		apikeyPrivilegeAction = PrivilegeAgentConfigRead.Action
-	case ActionEventIngest:
+	case ActionEventIngest, ActionSampledTracesPublish:
 		apikeyPrivilegeAction = PrivilegeEventWrite.Action
-	case ActionSourcemapUpload:
+	case ActionSourcemapUpload, ActionMappingUpload:
+		// There is no separate privilege for mapping files;
+		// sourcemap:write covers all debug asset uploads.
 		apikeyPrivilegeAction = PrivilegeSourcemapWrite.Action
+	case ActionAdmin:
+		// There is no API Key privilege granting admin access;
+		// the admin API requires the admin token.
+		return fmt.Errorf("%w: API Key not permitted action %q", ErrUnauthorized, action)
 	default:
 		return fmt.Errorf("unknown action %q", action)
 	}
-	if a.permissions[apikeyPrivilegeAction] {
+	permissions := a.permissions
+	if resource.ServiceName != "" && !a.wildcardPermissions[apikeyPrivilegeAction] {
+		esResource := ResourceService(resource.ServiceName)
+		response, err := a.auth.hasPrivileges(ctx, a.id, a.credentials, esResource)
+		if err != nil {
+			return err
+		}
+		permissions = response.Application[Application][esResource]
+	}
+	if permissions[apikeyPrivilegeAction] {
 		return nil
 	}
+	if resource.ServiceName != "" {
+		return fmt.Errorf(
+			"%w: API Key not permitted action %q for service %q",
+			ErrUnauthorized, apikeyPrivilegeAction, resource.ServiceName,
+		)
+	}
 	return fmt.Errorf("%w: API Key not permitted action %q", ErrUnauthorized, apikeyPrivilegeAction)
 }
 
@@ -20,9 +20,12 @@ package auth
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -68,6 +71,107 @@ func TestAPIKeyAuthorizer(t *testing.T) {
 	assert.EqualError(t, err, `unauthorized: API Key not permitted action "sourcemap:write"`)
 	assert.True(t, errors.Is(err, ErrUnauthorized))
 
+	err = authz.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.EqualError(t, err, `unauthorized: API Key not permitted action "admin"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
 	err = authz.Authorize(context.Background(), "unknown", Resource{})
 	assert.EqualError(t, err, `unknown action "unknown"`)
 }
+
+func TestAPIKeyAuthorizerServiceResource(t *testing.T) {
+	// The API Key has privileges for the internal resource
+	// and the "checkout" service, but no other services.
+	srv, requestedResources := newHasPrivilegesServer(t, func(resource string) string {
+		switch resource {
+		case "-", "service:checkout":
+			return `{"config_agent:read": true, "event:write": true, "sourcemap:write": false}`
+		}
+		return `{"config_agent:read": false, "event:write": false, "sourcemap:write": false}`
+	})
+	authz := newTestAPIKeyAuthorizer(t, srv.URL, 10)
+
+	err := authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "checkout"})
+	assert.NoError(t, err)
+
+	err = authz.Authorize(context.Background(), ActionAgentConfig, Resource{ServiceName: "checkout"})
+	assert.NoError(t, err)
+
+	err = authz.Authorize(context.Background(), ActionSourcemapUpload, Resource{ServiceName: "checkout"})
+	assert.EqualError(t, err, `unauthorized: API Key not permitted action "sourcemap:write" for service "checkout"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	err = authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "frontend"})
+	assert.EqualError(t, err, `unauthorized: API Key not permitted action "event:write" for service "frontend"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	// Privileges are cached per API Key and resource.
+	err = authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "frontend"})
+	assert.Error(t, err)
+	assert.Equal(t, [][]string{{"-", "*"}, {"service:checkout"}, {"service:frontend"}}, *requestedResources)
+}
+
+func TestAPIKeyAuthorizerWildcardResource(t *testing.T) {
+	// The API Key has privileges for all services.
+	srv, requestedResources := newHasPrivilegesServer(t, func(resource string) string {
+		return `{"config_agent:read": true, "event:write": true, "sourcemap:write": false}`
+	})
+	// The cache limit is lower than the number of services; services
+	// must not be looked up individually, or the limit would be reached.
+	authz := newTestAPIKeyAuthorizer(t, srv.URL, 5)
+
+	for i := 0; i < 100; i++ {
+		err := authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: fmt.Sprintf("service-%d", i)})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, [][]string{{"-", "*"}}, *requestedResources)
+
+	// Privileges not granted by the wildcard resource
+	// are still checked for the specific service.
+	err := authz.Authorize(context.Background(), ActionSourcemapUpload, Resource{ServiceName: "service-0"})
+	assert.EqualError(t, err, `unauthorized: API Key not permitted action "sourcemap:write" for service "service-0"`)
+	assert.Equal(t, [][]string{{"-", "*"}, {"service:service-0"}}, *requestedResources)
+}
+
+// newHasPrivilegesServer returns a fake Elasticsearch server which responds to
+// _has_privileges requests with the permissions returned by permissions for
+// each requested resource, and records the requested resources.
+func newHasPrivilegesServer(t testing.TB, permissions func(resource string) string) (*httptest.Server, *[][]string) {
+	var requestedResources [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Application []struct {
+				Resources []string `json:"resources"`
+			} `json:"application"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		resources := body.Application[0].Resources
+		requestedResources = append(requestedResources, resources)
+
+		resourcePermissions := make([]string, len(resources))
+		for i, resource := range resources {
+			resourcePermissions[i] = fmt.Sprintf("%q: %s", resource, permissions(resource))
+		}
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{
+                  "username": "api_key_username",
+                  "application": {"apm": {%s}}
+                }`, strings.Join(resourcePermissions, ", "))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requestedResources
+}
+
+func newTestAPIKeyAuthorizer(t testing.TB, esURL string, limit int) Authorizer {
+	esConfig := elasticsearch.DefaultConfig()
+	esConfig.Hosts = elasticsearch.Hosts{esURL}
+	apikeyAuthConfig := config.APIKeyAgentAuth{Enabled: true, LimitPerMin: limit, ESConfig: esConfig}
+	authenticator, err := NewAuthenticator(config.AgentAuth{APIKey: apikeyAuthConfig})
+	require.NoError(t, err)
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("valid_id:key_value"))
+	_, authz, err := authenticator.Authenticate(context.Background(), headers.APIKey, credentials)
+	require.NoError(t, err)
+	return authz
+}
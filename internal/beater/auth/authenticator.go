@@ -45,9 +45,25 @@ const (
 	MethodAPIKey Method = "api_key"
 
 	// MethodSecretToken identifies the auth methd using a shared secret token.
-	// Clients with this secret token have unrestricted privileges.
+	// Clients with one of the secret tokens have unrestricted privileges,
+	// except for access to the admin API.
 	MethodSecretToken Method = "secret_token"
 
+	// MethodAdminToken identifies the auth method using the admin token.
+	// Clients with the admin token have unrestricted privileges, including
+	// access to the admin API.
+	MethodAdminToken Method = "admin_token"
+
+	// MethodJWT identifies the auth method using JSON Web Tokens issued by
+	// a trusted identity provider. Clients that authenticate with a JWT may
+	// have restricted privileges.
+	MethodJWT Method = "jwt"
+
+	// MethodClientCertificate identifies the auth method using verified TLS
+	// client certificates. Clients that authenticate with a client certificate
+	// may be restricted by agent and/or service.
+	MethodClientCertificate Method = "client_certificate"
+
 	// MethodAnonymous identifies the anonymous access auth method.
 	// Anonymous clients will typically be restricted by agent and/or service.
 	MethodAnonymous Method = ""
@@ -65,6 +81,18 @@ const (
 
 	// ActionSourcemapUpload is an Action describing an attempt to upload a source map.
 	ActionSourcemapUpload Action = "sourcemap"
+
+	// ActionMappingUpload is an Action describing an attempt to upload an
+	// R8/ProGuard mapping file.
+	ActionMappingUpload Action = "mapping"
+
+	// ActionSampledTracesPublish is an Action describing an attempt by
+	// another APM Server to publish tail-based sampling decisions.
+	ActionSampledTracesPublish Action = "sampled_traces_publish"
+
+	// ActionAdmin is an Action describing an attempt to access the admin API.
+	// Only clients authenticated with the admin token may perform this action.
+	ActionAdmin Action = "admin"
 )
 
 const (
@@ -86,10 +114,13 @@ var ErrUnauthorized = errors.New("unauthorized")
 
 // Authenticator authenticates clients.
 type Authenticator struct {
-	secretToken string
+	secretTokens *secretTokenAuth
+	adminToken   []byte
 
-	apikey    *apikeyAuth
-	anonymous *anonymousAuth
+	apikey     *apikeyAuth
+	jwt        *jwtAuth
+	clientCert *clientCertAuth
+	anonymous  *anonymousAuth
 }
 
 // Authorizer provides an interface for authorizing an action and resource.
@@ -127,6 +158,18 @@ type AuthenticationDetails struct {
 	// APIKey holds authentication details related to API Key auth.
 	// This will be set when Method is MethodAPIKey.
 	APIKey *APIKeyAuthenticationDetails
+
+	// SecretToken holds authentication details related to secret token auth.
+	// This will be set when Method is MethodSecretToken.
+	SecretToken *SecretTokenAuthenticationDetails
+
+	// JWT holds authentication details related to JWT auth.
+	// This will be set when Method is MethodJWT.
+	JWT *JWTAuthenticationDetails
+
+	// ClientCertificate holds authentication details related to TLS client
+	// certificate auth. This will be set when Method is MethodClientCertificate.
+	ClientCertificate *ClientCertificateAuthenticationDetails
 }
 
 // APIKeyAuthenticationDetails holds API Key related authentication details.
@@ -138,10 +181,36 @@ type APIKeyAuthenticationDetails struct {
 	Username string
 }
 
+// SecretTokenAuthenticationDetails holds secret token related authentication details.
+type SecretTokenAuthenticationDetails struct {
+	// Name holds the name of the secret token. The secret token defined by
+	// the "secret_token" config is named config.DefaultSecretTokenName.
+	Name string
+}
+
+// JWTAuthenticationDetails holds JWT related authentication details.
+type JWTAuthenticationDetails struct {
+	// Issuer holds the "iss" claim of the JWT.
+	Issuer string
+
+	// Subject holds the "sub" claim of the JWT, identifying the client.
+	Subject string
+}
+
+// ClientCertificateAuthenticationDetails holds TLS client certificate related
+// authentication details.
+type ClientCertificateAuthenticationDetails struct {
+	// Subject holds the distinguished name of the client certificate's subject.
+	Subject string
+}
+
 // NewAuthenticator creates an Authenticator with config, authenticating
 // clients with one of the allowed methods.
 func NewAuthenticator(cfg config.AgentAuth) (*Authenticator, error) {
-	b := Authenticator{secretToken: cfg.SecretToken}
+	b := Authenticator{secretTokens: newSecretTokenAuth(cfg)}
+	if cfg.AdminToken != "" {
+		b.adminToken = []byte(cfg.AdminToken)
+	}
 	if cfg.APIKey.Enabled {
 		// Do not use apm-server's credentials for API Key requests;
 		// we should only use API Key credentials provided by clients
@@ -157,6 +226,16 @@ func NewAuthenticator(cfg config.AgentAuth) (*Authenticator, error) {
 		cache := newPrivilegesCache(cacheTimeoutMinute, cfg.APIKey.LimitPerMin)
 		b.apikey = newApikeyAuth(client, cache)
 	}
+	if cfg.JWT.Enabled {
+		jwt, err := newJWTAuth(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		b.jwt = jwt
+	}
+	if cfg.ClientCertificate.Enabled {
+		b.clientCert = newClientCertAuth(cfg.ClientCertificate)
+	}
 	if cfg.Anonymous.Enabled {
 		b.anonymous = newAnonymousAuth(cfg.Anonymous.AllowAgent, cfg.Anonymous.AllowService)
 	}
@@ -167,21 +246,42 @@ func NewAuthenticator(cfg config.AgentAuth) (*Authenticator, error) {
 // returning the authentication details and an Authorizer for authorizing specific
 // actions and resources.
 //
+// The admin token is accepted regardless of which other auth methods are
+// configured, and is the only credential permitting ActionAdmin. It permits
+// no other action.
+//
+// If kind is empty and ctx holds a verified TLS client certificate (see
+// ContextWithClientCertificate), the client certificate will be checked
+// before falling back to anonymous access.
+//
 // Authenticate will return ErrAuthFailed (possibly wrapped) if at least one auth
 // method is configured and no valid credentials have been supplied. Other errors
 // may be returned, for example because the server cannot communicate with external
 // systems.
 func (a *Authenticator) Authenticate(ctx context.Context, kind string, token string) (AuthenticationDetails, Authorizer, error) {
-	if a.apikey == nil && a.secretToken == "" {
+	if kind == headers.Bearer && a.adminToken != nil &&
+		subtle.ConstantTimeCompare(a.adminToken, []byte(token)) == 1 {
+		return AuthenticationDetails{Method: MethodAdminToken}, adminAuth{}, nil
+	}
+	if a.apikey == nil && a.jwt == nil && a.clientCert == nil && a.secretTokens == nil {
 		// No auth required, let everyone through.
 		return AuthenticationDetails{Method: MethodNone}, allowAuth{}, nil
 	}
 	switch kind {
 	case "":
+		err := errAuthMissing
+		if cert, ok := clientCertificateFromContext(ctx); ok && a.clientCert != nil {
+			var details *ClientCertificateAuthenticationDetails
+			var authz *clientCertAuthorizer
+			details, authz, err = a.clientCert.authenticate(cert)
+			if err == nil {
+				return AuthenticationDetails{Method: MethodClientCertificate, ClientCertificate: details}, authz, nil
+			}
+		}
 		if a.anonymous != nil {
 			return AuthenticationDetails{Method: MethodAnonymous}, a.anonymous, nil
 		}
-		return AuthenticationDetails{}, nil, errAuthMissing
+		return AuthenticationDetails{}, nil, err
 	case headers.APIKey:
 		if a.apikey != nil {
 			details, authz, err := a.apikey.authenticate(ctx, token)
@@ -191,8 +291,21 @@ func (a *Authenticator) Authenticate(ctx context.Context, kind string, token str
 			return AuthenticationDetails{Method: MethodAPIKey, APIKey: details}, authz, nil
 		}
 	case headers.Bearer:
-		if a.secretToken != "" && subtle.ConstantTimeCompare([]byte(a.secretToken), []byte(token)) == 1 {
-			return AuthenticationDetails{Method: MethodSecretToken}, allowAuth{}, nil
+		if a.secretTokens != nil {
+			details, ok, err := a.secretTokens.authenticate(token)
+			if err != nil {
+				return AuthenticationDetails{}, nil, err
+			}
+			if ok {
+				return AuthenticationDetails{Method: MethodSecretToken, SecretToken: details}, allowAuth{}, nil
+			}
+		}
+		if a.jwt != nil && isJWT(token) {
+			details, authz, err := a.jwt.authenticate(ctx, token)
+			if err != nil {
+				return AuthenticationDetails{}, nil, err
+			}
+			return AuthenticationDetails{Method: MethodJWT, JWT: details}, authz, nil
 		}
 	default:
 		return AuthenticationDetails{}, nil, fmt.Errorf(
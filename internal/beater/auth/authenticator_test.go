@@ -86,8 +86,39 @@ func TestAuthenticatorSecretToken(t *testing.T) {
 
 	details, authz, err = authenticator.Authenticate(context.Background(), headers.Bearer, "valid")
 	assert.NoError(t, err)
-	assert.Equal(t, AuthenticationDetails{Method: MethodSecretToken}, details)
+	assert.Equal(t, AuthenticationDetails{
+		Method:      MethodSecretToken,
+		SecretToken: &SecretTokenAuthenticationDetails{Name: config.DefaultSecretTokenName},
+	}, details)
 	assert.Equal(t, allowAuth{}, authz)
+
+	// Secret tokens do not grant access to the admin API.
+	err = authz.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+}
+
+func TestAuthenticatorAdminToken(t *testing.T) {
+	for name, cfg := range map[string]config.AgentAuth{
+		"no_agent_auth": {AdminToken: "admin"},
+		"secret_token":  {AdminToken: "admin", SecretToken: "valid"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			authenticator, err := NewAuthenticator(cfg)
+			require.NoError(t, err)
+
+			details, authz, err := authenticator.Authenticate(context.Background(), headers.Bearer, "admin")
+			require.NoError(t, err)
+			assert.Equal(t, AuthenticationDetails{Method: MethodAdminToken}, details)
+			assert.NoError(t, authz.Authorize(context.Background(), ActionAdmin, Resource{}))
+			assert.ErrorIs(t, authz.Authorize(context.Background(), ActionEventIngest, Resource{}), ErrUnauthorized)
+
+			// The admin token is only accepted as a bearer token.
+			_, authz, err = authenticator.Authenticate(context.Background(), headers.APIKey, "admin")
+			if err == nil {
+				assert.Error(t, authz.Authorize(context.Background(), ActionAdmin, Resource{}))
+			}
+		})
+	}
 }
 
 func TestAuthenticatorAPIKey(t *testing.T) {
@@ -127,14 +158,15 @@ func TestAuthenticatorAPIKey(t *testing.T) {
 			Username: "api_key_username",
 		},
 	}, details)
-	assert.Equal(t, &apikeyAuthorizer{permissions: elasticsearch.Permissions{
+	require.IsType(t, &apikeyAuthorizer{}, authz)
+	assert.Equal(t, elasticsearch.Permissions{
 		"config_agent:read": true,
 		"event:write":       true,
 		"sourcemap:write":   false,
-	}}, authz)
+	}, authz.(*apikeyAuthorizer).permissions)
 
 	assert.Equal(t, "/_security/user/_has_privileges", requestURLPath)
-	assert.Equal(t, `{"application":[{"application":"apm","privileges":["config_agent:read","event:write","sourcemap:write"],"resources":["-"]}]}`+"\n", string(requestBody))
+	assert.Equal(t, `{"application":[{"application":"apm","privileges":["config_agent:read","event:write","sourcemap:write"],"resources":["-","*"]}]}`+"\n", string(requestBody))
 	assert.Equal(t, "ApiKey "+credentials, requestAuthorizationHeader)
 }
 
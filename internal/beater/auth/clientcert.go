@@ -0,0 +1,164 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+)
+
+// clientCertAuth authenticates clients using verified TLS client
+// certificates, matching them against a list of rules.
+type clientCertAuth struct {
+	rules []clientCertRule
+}
+
+type clientCertRule struct {
+	subject *regexp.Regexp // nil if unspecified
+	san     *regexp.Regexp // nil if unspecified
+	authz   *clientCertAuthorizer
+}
+
+func newClientCertAuth(cfg config.ClientCertificateAgentAuth) *clientCertAuth {
+	rules := make([]clientCertRule, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		rules[i] = clientCertRule{
+			subject: compileCertPattern(rule.Subject),
+			san:     compileCertPattern(rule.SAN),
+			authz:   newClientCertAuthorizer(rule.AllowAgent, rule.AllowService),
+		}
+	}
+	return &clientCertAuth{rules: rules}
+}
+
+// compileCertPattern compiles a pattern, where "*" matches any sequence
+// of characters and all other characters match literally.
+func compileCertPattern(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+func (a *clientCertAuth) authenticate(cert *x509.Certificate) (*ClientCertificateAuthenticationDetails, *clientCertAuthorizer, error) {
+	subject := cert.Subject.String()
+	for _, rule := range a.rules {
+		if rule.subject != nil && !rule.subject.MatchString(subject) {
+			continue
+		}
+		if rule.san != nil && !matchAnySAN(rule.san, cert) {
+			continue
+		}
+		details := &ClientCertificateAuthenticationDetails{Subject: subject}
+		return details, rule.authz, nil
+	}
+	return nil, nil, fmt.Errorf("%w: client certificate %q does not match any rules", ErrAuthFailed, subject)
+}
+
+func matchAnySAN(pattern *regexp.Regexp, cert *x509.Certificate) bool {
+	for _, name := range cert.DNSNames {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if pattern.MatchString(email) {
+			return true
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if pattern.MatchString(ip.String()) {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if pattern.MatchString(uri.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientCertAuthorizer implements the Authorizer interface, authorizing clients
+// authenticated with a client certificate according to the allowed agents and
+// services of the matching rule.
+type clientCertAuthorizer struct {
+	allowedAgents   map[string]bool
+	allowedServices map[string]bool
+}
+
+func newClientCertAuthorizer(allowAgent, allowService []string) *clientCertAuthorizer {
+	a := &clientCertAuthorizer{
+		allowedAgents:   make(map[string]bool),
+		allowedServices: make(map[string]bool),
+	}
+	for _, name := range allowAgent {
+		a.allowedAgents[name] = true
+	}
+	for _, name := range allowService {
+		a.allowedServices[name] = true
+	}
+	return a
+}
+
+// Authorize checks if the client certificate is authorized for the given action and resource.
+//
+// Unlike anonymous access, source map uploads are permitted for the allowed services.
+func (a *clientCertAuthorizer) Authorize(ctx context.Context, action Action, resource Resource) error {
+	switch action {
+	case ActionAgentConfig, ActionSourcemapUpload, ActionMappingUpload:
+		// Agent config queries and asset uploads do not provide an
+		// agent name, so only the service name is checked here.
+		if len(a.allowedServices) != 0 && !a.allowedServices[resource.ServiceName] {
+			return fmt.Errorf(
+				"%w: client certificate not permitted for service %q",
+				ErrUnauthorized, resource.ServiceName,
+			)
+		}
+		return nil
+	case ActionEventIngest:
+		if len(a.allowedServices) != 0 && !a.allowedServices[resource.ServiceName] {
+			return fmt.Errorf(
+				"%w: client certificate not permitted for service %q",
+				ErrUnauthorized, resource.ServiceName,
+			)
+		}
+		if len(a.allowedAgents) != 0 && !a.allowedAgents[resource.AgentName] {
+			return fmt.Errorf(
+				"%w: client certificate not permitted for agent %q",
+				ErrUnauthorized, resource.AgentName,
+			)
+		}
+		return nil
+	case ActionSampledTracesPublish:
+		return fmt.Errorf("%w: client certificate not permitted for sampled trace IDs", ErrUnauthorized)
+	case ActionAdmin:
+		return fmt.Errorf("%w: client certificate not permitted for admin API", ErrUnauthorized)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
@@ -0,0 +1,166 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+)
+
+func TestClientCertAuthenticate(t *testing.T) {
+	clientCertAuth := newClientCertAuth(config.ClientCertificateAgentAuth{
+		Enabled: true,
+		Rules: []config.ClientCertificateRule{{
+			Subject:      "CN=opbeans-*,O=Elastic",
+			AllowService: []string{"opbeans-go"},
+		}, {
+			SAN:        "spiffe://cluster.local/ns/*/sa/opbeans",
+			AllowAgent: []string{"go"},
+		}, {
+			Subject: "CN=*",
+			SAN:     "*.internal",
+		}, {
+			SAN: "10.0.0.*",
+		}},
+	})
+
+	spiffeURI, err := url.Parse("spiffe://cluster.local/ns/default/sa/opbeans")
+	require.NoError(t, err)
+
+	for name, test := range map[string]struct {
+		cert        *x509.Certificate
+		expectRule  int
+		expectError error
+	}{
+		"subject": {
+			cert:       &x509.Certificate{Subject: pkix.Name{CommonName: "opbeans-go", Organization: []string{"Elastic"}}},
+			expectRule: 0,
+		},
+		"san_uri": {
+			cert:       &x509.Certificate{Subject: pkix.Name{CommonName: "workload"}, URIs: []*url.URL{spiffeURI}},
+			expectRule: 1,
+		},
+		"subject_and_san_dns": {
+			cert:       &x509.Certificate{Subject: pkix.Name{CommonName: "workload"}, DNSNames: []string{"apm.internal"}},
+			expectRule: 2,
+		},
+		"san_ip": {
+			cert:       &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+			expectRule: 3,
+		},
+		"subject_mismatch": {
+			cert:        &x509.Certificate{Subject: pkix.Name{CommonName: "opbeans-go", Organization: []string{"Other"}}},
+			expectError: fmt.Errorf(`%w: client certificate "CN=opbeans-go,O=Other" does not match any rules`, ErrAuthFailed),
+		},
+		"san_mismatch": {
+			cert:        &x509.Certificate{EmailAddresses: []string{"admin@apm.internal"}},
+			expectError: fmt.Errorf(`%w: client certificate "" does not match any rules`, ErrAuthFailed),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			details, authz, err := clientCertAuth.authenticate(test.cert)
+			if test.expectError != nil {
+				assert.Equal(t, test.expectError, err)
+				assert.True(t, errors.Is(err, ErrAuthFailed))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, &ClientCertificateAuthenticationDetails{Subject: test.cert.Subject.String()}, details)
+			assert.Same(t, clientCertAuth.rules[test.expectRule].authz, authz)
+		})
+	}
+}
+
+func TestClientCertAuthorizer(t *testing.T) {
+	authz := newClientCertAuthorizer([]string{"go"}, []string{"opbeans-go"})
+	ctx := context.Background()
+	allowed := Resource{AgentName: "go", ServiceName: "opbeans-go"}
+
+	assert.NoError(t, authz.Authorize(ctx, ActionAgentConfig, allowed))
+	assert.NoError(t, authz.Authorize(ctx, ActionEventIngest, allowed))
+	assert.NoError(t, authz.Authorize(ctx, ActionSourcemapUpload, allowed))
+	assert.NoError(t, authz.Authorize(ctx, ActionMappingUpload, allowed))
+
+	err := authz.Authorize(ctx, ActionEventIngest, Resource{AgentName: "java", ServiceName: "opbeans-go"})
+	assert.EqualError(t, err, `unauthorized: client certificate not permitted for agent "java"`)
+	err = authz.Authorize(ctx, ActionSourcemapUpload, Resource{ServiceName: "opbeans-java"})
+	assert.EqualError(t, err, `unauthorized: client certificate not permitted for service "opbeans-java"`)
+	err = authz.Authorize(ctx, ActionSampledTracesPublish, Resource{})
+	assert.EqualError(t, err, `unauthorized: client certificate not permitted for sampled trace IDs`)
+	err = authz.Authorize(ctx, ActionAdmin, Resource{})
+	assert.EqualError(t, err, `unauthorized: client certificate not permitted for admin API`)
+
+	// Empty allow lists permit all agents and services.
+	authz = newClientCertAuthorizer(nil, nil)
+	assert.NoError(t, authz.Authorize(ctx, ActionEventIngest, Resource{AgentName: "java", ServiceName: "opbeans-java"}))
+}
+
+func TestAuthenticatorClientCertificate(t *testing.T) {
+	authenticator, err := NewAuthenticator(config.AgentAuth{
+		ClientCertificate: config.ClientCertificateAgentAuth{
+			Enabled: true,
+			Rules:   []config.ClientCertificateRule{{Subject: "CN=opbeans"}},
+		},
+	})
+	require.NoError(t, err)
+
+	// Without a client certificate in the context, clients must supply credentials.
+	_, _, err = authenticator.Authenticate(context.Background(), "", "")
+	assert.Equal(t, errAuthMissing, err)
+
+	ctx := ContextWithClientCertificate(context.Background(), &x509.Certificate{
+		Subject: pkix.Name{CommonName: "opbeans"},
+	})
+	details, authz, err := authenticator.Authenticate(ctx, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, AuthenticationDetails{
+		Method:            MethodClientCertificate,
+		ClientCertificate: &ClientCertificateAuthenticationDetails{Subject: "CN=opbeans"},
+	}, details)
+	assert.Equal(t, newClientCertAuthorizer(nil, nil), authz)
+
+	ctx = ContextWithClientCertificate(context.Background(), &x509.Certificate{
+		Subject: pkix.Name{CommonName: "other"},
+	})
+	_, _, err = authenticator.Authenticate(ctx, "", "")
+	assert.EqualError(t, err, `authentication failed: client certificate "CN=other" does not match any rules`)
+
+	// Anonymous access is used when the client certificate does not match any rules.
+	authenticator, err = NewAuthenticator(config.AgentAuth{
+		ClientCertificate: config.ClientCertificateAgentAuth{
+			Enabled: true,
+			Rules:   []config.ClientCertificateRule{{Subject: "CN=opbeans"}},
+		},
+		Anonymous: config.AnonymousAgentAuth{Enabled: true},
+	})
+	require.NoError(t, err)
+	details, _, err = authenticator.Authenticate(ctx, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, AuthenticationDetails{Method: MethodAnonymous}, details)
+}
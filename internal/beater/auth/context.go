@@ -19,6 +19,7 @@ package auth
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 )
 
@@ -27,6 +28,10 @@ var ErrNoAuthorizer = errors.New("no Authorizer in context")
 
 type authorizationKey struct{}
 
+type clientCertificateKey struct{}
+
+type authenticationDetailsKey struct{}
+
 // ContextWithAuthorizer returns a copy of parent associated with auth.
 func ContextWithAuthorizer(parent context.Context, auth Authorizer) context.Context {
 	return context.WithValue(parent, authorizationKey{}, auth)
@@ -49,3 +54,29 @@ func Authorize(ctx context.Context, action Action, resource Resource) error {
 	}
 	return auth.Authorize(ctx, action, resource)
 }
+
+// ContextWithAuthenticationDetails returns a copy of parent associated with details.
+func ContextWithAuthenticationDetails(parent context.Context, details AuthenticationDetails) context.Context {
+	return context.WithValue(parent, authenticationDetailsKey{}, details)
+}
+
+// AuthenticationDetailsFromContext returns the AuthenticationDetails stored in ctx,
+// if any, and a boolean indicating whether they were found.
+func AuthenticationDetailsFromContext(ctx context.Context) (AuthenticationDetails, bool) {
+	details, ok := ctx.Value(authenticationDetailsKey{}).(AuthenticationDetails)
+	return details, ok
+}
+
+// ContextWithClientCertificate returns a copy of parent associated with cert,
+// the verified TLS client certificate of the peer. The certificate will be
+// considered by Authenticator.Authenticate when no credentials are supplied.
+func ContextWithClientCertificate(parent context.Context, cert *x509.Certificate) context.Context {
+	return context.WithValue(parent, clientCertificateKey{}, cert)
+}
+
+// clientCertificateFromContext returns the verified TLS client certificate
+// stored in ctx, if any, and a boolean indicating whether one was found.
+func clientCertificateFromContext(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(clientCertificateKey{}).(*x509.Certificate)
+	return cert, ok && cert != nil
+}
@@ -0,0 +1,244 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksFetchTimeout is the timeout for fetching a remote JWKS.
+	jwksFetchTimeout = 10 * time.Second
+
+	// jwksMinRefreshInterval is the minimum interval between attempts to
+	// fetch a remote JWKS, to avoid clients being able to trigger excessive
+	// requests with tokens signed by unknown keys.
+	jwksMinRefreshInterval = 10 * time.Second
+
+	// maxJWKSSize is the maximum size of a JWKS in bytes.
+	maxJWKSSize = 1024 * 1024
+)
+
+// jwksProvider provides JSON Web Key Sets for verifying JWT signatures.
+type jwksProvider interface {
+	// keys returns the keys with the given key ID, or all keys if kid is empty.
+	keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error)
+}
+
+// staticJWKS is a jwksProvider for a JWKS which never changes,
+// such as one loaded from a local file.
+type staticJWKS []jose.JSONWebKey
+
+func loadJWKSFile(path string) (staticJWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file %q: %w", path, err)
+	}
+	return keys, nil
+}
+
+func (s staticJWKS) keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	return filterJWKS(s, kid), nil
+}
+
+// remoteJWKS is a jwksProvider for a JWKS fetched from a URL, which is
+// cached and refreshed periodically. The JWKS is additionally refreshed
+// when a token is signed with an unknown key ID, to handle key rotation.
+// Fetches are attempted at most once every jwksMinRefreshInterval.
+//
+// Fetches happen in the background, detached from the requests which
+// trigger them, and are shared by concurrent requests. Requests are only
+// blocked waiting for a fetch if no keys have been fetched yet, or if the
+// token's key ID is unknown; otherwise the cached keys are used while the
+// JWKS is refreshed.
+type remoteJWKS struct {
+	client          *http.Client
+	url             string
+	refreshInterval time.Duration
+	now             func() time.Time
+	group           singleflight.Group
+
+	mu        sync.Mutex
+	cached    []jose.JSONWebKey
+	fetched   time.Time
+	attempted time.Time
+	fetching  bool
+	fetchErr  error
+}
+
+func newRemoteJWKS(url string, refreshInterval time.Duration) *remoteJWKS {
+	return &remoteJWKS{
+		client:          &http.Client{Timeout: jwksFetchTimeout},
+		url:             url,
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+	}
+}
+
+func (r *remoteJWKS) keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	r.mu.Lock()
+	now := r.now()
+	keys := filterJWKS(r.cached, kid)
+	fetched := !r.fetched.IsZero()
+	stale := !fetched || now.Sub(r.fetched) >= r.refreshInterval
+	unknownKey := kid != "" && len(keys) == 0
+	var refreshed <-chan singleflight.Result
+	if (stale || unknownKey) && now.Sub(r.attempted) >= jwksMinRefreshInterval {
+		r.attempted = now
+		r.fetching = true
+		refreshed = r.group.DoChan("", r.refresh)
+	} else if r.fetching && (!fetched || unknownKey) {
+		// Wait for the fetch already in progress.
+		refreshed = r.group.DoChan("", r.refresh)
+	}
+	r.mu.Unlock()
+
+	if fetched && !unknownKey || refreshed == nil {
+		// Continue using the previously fetched keys,
+		// while any refresh completes in the background.
+		return r.result(kid)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-refreshed:
+		return r.result(kid)
+	}
+}
+
+// result returns the cached keys with the given key ID, or an error
+// if the JWKS has not been fetched successfully.
+func (r *remoteJWKS) result(kid string) ([]jose.JSONWebKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fetched.IsZero() {
+		if r.fetchErr != nil {
+			return nil, r.fetchErr
+		}
+		return nil, errors.New("JWKS has not been fetched")
+	}
+	return filterJWKS(r.cached, kid), nil
+}
+
+// refresh fetches the JWKS and updates the cached keys. If fetching
+// fails, the previously fetched keys continue to be used, and fetching
+// is retried at the next opportunity.
+func (r *remoteJWKS) refresh() (interface{}, error) {
+	keys, err := r.fetch(context.Background())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fetching = false
+	r.fetchErr = err
+	if err == nil {
+		r.cached = keys
+		r.fetched = r.now()
+	}
+	return nil, err
+}
+
+func (r *remoteJWKS) fetch(ctx context.Context) ([]jose.JSONWebKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: unexpected status %q", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	return keys, nil
+}
+
+func filterJWKS(keys []jose.JSONWebKey, kid string) []jose.JSONWebKey {
+	if kid == "" {
+		return keys
+	}
+	var filtered []jose.JSONWebKey
+	for _, key := range keys {
+		if key.KeyID == kid {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
+}
+
+// parseJWKS parses a JSON Web Key Set, as defined in RFC 7517, returning
+// the public signature verification keys. Keys with unsupported types,
+// and keys intended for encryption, are ignored.
+func parseJWKS(data []byte) ([]jose.JSONWebKey, error) {
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make([]jose.JSONWebKey, 0, len(jwks.Keys))
+	for _, data := range jwks.Keys {
+		var params struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+		}
+		if err := json.Unmarshal(data, &params); err != nil {
+			return nil, err
+		}
+		if params.Use != "" && params.Use != "sig" {
+			continue
+		}
+		switch {
+		case params.Kty == "RSA", params.Kty == "EC":
+		case params.Kty == "OKP" && params.Crv == "Ed25519":
+		default:
+			continue
+		}
+		var key jose.JSONWebKey
+		if err := key.UnmarshalJSON(data); err != nil {
+			return nil, fmt.Errorf("invalid %s key %q: %w", params.Kty, params.Kid, err)
+		}
+		keys = append(keys, key.Public())
+	}
+	return keys, nil
+}
@@ -0,0 +1,238 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+)
+
+// jwtAuth authenticates clients using JSON Web Tokens (JWT) signed
+// with one of the keys in a JSON Web Key Set (JWKS).
+type jwtAuth struct {
+	jwks          jwksProvider
+	issuer        string
+	audience      string
+	clockSkew     time.Duration
+	servicesClaim string
+	scopeClaim    string
+
+	// defaultServices and defaultPrivileges are used
+	// for tokens missing the corresponding claim.
+	defaultServices   []string
+	defaultPrivileges []string
+
+	now func() time.Time
+}
+
+func newJWTAuth(cfg config.JWTAgentAuth) (*jwtAuth, error) {
+	var jwks jwksProvider
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKSFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		jwks = keys
+	} else {
+		jwks = newRemoteJWKS(cfg.JWKSURL, cfg.JWKSRefreshInterval)
+	}
+	return &jwtAuth{
+		jwks:              jwks,
+		issuer:            cfg.Issuer,
+		audience:          cfg.Audience,
+		clockSkew:         cfg.ClockSkew,
+		servicesClaim:     cfg.ServicesClaim,
+		scopeClaim:        cfg.ScopeClaim,
+		defaultServices:   cfg.DefaultServices,
+		defaultPrivileges: cfg.DefaultPrivileges,
+		now:               time.Now,
+	}, nil
+}
+
+// isJWT reports whether token has the structure of a JWT in
+// JWS compact serialization: three dot-separated parts.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func (a *jwtAuth) authenticate(ctx context.Context, token string) (*JWTAuthenticationDetails, *jwtAuthorizer, error) {
+	registered, claims, err := a.verify(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	details := &JWTAuthenticationDetails{Issuer: registered.Issuer, Subject: registered.Subject}
+
+	services := a.defaultServices
+	if value, ok := claims[a.servicesClaim]; ok {
+		if services, err = claimStrings(value); err != nil {
+			return nil, nil, fmt.Errorf("%w: invalid %q claim: %s", ErrAuthFailed, a.servicesClaim, err)
+		}
+	}
+	scopes := a.defaultPrivileges
+	if value, ok := claims[a.scopeClaim]; ok {
+		if scopes, err = claimStrings(value); err != nil {
+			return nil, nil, fmt.Errorf("%w: invalid %q claim: %s", ErrAuthFailed, a.scopeClaim, err)
+		}
+	}
+	return details, &jwtAuthorizer{
+		services:   stringSet(services),
+		privileges: stringSet(scopes),
+	}, nil
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// verify verifies the signature and registered claims of token,
+// returning the registered claims and all claims.
+func (a *jwtAuth) verify(ctx context.Context, token string) (*jwt.Claims, map[string]interface{}, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: malformed JWT: %s", ErrAuthFailed, err)
+	}
+	// ParseSigned only accepts the compact serialization,
+	// which always has exactly one signature.
+	header := parsed.Headers[0]
+	keys, err := a.jwks.keys(ctx, header.KeyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var registered jwt.Claims
+	var claims map[string]interface{}
+	var verified bool
+	for _, key := range keys {
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			continue
+		}
+		// Claims verifies the signature with the key, rejecting
+		// algorithms that do not match the key type, before
+		// decoding the claims.
+		if err := parsed.Claims(key.Key, &registered, &claims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, nil, fmt.Errorf("%w: invalid JWT signature", ErrAuthFailed)
+	}
+	if err := a.verifyClaims(registered); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrAuthFailed, err)
+	}
+	return &registered, claims, nil
+}
+
+func (a *jwtAuth) verifyClaims(claims jwt.Claims) error {
+	if claims.Expiry == nil {
+		return errors.New("JWT has no expiry")
+	}
+	err := claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:   a.issuer,
+		Audience: jwt.Audience{a.audience},
+		Time:     a.now(),
+	}, a.clockSkew)
+	switch err {
+	case nil:
+		return nil
+	case jwt.ErrInvalidIssuer:
+		return fmt.Errorf("unexpected JWT issuer %q", claims.Issuer)
+	case jwt.ErrInvalidAudience:
+		return errors.New("JWT audience does not match")
+	case jwt.ErrExpired:
+		return errors.New("JWT has expired")
+	case jwt.ErrNotValidYet:
+		return errors.New("JWT is not yet valid")
+	}
+	return err
+}
+
+// jwtAuthorizer implements the Authorizer interface, authorizing clients
+// according to the claims of their JWT.
+type jwtAuthorizer struct {
+	// services holds the services the client is allowed access to.
+	// The service "*" allows access to all services.
+	services map[string]bool
+
+	// privileges holds the API Key privilege actions granted to the client.
+	privileges map[string]bool
+}
+
+// Authorize checks if the client is authorized for the given action and resource.
+//
+// The client must have the API Key privilege corresponding to the action in its
+// scope claim, and the resource's service must be listed in its services claim.
+// Missing claims are replaced by the configured defaults, which grant nothing
+// unless configured otherwise.
+func (a *jwtAuthorizer) Authorize(ctx context.Context, action Action, resource Resource) error {
+	var privilege string
+	switch action {
+	case ActionAgentConfig:
+		privilege = string(PrivilegeAgentConfigRead.Action)
+	case ActionEventIngest, ActionSampledTracesPublish:
+		privilege = string(PrivilegeEventWrite.Action)
+	case ActionSourcemapUpload, ActionMappingUpload:
+		privilege = string(PrivilegeSourcemapWrite.Action)
+	case ActionAdmin:
+		// There is no JWT scope granting admin access;
+		// the admin API requires the admin token.
+		return fmt.Errorf("%w: JWT not permitted action %q", ErrUnauthorized, action)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	if !a.privileges[privilege] {
+		return fmt.Errorf("%w: JWT not permitted action %q", ErrUnauthorized, privilege)
+	}
+	if resource.ServiceName != "" && !a.services["*"] && !a.services[resource.ServiceName] {
+		return fmt.Errorf("%w: JWT not permitted for service %q", ErrUnauthorized, resource.ServiceName)
+	}
+	return nil
+}
+
+// claimStrings returns the values of a claim which may be either a
+// string holding a space-separated list, or an array of strings.
+func claimStrings(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.Fields(value), nil
+	case []interface{}:
+		values := make([]string, len(value))
+		for i, v := range value {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string, got %T", v)
+			}
+			values[i] = s
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("expected string or array, got %T", value)
+	}
+}
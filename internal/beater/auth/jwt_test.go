@@ -0,0 +1,501 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+)
+
+type jwtTestKeys struct {
+	rsa     *rsa.PrivateKey
+	ecdsa   *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func newJWTTestKeys(t testing.TB) jwtTestKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return jwtTestKeys{rsa: rsaKey, ecdsa: ecdsaKey, ed25519: ed25519Key}
+}
+
+// jwks returns a JWKS containing the public keys, with key IDs
+// "rsa", "ec" and "ed" respectively.
+func (k jwtTestKeys) jwks() []byte {
+	b64 := base64.RawURLEncoding.EncodeToString
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA", "kid": "rsa", "use": "sig",
+			"n": b64(k.rsa.N.Bytes()),
+			"e": b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		}, {
+			"kty": "EC", "kid": "ec", "crv": "P-256", "alg": "ES256",
+			"x": b64(k.ecdsa.X.FillBytes(make([]byte, 32))),
+			"y": b64(k.ecdsa.Y.FillBytes(make([]byte, 32))),
+		}, {
+			"kty": "OKP", "kid": "ed", "crv": "Ed25519",
+			"x": b64(k.ed25519.Public().(ed25519.PublicKey)),
+		}, {
+			// Encryption keys must be ignored.
+			"kty": "RSA", "kid": "enc", "use": "enc",
+			"n": b64(k.rsa.N.Bytes()),
+			"e": b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		}},
+	})
+	return data
+}
+
+// sign returns a JWT with the given claims, signed using alg with the key
+// identified by kid.
+func (k jwtTestKeys) sign(t testing.TB, alg, kid string, claims map[string]interface{}) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signingInput := b64(header) + "." + b64(payload)
+
+	var signature []byte
+	digest := func(hash crypto.Hash) []byte {
+		h := hash.New()
+		h.Write([]byte(signingInput))
+		return h.Sum(nil)
+	}
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest(crypto.SHA256))
+	case "PS384":
+		signature, err = rsa.SignPSS(rand.Reader, k.rsa, crypto.SHA384, digest(crypto.SHA384),
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ecdsa, digest(crypto.SHA256))
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "EdDSA":
+		signature = ed25519.Sign(k.ed25519, []byte(signingInput))
+	}
+	require.NoError(t, err)
+	return signingInput + "." + b64(signature)
+}
+
+func validJWTClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss": "https://idp.example.com",
+		"sub": "workload-1",
+		"aud": []string{"other", "apm-server"},
+		"exp": now.Add(time.Minute).Unix(),
+		"nbf": now.Add(-time.Minute).Unix(),
+	}
+}
+
+func newTestJWTAuth(t testing.TB, keys jwtTestKeys, now time.Time) *jwtAuth {
+	jwks, err := parseJWKS(keys.jwks())
+	require.NoError(t, err)
+	return &jwtAuth{
+		jwks:          staticJWKS(jwks),
+		issuer:        "https://idp.example.com",
+		audience:      "apm-server",
+		clockSkew:     10 * time.Second,
+		servicesClaim: "services",
+		scopeClaim:    "scope",
+		now:           func() time.Time { return now },
+	}
+}
+
+func TestJWTAuthVerify(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	now := time.Now()
+	jwtAuth := newTestJWTAuth(t, keys, now)
+
+	withClaims := func(f func(claims map[string]interface{})) map[string]interface{} {
+		claims := validJWTClaims(now)
+		f(claims)
+		return claims
+	}
+
+	for name, test := range map[string]struct {
+		token       string
+		expectedErr string
+	}{
+		"RS256":  {token: keys.sign(t, "RS256", "rsa", validJWTClaims(now))},
+		"PS384":  {token: keys.sign(t, "PS384", "rsa", validJWTClaims(now))},
+		"ES256":  {token: keys.sign(t, "ES256", "ec", validJWTClaims(now))},
+		"EdDSA":  {token: keys.sign(t, "EdDSA", "ed", validJWTClaims(now))},
+		"no kid": {token: keys.sign(t, "ES256", "", validJWTClaims(now))},
+		"string audience": {
+			token: keys.sign(t, "RS256", "rsa", withClaims(func(claims map[string]interface{}) {
+				claims["aud"] = "apm-server"
+			})),
+		},
+		"expired within clock skew": {
+			token: keys.sign(t, "RS256", "rsa", withClaims(func(claims map[string]interface{}) {
+				claims["exp"] = now.Add(-5 * time.Second).Unix()
+			})),
+		},
+		"expired": {
+			token: keys.sign(t, "RS256", "rsa", withClaims(func(claims map[string]interface{}) {
+				claims["exp"] = now.Add(-time.Minute).Unix()
+			})),
+			expectedErr: "authentication failed: JWT has expired",
+		},
+		"no expiry": {
+			token: keys.sign(t, "RS256", "rsa", withClaims(func(claims map[string]interface{}) {
+				delete(claims, "exp")
+			})),
+			expectedErr: "authentication failed: JWT has no expiry",
+		},
+		"not yet valid": {
+			token: keys.sign(t, "RS256", "rsa", withClaims(func(claims map[string]interface{}) {
+				claims["nbf"] = now.Add(time.Minute).Unix()
+			})),
+			expectedErr: "authentication failed: JWT is not yet valid",
+		},
+		"wrong issuer": {
+			token: keys.sign(t, "RS256", "rsa", withClaims(func(claims map[string]interface{}) {
+				claims["iss"] = "https://evil.example.com"
+			})),
+			expectedErr: `authentication failed: unexpected JWT issuer "https://evil.example.com"`,
+		},
+		"wrong audience": {
+			token: keys.sign(t, "RS256", "rsa", withClaims(func(claims map[string]interface{}) {
+				claims["aud"] = "other"
+			})),
+			expectedErr: "authentication failed: JWT audience does not match",
+		},
+		"key algorithm mismatch": {
+			// The "ec" key may only be used with ES256.
+			token:       keys.sign(t, "RS256", "ec", validJWTClaims(now)),
+			expectedErr: "authentication failed: invalid JWT signature",
+		},
+		"unknown kid": {
+			token:       keys.sign(t, "RS256", "unknown", validJWTClaims(now)),
+			expectedErr: "authentication failed: invalid JWT signature",
+		},
+		"encryption key": {
+			token:       keys.sign(t, "RS256", "enc", validJWTClaims(now)),
+			expectedErr: "authentication failed: invalid JWT signature",
+		},
+		"unsigned": {
+			token:       keys.sign(t, "none", "", validJWTClaims(now)),
+			expectedErr: "authentication failed: invalid JWT signature",
+		},
+		"tampered claims": {
+			token: func() string {
+				token := keys.sign(t, "ES256", "ec", validJWTClaims(now))
+				other := keys.sign(t, "ES256", "ec", withClaims(func(claims map[string]interface{}) {
+					claims["sub"] = "workload-2"
+				}))
+				return token[:len(token)-86] + other[len(other)-86:]
+			}(),
+			expectedErr: "authentication failed: invalid JWT signature",
+		},
+		"malformed": {
+			token:       "a.b.c",
+			expectedErr: "authentication failed: malformed JWT: illegal base64 data at input byte 0",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, claims, err := jwtAuth.verify(context.Background(), test.token)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				assert.True(t, errors.Is(err, ErrAuthFailed))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "workload-1", claims["sub"])
+		})
+	}
+}
+
+func TestJWTAuthorizer(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	now := time.Now()
+	jwtAuth := newTestJWTAuth(t, keys, now)
+
+	authenticate := func(extraClaims map[string]interface{}) Authorizer {
+		claims := validJWTClaims(now)
+		for k, v := range extraClaims {
+			claims[k] = v
+		}
+		details, authz, err := jwtAuth.authenticate(context.Background(), keys.sign(t, "ES256", "ec", claims))
+		require.NoError(t, err)
+		assert.Equal(t, &JWTAuthenticationDetails{Issuer: "https://idp.example.com", Subject: "workload-1"}, details)
+		return authz
+	}
+
+	// No services or scope claims, and no defaults: nothing is allowed.
+	authz := authenticate(nil)
+	err := authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "checkout"})
+	assert.EqualError(t, err, `unauthorized: JWT not permitted action "event:write"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	err = authz.Authorize(context.Background(), ActionSourcemapUpload, Resource{ServiceName: "frontend"})
+	assert.EqualError(t, err, `unauthorized: JWT not permitted action "sourcemap:write"`)
+
+	// Claims with a name other than the configured one are ignored.
+	authz = authenticate(map[string]interface{}{
+		"apm_services": "checkout",
+		"scp":          "event:write",
+	})
+	err = authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "checkout"})
+	assert.EqualError(t, err, `unauthorized: JWT not permitted action "event:write"`)
+
+	// A scope claim without a services claim grants no services.
+	authz = authenticate(map[string]interface{}{"scope": "event:write"})
+	err = authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "checkout"})
+	assert.EqualError(t, err, `unauthorized: JWT not permitted for service "checkout"`)
+
+	// The "*" service allows access to all services, but never the admin API.
+	authz = authenticate(map[string]interface{}{
+		"services": "*",
+		"scope":    []string{"event:write", "sourcemap:write"},
+	})
+	assert.NoError(t, authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "checkout"}))
+	assert.NoError(t, authz.Authorize(context.Background(), ActionSourcemapUpload, Resource{ServiceName: "frontend"}))
+	err = authz.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.EqualError(t, err, `unauthorized: JWT not permitted action "admin"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	authz = authenticate(map[string]interface{}{
+		"services": []string{"checkout", "cart"},
+		"scope":    "openid event:write config_agent:read",
+	})
+	assert.NoError(t, authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "checkout"}))
+	assert.NoError(t, authz.Authorize(context.Background(), ActionAgentConfig, Resource{ServiceName: "cart"}))
+	err = authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "frontend"})
+	assert.EqualError(t, err, `unauthorized: JWT not permitted for service "frontend"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	err = authz.Authorize(context.Background(), ActionSourcemapUpload, Resource{ServiceName: "checkout"})
+	assert.EqualError(t, err, `unauthorized: JWT not permitted action "sourcemap:write"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	err = authz.Authorize(context.Background(), "unknown", Resource{})
+	assert.EqualError(t, err, `unknown action "unknown"`)
+
+	// Missing claims are replaced by the configured defaults.
+	jwtAuth.defaultServices = []string{"checkout"}
+	jwtAuth.defaultPrivileges = []string{"config_agent:read"}
+	authz = authenticate(nil)
+	assert.NoError(t, authz.Authorize(context.Background(), ActionAgentConfig, Resource{ServiceName: "checkout"}))
+	err = authz.Authorize(context.Background(), ActionAgentConfig, Resource{ServiceName: "cart"})
+	assert.EqualError(t, err, `unauthorized: JWT not permitted for service "cart"`)
+	err = authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "checkout"})
+	assert.EqualError(t, err, `unauthorized: JWT not permitted action "event:write"`)
+	authz = authenticate(map[string]interface{}{"services": "cart"})
+	assert.NoError(t, authz.Authorize(context.Background(), ActionAgentConfig, Resource{ServiceName: "cart"}))
+
+	claims := validJWTClaims(now)
+	claims["services"] = 123
+	_, _, err = jwtAuth.authenticate(context.Background(), keys.sign(t, "ES256", "ec", claims))
+	assert.EqualError(t, err, `authentication failed: invalid "services" claim: expected string or array, got float64`)
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	var fetches int64
+	var fail atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(keys.jwks())
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	now := time.Now()
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+	jwks := newRemoteJWKS(srv.URL, time.Hour)
+	jwks.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	// The JWKS is fetched on first use, and then cached.
+	found, err := jwks.keys(context.Background(), "rsa")
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+	found, err = jwks.keys(context.Background(), "")
+	require.NoError(t, err)
+	assert.Len(t, found, 3)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&fetches))
+
+	// Unknown key IDs trigger a refresh, at most once every jwksMinRefreshInterval.
+	found, err = jwks.keys(context.Background(), "unknown")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&fetches))
+	advance(jwksMinRefreshInterval)
+	_, err = jwks.keys(context.Background(), "unknown")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&fetches))
+
+	// The JWKS is refreshed in the background after the refresh interval.
+	// If refreshing fails, the previously fetched keys continue to be used.
+	fail.Store(true)
+	advance(time.Hour)
+	found, err = jwks.keys(context.Background(), "ec")
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&fetches) == 3
+	}, 10*time.Second, 10*time.Millisecond)
+	found, err = jwks.keys(context.Background(), "ec")
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+
+	// Initial fetch failures are returned.
+	jwks = newRemoteJWKS(srv.URL, time.Hour)
+	_, err = jwks.keys(context.Background(), "ec")
+	assert.EqualError(t, err, `failed to fetch JWKS: unexpected status "500 Internal Server Error"`)
+}
+
+func TestRemoteJWKSSlowFetch(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	var fetches int64
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&fetches, 1) > 1 {
+			<-unblock
+		}
+		w.Write(keys.jwks())
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	var mu sync.Mutex
+	now := time.Now()
+	jwks := newRemoteJWKS(srv.URL, time.Hour)
+	jwks.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	_, err := jwks.keys(context.Background(), "rsa")
+	require.NoError(t, err)
+
+	mu.Lock()
+	now = now.Add(time.Hour)
+	mu.Unlock()
+
+	// Requests with known keys are served from the cache while the
+	// stale JWKS is refreshed, and are not blocked by the slow fetch.
+	found, err := jwks.keys(context.Background(), "rsa")
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&fetches) == 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// Requests with unknown keys wait for the fetch in progress, and give up
+	// when their context is done without cancelling the fetch, which is shared
+	// with other requests. Concurrent requests do not trigger more fetches.
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err = jwks.keys(ctx, "unknown")
+		cancel()
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	found, err = jwks.keys(context.Background(), "ec")
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&fetches))
+}
+
+func TestAuthenticatorJWT(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(jwksFile, keys.jwks(), 0644))
+
+	authenticator, err := NewAuthenticator(config.AgentAuth{
+		SecretToken: "abc123",
+		JWT: config.JWTAgentAuth{
+			Enabled:       true,
+			JWKSFile:      jwksFile,
+			Issuer:        "https://idp.example.com",
+			Audience:      "apm-server",
+			ServicesClaim: "services",
+			ScopeClaim:    "scope",
+		},
+	})
+	require.NoError(t, err)
+
+	token := keys.sign(t, "RS256", "rsa", validJWTClaims(time.Now()))
+	details, authz, err := authenticator.Authenticate(context.Background(), headers.Bearer, token)
+	require.NoError(t, err)
+	assert.Equal(t, AuthenticationDetails{
+		Method: MethodJWT,
+		JWT: &JWTAuthenticationDetails{
+			Issuer:  "https://idp.example.com",
+			Subject: "workload-1",
+		},
+	}, details)
+	assert.IsType(t, &jwtAuthorizer{}, authz)
+
+	// The secret token may still be used.
+	details, _, err = authenticator.Authenticate(context.Background(), headers.Bearer, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, AuthenticationDetails{
+		Method:      MethodSecretToken,
+		SecretToken: &SecretTokenAuthenticationDetails{Name: config.DefaultSecretTokenName},
+	}, details)
+
+	_, _, err = authenticator.Authenticate(context.Background(), headers.Bearer, "a.b.c")
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+	_, _, err = authenticator.Authenticate(context.Background(), headers.Bearer, "wrong")
+	assert.Equal(t, ErrAuthFailed, err)
+
+	_, err = NewAuthenticator(config.AgentAuth{
+		JWT: config.JWTAgentAuth{Enabled: true, JWKSFile: filepath.Join(t.TempDir(), "missing.json")},
+	})
+	assert.ErrorContains(t, err, "failed to read JWKS file")
+}
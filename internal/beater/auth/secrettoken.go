@@ -0,0 +1,116 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+)
+
+// secretTokenRegistry holds per-token usage metrics, so that the use of
+// secret tokens may be monitored while rotating them.
+var secretTokenRegistry = monitoring.Default.NewRegistry("apm-server.auth.secret_tokens")
+
+// secretTokenAuth authenticates clients using one of several named
+// secret tokens, each of which may have a limited period of validity.
+type secretTokenAuth struct {
+	tokens []secretToken
+	now    func() time.Time
+}
+
+type secretToken struct {
+	name      string
+	value     []byte
+	notBefore time.Time
+	notAfter  time.Time
+
+	// accepted and rejected count the requests that have supplied this
+	// secret token, within and outside of its period of validity.
+	accepted *monitoring.Int
+	rejected *monitoring.Int
+}
+
+func newSecretTokenAuth(cfg config.AgentAuth) *secretTokenAuth {
+	tokens := cfg.SecretTokens
+	if cfg.SecretToken != "" {
+		tokens = append([]config.SecretToken{{
+			Name:  config.DefaultSecretTokenName,
+			Value: cfg.SecretToken,
+		}}, tokens...)
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	a := &secretTokenAuth{tokens: make([]secretToken, len(tokens)), now: time.Now}
+	for i, token := range tokens {
+		a.tokens[i] = secretToken{
+			name:      token.Name,
+			value:     []byte(token.Value),
+			notBefore: token.NotBefore,
+			notAfter:  token.NotAfter,
+			accepted:  secretTokenCounter(token.Name + ".accepted"),
+			rejected:  secretTokenCounter(token.Name + ".rejected"),
+		}
+	}
+	return a
+}
+
+// secretTokenCounter returns the secret token metric with the given name,
+// creating it if it does not exist. Metrics may already exist if the server
+// has been reloaded.
+func secretTokenCounter(name string) *monitoring.Int {
+	if counter, ok := secretTokenRegistry.Get(name).(*monitoring.Int); ok {
+		return counter
+	}
+	return monitoring.NewInt(secretTokenRegistry, name)
+}
+
+// authenticate checks whether token matches one of the secret tokens,
+// returning the authentication details and true if it does.
+//
+// If token matches a secret token outside of its period of validity,
+// an error wrapping ErrAuthFailed is returned.
+func (a *secretTokenAuth) authenticate(token string) (*SecretTokenAuthenticationDetails, bool, error) {
+	// Compare the token with all secret tokens, to avoid revealing
+	// through timing which of the secret tokens matched.
+	var matched *secretToken
+	for i := range a.tokens {
+		if subtle.ConstantTimeCompare(a.tokens[i].value, []byte(token)) == 1 {
+			matched = &a.tokens[i]
+		}
+	}
+	if matched == nil {
+		return nil, false, nil
+	}
+	now := a.now()
+	if !matched.notBefore.IsZero() && now.Before(matched.notBefore) {
+		matched.rejected.Inc()
+		return nil, false, fmt.Errorf("%w: secret token %q is not yet valid", ErrAuthFailed, matched.name)
+	}
+	if !matched.notAfter.IsZero() && now.After(matched.notAfter) {
+		matched.rejected.Inc()
+		return nil, false, fmt.Errorf("%w: secret token %q has expired", ErrAuthFailed, matched.name)
+	}
+	matched.accepted.Inc()
+	return &SecretTokenAuthenticationDetails{Name: matched.name}, true, nil
+}
@@ -0,0 +1,103 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+)
+
+func TestAuthenticatorSecretTokens(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	authenticator, err := NewAuthenticator(config.AgentAuth{
+		SecretToken: "legacy",
+		SecretTokens: []config.SecretToken{{
+			Name:     "old",
+			Value:    "old_value",
+			NotAfter: now.Add(-time.Second),
+		}, {
+			Name:      "current",
+			Value:     "current_value",
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  now.Add(time.Hour),
+		}, {
+			Name:      "next",
+			Value:     "next_value",
+			NotBefore: now.Add(time.Second),
+		}},
+	})
+	require.NoError(t, err)
+	authenticator.secretTokens.now = func() time.Time { return now }
+
+	tokens := make(map[string]*secretToken)
+	for i, token := range authenticator.secretTokens.tokens {
+		tokens[token.name] = &authenticator.secretTokens.tokens[i]
+	}
+	require.Len(t, tokens, 4)
+	accepted := make(map[string]int64)
+	rejected := make(map[string]int64)
+	for name, token := range tokens {
+		accepted[name] = token.accepted.Get()
+		rejected[name] = token.rejected.Get()
+	}
+
+	for _, name := range []string{config.DefaultSecretTokenName, "current"} {
+		value := name + "_value"
+		if name == config.DefaultSecretTokenName {
+			value = "legacy"
+		}
+		details, authz, err := authenticator.Authenticate(context.Background(), headers.Bearer, value)
+		require.NoError(t, err)
+		assert.Equal(t, AuthenticationDetails{
+			Method:      MethodSecretToken,
+			SecretToken: &SecretTokenAuthenticationDetails{Name: name},
+		}, details)
+		assert.Equal(t, allowAuth{}, authz)
+		accepted[name]++
+	}
+
+	_, _, err = authenticator.Authenticate(context.Background(), headers.Bearer, "old_value")
+	assert.EqualError(t, err, `authentication failed: secret token "old" has expired`)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+	rejected["old"]++
+
+	_, _, err = authenticator.Authenticate(context.Background(), headers.Bearer, "next_value")
+	assert.EqualError(t, err, `authentication failed: secret token "next" is not yet valid`)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+	rejected["next"]++
+
+	_, _, err = authenticator.Authenticate(context.Background(), headers.Bearer, "unknown")
+	assert.Equal(t, ErrAuthFailed, err)
+
+	for name, token := range tokens {
+		assert.Equal(t, accepted[name], token.accepted.Get(), name)
+		assert.Equal(t, rejected[name], token.rejected.Get(), name)
+	}
+
+	// Creating another Authenticator reuses the existing metrics.
+	reloaded, err := NewAuthenticator(config.AgentAuth{SecretToken: "legacy"})
+	require.NoError(t, err)
+	assert.Same(t, tokens[config.DefaultSecretTokenName].accepted, reloaded.secretTokens.tokens[0].accepted)
+}
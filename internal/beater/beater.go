@@ -61,15 +61,19 @@ import (
 	"github.com/elastic/apm-server/internal/elasticsearch"
 	"github.com/elastic/apm-server/internal/idxmgmt"
 	"github.com/elastic/apm-server/internal/kibana"
+	"github.com/elastic/apm-server/internal/logs"
 	srvmodelprocessor "github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/publish"
+	"github.com/elastic/apm-server/internal/r8"
 	"github.com/elastic/apm-server/internal/sourcemap"
 	"github.com/elastic/apm-server/internal/version"
 )
 
 var (
-	monitoringRegistry         = monitoring.Default.NewRegistry("apm-server.sampling")
-	transactionsDroppedCounter = monitoring.NewInt(monitoringRegistry, "transactions_dropped")
+	monitoringRegistry          = monitoring.Default.NewRegistry("apm-server.sampling")
+	transactionsDroppedCounter  = monitoring.NewInt(monitoringRegistry, "transactions_dropped")
+	filtersMonitoringRegistry   = monitoring.Default.NewRegistry("apm-server.filters")
+	redactionMonitoringRegistry = monitoring.Default.NewRegistry("apm-server.redaction")
 )
 
 // Runner initialises and runs and orchestrates the APM Server
@@ -239,6 +243,12 @@ func (s *Runner) Run(ctx context.Context) error {
 			s.config.Aggregation.ServiceTransactions.MaxGroups, memLimitGB,
 		)
 	}
+	if s.config.Aggregation.Errors.MaxGroups <= 0 {
+		s.config.Aggregation.Errors.MaxGroups = maxGroupsForAggregation(memLimitGB)
+		s.logger.Infof("Errors.MaxGroups for error aggregation set to %d based on %0.1fgb of memory",
+			s.config.Aggregation.Errors.MaxGroups, memLimitGB,
+		)
+	}
 
 	// Send config to telemetry.
 	recordAPMServerConfig(s.config)
@@ -335,6 +345,7 @@ func (s *Runner) Run(ctx context.Context) error {
 	}
 
 	var sourcemapFetcher sourcemap.Fetcher
+	var sourcemapUploader sourcemap.Uploader
 	if s.config.RumConfig.Enabled && s.config.RumConfig.SourceMapping.Enabled {
 		fetcher, cancel, err := newSourcemapFetcher(
 			s.config.RumConfig.SourceMapping,
@@ -345,6 +356,23 @@ func (s *Runner) Run(ctx context.Context) error {
 		}
 		defer cancel()
 		sourcemapFetcher = fetcher
+
+		uploader, err := newSourcemapUploader(s.config.RumConfig.SourceMapping, newElasticsearchClient)
+		if err != nil {
+			return err
+		}
+		sourcemapUploader = uploader
+	}
+
+	var r8Fetcher r8.MappingFetcher
+	var r8Uploader r8.Uploader
+	if s.config.Deobfuscation.Enabled {
+		fetcher, uploader, err := newR8FetcherUploader(s.config.Deobfuscation, newElasticsearchClient)
+		if err != nil {
+			return err
+		}
+		r8Fetcher = fetcher
+		r8Uploader = uploader
 	}
 
 	// Create the runServer function. We start with newBaseRunServer, and then
@@ -364,23 +392,54 @@ func (s *Runner) Run(ctx context.Context) error {
 		return err
 	}
 
-	// Note that we intentionally do not use a grpc.Creds ServerOption
-	// even if TLS is enabled, as TLS is handled by the net/http server.
+	quotas, err := newQuotas(s.config.Quotas)
+	if err != nil {
+		return err
+	}
+
+	filter, err := newFilterBatchProcessor(s.config.Filters)
+	if err != nil {
+		return err
+	}
+
+	redactor, err := newRedactionBatchProcessor(s.config.Redaction)
+	if err != nil {
+		return err
+	}
+
+	// Note that we intentionally do not use TLS grpc.Creds even if TLS
+	// is enabled, as TLS is handled by the net/http server. Instead we
+	// expose the state of the TLS connection to gRPC, so that verified
+	// client certificates may be used for authentication.
 	gRPCLogger := s.logger.Named("grpc")
-	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+	grpcServerOptions := []grpc.ServerOption{grpc.ChainUnaryInterceptor(
 		apmgrpc.NewUnaryServerInterceptor(apmgrpc.WithRecovery(), apmgrpc.WithTracer(tracer)),
-		interceptors.ClientMetadata(),
+		interceptors.ClientMetadata(s.config.TrustedProxyPrefixes),
 		interceptors.Logging(gRPCLogger),
 		interceptors.Metrics(gRPCLogger),
 		interceptors.Timeout(),
 		interceptors.Auth(authenticator),
 		interceptors.AnonymousRateLimit(ratelimitStore),
-	))
+	)}
+	if s.config.TLS.IsEnabled() {
+		grpcServerOptions = append(grpcServerOptions, grpc.Creds(tlsConnectionInfo{}))
+	}
+	grpcServer := grpc.NewServer(grpcServerOptions...)
+
+	geoipEnricher, geoipRunFunc, err := newGeoIPEnricher(s.config)
+	if err != nil {
+		return err
+	}
+	if geoipRunFunc != nil {
+		g.Go(func() error {
+			return geoipRunFunc(ctx)
+		})
+	}
 
 	// Create the BatchProcessor chain that is used to process all events,
 	// including the metrics aggregated by APM Server.
 	finalBatchProcessor, closeFinalBatchProcessor, err := s.newFinalBatchProcessor(
-		tracer, newElasticsearchClient, memLimitGB,
+		tracer, newElasticsearchClient, memLimitGB, geoipEnricher,
 	)
 	if err != nil {
 		return err
@@ -435,6 +494,8 @@ func (s *Runner) Run(ctx context.Context) error {
 		BatchProcessor:         batchProcessor,
 		AgentConfig:            agentConfigReporter,
 		SourcemapFetcher:       sourcemapFetcher,
+		SourcemapUploader:      sourcemapUploader,
+		R8Uploader:             r8Uploader,
 		PublishReady:           publishReady,
 		KibanaClient:           kibanaClient,
 		NewElasticsearchClient: newElasticsearchClient,
@@ -455,16 +516,38 @@ func (s *Runner) Run(ctx context.Context) error {
 		// Add a model processor that rate limits, and checks authorization for the
 		// agent and service for each event. These must come at the beginning of the
 		// processor chain.
-		model.ProcessBatchFunc(rateLimitBatchProcessor),
+		newRateLimitBatchProcessor(quotas),
 		model.ProcessBatchFunc(authorizeEventIngestProcessor),
-
+	}
+	if filter != nil {
+		// Drop filtered events before any further processing, so they
+		// are not aggregated, sampled, or indexed.
+		preBatchProcessors = append(preBatchProcessors, filter)
+	}
+	if redactor != nil {
+		// Redact personal data before events are aggregated, sampled,
+		// or indexed, regardless of which protocol they were received on.
+		preBatchProcessors = append(preBatchProcessors, redactor)
+	}
+	preBatchProcessors = append(preBatchProcessors,
 		// Pre-process events before they are sent to the final processors for
 		// aggregation, sampling, and indexing.
 		modelprocessor.SetHostHostname{},
 		modelprocessor.SetServiceNodeName{},
+	)
+	if r8Fetcher != nil {
+		// Deobfuscate Android stack traces before computing grouping keys,
+		// so errors are grouped by their original stack frames.
+		preBatchProcessors = append(preBatchProcessors, r8.BatchProcessor{
+			Fetcher: r8Fetcher,
+			Timeout: s.config.Deobfuscation.Timeout,
+			Logger:  logp.NewLogger(logs.Stacktrace),
+		})
+	}
+	preBatchProcessors = append(preBatchProcessors,
 		modelprocessor.SetGroupingKey{},
 		modelprocessor.SetErrorMessage{},
-	}
+	)
 	if s.config.DefaultServiceEnvironment != "" {
 		preBatchProcessors = append(preBatchProcessors, &modelprocessor.SetDefaultServiceEnvironment{
 			DefaultServiceEnvironment: s.config.DefaultServiceEnvironment,
@@ -616,16 +699,19 @@ func (s *Runner) waitReady(
 // newFinalBatchProcessor returns the final model.BatchProcessor that publishes events,
 // and a cleanup function which should be called on server shutdown. If the output is
 // "elasticsearch", then we use docappender; otherwise we use the libbeat publisher.
+//
+// If enricher is non-nil, it is used to add fields to events as they are encoded.
 func (s *Runner) newFinalBatchProcessor(
 	tracer *apm.Tracer,
 	newElasticsearchClient func(cfg *elasticsearch.Config) (*elasticsearch.Client, error),
 	memLimit float64,
+	enricher publish.JSONEnricher,
 ) (model.BatchProcessor, func(context.Context) error, error) {
 
 	monitoring.Default.Remove("libbeat")
 	libbeatMonitoringRegistry := monitoring.Default.NewRegistry("libbeat")
 	if s.elasticsearchOutputConfig == nil {
-		return s.newLibbeatFinalBatchProcessor(tracer, libbeatMonitoringRegistry)
+		return s.newLibbeatFinalBatchProcessor(tracer, libbeatMonitoringRegistry, enricher)
 	}
 
 	stateRegistry := monitoring.GetNamespace("state").GetRegistry()
@@ -738,7 +824,7 @@ func (s *Runner) newFinalBatchProcessor(
 		v.OnKey("destroyed")
 		v.OnInt(stats.IndexersDestroyed)
 	})
-	return newDocappenderBatchProcessor(appender), appender.Close, nil
+	return newDocappenderBatchProcessor(appender, enricher), appender.Close, nil
 }
 
 func docappenderConfig(
@@ -773,6 +859,7 @@ func docappenderConfig(
 func (s *Runner) newLibbeatFinalBatchProcessor(
 	tracer *apm.Tracer,
 	libbeatMonitoringRegistry *monitoring.Registry,
+	enricher publish.JSONEnricher,
 ) (model.BatchProcessor, func(context.Context) error, error) {
 	// When the publisher stops cleanly it will close its pipeline client,
 	// calling the acker's Close method and unblock Wait.
@@ -810,7 +897,7 @@ func (s *Runner) newLibbeatFinalBatchProcessor(
 		return nil, nil, fmt.Errorf("failed to create libbeat output pipeline: %w", err)
 	}
 	pipelineConnector := pipetool.WithACKer(pipeline, acker)
-	publisher, err := publish.NewPublisher(pipelineConnector, tracer)
+	publisher, err := publish.NewPublisher(pipelineConnector, tracer, enricher)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -869,6 +956,41 @@ func newSourcemapFetcher(
 	return chained, cancel, nil
 }
 
+// newSourcemapUploader returns a sourcemap.Uploader which stores source maps
+// in the index read by the fetcher returned from newSourcemapFetcher.
+func newSourcemapUploader(
+	cfg config.SourceMapping,
+	newElasticsearchClient func(*elasticsearch.Config) (*elasticsearch.Client, error),
+) (sourcemap.Uploader, error) {
+	esClient, err := newElasticsearchClient(cfg.ESConfig)
+	if err != nil {
+		return nil, err
+	}
+	return sourcemap.NewElasticsearchUploader(esClient, sourcemapIndex), nil
+}
+
+const r8MappingIndex = ".apm-r8-mapping"
+
+// newR8FetcherUploader returns an r8.MappingFetcher and r8.Uploader which
+// respectively read and store mapping files in Elasticsearch.
+func newR8FetcherUploader(
+	cfg config.DeobfuscationConfig,
+	newElasticsearchClient func(*elasticsearch.Config) (*elasticsearch.Client, error),
+) (r8.MappingFetcher, r8.Uploader, error) {
+	esClient, err := newElasticsearchClient(cfg.ESConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	fetcher, err := r8.NewCachingFetcher(
+		r8.NewElasticsearchFetcher(esClient, r8MappingIndex),
+		cfg.Cache.Size, cfg.Cache.Expiration,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fetcher, r8.NewElasticsearchUploader(esClient, r8MappingIndex), nil
+}
+
 // TODO: This is copying behavior from libbeat:
 // https://github.com/elastic/beats/blob/b9ced47dba8bb55faa3b2b834fd6529d3c4d0919/libbeat/cmd/instance/beat.go#L927-L950
 // Remove this when cluster_uuid no longer needs to be queried from ES.
@@ -17,12 +17,20 @@
 
 package config
 
+import (
+	"github.com/pkg/errors"
+
+	"github.com/elastic/apm-server/internal/model/modeldimension"
+)
+
 const (
 	defaultTransactionAggregationHDRHistogramSignificantFigures = 2
 
 	defaultServiceDestinationAggregationMaxGroups = 10000
 
 	defaultServiceTxAggregationHDRHistogramSignificantFigures = 2
+
+	defaultHistogramRepresentation = "hdr"
 )
 
 // AggregationConfig holds configuration related to various metrics aggregations.
@@ -30,6 +38,11 @@ type AggregationConfig struct {
 	Transactions        TransactionAggregationConfig        `config:"transactions"`
 	ServiceDestinations ServiceDestinationAggregationConfig `config:"service_destinations"`
 	ServiceTransactions ServiceTransactionAggregationConfig `config:"service_transactions"`
+	Errors              ErrorAggregationConfig              `config:"errors"`
+
+	// PersistState controls whether in-flight aggregation state is written
+	// to disk on shutdown and restored on startup, rather than published.
+	PersistState bool `config:"persist_state"`
 }
 
 // TransactionAggregationConfig holds configuration related to transaction metrics aggregation.
@@ -37,6 +50,33 @@ type TransactionAggregationConfig struct {
 	MaxTransactionGroups           int `config:"max_groups"`   // if <= 0 then will be set based on memory limits
 	MaxServices                    int `config:"max_services"` // if <= 0 then will be set based on memory limits
 	HDRHistogramSignificantFigures int `config:"hdrhistogram_significant_figures" validate:"min=1, max=5"`
+
+	// HistogramRepresentation controls how duration histograms are represented
+	// in published metrics: "hdr", "exponential", or "tdigest". See
+	// validateHistogramRepresentation.
+	HistogramRepresentation string `config:"histogram_representation"`
+
+	// ExtraDimensions holds event field paths to aggregate transaction
+	// metrics by, in addition to the default dimensions.
+	ExtraDimensions []string `config:"extra_dimensions"`
+}
+
+// Validate validates the transaction aggregation config.
+func (c *TransactionAggregationConfig) Validate() error {
+	if err := validateHistogramRepresentation(c.HistogramRepresentation); err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(c.ExtraDimensions))
+	for i, field := range c.ExtraDimensions {
+		if _, err := modeldimension.Parse(field); err != nil {
+			return errors.Wrapf(err, "extra_dimensions[%d]", i)
+		}
+		if seen[field] {
+			return errors.Errorf("extra_dimensions[%d]: duplicate field %q", i, field)
+		}
+		seen[field] = true
+	}
+	return nil
 }
 
 // ServiceDestinationAggregationConfig holds configuration related to span metrics aggregation for service maps.
@@ -48,18 +88,55 @@ type ServiceDestinationAggregationConfig struct {
 type ServiceTransactionAggregationConfig struct {
 	MaxGroups                      int `config:"max_groups"` // if <= 0 then will be set based on memory limits
 	HDRHistogramSignificantFigures int `config:"hdrhistogram_significant_figures" validate:"min=1, max=5"`
+
+	// HistogramRepresentation controls how duration histograms are represented
+	// in published metrics: "hdr", "exponential", or "tdigest". See
+	// validateHistogramRepresentation.
+	HistogramRepresentation string `config:"histogram_representation"`
+}
+
+// Validate validates the service transaction aggregation config.
+func (c *ServiceTransactionAggregationConfig) Validate() error {
+	return validateHistogramRepresentation(c.HistogramRepresentation)
+}
+
+// ErrorAggregationConfig holds configuration related to error metrics aggregation.
+type ErrorAggregationConfig struct {
+	MaxGroups int `config:"max_groups"` // if <= 0 then will be set based on memory limits
 }
 
 func defaultAggregationConfig() AggregationConfig {
 	return AggregationConfig{
 		Transactions: TransactionAggregationConfig{
 			HDRHistogramSignificantFigures: defaultTransactionAggregationHDRHistogramSignificantFigures,
+			HistogramRepresentation:        defaultHistogramRepresentation,
 		},
 		ServiceDestinations: ServiceDestinationAggregationConfig{
 			MaxGroups: defaultServiceDestinationAggregationMaxGroups,
 		},
 		ServiceTransactions: ServiceTransactionAggregationConfig{
 			HDRHistogramSignificantFigures: defaultServiceTxAggregationHDRHistogramSignificantFigures,
+			HistogramRepresentation:        defaultHistogramRepresentation,
 		},
 	}
 }
+
+// validateHistogramRepresentation checks that s names a supported histogram
+// representation:
+//
+//   - "hdr" publishes the HDR histogram buckets used for aggregation, with
+//     each value being the upper limit of a bucket.
+//   - "exponential" publishes the midpoints of base-2 exponential histogram
+//     buckets with a fixed scale of 4, encoded as an Elasticsearch histogram
+//     like the other representations. The buckets match those of OpenTelemetry
+//     exponential histograms with scale 4, but the published metrics are not
+//     exponential histograms, and so cannot be directly combined with OTLP
+//     exponential histogram data points.
+//   - "tdigest" publishes the centroid means of a T-Digest with compression 200.
+func validateHistogramRepresentation(s string) error {
+	switch s {
+	case "hdr", "exponential", "tdigest":
+		return nil
+	}
+	return errors.Errorf("histogram_representation: unsupported value %q, expected one of hdr, exponential, tdigest", s)
+}
@@ -45,6 +45,31 @@ func TestAggregationConfigInvalid(t *testing.T) {
 		key:    "aggregation.transactions.hdrhistogram_significant_figures",
 		value:  float64(6),
 		expect: "Error processing configuration: requires value <= 5 accessing 'aggregation.transactions.hdrhistogram_significant_figures'",
+	}, {
+		name:   "unsupported extra_dimensions field",
+		key:    "aggregation.transactions.extra_dimensions",
+		value:  []string{"labels.tenant", "transaction.name"},
+		expect: `Error processing configuration: extra_dimensions[1]: unsupported field "transaction.name" accessing 'aggregation.transactions'`,
+	}, {
+		name:   "empty extra_dimensions label key",
+		key:    "aggregation.transactions.extra_dimensions",
+		value:  []string{"labels."},
+		expect: `Error processing configuration: extra_dimensions[0]: unsupported field "labels." accessing 'aggregation.transactions'`,
+	}, {
+		name:   "duplicate extra_dimensions field",
+		key:    "aggregation.transactions.extra_dimensions",
+		value:  []string{"url.scheme", "url.scheme"},
+		expect: `Error processing configuration: extra_dimensions[1]: duplicate field "url.scheme" accessing 'aggregation.transactions'`,
+	}, {
+		name:   "unsupported transactions histogram_representation",
+		key:    "aggregation.transactions.histogram_representation",
+		value:  "exphist",
+		expect: `Error processing configuration: histogram_representation: unsupported value "exphist", expected one of hdr, exponential, tdigest accessing 'aggregation.transactions'`,
+	}, {
+		name:   "empty service_transactions histogram_representation",
+		key:    "aggregation.service_transactions.histogram_representation",
+		value:  "",
+		expect: `Error processing configuration: histogram_representation: unsupported value "", expected one of hdr, exponential, tdigest accessing 'aggregation.service_transactions'`,
 	}} {
 		t.Run(test.name, func(t *testing.T) {
 			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
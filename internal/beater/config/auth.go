@@ -18,6 +18,9 @@
 package config
 
 import (
+	"regexp"
+	"time"
+
 	"github.com/pkg/errors"
 
 	"github.com/elastic/elastic-agent-libs/config"
@@ -28,16 +31,52 @@ import (
 
 // AgentAuth holds config related to agent auth.
 type AgentAuth struct {
-	Anonymous   AnonymousAgentAuth `config:"anonymous"`
-	APIKey      APIKeyAgentAuth    `config:"api_key"`
-	SecretToken string             `config:"secret_token"`
+	Anonymous         AnonymousAgentAuth         `config:"anonymous"`
+	APIKey            APIKeyAgentAuth            `config:"api_key"`
+	ClientCertificate ClientCertificateAgentAuth `config:"client_certificate"`
+	JWT               JWTAgentAuth               `config:"jwt"`
+	SecretToken       string                     `config:"secret_token"`
+	SecretTokens      []SecretToken              `config:"secret_tokens"`
+
+	// AdminToken holds an optional token for accessing the admin API.
+	// Agent credentials never grant access to the admin API.
+	AdminToken string `config:"admin_token"`
+}
+
+// DefaultSecretTokenName is the name used for identifying the
+// secret token defined by AgentAuth.SecretToken.
+const DefaultSecretTokenName = "default"
+
+// Validate validates the agent auth config.
+func (a *AgentAuth) Validate() error {
+	names := make(map[string]bool)
+	if a.SecretToken != "" {
+		names[DefaultSecretTokenName] = true
+	}
+	for i, token := range a.SecretTokens {
+		if names[token.Name] {
+			return errors.Errorf("secret_tokens[%d]: duplicate name %q", i, token.Name)
+		}
+		names[token.Name] = true
+	}
+	if a.AdminToken != "" {
+		if a.AdminToken == a.SecretToken {
+			return errors.New("admin_token must differ from secret_token")
+		}
+		for i, token := range a.SecretTokens {
+			if a.AdminToken == token.Value {
+				return errors.Errorf("admin_token must differ from secret_tokens[%d]", i)
+			}
+		}
+	}
+	return nil
 }
 
 func (a *AgentAuth) setAnonymousDefaults(logger *logp.Logger, rumEnabled bool) error {
 	if a.Anonymous.enabledSet {
 		return nil
 	}
-	if !a.APIKey.Enabled && a.SecretToken == "" {
+	if !a.APIKey.Enabled && !a.ClientCertificate.Enabled && !a.JWT.Enabled && a.SecretToken == "" && len(a.SecretTokens) == 0 {
 		// No auth is required.
 		return nil
 	}
@@ -79,6 +118,197 @@ func (a *APIKeyAgentAuth) setup(log *logp.Logger, outputESCfg *config.C) error {
 	return nil
 }
 
+// SecretToken holds a named secret token for agent auth, which may be
+// restricted to a period of validity. Defining multiple secret tokens
+// with overlapping periods enables tokens to be rotated without
+// simultaneously reconfiguring all agents.
+type SecretToken struct {
+	// Name identifies the secret token in authentication details
+	// and monitoring metrics. Name must be unique.
+	Name string
+
+	// Value holds the secret token value.
+	Value string
+
+	// NotBefore holds the time before which the secret token is invalid.
+	// If NotBefore is zero, the secret token is valid from any time.
+	NotBefore time.Time
+
+	// NotAfter holds the time after which the secret token is invalid.
+	// If NotAfter is zero, the secret token never expires.
+	NotAfter time.Time
+}
+
+// Unpack unpacks a SecretToken, parsing not_before and not_after as
+// RFC 3339 timestamps.
+func (t *SecretToken) Unpack(in *config.C) error {
+	var raw struct {
+		Name      string `config:"name"`
+		Value     string `config:"value"`
+		NotBefore string `config:"not_before"`
+		NotAfter  string `config:"not_after"`
+	}
+	if err := in.Unpack(&raw); err != nil {
+		return errors.Wrap(err, "error unpacking secret token config")
+	}
+	*t = SecretToken{Name: raw.Name, Value: raw.Value}
+	if raw.NotBefore != "" {
+		notBefore, err := time.Parse(time.RFC3339, raw.NotBefore)
+		if err != nil {
+			return errors.Wrap(err, "invalid not_before")
+		}
+		t.NotBefore = notBefore
+	}
+	if raw.NotAfter != "" {
+		notAfter, err := time.Parse(time.RFC3339, raw.NotAfter)
+		if err != nil {
+			return errors.Wrap(err, "invalid not_after")
+		}
+		t.NotAfter = notAfter
+	}
+	return t.validate()
+}
+
+func (t *SecretToken) validate() error {
+	if t.Name == "" {
+		return errors.New("name must be specified")
+	}
+	if !secretTokenNameRegexp.MatchString(t.Name) {
+		return errors.Errorf("invalid name %q: must contain only letters, digits, '-', and '_'", t.Name)
+	}
+	if t.Value == "" {
+		return errors.New("value must be specified")
+	}
+	if !t.NotBefore.IsZero() && !t.NotAfter.IsZero() && !t.NotAfter.After(t.NotBefore) {
+		return errors.New("not_after must be after not_before")
+	}
+	return nil
+}
+
+// secretTokenNameRegexp restricts secret token names, as they are used
+// in monitoring metric names.
+var secretTokenNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// ClientCertificateAgentAuth holds config related to TLS client certificate
+// auth for agents.
+//
+// Requests without an Authorization header, whose verified client certificate
+// matches one of the rules, are authenticated and authorized according to the
+// first matching rule.
+type ClientCertificateAgentAuth struct {
+	Enabled bool                    `config:"enabled"`
+	Rules   []ClientCertificateRule `config:"rules"`
+}
+
+// ClientCertificateRule holds patterns for matching client certificates,
+// and the agents and services that matching clients are allowed access to.
+//
+// Patterns may contain "*" wildcards, matching any sequence of characters.
+// If both Subject and SAN are specified, both must match.
+type ClientCertificateRule struct {
+	// Subject holds a pattern matched against the certificate's subject
+	// distinguished name, formatted as in RFC 2253, e.g. "CN=checkout,O=Example".
+	Subject string `config:"subject"`
+
+	// SAN holds a pattern matched against each of the certificate's DNS
+	// name, email address, IP address, and URI subject alternative names.
+	SAN string `config:"san"`
+
+	// AllowService holds the services that matching clients are allowed
+	// access to. If empty, all services are allowed.
+	AllowService []string `config:"allow_service"`
+
+	// AllowAgent holds the agents that matching clients are allowed to
+	// send events for. If empty, all agents are allowed.
+	AllowAgent []string `config:"allow_agent"`
+}
+
+// Validate validates the client certificate auth config.
+func (a *ClientCertificateAgentAuth) Validate() error {
+	if a.Enabled && len(a.Rules) == 0 {
+		return errors.New("at least one rule must be specified")
+	}
+	for i, rule := range a.Rules {
+		if rule.Subject == "" && rule.SAN == "" {
+			return errors.Errorf("rules[%d]: one of subject or san must be specified", i)
+		}
+	}
+	return nil
+}
+
+// JWTAgentAuth holds config related to JSON Web Token (JWT) auth for agents.
+//
+// Agents send JWTs as bearer tokens. The JWTs must be signed by one of the
+// keys in the JSON Web Key Set (JWKS) loaded from JWKSFile or JWKSURL, and
+// must have matching "iss" and "aud" claims, and an unexpired "exp" claim.
+type JWTAgentAuth struct {
+	Enabled bool `config:"enabled"`
+
+	// JWKSFile holds the path to a local JWKS file.
+	JWKSFile string `config:"jwks_file"`
+
+	// JWKSURL holds the URL from which the JWKS will be fetched, and
+	// periodically refreshed every JWKSRefreshInterval.
+	JWKSURL             string        `config:"jwks_url"`
+	JWKSRefreshInterval time.Duration `config:"jwks_refresh_interval"`
+
+	// Issuer holds the required value of the "iss" claim.
+	Issuer string `config:"issuer"`
+
+	// Audience holds a value which must be contained in the "aud" claim.
+	Audience string `config:"audience"`
+
+	// ClockSkew holds the amount of clock skew to allow for when
+	// checking the "exp" and "nbf" claims.
+	ClockSkew time.Duration `config:"clock_skew"`
+
+	// ServicesClaim holds the name of the claim listing the services
+	// the client is allowed access to, where "*" allows access to all
+	// services. If the claim is missing from a token, DefaultServices
+	// is used instead.
+	ServicesClaim string `config:"services_claim"`
+
+	// ScopeClaim holds the name of the claim listing the privileges
+	// granted to the client, such as "event:write". If the claim is
+	// missing from a token, DefaultPrivileges is used instead.
+	ScopeClaim string `config:"scope_claim"`
+
+	// DefaultServices holds the services the client is allowed access
+	// to when a token has no ServicesClaim. By default, none.
+	DefaultServices []string `config:"default_services"`
+
+	// DefaultPrivileges holds the privileges granted to the client
+	// when a token has no ScopeClaim. By default, none.
+	DefaultPrivileges []string `config:"default_privileges"`
+}
+
+// Validate validates the JWT auth config.
+func (a *JWTAgentAuth) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if (a.JWKSFile == "") == (a.JWKSURL == "") {
+		return errors.New("exactly one of jwks_file or jwks_url must be specified")
+	}
+	if a.Issuer == "" {
+		return errors.New("issuer must be specified")
+	}
+	if a.Audience == "" {
+		return errors.New("audience must be specified")
+	}
+	if a.JWKSRefreshInterval <= 0 {
+		return errors.New("jwks_refresh_interval must be greater than zero")
+	}
+	for _, privilege := range a.DefaultPrivileges {
+		switch privilege {
+		case "event:write", "config_agent:read", "sourcemap:write":
+		default:
+			return errors.Errorf("unknown privilege %q in default_privileges", privilege)
+		}
+	}
+	return nil
+}
+
 // AnonymousAgentAuth holds config related to anonymous access for agents.
 //
 // If RUM is enabled, and either secret_token or api_key auth is defined,
@@ -105,6 +335,7 @@ func defaultAgentAuth() AgentAuth {
 	return AgentAuth{
 		Anonymous: defaultAnonymousAgentAuth(),
 		APIKey:    defaultAPIKeyAgentAuth(),
+		JWT:       defaultJWTAgentAuth(),
 	}
 }
 
@@ -119,6 +350,16 @@ func defaultAnonymousAgentAuth() AnonymousAgentAuth {
 	}
 }
 
+func defaultJWTAgentAuth() JWTAgentAuth {
+	return JWTAgentAuth{
+		Enabled:             false,
+		JWKSRefreshInterval: time.Hour,
+		ClockSkew:           30 * time.Second,
+		ServicesClaim:       "services",
+		ScopeClaim:          "scope",
+	}
+}
+
 func defaultAPIKeyAgentAuth() APIKeyAgentAuth {
 	return APIKeyAgentAuth{
 		Enabled:     false,
@@ -181,3 +181,201 @@ func TestSecretTokenAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestSecretTokensAuth(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(`{"rum.enabled": true, "auth.secret_tokens": [
+		{"name": "old", "value": "abc", "not_after": "2023-06-01T00:00:00Z"},
+		{"name": "new", "value": "def", "not_before": "2023-05-01T00:00:00Z"}
+	]}`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []SecretToken{{
+		Name:     "old",
+		Value:    "abc",
+		NotAfter: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+	}, {
+		Name:      "new",
+		Value:     "def",
+		NotBefore: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+	}}, cfg.AgentAuth.SecretTokens)
+
+	// Anonymous access is enabled for RUM by default when secret tokens are defined.
+	assert.True(t, cfg.AgentAuth.Anonymous.Enabled)
+
+	for name, tc := range map[string]struct {
+		cfg         string
+		expectedErr string
+	}{
+		"no name": {
+			cfg:         `{"auth.secret_tokens": [{"value": "abc"}]}`,
+			expectedErr: "name must be specified",
+		},
+		"invalid name": {
+			cfg:         `{"auth.secret_tokens": [{"name": "a.b", "value": "abc"}]}`,
+			expectedErr: `invalid name "a.b"`,
+		},
+		"no value": {
+			cfg:         `{"auth.secret_tokens": [{"name": "abc"}]}`,
+			expectedErr: "value must be specified",
+		},
+		"invalid not_before": {
+			cfg:         `{"auth.secret_tokens": [{"name": "abc", "value": "abc", "not_before": "2023-06-01"}]}`,
+			expectedErr: "invalid not_before",
+		},
+		"not_after before not_before": {
+			cfg: `{"auth.secret_tokens": [{
+				"name": "abc", "value": "abc",
+				"not_before": "2023-06-01T00:00:00Z", "not_after": "2023-05-01T00:00:00Z"
+			}]}`,
+			expectedErr: "not_after must be after not_before",
+		},
+		"duplicate name": {
+			cfg:         `{"auth.secret_tokens": [{"name": "abc", "value": "abc"}, {"name": "abc", "value": "def"}]}`,
+			expectedErr: `secret_tokens[1]: duplicate name "abc"`,
+		},
+		"admin_token matching secret_token": {
+			cfg:         `{"auth.secret_token": "abc", "auth.admin_token": "abc"}`,
+			expectedErr: "admin_token must differ from secret_token",
+		},
+		"admin_token matching secret_tokens": {
+			cfg:         `{"auth.admin_token": "def", "auth.secret_tokens": [{"name": "abc", "value": "def"}]}`,
+			expectedErr: "admin_token must differ from secret_tokens[0]",
+		},
+		"duplicate default name": {
+			cfg:         `{"auth.secret_token": "abc", "auth.secret_tokens": [{"name": "default", "value": "def"}]}`,
+			expectedErr: `secret_tokens[0]: duplicate name "default"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(tc.cfg), nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestJWTAgentAuth(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(`{"auth.jwt": {
+		"enabled": true,
+		"jwks_file": "jwks.json",
+		"issuer": "https://idp.example.com",
+		"audience": "apm-server",
+		"services_claim": "apm_services",
+		"default_services": ["*"],
+		"default_privileges": ["event:write"]
+	}}`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, JWTAgentAuth{
+		Enabled:             true,
+		JWKSFile:            "jwks.json",
+		JWKSRefreshInterval: time.Hour,
+		Issuer:              "https://idp.example.com",
+		Audience:            "apm-server",
+		ClockSkew:           30 * time.Second,
+		ServicesClaim:       "apm_services",
+		ScopeClaim:          "scope",
+		DefaultServices:     []string{"*"},
+		DefaultPrivileges:   []string{"event:write"},
+	}, cfg.AgentAuth.JWT)
+
+	// Anonymous access is enabled for RUM by default when JWT auth is enabled.
+	cfg, err = NewConfig(config.MustNewConfigFrom(`{"rum.enabled": true, "auth.jwt": {
+		"enabled": true,
+		"jwks_url": "https://idp.example.com/.well-known/jwks.json",
+		"issuer": "https://idp.example.com",
+		"audience": "apm-server"
+	}}`), nil)
+	require.NoError(t, err)
+	assert.True(t, cfg.AgentAuth.Anonymous.Enabled)
+
+	for name, tc := range map[string]struct {
+		cfg         string
+		expectedErr string
+	}{
+		"no jwks": {
+			cfg:         `{"enabled": true, "issuer": "iss", "audience": "aud"}`,
+			expectedErr: "exactly one of jwks_file or jwks_url must be specified",
+		},
+		"jwks file and url": {
+			cfg:         `{"enabled": true, "jwks_file": "jwks.json", "jwks_url": "http://idp.invalid", "issuer": "iss", "audience": "aud"}`,
+			expectedErr: "exactly one of jwks_file or jwks_url must be specified",
+		},
+		"no issuer": {
+			cfg:         `{"enabled": true, "jwks_file": "jwks.json", "audience": "aud"}`,
+			expectedErr: "issuer must be specified",
+		},
+		"no audience": {
+			cfg:         `{"enabled": true, "jwks_file": "jwks.json", "issuer": "iss"}`,
+			expectedErr: "audience must be specified",
+		},
+		"unknown default privilege": {
+			cfg:         `{"enabled": true, "jwks_file": "jwks.json", "issuer": "iss", "audience": "aud", "default_privileges": ["event:read"]}`,
+			expectedErr: `unknown privilege "event:read" in default_privileges`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			input := config.NewConfig()
+			input.SetChild("auth.jwt", -1, config.MustNewConfigFrom(tc.cfg))
+			_, err := NewConfig(input, nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestClientCertificateAgentAuth(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(`{
+		"ssl": {
+			"enabled": true,
+			"certificate": "../../testdata/tls/certificate.pem",
+			"key": "../../testdata/tls/key.pem",
+			"certificate_authorities": ["../../testdata/tls/ca.crt.pem"],
+			"client_authentication": "optional"
+		},
+		"auth.client_certificate": {
+			"enabled": true,
+			"rules": [
+				{"subject": "CN=checkout,*", "allow_service": ["checkout"]},
+				{"san": "spiffe://cluster.local/ns/*", "allow_agent": ["go", "java"]}
+			]
+		}
+	}`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, ClientCertificateAgentAuth{
+		Enabled: true,
+		Rules: []ClientCertificateRule{{
+			Subject:      "CN=checkout,*",
+			AllowService: []string{"checkout"},
+		}, {
+			SAN:        "spiffe://cluster.local/ns/*",
+			AllowAgent: []string{"go", "java"},
+		}},
+	}, cfg.AgentAuth.ClientCertificate)
+
+	for name, tc := range map[string]struct {
+		cfg         string
+		expectedErr string
+	}{
+		"no rules": {
+			cfg:         `{"ssl": {"enabled": true, "certificate": "cert.pem", "key": "key.pem", "client_authentication": "required"}, "auth.client_certificate.enabled": true}`,
+			expectedErr: "at least one rule must be specified",
+		},
+		"no patterns": {
+			cfg:         `{"ssl": {"enabled": true, "certificate": "cert.pem", "key": "key.pem", "client_authentication": "required"}, "auth.client_certificate": {"enabled": true, "rules": [{"allow_service": ["foo"]}]}}`,
+			expectedErr: "rules[0]: one of subject or san must be specified",
+		},
+		"no client authentication": {
+			cfg:         `{"ssl": {"enabled": true, "certificate": "cert.pem", "key": "key.pem", "client_authentication": "none"}, "auth.client_certificate": {"enabled": true, "rules": [{"san": "*"}]}}`,
+			expectedErr: "auth.client_certificate requires ssl to be enabled, and ssl.client_authentication to be optional or required",
+		},
+		"ssl disabled": {
+			cfg:         `{"auth.client_certificate": {"enabled": true, "rules": [{"san": "*"}]}}`,
+			expectedErr: "auth.client_certificate requires ssl to be enabled, and ssl.client_authentication to be optional or required",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(tc.cfg), nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
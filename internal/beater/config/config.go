@@ -18,6 +18,7 @@
 package config
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 
 	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 const (
@@ -44,11 +46,35 @@ type Config struct {
 	// AgentAuth holds agent auth config.
 	AgentAuth AgentAuth `config:"auth"`
 
+	// TrustedProxies holds the CIDR network prefixes, or IP addresses, of
+	// proxies which are trusted to report the originating client address
+	// in Forwarded, X-Real-IP and X-Forwarded-For headers.
+	TrustedProxies []string `config:"trusted_proxies"`
+
+	// TrustedProxyPrefixes holds TrustedProxies parsed into network prefixes.
+	TrustedProxyPrefixes netutil.TrustedProxies `config:",ignore"`
+
+	// Quotas holds configuration for per-service, per-API Key, and
+	// per-secret token ingestion quotas.
+	Quotas QuotaConfig `config:"quotas"`
+
+	// Filters holds rules for dropping events, applied in order.
+	Filters []FilterRule `config:"filters"`
+
+	// Redaction holds rules for redacting personal data from events.
+	Redaction []RedactionRule `config:"redaction"`
+
+	// GeoIP holds configuration for enriching events from backend
+	// agents with the location and autonomous system of client.ip.
+	// Events from RUM agents are enriched according to RumConfig.GeoIP.
+	GeoIP GeoIPConfig `config:"geoip"`
+
 	MaxHeaderSize             int                     `config:"max_header_size"`
 	IdleTimeout               time.Duration           `config:"idle_timeout"`
 	ReadTimeout               time.Duration           `config:"read_timeout"`
 	WriteTimeout              time.Duration           `config:"write_timeout"`
 	MaxEventSize              int                     `config:"max_event_size"`
+	MaxRemoteWriteSize        int                     `config:"max_remote_write_size"`
 	ShutdownTimeout           time.Duration           `config:"shutdown_timeout"`
 	TLS                       *tlscommon.ServerConfig `config:"ssl"`
 	MaxConnections            int                     `config:"max_connections"`
@@ -57,6 +83,7 @@ type Config struct {
 	Pprof                     PprofConfig             `config:"pprof"`
 	AugmentEnabled            bool                    `config:"capture_personal_data"`
 	RumConfig                 RumConfig               `config:"rum"`
+	Deobfuscation             DeobfuscationConfig     `config:"deobfuscation"`
 	Kibana                    KibanaConfig            `config:"kibana"`
 	AgentConfig               AgentConfig             `config:"agent.config"`
 	Aggregation               AggregationConfig       `config:"aggregation"`
@@ -86,6 +113,11 @@ type Config struct {
 func NewConfig(ucfg *config.C, outputESCfg *config.C) (*Config, error) {
 	logger := logp.NewLogger(logs.Config)
 	c := DefaultConfig()
+	if ucfg.HasField("trusted_proxies") {
+		// Lists are merged with the defaults by index,
+		// so clear the defaults to replace them entirely.
+		c.TrustedProxies = nil
+	}
 	if err := ucfg.Unpack(c); err != nil {
 		return nil, errors.Wrap(err, "Error processing configuration")
 	}
@@ -96,6 +128,20 @@ func NewConfig(ucfg *config.C, outputESCfg *config.C) (*Config, error) {
 		}
 	}
 
+	trustedProxies, err := netutil.ParseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error processing configuration")
+	}
+	c.TrustedProxyPrefixes = trustedProxies
+
+	if err := validateFilterRules(c.Filters); err != nil {
+		return nil, errors.Wrap(err, "Error processing configuration")
+	}
+
+	if err := validateRedactionRules(c.Redaction); err != nil {
+		return nil, errors.Wrap(err, "Error processing configuration")
+	}
+
 	if err := c.AgentConfig.setup(logger, outputESCfg); err != nil {
 		return nil, err
 	}
@@ -104,6 +150,18 @@ func NewConfig(ucfg *config.C, outputESCfg *config.C) (*Config, error) {
 		return nil, err
 	}
 
+	if err := c.Deobfuscation.setup(logger, outputESCfg); err != nil {
+		return nil, err
+	}
+
+	if c.AgentAuth.ClientCertificate.Enabled {
+		if !c.TLS.IsEnabled() || tls.ClientAuthType(c.TLS.ClientAuth) == tls.NoClientCert {
+			return nil, errors.New(
+				"auth.client_certificate requires ssl to be enabled, and ssl.client_authentication to be optional or required",
+			)
+		}
+	}
+
 	if err := c.AgentAuth.setAnonymousDefaults(logger, c.RumConfig.Enabled); err != nil {
 		return nil, err
 	}
@@ -128,24 +186,43 @@ func NewConfig(ucfg *config.C, outputESCfg *config.C) (*Config, error) {
 	return c, nil
 }
 
+// defaultTrustedProxies holds the loopback and private network ranges,
+// which are trusted to report the client address by default.
+var defaultTrustedProxies = []string{
+	"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+	"::1/128", "fc00::/7",
+}
+
+var defaultTrustedProxyPrefixes = func() netutil.TrustedProxies {
+	trustedProxies, err := netutil.ParseTrustedProxies(defaultTrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	return trustedProxies
+}()
+
 // DefaultConfig returns a config with default settings for `apm-server` config options.
 func DefaultConfig() *Config {
 	return &Config{
-		Host:            net.JoinHostPort("127.0.0.1", DefaultPort),
-		MaxHeaderSize:   1 * 1024 * 1024, // 1mb
-		MaxConnections:  0,               // unlimited
-		IdleTimeout:     45 * time.Second,
-		ReadTimeout:     30 * time.Second,
-		WriteTimeout:    30 * time.Second,
-		MaxEventSize:    300 * 1024, // 300 kb
-		ShutdownTimeout: 30 * time.Second,
-		AugmentEnabled:  true,
+		Host:                 net.JoinHostPort("127.0.0.1", DefaultPort),
+		MaxHeaderSize:        1 * 1024 * 1024, // 1mb
+		MaxConnections:       0,               // unlimited
+		IdleTimeout:          45 * time.Second,
+		ReadTimeout:          30 * time.Second,
+		WriteTimeout:         30 * time.Second,
+		MaxEventSize:         300 * 1024,       // 300 kb
+		MaxRemoteWriteSize:   10 * 1024 * 1024, // 10mb
+		ShutdownTimeout:      30 * time.Second,
+		AugmentEnabled:       true,
+		TrustedProxies:       append([]string(nil), defaultTrustedProxies...),
+		TrustedProxyPrefixes: append(netutil.TrustedProxies(nil), defaultTrustedProxyPrefixes...),
 		Expvar: ExpvarConfig{
 			Enabled: false,
 			URL:     "/debug/vars",
 		},
 		Pprof:              PprofConfig{Enabled: false},
 		RumConfig:          defaultRum(),
+		Deobfuscation:      defaultDeobfuscationConfig(),
 		Kibana:             defaultKibanaConfig(),
 		AgentConfig:        defaultAgentConfig(),
 		Aggregation:        defaultAggregationConfig(),
@@ -153,6 +230,8 @@ func DefaultConfig() *Config {
 		Profiling:          defaultProfilingConfig(),
 		DataStreams:        defaultDataStreamsConfig(),
 		AgentAuth:          defaultAgentAuth(),
+		Quotas:             defaultQuotaConfig(),
+		GeoIP:              defaultGeoIPConfig(),
 		JavaAttacherConfig: defaultJavaAttacherConfig(),
 		WaitReadyInterval:  5 * time.Second,
 	}
@@ -19,6 +19,7 @@ package config
 
 import (
 	"go/token"
+	"net/netip"
 	"path/filepath"
 	"testing"
 	"time"
@@ -31,6 +32,7 @@ import (
 	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 
 	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 var testdataCertificateConfig = tlscommon.CertificateConfig{
@@ -169,14 +171,35 @@ func TestUnpackConfig(t *testing.T) {
 				"host":                    "localhost:3000",
 				"max_header_size":         8,
 				"max_event_size":          100,
+				"max_remote_write_size":   200,
 				"idle_timeout":            5 * time.Second,
 				"read_timeout":            3 * time.Second,
 				"write_timeout":           4 * time.Second,
 				"shutdown_timeout":        9 * time.Second,
 				"capture_personal_data":   true,
 				"max_concurrent_decoders": 100,
+				"trusted_proxies":         []string{"10.0.0.0/8", "192.168.1.1"},
+				"quotas": map[string]interface{}{
+					"burst_multiplier": 2,
+					"rules": []map[string]interface{}{
+						{"service": "opbeans-go", "events_per_second": 100},
+						{"api_key_id": "*", "bytes_per_second": 1024},
+					},
+				},
+				"geoip": map[string]interface{}{
+					"database":        "GeoLite2-City.mmdb",
+					"asn_database":    "GeoLite2-ASN.mmdb",
+					"reload_interval": "10s",
+				},
 				"auth": map[string]interface{}{
 					"secret_token": "1234random",
+					"jwt": map[string]interface{}{
+						"enabled":    true,
+						"jwks_url":   "https://idp.example.com/.well-known/jwks.json",
+						"issuer":     "https://idp.example.com",
+						"audience":   "apm-server",
+						"clock_skew": "10s",
+					},
 					"api_key": map[string]interface{}{
 						"enabled":             true,
 						"limit":               200,
@@ -219,6 +242,7 @@ func TestUnpackConfig(t *testing.T) {
 					},
 					"library_pattern":       "^custom",
 					"exclude_from_grouping": "^grouping",
+					"geoip.database":        "GeoLite2-Country.mmdb",
 				},
 				"register": map[string]interface{}{
 					"ingest": map[string]interface{}{
@@ -234,16 +258,23 @@ func TestUnpackConfig(t *testing.T) {
 					"api_key": "id:api_key",
 				},
 				"aggregation": map[string]interface{}{
+					"persist_state": true,
 					"transactions": map[string]interface{}{
 						"rollup_intervals":                 []string{"10s", "10m"},
 						"max_groups":                       123,
 						"hdrhistogram_significant_figures": 1,
+						"extra_dimensions":                 []string{"http.request.method", "labels.tenant"},
+						"histogram_representation":         "exponential",
 					},
 					"service_destinations": map[string]interface{}{
 						"max_groups": 456,
 					},
 					"service_transactions": map[string]interface{}{
-						"max_groups": 457,
+						"max_groups":               457,
+						"histogram_representation": "tdigest",
+					},
+					"errors": map[string]interface{}{
+						"max_groups": 789,
 					},
 				},
 				"default_service_environment":                     "overridden",
@@ -257,6 +288,7 @@ func TestUnpackConfig(t *testing.T) {
 				Host:                  "localhost:3000",
 				MaxHeaderSize:         8,
 				MaxEventSize:          100,
+				MaxRemoteWriteSize:    200,
 				IdleTimeout:           5000000000,
 				ReadTimeout:           3000000000,
 				WriteTimeout:          4000000000,
@@ -278,6 +310,16 @@ func TestUnpackConfig(t *testing.T) {
 						configured:   true,
 						esConfigured: true,
 					},
+					JWT: JWTAgentAuth{
+						Enabled:             true,
+						JWKSURL:             "https://idp.example.com/.well-known/jwks.json",
+						JWKSRefreshInterval: time.Hour,
+						Issuer:              "https://idp.example.com",
+						Audience:            "apm-server",
+						ClockSkew:           10 * time.Second,
+						ServicesClaim:       "services",
+						ScopeClaim:          "scope",
+					},
 					Anonymous: AnonymousAgentAuth{
 						Enabled:      true,
 						AllowService: []string{"opbeans-rum"},
@@ -296,6 +338,24 @@ func TestUnpackConfig(t *testing.T) {
 					CAs:         []string{"../../testdata/tls/ca.crt.pem"},
 				},
 				AugmentEnabled: true,
+				TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"},
+				TrustedProxyPrefixes: netutil.TrustedProxies{
+					netip.MustParsePrefix("10.0.0.0/8"),
+					netip.MustParsePrefix("192.168.1.1/32"),
+				},
+				Quotas: QuotaConfig{
+					BurstMultiplier: 2,
+					CacheSize:       10000,
+					Rules: []QuotaRule{
+						{Service: "opbeans-go", EventsPerSecond: 100},
+						{APIKeyID: "*", BytesPerSecond: 1024},
+					},
+				},
+				GeoIP: GeoIPConfig{
+					Database:       "GeoLite2-City.mmdb",
+					ASNDatabase:    "GeoLite2-ASN.mmdb",
+					ReloadInterval: 10 * time.Second,
+				},
 				Expvar: ExpvarConfig{
 					Enabled: true,
 					URL:     "/debug/vars",
@@ -322,7 +382,12 @@ func TestUnpackConfig(t *testing.T) {
 					},
 					LibraryPattern:      "^custom",
 					ExcludeFromGrouping: "^grouping",
+					GeoIP: GeoIPConfig{
+						Database:       "GeoLite2-Country.mmdb",
+						ReloadInterval: time.Minute,
+					},
 				},
+				Deobfuscation: defaultDeobfuscationConfig(),
 				Kibana: KibanaConfig{
 					Enabled:      true,
 					ClientConfig: defaultDecodedKibanaClientConfig,
@@ -341,9 +406,12 @@ func TestUnpackConfig(t *testing.T) {
 					ESOverrideConfigured: true,
 				},
 				Aggregation: AggregationConfig{
+					PersistState: true,
 					Transactions: TransactionAggregationConfig{
 						MaxTransactionGroups:           123,
 						HDRHistogramSignificantFigures: 1,
+						HistogramRepresentation:        "exponential",
+						ExtraDimensions:                []string{"http.request.method", "labels.tenant"},
 					},
 					ServiceDestinations: ServiceDestinationAggregationConfig{
 						MaxGroups: 456,
@@ -351,6 +419,10 @@ func TestUnpackConfig(t *testing.T) {
 					ServiceTransactions: ServiceTransactionAggregationConfig{
 						MaxGroups:                      457,
 						HDRHistogramSignificantFigures: 2,
+						HistogramRepresentation:        "tdigest",
+					},
+					Errors: ErrorAggregationConfig{
+						MaxGroups: 789,
 					},
 				},
 				Sampling: SamplingConfig{
@@ -362,6 +434,7 @@ func TestUnpackConfig(t *testing.T) {
 						StorageGCInterval:     5 * time.Minute,
 						StorageLimit:          "3GB",
 						StorageLimitParsed:    3000000000,
+						StorageCodec:          "json",
 						TTL:                   30 * time.Minute,
 					},
 				},
@@ -427,6 +500,7 @@ func TestUnpackConfig(t *testing.T) {
 					"interval":          "2m",
 					"ingest_rate_decay": 1.0,
 					"storage_limit":     "1GB",
+					"storage_codec":     "binary",
 				},
 				"data_streams": map[string]interface{}{
 					"namespace":            "foo",
@@ -434,13 +508,14 @@ func TestUnpackConfig(t *testing.T) {
 				},
 			},
 			outCfg: &Config{
-				Host:            "localhost:3000",
-				MaxHeaderSize:   1048576,
-				MaxEventSize:    307200,
-				IdleTimeout:     45000000000,
-				ReadTimeout:     30000000000,
-				WriteTimeout:    30000000000,
-				ShutdownTimeout: 30000000000,
+				Host:               "localhost:3000",
+				MaxHeaderSize:      1048576,
+				MaxEventSize:       307200,
+				MaxRemoteWriteSize: 10485760,
+				IdleTimeout:        45000000000,
+				ReadTimeout:        30000000000,
+				WriteTimeout:       30000000000,
+				ShutdownTimeout:    30000000000,
 				AgentAuth: AgentAuth{
 					SecretToken: "1234random",
 					APIKey: APIKeyAgentAuth{
@@ -449,6 +524,7 @@ func TestUnpackConfig(t *testing.T) {
 						ESConfig:    elasticsearch.DefaultConfig(),
 						configured:  true,
 					},
+					JWT: defaultJWTAgentAuth(),
 					Anonymous: AnonymousAgentAuth{
 						Enabled:    true,
 						AllowAgent: []string{"rum-js", "js-base"},
@@ -464,6 +540,20 @@ func TestUnpackConfig(t *testing.T) {
 					ClientAuth:  0,
 				},
 				AugmentEnabled: true,
+				TrustedProxies: []string{
+					"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+					"::1/128", "fc00::/7",
+				},
+				TrustedProxyPrefixes: netutil.TrustedProxies{
+					netip.MustParsePrefix("127.0.0.0/8"),
+					netip.MustParsePrefix("10.0.0.0/8"),
+					netip.MustParsePrefix("172.16.0.0/12"),
+					netip.MustParsePrefix("192.168.0.0/16"),
+					netip.MustParsePrefix("::1/128"),
+					netip.MustParsePrefix("fc00::/7"),
+				},
+				Quotas: defaultQuotaConfig(),
+				GeoIP:  defaultGeoIPConfig(),
 				Expvar: ExpvarConfig{
 					Enabled: true,
 					URL:     "/debug/vars",
@@ -482,8 +572,10 @@ func TestUnpackConfig(t *testing.T) {
 					},
 					LibraryPattern:      "rum",
 					ExcludeFromGrouping: "^/webpack",
+					GeoIP:               defaultGeoIPConfig(),
 				},
-				Kibana: defaultKibanaConfig(),
+				Deobfuscation: defaultDeobfuscationConfig(),
+				Kibana:        defaultKibanaConfig(),
 				AgentConfig: AgentConfig{
 					ESConfig: elasticsearch.DefaultConfig(),
 					Cache:    Cache{Expiration: 30 * time.Second},
@@ -492,6 +584,7 @@ func TestUnpackConfig(t *testing.T) {
 					Transactions: TransactionAggregationConfig{
 						MaxTransactionGroups:           0, // Default value is set as per memory limit
 						HDRHistogramSignificantFigures: 2,
+						HistogramRepresentation:        "hdr",
 					},
 					ServiceDestinations: ServiceDestinationAggregationConfig{
 						MaxGroups: 10000,
@@ -499,6 +592,10 @@ func TestUnpackConfig(t *testing.T) {
 					ServiceTransactions: ServiceTransactionAggregationConfig{
 						MaxGroups:                      0, // Default value is set as per memory limit
 						HDRHistogramSignificantFigures: 2,
+						HistogramRepresentation:        "hdr",
+					},
+					Errors: ErrorAggregationConfig{
+						MaxGroups: 0, // Default value is set as per memory limit
 					},
 				},
 				Sampling: SamplingConfig{
@@ -511,6 +608,7 @@ func TestUnpackConfig(t *testing.T) {
 						StorageGCInterval:     5 * time.Minute,
 						StorageLimit:          "1GB",
 						StorageLimitParsed:    1000000000,
+						StorageCodec:          "binary",
 						TTL:                   30 * time.Minute,
 					},
 				},
@@ -722,6 +820,7 @@ func TestTLSSettings(t *testing.T) {
 func TestNewConfig_ESConfig(t *testing.T) {
 	ucfg, err := config.NewConfigFrom(`{
 		"rum.enabled": true,
+		"deobfuscation.enabled": true,
 		"auth.api_key.enabled": true,
 		"sampling.tail.policies": [{"sample_rate": 0.5}],
 		"profiling": {
@@ -735,6 +834,7 @@ func TestNewConfig_ESConfig(t *testing.T) {
 	cfg, err := NewConfig(ucfg, nil)
 	require.NoError(t, err)
 	assert.Equal(t, elasticsearch.DefaultConfig(), cfg.RumConfig.SourceMapping.ESConfig)
+	assert.Equal(t, elasticsearch.DefaultConfig(), cfg.Deobfuscation.ESConfig)
 	assert.Equal(t, elasticsearch.DefaultConfig(), cfg.AgentAuth.APIKey.ESConfig)
 	assert.Equal(t, elasticsearch.DefaultConfig(), cfg.Sampling.Tail.ESConfig)
 	assert.Equal(t, elasticsearch.DefaultConfig(), cfg.Profiling.ESConfig)
@@ -746,6 +846,7 @@ func TestNewConfig_ESConfig(t *testing.T) {
 	require.NoError(t, err)
 	assert.NotNil(t, cfg.RumConfig.SourceMapping.ESConfig)
 	assert.Equal(t, []string{"192.0.0.168:9200"}, []string(cfg.RumConfig.SourceMapping.ESConfig.Hosts))
+	assert.Equal(t, []string{"192.0.0.168:9200"}, []string(cfg.Deobfuscation.ESConfig.Hosts))
 	assert.Equal(t, []string{"192.0.0.168:9200"}, []string(cfg.AgentAuth.APIKey.ESConfig.Hosts))
 	assert.Equal(t, []string{"192.0.0.168:9200"}, []string(cfg.Sampling.Tail.ESConfig.Hosts))
 	assert.Equal(t, []string{"192.0.0.168:9200"}, []string(cfg.Profiling.ESConfig.Hosts))
@@ -753,6 +854,42 @@ func TestNewConfig_ESConfig(t *testing.T) {
 	assert.NotEqual(t, []string{"192.0.0.168:9200"}, []string(cfg.Profiling.MetricsESConfig.Hosts))
 }
 
+func TestNewConfig_TrustedProxies(t *testing.T) {
+	cfg, err := NewConfig(config.NewConfig(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConfig().TrustedProxyPrefixes, cfg.TrustedProxyPrefixes)
+	assert.False(t, cfg.TrustedProxyPrefixes.Contains(netip.MustParseAddr("203.0.113.1")))
+	assert.True(t, cfg.TrustedProxyPrefixes.Contains(netip.MustParseAddr("10.1.2.3")))
+
+	// A configured list replaces the defaults entirely.
+	cfg, err = NewConfig(config.MustNewConfigFrom(`{"trusted_proxies": ["203.0.113.1"]}`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"203.0.113.1"}, cfg.TrustedProxies)
+	assert.Equal(t, netutil.TrustedProxies{netip.MustParsePrefix("203.0.113.1/32")}, cfg.TrustedProxyPrefixes)
+
+	// An empty list trusts no network peers.
+	cfg, err = NewConfig(config.MustNewConfigFrom(`{"trusted_proxies": []}`), nil)
+	require.NoError(t, err)
+	assert.Empty(t, cfg.TrustedProxyPrefixes)
+	assert.False(t, cfg.TrustedProxyPrefixes.Contains(netip.MustParseAddr("127.0.0.1")))
+
+	_, err = NewConfig(config.MustNewConfigFrom(`{"trusted_proxies": ["proxy.invalid"]}`), nil)
+	assert.Error(t, err)
+}
+
+func TestNewConfig_DeobfuscationCache(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(`{"deobfuscation.enabled": true}`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, DeobfuscationCache{Expiration: 5 * time.Minute, Size: 128}, cfg.Deobfuscation.Cache)
+
+	cfg, err = NewConfig(config.MustNewConfigFrom(`{"deobfuscation.cache.size": 16}`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 16, cfg.Deobfuscation.Cache.Size)
+
+	_, err = NewConfig(config.MustNewConfigFrom(`{"deobfuscation.cache.size": 0}`), nil)
+	assert.Error(t, err)
+}
+
 func newBool(v bool) *bool {
 	return &v
 }
@@ -0,0 +1,109 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-ucfg"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+const (
+	defaultDeobfuscationTimeout         = 5 * time.Second
+	defaultDeobfuscationCacheExpiration = 5 * time.Minute
+	defaultDeobfuscationCacheSize       = 128
+)
+
+// DeobfuscationConfig holds configuration for deobfuscating stack traces of
+// errors reported by Android agents, using R8/ProGuard mapping files.
+type DeobfuscationConfig struct {
+	Enabled  bool               `config:"enabled"`
+	Timeout  time.Duration      `config:"timeout" validate:"positive"`
+	Cache    DeobfuscationCache `config:"cache"`
+	ESConfig *elasticsearch.Config
+
+	es *config.C
+}
+
+// DeobfuscationCache holds configuration for caching parsed mapping files.
+type DeobfuscationCache struct {
+	Expiration time.Duration `config:"expiration" validate:"min=1s"`
+	Size       int           `config:"size" validate:"min=1"`
+}
+
+func (c *DeobfuscationConfig) Unpack(in *config.C) error {
+	type deobfuscationConfig DeobfuscationConfig
+	cfg := deobfuscationConfig(defaultDeobfuscationConfig())
+	if err := in.Unpack(&cfg); err != nil {
+		return errors.Wrap(err, "error unpacking deobfuscation config")
+	}
+	*c = DeobfuscationConfig(cfg)
+
+	var err error
+	c.es, err = in.Child("elasticsearch", -1)
+
+	var ucfgError ucfg.Error
+	if !errors.As(err, &ucfgError) || ucfgError.Reason() != ucfg.ErrMissing {
+		return errors.Wrap(err, "error unpacking deobfuscation elasticsearch config")
+	}
+	return nil
+}
+
+func (c *DeobfuscationConfig) setup(log *logp.Logger, outputESCfg *config.C) error {
+	if !c.Enabled {
+		return nil
+	}
+	if outputESCfg != nil {
+		if err := outputESCfg.Unpack(&c.ESConfig); err != nil {
+			return errors.Wrap(err, "error unpacking output.elasticsearch for deobfuscation")
+		}
+	}
+	if c.es != nil {
+		log.Info("using apm-server.deobfuscation.elasticsearch for fetching mapping files")
+
+		// Empty out credential fields before merging if credentials are provided in deobfuscation ES config
+		if c.es.HasField("api_key") || c.es.HasField("username") {
+			c.ESConfig.APIKey = ""
+			c.ESConfig.Username = ""
+			c.ESConfig.Password = ""
+		}
+
+		if err := c.es.Unpack(c.ESConfig); err != nil {
+			return errors.Wrap(err, "error unpacking apm-server.deobfuscation.elasticsearch for fetching mapping files")
+		}
+		c.es = nil
+	}
+	return nil
+}
+
+func defaultDeobfuscationConfig() DeobfuscationConfig {
+	return DeobfuscationConfig{
+		ESConfig: elasticsearch.DefaultConfig(),
+		Timeout:  defaultDeobfuscationTimeout,
+		Cache: DeobfuscationCache{
+			Expiration: defaultDeobfuscationCacheExpiration,
+			Size:       defaultDeobfuscationCacheSize,
+		},
+	}
+}
@@ -0,0 +1,87 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"path"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var filterRuleNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// FilterRule holds a rule for dropping events matching event fields,
+// or keeping only a fraction of them.
+type FilterRule struct {
+	// Name identifies the rule, and is used for naming its metrics.
+	Name string `config:"name"`
+
+	// Match holds the event fields which this rule matches. An event
+	// must match all specified fields to match the rule.
+	Match struct {
+		Service struct {
+			Name string `config:"name"`
+		} `config:"service"`
+		Transaction struct {
+			Name string `config:"name"`
+			Type string `config:"type"`
+		} `config:"transaction"`
+		URL struct {
+			// Path holds a glob pattern matched against url.path.
+			Path string `config:"path"`
+		} `config:"url"`
+		Processor struct {
+			Event string `config:"event"`
+		} `config:"processor"`
+		Labels map[string]string `config:"labels"`
+	} `config:"match"`
+
+	// SampleRate holds the fraction of matching events which are kept.
+	// By default all matching events are dropped.
+	SampleRate float64 `config:"sample_rate" validate:"min=0, max=1"`
+}
+
+// Validate validates the filter rule.
+func (r *FilterRule) Validate() error {
+	if !filterRuleNameRegexp.MatchString(r.Name) {
+		return errors.Errorf("invalid filter name %q: must match %s", r.Name, filterRuleNameRegexp)
+	}
+	m := r.Match
+	if m.Service.Name == "" && m.Transaction.Name == "" && m.Transaction.Type == "" &&
+		m.URL.Path == "" && m.Processor.Event == "" && len(m.Labels) == 0 {
+		return errors.Errorf("filter %q: at least one match condition must be specified", r.Name)
+	}
+	if m.URL.Path != "" {
+		if _, err := path.Match(m.URL.Path, ""); err != nil {
+			return errors.Wrapf(err, "filter %q: invalid match.url.path %q", r.Name, m.URL.Path)
+		}
+	}
+	return nil
+}
+
+func validateFilterRules(rules []FilterRule) error {
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if names[rule.Name] {
+			return errors.Errorf("duplicate filter name %q", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func TestFilterRulesInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		value  interface{}
+		expect string
+	}{{
+		name:   "no name",
+		value:  []map[string]interface{}{{"match": map[string]interface{}{"service.name": "a"}}},
+		expect: `invalid filter name ""`,
+	}, {
+		name:   "invalid name",
+		value:  []map[string]interface{}{{"name": "a.b", "match": map[string]interface{}{"service.name": "a"}}},
+		expect: `invalid filter name "a.b"`,
+	}, {
+		name:   "no match conditions",
+		value:  []map[string]interface{}{{"name": "a"}},
+		expect: `filter "a": at least one match condition must be specified`,
+	}, {
+		name:   "invalid url.path",
+		value:  []map[string]interface{}{{"name": "a", "match": map[string]interface{}{"url.path": "/[a"}}},
+		expect: `filter "a": invalid match.url.path "/[a": syntax error in pattern`,
+	}, {
+		name: "invalid sample_rate",
+		value: []map[string]interface{}{
+			{"name": "a", "match": map[string]interface{}{"service.name": "a"}, "sample_rate": 1.5},
+		},
+		expect: "requires value <= 1",
+	}, {
+		name: "duplicate name",
+		value: []map[string]interface{}{
+			{"name": "a", "match": map[string]interface{}{"service.name": "a"}},
+			{"name": "a", "match": map[string]interface{}{"service.name": "b"}},
+		},
+		expect: `duplicate filter name "a"`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"filters": test.value,
+			}), nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expect)
+		})
+	}
+}
+
+func TestFilterRules(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"filters": []map[string]interface{}{{
+			"name": "healthz",
+			"match": map[string]interface{}{
+				"service.name":     "opbeans-go",
+				"transaction.name": "GET /healthz",
+				"transaction.type": "request",
+				"url.path":         "/health*",
+				"processor.event":  "transaction",
+				"labels":           map[string]interface{}{"team": "ops"},
+			},
+			"sample_rate": 0.1,
+		}},
+	}), nil)
+	require.NoError(t, err)
+
+	var expected FilterRule
+	expected.Name = "healthz"
+	expected.Match.Service.Name = "opbeans-go"
+	expected.Match.Transaction.Name = "GET /healthz"
+	expected.Match.Transaction.Type = "request"
+	expected.Match.URL.Path = "/health*"
+	expected.Match.Processor.Event = "transaction"
+	expected.Match.Labels = map[string]string{"team": "ops"}
+	expected.SampleRate = 0.1
+	assert.Equal(t, []FilterRule{expected}, cfg.Filters)
+}
@@ -0,0 +1,47 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import "time"
+
+const defaultGeoIPReloadInterval = time.Minute
+
+// GeoIPConfig holds configuration for enriching events with the geographical
+// location and autonomous system of client.ip, using local MaxMind databases.
+type GeoIPConfig struct {
+	// Database holds the path to a GeoIP2 or GeoLite2 City or Country
+	// database, used for setting client.geo.*.
+	Database string `config:"database"`
+
+	// ASNDatabase holds the path to a GeoIP2 ISP or GeoLite2 ASN database,
+	// used for setting source.as.*.
+	ASNDatabase string `config:"asn_database"`
+
+	// ReloadInterval holds the interval for checking whether the database
+	// files have been modified, and reloading them if so.
+	ReloadInterval time.Duration `config:"reload_interval" validate:"positive"`
+}
+
+// IsEnabled reports whether GeoIP enrichment is enabled.
+func (c *GeoIPConfig) IsEnabled() bool {
+	return c.Database != "" || c.ASNDatabase != ""
+}
+
+func defaultGeoIPConfig() GeoIPConfig {
+	return GeoIPConfig{ReloadInterval: defaultGeoIPReloadInterval}
+}
@@ -0,0 +1,89 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+// QuotaConfig holds configuration for ingestion quotas, limiting the rate
+// of events and bytes ingested per service, API Key, or secret token.
+type QuotaConfig struct {
+	// BurstMultiplier holds the multiple of each quota's rates which may
+	// be consumed in a burst.
+	BurstMultiplier int `config:"burst_multiplier" validate:"min=1"`
+
+	// CacheSize holds the maximum number of distinct values for which
+	// wildcard quotas will maintain a distinct rate limit. Once this has
+	// been reached, values will begin sharing rate limits.
+	CacheSize int `config:"cache_size" validate:"min=1"`
+
+	// Rules holds the quotas. Each event is subject to all matching quotas.
+	Rules []QuotaRule `config:"rules"`
+}
+
+// QuotaRule holds an ingestion quota for a service name, API Key ID, or
+// secret token name. Exactly one of Service, APIKeyID, or SecretToken
+// must be specified; the value "*" applies the quota to each distinct
+// value that does not have its own quota.
+type QuotaRule struct {
+	Service     string `config:"service"`
+	APIKeyID    string `config:"api_key_id"`
+	SecretToken string `config:"secret_token"`
+
+	// EventsPerSecond holds the maximum number of events per second.
+	// If EventsPerSecond is zero, the number of events is not limited.
+	EventsPerSecond float64 `config:"events_per_second" validate:"min=0"`
+
+	// BytesPerSecond holds the maximum number of bytes per second, measured
+	// as the size of the events' JSON encoding. If BytesPerSecond is zero,
+	// the number of bytes is not limited.
+	BytesPerSecond float64 `config:"bytes_per_second" validate:"min=0"`
+}
+
+// Validate validates the quota config.
+func (c *QuotaConfig) Validate() error {
+	keys := make(map[QuotaRule]bool)
+	for i, rule := range c.Rules {
+		var n int
+		for _, v := range []string{rule.Service, rule.APIKeyID, rule.SecretToken} {
+			if v != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			return errors.Errorf("rules[%d]: exactly one of service, api_key_id, or secret_token must be specified", i)
+		}
+		if rule.EventsPerSecond == 0 && rule.BytesPerSecond == 0 {
+			return errors.Errorf("rules[%d]: at least one of events_per_second or bytes_per_second must be specified", i)
+		}
+		key := QuotaRule{Service: rule.Service, APIKeyID: rule.APIKeyID, SecretToken: rule.SecretToken}
+		if keys[key] {
+			return errors.Errorf("rules[%d]: duplicate quota", i)
+		}
+		keys[key] = true
+	}
+	return nil
+}
+
+func defaultQuotaConfig() QuotaConfig {
+	return QuotaConfig{
+		BurstMultiplier: 3,
+		CacheSize:       10000,
+	}
+}
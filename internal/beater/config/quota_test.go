@@ -0,0 +1,78 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func TestQuotaConfigInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		value  interface{}
+		expect string
+	}{{
+		name:   "no key",
+		value:  map[string]interface{}{"rules": []map[string]interface{}{{"events_per_second": 1}}},
+		expect: "rules[0]: exactly one of service, api_key_id, or secret_token must be specified",
+	}, {
+		name: "multiple keys",
+		value: map[string]interface{}{"rules": []map[string]interface{}{
+			{"service": "a", "api_key_id": "b", "events_per_second": 1},
+		}},
+		expect: "rules[0]: exactly one of service, api_key_id, or secret_token must be specified",
+	}, {
+		name:   "no limits",
+		value:  map[string]interface{}{"rules": []map[string]interface{}{{"service": "a"}}},
+		expect: "rules[0]: at least one of events_per_second or bytes_per_second must be specified",
+	}, {
+		name: "duplicate",
+		value: map[string]interface{}{"rules": []map[string]interface{}{
+			{"service": "*", "events_per_second": 1},
+			{"service": "*", "bytes_per_second": 1},
+		}},
+		expect: "rules[1]: duplicate quota",
+	}, {
+		name:   "negative rate",
+		value:  map[string]interface{}{"rules": []map[string]interface{}{{"service": "a", "events_per_second": -1}}},
+		expect: "requires value >= 0",
+	}, {
+		name:   "zero burst_multiplier",
+		value:  map[string]interface{}{"burst_multiplier": 0},
+		expect: "requires value >= 1",
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"quotas": test.value,
+			}), nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expect)
+		})
+	}
+}
+
+func TestQuotaConfigDefault(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{}), nil)
+	require.NoError(t, err)
+	assert.Equal(t, defaultQuotaConfig(), cfg.Quotas)
+}
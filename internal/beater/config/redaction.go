@@ -0,0 +1,89 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var redactionRuleNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+const (
+	redactionActionMask = "mask"
+	redactionActionHash = "hash"
+)
+
+// RedactionRule holds a rule for redacting personal data from event fields.
+type RedactionRule struct {
+	// Name identifies the rule, and is used for naming its metrics.
+	Name string `config:"name"`
+
+	// Fields holds the event fields to which the rule applies.
+	Fields []string `config:"fields"`
+
+	// Names holds case-insensitive glob patterns matched against the names
+	// of URL query parameters, HTTP headers and cookies, and labels.
+	Names []string `config:"names"`
+
+	// Patterns holds regular expressions matched against field values.
+	Patterns []string `config:"patterns"`
+
+	// Action holds the redaction action: "mask" (the default) or "hash".
+	Action string `config:"action"`
+}
+
+// Hash reports whether redacted values should be hashed, rather than masked.
+func (r *RedactionRule) Hash() bool {
+	return r.Action == redactionActionHash
+}
+
+// Validate validates the redaction rule.
+func (r *RedactionRule) Validate() error {
+	if !redactionRuleNameRegexp.MatchString(r.Name) {
+		return errors.Errorf("invalid redaction rule name %q: must match %s", r.Name, redactionRuleNameRegexp)
+	}
+	if len(r.Fields) == 0 {
+		return errors.Errorf("redaction rule %q: at least one field must be specified", r.Name)
+	}
+	switch r.Action {
+	case "", redactionActionMask, redactionActionHash:
+	default:
+		return errors.Errorf("redaction rule %q: invalid action %q, expected %q or %q",
+			r.Name, r.Action, redactionActionMask, redactionActionHash,
+		)
+	}
+	for _, pattern := range r.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.Wrapf(err, "redaction rule %q: invalid pattern", r.Name)
+		}
+	}
+	return nil
+}
+
+func validateRedactionRules(rules []RedactionRule) error {
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if names[rule.Name] {
+			return errors.Errorf("duplicate redaction rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
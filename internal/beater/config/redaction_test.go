@@ -0,0 +1,94 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func TestRedactionRulesInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		value  interface{}
+		expect string
+	}{{
+		name:   "no name",
+		value:  []map[string]interface{}{{"fields": []string{"user.email"}}},
+		expect: `invalid redaction rule name ""`,
+	}, {
+		name:   "no fields",
+		value:  []map[string]interface{}{{"name": "a"}},
+		expect: `redaction rule "a": at least one field must be specified`,
+	}, {
+		name:   "invalid action",
+		value:  []map[string]interface{}{{"name": "a", "fields": []string{"user.email"}, "action": "drop"}},
+		expect: `redaction rule "a": invalid action "drop", expected "mask" or "hash"`,
+	}, {
+		name:   "invalid pattern",
+		value:  []map[string]interface{}{{"name": "a", "fields": []string{"labels"}, "patterns": []string{"("}}},
+		expect: `redaction rule "a": invalid pattern`,
+	}, {
+		name: "duplicate name",
+		value: []map[string]interface{}{
+			{"name": "a", "fields": []string{"user.email"}},
+			{"name": "a", "fields": []string{"span.db.statement"}},
+		},
+		expect: `duplicate redaction rule name "a"`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"redaction": test.value,
+			}), nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expect)
+		})
+	}
+}
+
+func TestRedactionRules(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"redaction": []map[string]interface{}{{
+			"name":   "secrets",
+			"fields": []string{"url.query", "http.request.headers"},
+			"names":  []string{"authorization", "*token*"},
+		}, {
+			"name":     "emails",
+			"fields":   []string{"user.email"},
+			"patterns": []string{"@.*$"},
+			"action":   "hash",
+		}},
+	}), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []RedactionRule{{
+		Name:   "secrets",
+		Fields: []string{"url.query", "http.request.headers"},
+		Names:  []string{"authorization", "*token*"},
+	}, {
+		Name:     "emails",
+		Fields:   []string{"user.email"},
+		Patterns: []string{"@.*$"},
+		Action:   "hash",
+	}}, cfg.Redaction)
+	assert.False(t, cfg.Redaction[0].Hash())
+	assert.True(t, cfg.Redaction[1].Hash())
+}
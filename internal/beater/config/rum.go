@@ -46,6 +46,7 @@ type RumConfig struct {
 	LibraryPattern      string              `config:"library_pattern"`
 	ExcludeFromGrouping string              `config:"exclude_from_grouping"`
 	SourceMapping       SourceMapping       `config:"source_mapping"`
+	GeoIP               GeoIPConfig         `config:"geoip"`
 }
 
 // SourceMapping holds sourcemap config information
@@ -131,5 +132,6 @@ func defaultRum() RumConfig {
 		SourceMapping:       defaultSourcemapping(),
 		LibraryPattern:      defaultLibraryPattern,
 		ExcludeFromGrouping: defaultExcludeFromGrouping,
+		GeoIP:               defaultGeoIPConfig(),
 	}
 }
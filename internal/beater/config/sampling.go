@@ -27,6 +27,7 @@ import (
 	"github.com/elastic/apm-server/internal/logs"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 )
 
 // SamplingConfig holds configuration related to sampling.
@@ -45,6 +46,10 @@ type TailSamplingConfig struct {
 	// that dropping non-matching traces is intentional.
 	Policies []TailSamplingPolicy `config:"policies"`
 
+	// Peers holds configuration for sharing sampling decisions directly
+	// with other APM Servers, rather than via Elasticsearch.
+	Peers TailSamplingPeersConfig `config:"peers"`
+
 	ESConfig              *elasticsearch.Config `config:"elasticsearch"`
 	Interval              time.Duration         `config:"interval" validate:"min=1s"`
 	IngestRateDecayFactor float64               `config:"ingest_rate_decay" validate:"min=0, max=1"`
@@ -53,9 +58,43 @@ type TailSamplingConfig struct {
 	StorageLimit          string                `config:"storage_limit"`
 	StorageLimitParsed    uint64
 
+	// StorageCodec holds the name of the codec used for encoding events
+	// in local storage: "json" or "binary". Events encoded with either
+	// codec can be decoded after changing the codec.
+	StorageCodec string `config:"storage_codec"`
+
 	esConfigured bool
 }
 
+// TailSamplingPeersConfig holds configuration for sharing tail-sampling
+// decisions directly with other APM Servers.
+type TailSamplingPeersConfig struct {
+	// Hosts holds the addresses of the other APM Servers, in host:port form.
+	//
+	// If Hosts is empty, sampling decisions are shared via Elasticsearch.
+	Hosts []string `config:"hosts"`
+
+	// SecretToken and APIKey hold optional credentials for authenticating
+	// with peers. At most one may be specified, and TLS must be enabled
+	// if either is, to avoid sending credentials in plain text.
+	SecretToken string `config:"secret_token"`
+	APIKey      string `config:"api_key"`
+
+	// TLS holds TLS configuration for connecting to peers.
+	TLS *tlscommon.Config `config:"ssl"`
+}
+
+// Validate validates the peers configuration.
+func (c *TailSamplingPeersConfig) Validate() error {
+	if c.SecretToken != "" && c.APIKey != "" {
+		return errors.New("only one of secret_token and api_key may be specified")
+	}
+	if (c.SecretToken != "" || c.APIKey != "") && !c.TLS.IsEnabled() {
+		return errors.New("ssl must be enabled when secret_token or api_key is specified")
+	}
+	return nil
+}
+
 // TailSamplingPolicy holds a tail-sampling policy.
 type TailSamplingPolicy struct {
 	// Service holds attributes of the service which this policy matches.
@@ -65,9 +104,17 @@ type TailSamplingPolicy struct {
 	} `config:"service"`
 
 	// Trace holds attributes of the trace which this policy matches.
+	//
+	// Most attributes are matched against the trace's root transaction.
+	// AnySpanFailure is matched against the trace's other events.
 	Trace struct {
-		Name    string `config:"name"`
-		Outcome string `config:"outcome"`
+		Name           string            `config:"name"`
+		Outcome        string            `config:"outcome"`
+		Type           string            `config:"type"`
+		MinDuration    time.Duration     `config:"min_duration"`
+		Labels         map[string]string `config:"labels"`
+		HTTPStatusCode int               `config:"http_status_code" validate:"min=0, max=999"`
+		AnySpanFailure bool              `config:"any_span_failure"`
 	} `config:"trace"`
 
 	// SampleRate holds the sample rate applied for this policy.
@@ -110,9 +157,14 @@ func (c *TailSamplingConfig) Validate() error {
 	if len(c.Policies) == 0 {
 		return errors.New("no policies specified")
 	}
+	switch c.StorageCodec {
+	case "json", "binary":
+	default:
+		return errors.Errorf("invalid storage_codec %q, expected \"json\" or \"binary\"", c.StorageCodec)
+	}
 	var anyDefaultPolicy bool
 	for _, policy := range c.Policies {
-		if policy == (TailSamplingPolicy{SampleRate: policy.SampleRate}) {
+		if policy.isDefault() {
 			// We have at least one default policy.
 			anyDefaultPolicy = true
 			break
@@ -124,6 +176,19 @@ func (c *TailSamplingConfig) Validate() error {
 	return nil
 }
 
+// isDefault reports whether p has empty criteria, and so matches all traces.
+func (p TailSamplingPolicy) isDefault() bool {
+	return p.Service.Name == "" &&
+		p.Service.Environment == "" &&
+		p.Trace.Name == "" &&
+		p.Trace.Outcome == "" &&
+		p.Trace.Type == "" &&
+		p.Trace.MinDuration == 0 &&
+		len(p.Trace.Labels) == 0 &&
+		p.Trace.HTTPStatusCode == 0 &&
+		!p.Trace.AnySpanFailure
+}
+
 func (c *TailSamplingConfig) setup(log *logp.Logger, outputESCfg *config.C) error {
 	if !c.Enabled {
 		return nil
@@ -153,6 +218,7 @@ func defaultTailSamplingConfig() TailSamplingConfig {
 		StorageGCInterval:     5 * time.Minute,
 		TTL:                   30 * time.Minute,
 		StorageLimit:          "3GB",
+		StorageCodec:          "json",
 	}
 	parsed, err := humanize.ParseBytes(cfg.StorageLimit)
 	if err != nil {
@@ -19,8 +19,10 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/elastic-agent-libs/config"
 )
@@ -51,4 +53,96 @@ func TestSamplingPoliciesValidation(t *testing.T) {
 		assert.NoError(t, err)
 		assert.False(t, c.Sampling.Tail.Enabled)
 	})
+	t.Run("NoDefaultPoliciesTraceCriteria", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies": []map[string]interface{}{{
+				"trace.any_span_failure": true,
+				"sample_rate":            0.5,
+			}},
+		}), nil)
+		assert.NoError(t, err)
+		assert.False(t, c.Sampling.Tail.Enabled)
+	})
+}
+
+func TestSamplingStorageCodec(t *testing.T) {
+	newConfig := func(codec string) *Config {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies":      []map[string]interface{}{{"sample_rate": 0.5}},
+			"sampling.tail.storage_codec": codec,
+		}), nil)
+		require.NoError(t, err)
+		return c
+	}
+	c := newConfig("binary")
+	assert.True(t, c.Sampling.Tail.Enabled)
+	assert.Equal(t, "binary", c.Sampling.Tail.StorageCodec)
+
+	c = newConfig("gob")
+	assert.False(t, c.Sampling.Tail.Enabled)
+}
+
+func TestSamplingPoliciesTraceCriteria(t *testing.T) {
+	c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"sampling.tail.policies": []map[string]interface{}{{
+			"trace.type":             "request",
+			"trace.min_duration":     "500ms",
+			"trace.labels":           map[string]interface{}{"tier": "gold"},
+			"trace.http_status_code": 503,
+			"trace.any_span_failure": true,
+			"sample_rate":            1.0,
+		}, {
+			"sample_rate": 0.1,
+		}},
+	}), nil)
+	require.NoError(t, err)
+	require.True(t, c.Sampling.Tail.Enabled)
+	require.Len(t, c.Sampling.Tail.Policies, 2)
+
+	trace := c.Sampling.Tail.Policies[0].Trace
+	assert.Equal(t, "request", trace.Type)
+	assert.Equal(t, 500*time.Millisecond, trace.MinDuration)
+	assert.Equal(t, map[string]string{"tier": "gold"}, trace.Labels)
+	assert.Equal(t, 503, trace.HTTPStatusCode)
+	assert.True(t, trace.AnySpanFailure)
+}
+
+func TestSamplingPeersValidation(t *testing.T) {
+	newConfig := func(peers map[string]interface{}) *Config {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies": []map[string]interface{}{{"sample_rate": 0.5}},
+			"sampling.tail.peers":    peers,
+		}), nil)
+		require.NoError(t, err)
+		return c
+	}
+
+	c := newConfig(map[string]interface{}{"hosts": []string{"apm-server-2:8200"}})
+	assert.True(t, c.Sampling.Tail.Enabled)
+
+	// Invalid peers configuration disables tail sampling.
+	c = newConfig(map[string]interface{}{
+		"hosts":        []string{"apm-server-2:8200"},
+		"secret_token": "abc123",
+		"api_key":      "def456",
+		"ssl.enabled":  true,
+	})
+	assert.False(t, c.Sampling.Tail.Enabled)
+
+	for _, credentials := range []string{"secret_token", "api_key"} {
+		// Credentials must not be sent in plain text.
+		c = newConfig(map[string]interface{}{
+			"hosts":     []string{"apm-server-2:8200"},
+			credentials: "abc123",
+		})
+		assert.False(t, c.Sampling.Tail.Enabled)
+
+		c = newConfig(map[string]interface{}{
+			"hosts":       []string{"apm-server-2:8200"},
+			credentials:   "abc123",
+			"ssl.enabled": true,
+		})
+		assert.True(t, c.Sampling.Tail.Enabled)
+		assert.Equal(t, "abc123", c.Sampling.Tail.Peers.SecretToken+c.Sampling.Tail.Peers.APIKey)
+	}
 }
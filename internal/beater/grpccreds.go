@@ -0,0 +1,85 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// tlsConnectionInfo is a credentials.TransportCredentials which exposes the
+// state of TLS connections terminated by the net/http server to gRPC, without
+// performing a handshake of its own. This enables the verified TLS client
+// certificate to be obtained from the gRPC peer info by interceptors.
+//
+// Connections without TLS are passed through with no auth info.
+type tlsConnectionInfo struct{}
+
+// ClientHandshake returns the connection unmodified; tlsConnectionInfo is
+// only used for servers.
+func (tlsConnectionInfo) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, nil, nil
+}
+
+// ServerHandshake returns conn unmodified, along with credentials.TLSInfo if
+// conn is, or wraps, a TLS connection.
+func (tlsConnectionInfo) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	state, ok := tlsConnectionState(conn)
+	if !ok {
+		return conn, nil, nil
+	}
+	return conn, credentials.TLSInfo{
+		State: state,
+		CommonAuthInfo: credentials.CommonAuthInfo{
+			SecurityLevel: credentials.PrivacyAndIntegrity,
+		},
+	}, nil
+}
+
+func (tlsConnectionInfo) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{}
+}
+
+func (c tlsConnectionInfo) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (tlsConnectionInfo) OverrideServerName(string) error {
+	return nil
+}
+
+// tlsConnectionState returns the TLS connection state of conn, unwrapping
+// connections which implement "NetConn() net.Conn" like *tls.Conn does.
+func tlsConnectionState(conn net.Conn) (tls.ConnectionState, bool) {
+	for conn != nil {
+		if c, ok := conn.(interface {
+			ConnectionState() tls.ConnectionState
+		}); ok {
+			return c.ConnectionState(), true
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapper.NetConn()
+	}
+	return tls.ConnectionState{}, false
+}
@@ -0,0 +1,76 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestTLSConnectionState(t *testing.T) {
+	plain, other := net.Pipe()
+	defer plain.Close()
+	defer other.Close()
+
+	_, ok := tlsConnectionState(plain)
+	assert.False(t, ok)
+	_, ok = tlsConnectionState(netConnWrapper{plain})
+	assert.False(t, ok)
+
+	tlsConn := tls.Server(plain, &tls.Config{})
+	_, ok = tlsConnectionState(tlsConn)
+	assert.True(t, ok)
+
+	// Wrapped TLS connections, such as those produced by gmux,
+	// are unwrapped to obtain the TLS connection state.
+	_, ok = tlsConnectionState(netConnWrapper{netConnWrapper{tlsConn}})
+	assert.True(t, ok)
+}
+
+func TestTLSConnectionInfoServerHandshake(t *testing.T) {
+	plain, other := net.Pipe()
+	defer plain.Close()
+	defer other.Close()
+
+	conn, authInfo, err := tlsConnectionInfo{}.ServerHandshake(plain)
+	require.NoError(t, err)
+	assert.Equal(t, plain, conn)
+	assert.Nil(t, authInfo)
+
+	// No handshake is performed; the connection is returned unmodified.
+	tlsConn := netConnWrapper{tls.Server(plain, &tls.Config{})}
+	conn, authInfo, err = tlsConnectionInfo{}.ServerHandshake(tlsConn)
+	require.NoError(t, err)
+	assert.Equal(t, tlsConn, conn)
+	require.IsType(t, credentials.TLSInfo{}, authInfo)
+	assert.Equal(t, credentials.PrivacyAndIntegrity, authInfo.(credentials.TLSInfo).SecurityLevel)
+}
+
+// netConnWrapper wraps a net.Conn, exposing it with a NetConn method.
+type netConnWrapper struct {
+	net.Conn
+}
+
+func (w netConnWrapper) NetConn() net.Conn {
+	return w.Conn
+}
@@ -98,7 +98,7 @@ func (h *httpServer) start() error {
 		h.logger.Info("SSL enabled.")
 		return h.ServeTLS(h.httpListener, "", "")
 	}
-	if h.cfg.AgentAuth.SecretToken != "" {
+	if h.cfg.AgentAuth.SecretToken != "" || len(h.cfg.AgentAuth.SecretTokens) > 0 {
 		h.logger.Warn("Secret token is set, but SSL is not enabled.")
 	}
 	h.logger.Info("SSL disabled.")
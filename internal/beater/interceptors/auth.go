@@ -19,11 +19,14 @@ package interceptors
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
 	"github.com/elastic/apm-server/internal/beater/auth"
@@ -64,6 +67,9 @@ func Auth(authenticator *auth.Authenticator) grpc.UnaryServerInterceptor {
 		if !ok {
 			unaryAuthenticator = defaultAuthenticator
 		}
+		if cert := peerClientCertificate(ctx); cert != nil {
+			ctx = auth.ContextWithClientCertificate(ctx, cert)
+		}
 		details, authz, err := unaryAuthenticator.AuthenticateUnaryCall(ctx, req, info.FullMethod, authenticator)
 		if err != nil {
 			if errors.Is(err, auth.ErrAuthFailed) {
@@ -103,15 +109,31 @@ func (a AuthorizationMetadataAuthenticator) AuthenticateUnaryCall(
 	return authenticator.Authenticate(ctx, kind, token)
 }
 
-type authenticationDetailsKey struct{}
+// peerClientCertificate returns the verified TLS client certificate of the
+// gRPC peer, or nil if there is none.
+func peerClientCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return tlsInfo.State.VerifiedChains[0][0]
+}
 
 // ContextWithAuthenticationDetails returns a copy of ctx with details.
+//
+// This is equivalent to auth.ContextWithAuthenticationDetails.
 func ContextWithAuthenticationDetails(ctx context.Context, details auth.AuthenticationDetails) context.Context {
-	return context.WithValue(ctx, authenticationDetailsKey{}, details)
+	return auth.ContextWithAuthenticationDetails(ctx, details)
 }
 
-// AuthenticationDetailsFromContext returns client metadata extracted by the ClientMetadata interceptor.
+// AuthenticationDetailsFromContext returns authentication details added to the
+// context by the Auth interceptor.
+//
+// This is equivalent to auth.AuthenticationDetailsFromContext.
 func AuthenticationDetailsFromContext(ctx context.Context) (auth.AuthenticationDetails, bool) {
-	details, ok := ctx.Value(authenticationDetailsKey{}).(auth.AuthenticationDetails)
-	return details, ok
+	return auth.AuthenticationDetailsFromContext(ctx)
 }
@@ -24,8 +24,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 func TestClientMetadata(t *testing.T) {
@@ -33,12 +36,18 @@ func TestClientMetadata(t *testing.T) {
 		IP:   net.ParseIP("1.2.3.4"),
 		Port: 56837,
 	}
+	untrustedTCPAddr := &net.TCPAddr{
+		IP:   net.ParseIP("4.3.2.1"),
+		Port: 56837,
+	}
 	udpAddr := &net.UDPAddr{
 		IP:   net.ParseIP("1.1.1.1"),
 		Port: 1111,
 	}
 
-	interceptor := ClientMetadata()
+	trustedProxies, err := netutil.ParseTrustedProxies([]string{"1.2.3.0/24"})
+	require.NoError(t, err)
+	interceptor := ClientMetadata(trustedProxies)
 
 	for _, test := range []struct {
 		peer     *peer.Peer
@@ -65,6 +74,13 @@ func TestClientMetadata(t *testing.T) {
 			ClientIP:    netip.MustParseAddr("5.6.7.8"),
 			SourceNATIP: tcpAddr.AddrPort().Addr(),
 		},
+	}, {
+		peer:     &peer.Peer{Addr: untrustedTCPAddr},
+		metadata: metadata.Pairs("X-Real-Ip", "5.6.7.8"),
+		expected: ClientMetadataValues{
+			SourceAddr: untrustedTCPAddr,
+			ClientIP:   untrustedTCPAddr.AddrPort().Addr(),
+		},
 	}, {
 		metadata: metadata.Pairs("User-Agent", "User-Agent"),
 		expected: ClientMetadataValues{UserAgent: "User-Agent"},
@@ -20,8 +20,6 @@ package jaeger
 import (
 	"context"
 	"errors"
-	"fmt"
-	"strconv"
 
 	jaegermodel "github.com/jaegertracing/jaeger/model"
 	"github.com/jaegertracing/jaeger/proto-gen/api_v2"
@@ -138,8 +136,6 @@ var (
 	gRPCSamplingMonitoringMap monitoringMap = request.MonitoringMapForRegistry(
 		gRPCSamplingRegistry, append(request.DefaultResultIDs, request.IDEventReceivedCount),
 	)
-
-	jaegerAgentPrefixes = []string{otlp.AgentNameJaeger}
 )
 
 type grpcSampler struct {
@@ -148,55 +144,19 @@ type grpcSampler struct {
 }
 
 // GetSamplingStrategy implements the api_v2/sampling.proto.
-// Only probabilistic sampling is supported.
-// It fetches the sampling rate from the central configuration management and returns the sampling strategy.
+// It fetches the sampling strategy from the central configuration management.
 func (s *grpcSampler) GetSamplingStrategy(
 	ctx context.Context,
 	params *api_v2.SamplingStrategyParameters) (*api_v2.SamplingStrategyResponse, error) {
 
-	samplingRate, err := s.fetchSamplingRate(ctx, params.ServiceName)
+	strategy, id, err := fetchSamplingStrategy(ctx, s.fetcher, params.ServiceName)
 	if err != nil {
+		gRPCSamplingMonitoringMap.inc(id)
 		// do not return full error details since this is part of an unprotected endpoint response
 		s.logger.Error("no valid sampling rate fetched from Kibana", zap.Error(err))
 		return nil, errors.New("no sampling rate available, check server logs for more details")
 	}
-
-	return &api_v2.SamplingStrategyResponse{
-		StrategyType:          api_v2.SamplingStrategyType_PROBABILISTIC,
-		ProbabilisticSampling: &api_v2.ProbabilisticSamplingStrategy{SamplingRate: samplingRate},
-	}, nil
-}
-
-func (s *grpcSampler) fetchSamplingRate(ctx context.Context, service string) (float64, error) {
-	// Only service, and not agent, is known for config queries.
-	// For anonymous/untrusted agents, we filter the results using
-	// query.InsecureAgents below.
-	authResource := auth.Resource{ServiceName: service}
-	if err := auth.Authorize(ctx, auth.ActionAgentConfig, authResource); err != nil {
-		return 0, err
-	}
-
-	query := agentcfg.Query{
-		Service:              agentcfg.Service{Name: service},
-		InsecureAgents:       jaegerAgentPrefixes,
-		MarkAsAppliedByAgent: true,
-	}
-	result, err := s.fetcher.Fetch(ctx, query)
-	if err != nil {
-		gRPCSamplingMonitoringMap.inc(request.IDResponseErrorsServiceUnavailable)
-		return 0, fmt.Errorf("fetching sampling rate failed: %w", err)
-	}
-
-	if sr, ok := result.Source.Settings[agentcfg.TransactionSamplingRateKey]; ok {
-		srFloat64, err := strconv.ParseFloat(sr, 64)
-		if err != nil {
-			gRPCSamplingMonitoringMap.inc(request.IDResponseErrorsInternal)
-			return 0, fmt.Errorf("parsing error for sampling rate `%v`: %w", sr, err)
-		}
-		return srFloat64, nil
-	}
-	gRPCSamplingMonitoringMap.inc(request.IDResponseErrorsNotFound)
-	return 0, fmt.Errorf("no sampling rate found for %v", service)
+	return strategy, nil
 }
 
 var anonymousAuthenticator *auth.Authenticator
@@ -225,7 +185,17 @@ func (s *grpcSampler) AuthenticateUnaryCall(
 	fullMethodName string,
 	authenticator *auth.Authenticator,
 ) (auth.AuthenticationDetails, auth.Authorizer, error) {
-	details, authz, err := authenticator.Authenticate(ctx, "", "")
+	return samplingAuthenticator{authenticator}.Authenticate(ctx, "", "")
+}
+
+// samplingAuthenticator is a middleware.Authenticator for sampling strategy
+// queries, which falls back to anonymous access when authentication fails.
+type samplingAuthenticator struct {
+	authenticator *auth.Authenticator
+}
+
+func (a samplingAuthenticator) Authenticate(ctx context.Context, kind, token string) (auth.AuthenticationDetails, auth.Authorizer, error) {
+	details, authz, err := a.authenticator.Authenticate(ctx, kind, token)
 	if !errors.Is(err, auth.ErrAuthFailed) {
 		return details, authz, err
 	}
@@ -0,0 +1,220 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package jaeger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	thriftconverter "github.com/jaegertracing/jaeger/model/converter/thrift/jaeger"
+	jaegerthrift "github.com/jaegertracing/jaeger/thrift-gen/jaeger"
+	jaegertranslator "github.com/open-telemetry/opentelemetry-collector-contrib/pkg/translator/jaeger"
+	"go.uber.org/zap"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-data/input/otlp"
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/middleware"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+var (
+	httpCollectorRegistry = monitoring.Default.NewRegistry("apm-server.jaeger.http.collect")
+
+	// HTTPCollectorMonitoringMap holds a mapping for request.IDs to
+	// monitoring counters for the HTTP collector endpoint.
+	HTTPCollectorMonitoringMap = request.MonitoringMapForRegistry(
+		httpCollectorRegistry, append(request.DefaultResultIDs,
+			request.IDEventReceivedCount,
+			request.IDResponseValidAccepted,
+			request.IDResponseErrorsDecode,
+			request.IDResponseErrorsValidate,
+			request.IDResponseErrorsUnsupportedMediaType,
+			request.IDResponseErrorsMethodNotAllowed,
+			request.IDResponseErrorsRateLimit,
+			request.IDResponseErrorsTimeout,
+			request.IDResponseErrorsForbidden,
+			request.IDResponseErrorsUnauthorized,
+			request.IDResponseErrorsFullQueue,
+			request.IDResponseErrorsShuttingDown,
+			request.IDResponseErrorsInternal,
+		),
+	)
+
+	httpSamplingRegistry = monitoring.Default.NewRegistry("apm-server.jaeger.http.sampling")
+
+	// HTTPSamplingMonitoringMap holds a mapping for request.IDs to
+	// monitoring counters for the HTTP sampling endpoint.
+	HTTPSamplingMonitoringMap = request.MonitoringMapForRegistry(
+		httpSamplingRegistry, append(request.DefaultResultIDs,
+			request.IDResponseValidOK,
+			request.IDResponseErrorsInvalidQuery,
+			request.IDResponseErrorsMethodNotAllowed,
+			request.IDResponseErrorsNotFound,
+			request.IDResponseErrorsRateLimit,
+			request.IDResponseErrorsTimeout,
+			request.IDResponseErrorsForbidden,
+			request.IDResponseErrorsServiceUnavailable,
+			request.IDResponseErrorsInternal,
+		),
+	)
+)
+
+// acceptedThriftContentTypes holds the content types accepted by
+// the Jaeger collector's HTTP endpoint for Thrift-encoded batches.
+var acceptedThriftContentTypes = map[string]struct{}{
+	"application/x-thrift":                 {},
+	"application/vnd.apache.thrift.binary": {},
+}
+
+// NewHTTPCollectorHandler returns a request.Handler for receiving
+// Thrift-encoded Jaeger batches, as sent by Jaeger clients and agents
+// to the Jaeger collector's HTTP endpoint.
+//
+// Batches are translated into OpenTelemetry traces, and then processed
+// with an OTLP consumer. Requests are authenticated using the Authorization
+// header; the "elastic-apm-auth" process tag used for authenticating gRPC
+// requests is ignored, and removed to avoid indexing credentials.
+func NewHTTPCollectorHandler(logger *zap.Logger, processor model.BatchProcessor) request.Handler {
+	consumer := otlp.NewConsumer(otlp.ConsumerConfig{
+		Processor: processor,
+		Logger:    logger,
+	})
+	return func(c *request.Context) {
+		if c.Request.Method != http.MethodPost {
+			c.Result.SetDefault(request.IDResponseErrorsMethodNotAllowed)
+			c.WriteResult()
+			return
+		}
+
+		contentType := c.Request.Header.Get(headers.ContentType)
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if _, ok := acceptedThriftContentTypes[mediaType]; !ok {
+			c.Result.SetWithError(request.IDResponseErrorsUnsupportedMediaType, fmt.Errorf(
+				"invalid content type %q, expected %q", contentType, "application/x-thrift",
+			))
+			c.WriteResult()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsDecode, fmt.Errorf("failed to read request body: %w", err))
+			c.WriteResult()
+			return
+		}
+		batch := &jaegerthrift.Batch{}
+		if err := thrift.NewTDeserializer().Read(c.Request.Context(), batch, body); err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsDecode, fmt.Errorf("failed to decode batch: %w", err))
+			c.WriteResult()
+			return
+		}
+		removeThriftAuthTag(batch)
+
+		HTTPCollectorMonitoringMap[request.IDEventReceivedCount].Add(int64(len(batch.Spans)))
+		traces, err := jaegertranslator.ThriftToTraces(batch)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsValidate, err)
+			c.WriteResult()
+			return
+		}
+		if err := consumer.ConsumeTraces(c.Request.Context(), traces); err != nil {
+			c.SetConsumeError(err)
+			c.WriteResult()
+			return
+		}
+		c.Result.SetDefault(request.IDResponseValidAccepted)
+		c.WriteResult()
+	}
+}
+
+func removeThriftAuthTag(batch *jaegerthrift.Batch) {
+	if batch.Process == nil {
+		return
+	}
+	tags := batch.Process.Tags[:0]
+	for _, tag := range batch.Process.Tags {
+		if tag != nil && tag.Key == elasticAuthTag {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	batch.Process.Tags = tags
+}
+
+// NewHTTPSamplingHandler returns a request.Handler for querying sampling
+// strategies, as served by the Jaeger agent's HTTP sampling endpoint:
+// "GET /sampling?service=<name>". The sampling strategy is defined by
+// agent central configuration for the service, and is returned in the
+// JSON representation of the Jaeger Thrift SamplingStrategyResponse.
+//
+// Sampling strategy queries are unauthenticated; the handler should be
+// wrapped with a middleware.AuthMiddleware using SamplingAuthenticator.
+func NewHTTPSamplingHandler(fetcher agentcfg.Fetcher) request.Handler {
+	return func(c *request.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Result.SetDefault(request.IDResponseErrorsMethodNotAllowed)
+			c.WriteResult()
+			return
+		}
+
+		services := c.Request.URL.Query()["service"]
+		if len(services) != 1 {
+			c.Result.SetWithError(
+				request.IDResponseErrorsInvalidQuery,
+				errors.New("'service' parameter must be provided once"),
+			)
+			c.WriteResult()
+			return
+		}
+
+		strategy, id, err := fetchSamplingStrategy(c.Request.Context(), fetcher, services[0])
+		if err != nil {
+			// do not return full error details since this is part of an unprotected endpoint response
+			status := request.MapResultIDToStatus[id]
+			c.Result.Set(id, status.Code, status.Keyword,
+				"no sampling rate available, check server logs for more details", err,
+			)
+			c.WriteResult()
+			return
+		}
+		response, err := thriftconverter.ConvertSamplingResponseFromDomain(strategy)
+		if err != nil {
+			c.Result.SetWithError(request.IDResponseErrorsInternal, err)
+			c.WriteResult()
+			return
+		}
+		c.Result.SetWithBody(request.IDResponseValidOK, response)
+		c.WriteResult()
+	}
+}
+
+// SamplingAuthenticator returns a middleware.Authenticator for sampling
+// strategy queries, which falls back to anonymous access when authentication
+// fails, for consistency with the Jaeger gRPC sampling endpoint.
+func SamplingAuthenticator(authenticator *auth.Authenticator) middleware.Authenticator {
+	return samplingAuthenticator{authenticator}
+}
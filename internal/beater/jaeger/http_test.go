@@ -0,0 +1,283 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package jaeger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	jaegerthrift "github.com/jaegertracing/jaeger/thrift-gen/jaeger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+func TestHTTPCollectorHandler(t *testing.T) {
+	authValue := "Bearer secret"
+	stringValue := "value"
+	body, err := thrift.NewTSerializer().Write(context.Background(), &jaegerthrift.Batch{
+		Process: &jaegerthrift.Process{
+			ServiceName: "frontend",
+			Tags: []*jaegerthrift.Tag{
+				{Key: elasticAuthTag, VType: jaegerthrift.TagType_STRING, VStr: &authValue},
+				{Key: "process_tag", VType: jaegerthrift.TagType_STRING, VStr: &stringValue},
+			},
+		},
+		Spans: []*jaegerthrift.Span{{
+			TraceIdLow:    1,
+			SpanId:        2,
+			OperationName: "GET /api",
+			StartTime:     time.Now().UnixMicro(),
+			Duration:      1000,
+		}},
+	})
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		method      string
+		contentType string
+		body        []byte
+		processErr  error
+		expectedID  request.ResultID
+		expectBatch bool
+	}{
+		"x-thrift": {
+			contentType: "application/x-thrift",
+			body:        body,
+			expectedID:  request.IDResponseValidAccepted,
+			expectBatch: true,
+		},
+		"vnd.apache.thrift.binary": {
+			contentType: "application/vnd.apache.thrift.binary",
+			body:        body,
+			expectedID:  request.IDResponseValidAccepted,
+			expectBatch: true,
+		},
+		"method_not_allowed": {
+			method:     http.MethodGet,
+			expectedID: request.IDResponseErrorsMethodNotAllowed,
+		},
+		"missing_content_type": {
+			body:       body,
+			expectedID: request.IDResponseErrorsUnsupportedMediaType,
+		},
+		"invalid_content_type": {
+			contentType: "application/json",
+			body:        body,
+			expectedID:  request.IDResponseErrorsUnsupportedMediaType,
+		},
+		"invalid_thrift": {
+			contentType: "application/x-thrift",
+			body:        []byte{0xff, 0xff},
+			expectedID:  request.IDResponseErrorsDecode,
+		},
+		"rate_limited": {
+			contentType: "application/x-thrift",
+			body:        body,
+			processErr:  ratelimit.ErrRateLimitExceeded,
+			expectedID:  request.IDResponseErrorsRateLimit,
+			expectBatch: true,
+		},
+		"forbidden": {
+			contentType: "application/x-thrift",
+			body:        body,
+			processErr:  auth.ErrUnauthorized,
+			expectedID:  request.IDResponseErrorsForbidden,
+			expectBatch: true,
+		},
+		"internal": {
+			contentType: "application/x-thrift",
+			body:        body,
+			processErr:  errors.New("boom"),
+			expectedID:  request.IDResponseErrorsInternal,
+			expectBatch: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var batches []model.Batch
+			processor := model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+				batches = append(batches, *batch)
+				return tc.processErr
+			})
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, "/api/traces", bytes.NewReader(tc.body))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			w := httptest.NewRecorder()
+			c := request.NewContext()
+			c.Reset(w, r)
+			NewHTTPCollectorHandler(zap.NewNop(), processor)(c)
+
+			assert.Equal(t, tc.expectedID, c.Result.ID)
+			assert.Equal(t, request.MapResultIDToStatus[tc.expectedID].Code, w.Code)
+			if !tc.expectBatch {
+				assert.Empty(t, batches)
+				return
+			}
+			require.Len(t, batches, 1)
+			require.Len(t, batches[0], 1)
+			event := batches[0][0]
+			assert.Equal(t, "frontend", event.Service.Name)
+			require.NotNil(t, event.Transaction)
+			assert.Equal(t, "GET /api", event.Transaction.Name)
+			assert.Equal(t, "value", event.Labels["process_tag"].Value)
+			for k, v := range event.Labels {
+				assert.NotEqual(t, authValue, v.Value, "auth tag %q not removed", k)
+			}
+		})
+	}
+}
+
+func TestHTTPCollectorHandlerQuotaExceeded(t *testing.T) {
+	body, err := thrift.NewTSerializer().Write(context.Background(), &jaegerthrift.Batch{
+		Process: &jaegerthrift.Process{ServiceName: "frontend"},
+		Spans:   []*jaegerthrift.Span{{TraceIdLow: 1, SpanId: 2}},
+	})
+	require.NoError(t, err)
+	processor := model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		return &ratelimit.QuotaExceededError{
+			Kind:       ratelimit.QuotaKindService,
+			Value:      "frontend",
+			RetryAfter: 1500 * time.Millisecond,
+		}
+	})
+	r := httptest.NewRequest(http.MethodPost, "/api/traces", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-thrift")
+	w := httptest.NewRecorder()
+	c := request.NewContext()
+	c.Reset(w, r)
+	NewHTTPCollectorHandler(zap.NewNop(), processor)(c)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
+
+func TestHTTPSamplingHandler(t *testing.T) {
+	for name, tc := range map[string]struct {
+		method       string
+		target       string
+		settings     agentcfg.Settings
+		fetchErr     error
+		expectedID   request.ResultID
+		expectedBody string
+	}{
+		"probabilistic": {
+			target:       "/sampling?service=frontend",
+			settings:     agentcfg.Settings{agentcfg.TransactionSamplingRateKey: "0.5"},
+			expectedID:   request.IDResponseValidOK,
+			expectedBody: `{"strategyType":"PROBABILISTIC","probabilisticSampling":{"samplingRate":0.5}}`,
+		},
+		"rate_limiting": {
+			target:       "/sampling?service=frontend",
+			settings:     agentcfg.Settings{agentcfg.JaegerMaxTracesPerSecondKey: "10"},
+			expectedID:   request.IDResponseValidOK,
+			expectedBody: `{"strategyType":"RATE_LIMITING","rateLimitingSampling":{"maxTracesPerSecond":10}}`,
+		},
+		"per_operation": {
+			target: "/sampling?service=frontend",
+			settings: agentcfg.Settings{
+				agentcfg.TransactionSamplingRateKey:      "0.5",
+				agentcfg.JaegerOperationSamplingRatesKey: "GET /api=0.1",
+			},
+			expectedID: request.IDResponseValidOK,
+			expectedBody: `{
+				"strategyType":"PROBABILISTIC",
+				"probabilisticSampling":{"samplingRate":0.5},
+				"operationSampling":{
+					"defaultSamplingProbability":0.5,
+					"defaultLowerBoundTracesPerSecond":0,
+					"defaultUpperBoundTracesPerSecond":0,
+					"perOperationStrategies":[
+						{"operation":"GET /api","probabilisticSampling":{"samplingRate":0.1}}
+					]
+				}
+			}`,
+		},
+		"method_not_allowed": {
+			method:     http.MethodPost,
+			target:     "/sampling?service=frontend",
+			expectedID: request.IDResponseErrorsMethodNotAllowed,
+		},
+		"missing_service": {
+			target:     "/sampling",
+			expectedID: request.IDResponseErrorsInvalidQuery,
+		},
+		"multiple_services": {
+			target:     "/sampling?service=frontend&service=backend",
+			expectedID: request.IDResponseErrorsInvalidQuery,
+		},
+		"not_found": {
+			target:       "/sampling?service=frontend",
+			settings:     agentcfg.Settings{},
+			expectedID:   request.IDResponseErrorsNotFound,
+			expectedBody: `{"error":"no sampling rate available, check server logs for more details"}`,
+		},
+		"fetch_error": {
+			target:     "/sampling?service=frontend",
+			fetchErr:   errors.New("boom"),
+			expectedID: request.IDResponseErrorsServiceUnavailable,
+		},
+		"invalid_settings": {
+			target:     "/sampling?service=frontend",
+			settings:   agentcfg.Settings{agentcfg.TransactionSamplingRateKey: "foo"},
+			expectedID: request.IDResponseErrorsInternal,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fetcher := mockAgentConfigFetcher(agentcfg.Result{
+				Source: agentcfg.Source{Settings: tc.settings},
+			}, tc.fetchErr)
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tc.target, nil)
+			_, authorizer, err := anonymousAuthenticator.Authenticate(r.Context(), "", "")
+			require.NoError(t, err)
+			r = r.WithContext(auth.ContextWithAuthorizer(r.Context(), authorizer))
+			w := httptest.NewRecorder()
+			c := request.NewContext()
+			c.Reset(w, r)
+			NewHTTPSamplingHandler(fetcher)(c)
+
+			assert.Equal(t, tc.expectedID, c.Result.ID)
+			assert.Equal(t, request.MapResultIDToStatus[tc.expectedID].Code, w.Code)
+			if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package jaeger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/proto-gen/api_v2"
+
+	"github.com/elastic/apm-data/input/otlp"
+	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+var (
+	jaegerAgentPrefixes = []string{otlp.AgentNameJaeger}
+
+	errNoSamplingStrategy = errors.New("no sampling strategy found")
+)
+
+// fetchSamplingStrategy fetches agent configuration for service, and returns
+// the Jaeger sampling strategy defined by it.
+//
+// On error, the returned request.ResultID identifies the cause of the error.
+func fetchSamplingStrategy(
+	ctx context.Context,
+	fetcher agentcfg.Fetcher,
+	service string,
+) (*api_v2.SamplingStrategyResponse, request.ResultID, error) {
+	// Only service, and not agent, is known for config queries.
+	// For anonymous/untrusted agents, we filter the results using
+	// query.InsecureAgents below.
+	authResource := auth.Resource{ServiceName: service}
+	if err := auth.Authorize(ctx, auth.ActionAgentConfig, authResource); err != nil {
+		return nil, request.IDResponseErrorsForbidden, err
+	}
+
+	query := agentcfg.Query{
+		Service:              agentcfg.Service{Name: service},
+		InsecureAgents:       jaegerAgentPrefixes,
+		MarkAsAppliedByAgent: true,
+	}
+	result, err := fetcher.Fetch(ctx, query)
+	if err != nil {
+		return nil, request.IDResponseErrorsServiceUnavailable, fmt.Errorf("fetching sampling strategy failed: %w", err)
+	}
+	strategy, err := samplingStrategy(result.Source.Settings)
+	if err != nil {
+		if errors.Is(err, errNoSamplingStrategy) {
+			return nil, request.IDResponseErrorsNotFound, fmt.Errorf("%w for %v", err, service)
+		}
+		return nil, request.IDResponseErrorsInternal, err
+	}
+	return strategy, request.IDResponseValidOK, nil
+}
+
+// samplingStrategy returns the Jaeger sampling strategy defined by the
+// given agent configuration settings.
+//
+// If per-operation sampling rates are defined, a per-operation strategy is
+// returned, using the transaction sample rate (or 1.0 if unspecified) as the
+// default sampling probability. Otherwise if max traces per second is defined,
+// a rate limiting strategy is returned. Otherwise if the transaction sample
+// rate is defined, a probabilistic strategy is returned.
+//
+// Per-operation sampling rates may not be combined with max traces per second:
+// the per-operation strategy's upper bound is ignored by Jaeger clients.
+func samplingStrategy(settings agentcfg.Settings) (*api_v2.SamplingStrategyResponse, error) {
+	sampleRate, haveSampleRate := settings[agentcfg.TransactionSamplingRateKey]
+	operationSampleRates, haveOperationSampleRates := settings[agentcfg.JaegerOperationSamplingRatesKey]
+	maxTracesPerSecond, haveMaxTracesPerSecond := settings[agentcfg.JaegerMaxTracesPerSecondKey]
+	if !haveSampleRate && !haveOperationSampleRates && !haveMaxTracesPerSecond {
+		return nil, errNoSamplingStrategy
+	}
+	defaultSamplingRate := 1.0
+	if haveSampleRate {
+		rate, err := parseSamplingRate(sampleRate)
+		if err != nil {
+			return nil, fmt.Errorf("parsing error for sampling rate `%v`: %w", sampleRate, err)
+		}
+		defaultSamplingRate = rate
+	}
+	var tracesPerSecond float64
+	if haveMaxTracesPerSecond {
+		v, err := strconv.ParseFloat(maxTracesPerSecond, 64)
+		// The Jaeger Thrift protocol limits max traces per second to int16.
+		if err == nil && !(v >= 0 && v <= math.MaxInt16) {
+			err = fmt.Errorf("value out of range [0, %d]", math.MaxInt16)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing error for max traces per second `%v`: %w", maxTracesPerSecond, err)
+		}
+		tracesPerSecond = v
+	}
+
+	switch {
+	case haveOperationSampleRates:
+		strategies, err := parseOperationSamplingRates(operationSampleRates)
+		if err != nil {
+			return nil, fmt.Errorf("parsing error for operation sampling rates `%v`: %w", operationSampleRates, err)
+		}
+		// Jaeger clients have no upper bound for per-operation sampling,
+		// so max traces per second is used as the lower bound: clients
+		// sample up to this many traces per second for each operation,
+		// in addition to those sampled by the operation's sampling rate.
+		return &api_v2.SamplingStrategyResponse{
+			StrategyType:          api_v2.SamplingStrategyType_PROBABILISTIC,
+			ProbabilisticSampling: &api_v2.ProbabilisticSamplingStrategy{SamplingRate: defaultSamplingRate},
+			OperationSampling: &api_v2.PerOperationSamplingStrategies{
+				DefaultSamplingProbability:       defaultSamplingRate,
+				DefaultLowerBoundTracesPerSecond: tracesPerSecond,
+				PerOperationStrategies:           strategies,
+			},
+		}, nil
+	case haveMaxTracesPerSecond:
+		return &api_v2.SamplingStrategyResponse{
+			StrategyType: api_v2.SamplingStrategyType_RATE_LIMITING,
+			RateLimitingSampling: &api_v2.RateLimitingSamplingStrategy{
+				MaxTracesPerSecond: int32(math.Round(tracesPerSecond)),
+			},
+		}, nil
+	}
+	return &api_v2.SamplingStrategyResponse{
+		StrategyType:          api_v2.SamplingStrategyType_PROBABILISTIC,
+		ProbabilisticSampling: &api_v2.ProbabilisticSamplingStrategy{SamplingRate: defaultSamplingRate},
+	}, nil
+}
+
+// parseOperationSamplingRates parses a comma-separated list of
+// "<operation>=<rate>" pairs. Operation names may contain '=';
+// the rate follows the final '='.
+func parseOperationSamplingRates(s string) ([]*api_v2.OperationSamplingStrategy, error) {
+	var strategies []*api_v2.OperationSamplingStrategy
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		sep := strings.LastIndexByte(field, '=')
+		if sep <= 0 {
+			return nil, fmt.Errorf("expected <operation>=<rate>, got %q", field)
+		}
+		operation := strings.TrimSpace(field[:sep])
+		rate, err := parseSamplingRate(strings.TrimSpace(field[sep+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sampling rate for operation %q: %w", operation, err)
+		}
+		strategies = append(strategies, &api_v2.OperationSamplingStrategy{
+			Operation:             operation,
+			ProbabilisticSampling: &api_v2.ProbabilisticSamplingStrategy{SamplingRate: rate},
+		})
+	}
+	if len(strategies) == 0 {
+		return nil, errors.New("no operations specified")
+	}
+	return strategies, nil
+}
+
+func parseSamplingRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if !(rate >= 0 && rate <= 1) {
+		return 0, fmt.Errorf("sampling rate %v out of range [0, 1]", rate)
+	}
+	return rate, nil
+}
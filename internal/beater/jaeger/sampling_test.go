@@ -0,0 +1,169 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package jaeger
+
+import (
+	"testing"
+
+	"github.com/jaegertracing/jaeger/proto-gen/api_v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/agentcfg"
+)
+
+func TestSamplingStrategy(t *testing.T) {
+	probabilistic := func(rate float64) *api_v2.ProbabilisticSamplingStrategy {
+		return &api_v2.ProbabilisticSamplingStrategy{SamplingRate: rate}
+	}
+	for name, tc := range map[string]struct {
+		settings agentcfg.Settings
+		expected *api_v2.SamplingStrategyResponse
+	}{
+		"probabilistic": {
+			settings: agentcfg.Settings{agentcfg.TransactionSamplingRateKey: "0.75"},
+			expected: &api_v2.SamplingStrategyResponse{
+				StrategyType:          api_v2.SamplingStrategyType_PROBABILISTIC,
+				ProbabilisticSampling: probabilistic(0.75),
+			},
+		},
+		"rate_limiting": {
+			settings: agentcfg.Settings{agentcfg.JaegerMaxTracesPerSecondKey: "5"},
+			expected: &api_v2.SamplingStrategyResponse{
+				StrategyType:         api_v2.SamplingStrategyType_RATE_LIMITING,
+				RateLimitingSampling: &api_v2.RateLimitingSamplingStrategy{MaxTracesPerSecond: 5},
+			},
+		},
+		"rate_limiting_overrides_sample_rate": {
+			settings: agentcfg.Settings{
+				agentcfg.TransactionSamplingRateKey:  "0.75",
+				agentcfg.JaegerMaxTracesPerSecondKey: "2.5",
+			},
+			expected: &api_v2.SamplingStrategyResponse{
+				StrategyType:         api_v2.SamplingStrategyType_RATE_LIMITING,
+				RateLimitingSampling: &api_v2.RateLimitingSamplingStrategy{MaxTracesPerSecond: 3},
+			},
+		},
+		"per_operation": {
+			settings: agentcfg.Settings{
+				agentcfg.TransactionSamplingRateKey:      "0.5",
+				agentcfg.JaegerOperationSamplingRatesKey: "GET /a=0.1, op=with=equals=1,,",
+			},
+			expected: &api_v2.SamplingStrategyResponse{
+				StrategyType:          api_v2.SamplingStrategyType_PROBABILISTIC,
+				ProbabilisticSampling: probabilistic(0.5),
+				OperationSampling: &api_v2.PerOperationSamplingStrategies{
+					DefaultSamplingProbability: 0.5,
+					PerOperationStrategies: []*api_v2.OperationSamplingStrategy{
+						{Operation: "GET /a", ProbabilisticSampling: probabilistic(0.1)},
+						{Operation: "op=with=equals", ProbabilisticSampling: probabilistic(1)},
+					},
+				},
+			},
+		},
+		"per_operation_default_rate": {
+			settings: agentcfg.Settings{
+				agentcfg.JaegerOperationSamplingRatesKey: "op=0",
+			},
+			expected: &api_v2.SamplingStrategyResponse{
+				StrategyType:          api_v2.SamplingStrategyType_PROBABILISTIC,
+				ProbabilisticSampling: probabilistic(1),
+				OperationSampling: &api_v2.PerOperationSamplingStrategies{
+					DefaultSamplingProbability: 1,
+					PerOperationStrategies: []*api_v2.OperationSamplingStrategy{
+						{Operation: "op", ProbabilisticSampling: probabilistic(0)},
+					},
+				},
+			},
+		},
+		"per_operation_max_traces_per_second": {
+			settings: agentcfg.Settings{
+				agentcfg.TransactionSamplingRateKey:      "0.25",
+				agentcfg.JaegerOperationSamplingRatesKey: "op=0.5",
+				agentcfg.JaegerMaxTracesPerSecondKey:     "2.5",
+			},
+			expected: &api_v2.SamplingStrategyResponse{
+				StrategyType:          api_v2.SamplingStrategyType_PROBABILISTIC,
+				ProbabilisticSampling: probabilistic(0.25),
+				OperationSampling: &api_v2.PerOperationSamplingStrategies{
+					DefaultSamplingProbability:       0.25,
+					DefaultLowerBoundTracesPerSecond: 2.5,
+					PerOperationStrategies: []*api_v2.OperationSamplingStrategy{
+						{Operation: "op", ProbabilisticSampling: probabilistic(0.5)},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			strategy, err := samplingStrategy(tc.settings)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, strategy)
+		})
+	}
+}
+
+func TestSamplingStrategyErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		settings agentcfg.Settings
+		err      string
+	}{
+		"empty": {
+			settings: agentcfg.Settings{"capture_body": "all"},
+			err:      "no sampling strategy found",
+		},
+		"invalid_sample_rate": {
+			settings: agentcfg.Settings{agentcfg.TransactionSamplingRateKey: "foo"},
+			err:      "parsing error for sampling rate `foo`",
+		},
+		"sample_rate_out_of_range": {
+			settings: agentcfg.Settings{agentcfg.TransactionSamplingRateKey: "1.5"},
+			err:      "sampling rate 1.5 out of range [0, 1]",
+		},
+		"sample_rate_nan": {
+			settings: agentcfg.Settings{agentcfg.TransactionSamplingRateKey: "NaN"},
+			err:      "sampling rate NaN out of range [0, 1]",
+		},
+		"max_traces_per_second_out_of_range": {
+			settings: agentcfg.Settings{agentcfg.JaegerMaxTracesPerSecondKey: "-1"},
+			err:      "parsing error for max traces per second `-1`: value out of range [0, 32767]",
+		},
+		"operation_missing_rate": {
+			settings: agentcfg.Settings{agentcfg.JaegerOperationSamplingRatesKey: "op"},
+			err:      `expected <operation>=<rate>, got "op"`,
+		},
+		"operation_missing_name": {
+			settings: agentcfg.Settings{agentcfg.JaegerOperationSamplingRatesKey: "=0.5"},
+			err:      `expected <operation>=<rate>, got "=0.5"`,
+		},
+		"operation_invalid_rate": {
+			settings: agentcfg.Settings{agentcfg.JaegerOperationSamplingRatesKey: "op=2"},
+			err:      `invalid sampling rate for operation "op"`,
+		},
+		"no_operations": {
+			settings: agentcfg.Settings{agentcfg.JaegerOperationSamplingRatesKey: " , "},
+			err:      "no operations specified",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := samplingStrategy(tc.settings)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
@@ -44,7 +44,11 @@ func AuthMiddleware(authenticator Authenticator, required bool) Middleware {
 		return func(c *request.Context) {
 			header := c.Request.Header.Get(headers.Authorization)
 			kind, token := auth.ParseAuthorizationHeader(header)
-			details, authorizer, err := authenticator.Authenticate(c.Request.Context(), kind, token)
+			ctx := c.Request.Context()
+			if c.ClientCertificate != nil {
+				ctx = auth.ContextWithClientCertificate(ctx, c.ClientCertificate)
+			}
+			details, authorizer, err := authenticator.Authenticate(ctx, kind, token)
 			if err != nil {
 				if errors.Is(err, auth.ErrAuthFailed) {
 					if !required {
@@ -66,7 +70,9 @@ func AuthMiddleware(authenticator Authenticator, required bool) Middleware {
 				}
 			}
 			c.Authentication = details
-			c.Request = c.Request.WithContext(auth.ContextWithAuthorizer(c.Request.Context(), authorizer))
+			ctx = auth.ContextWithAuthorizer(c.Request.Context(), authorizer)
+			ctx = auth.ContextWithAuthenticationDetails(ctx, details)
+			c.Request = c.Request.WithContext(ctx)
 			h(c)
 
 			// Processors may indicate that a request is unauthorized by returning auth.ErrUnauthorized.
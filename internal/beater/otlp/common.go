@@ -18,7 +18,12 @@
 package otlp
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
 
 	"github.com/elastic/apm-data/input/otlp"
 	"github.com/elastic/apm-server/internal/beater/request"
@@ -33,9 +38,37 @@ var (
 	)
 )
 
+// consumeMetrics consumes metrics after removing data points which cannot be
+// consumed. Rejected data points are recorded in monitored, and reported in
+// the response's partial success.
+func consumeMetrics(ctx context.Context, c *otlp.Consumer, monitored *monitoredConsumer, metrics pmetric.Metrics) (pmetricotlp.ExportResponse, error) {
+	resp := pmetricotlp.NewExportResponse()
+	if r, unsupportedMetrics := rejectDataPoints(metrics); r.count > 0 {
+		monitored.addRejectedDataPoints(r, unsupportedMetrics)
+		resp.PartialSuccess().SetRejectedDataPoints(r.count)
+		resp.PartialSuccess().SetErrorMessage(r.message())
+		if metrics.MetricCount() == 0 {
+			return resp, nil
+		}
+	}
+	if err := c.ConsumeMetrics(ctx, metrics); err != nil {
+		return pmetricotlp.NewExportResponse(), err
+	}
+	return resp, nil
+}
+
 type monitoredConsumer struct {
 	mu       sync.RWMutex
 	consumer *otlp.Consumer
+
+	// rejectedDataPoints holds the number of data points rejected
+	// before consumption, and reported to clients as partial success.
+	rejectedDataPoints int64
+
+	// unsupportedMetrics holds the number of metrics with data points
+	// rejected before consumption, which would otherwise have been
+	// recorded by the consumer as unsupported and dropped.
+	unsupportedMetrics int64
 }
 
 func (m *monitoredConsumer) set(c *otlp.Consumer) {
@@ -44,6 +77,11 @@ func (m *monitoredConsumer) set(c *otlp.Consumer) {
 	m.consumer = c
 }
 
+func (m *monitoredConsumer) addRejectedDataPoints(r rejections, unsupportedMetrics int64) {
+	atomic.AddInt64(&m.rejectedDataPoints, r.count)
+	atomic.AddInt64(&m.unsupportedMetrics, unsupportedMetrics)
+}
+
 func (m *monitoredConsumer) collect(mode monitoring.Mode, V monitoring.Visitor) {
 	V.OnRegistryStart()
 	defer V.OnRegistryFinished()
@@ -56,5 +94,7 @@ func (m *monitoredConsumer) collect(mode monitoring.Mode, V monitoring.Visitor)
 	}
 
 	stats := c.Stats()
-	monitoring.ReportInt(V, "unsupported_dropped", stats.UnsupportedMetricsDropped)
+	unsupportedDropped := stats.UnsupportedMetricsDropped + atomic.LoadInt64(&m.unsupportedMetrics)
+	monitoring.ReportInt(V, "unsupported_dropped", unsupportedDropped)
+	monitoring.ReportInt(V, "rejected_data_points", atomic.LoadInt64(&m.rejectedDataPoints))
 }
@@ -78,7 +78,7 @@ func RegisterGRPCServices(
 	gRPCMonitoredConsumer.set(consumer)
 
 	ptraceotlp.RegisterGRPCServer(grpcServer, &tracesService{consumer: consumer})
-	pmetricotlp.RegisterGRPCServer(grpcServer, &metricsService{consumer: consumer})
+	pmetricotlp.RegisterGRPCServer(grpcServer, &metricsService{consumer: consumer, monitored: &gRPCMonitoredConsumer})
 	plogotlp.RegisterGRPCServer(grpcServer, &logsService{consumer: consumer})
 }
 
@@ -98,7 +98,8 @@ func (s *tracesService) Export(ctx context.Context, req ptraceotlp.ExportRequest
 
 type metricsService struct {
 	pmetricotlp.UnimplementedGRPCServer
-	consumer *otlp.Consumer
+	consumer  *otlp.Consumer
+	monitored *monitoredConsumer
 }
 
 func (s *metricsService) Export(ctx context.Context, req pmetricotlp.ExportRequest) (pmetricotlp.ExportResponse, error) {
@@ -106,8 +107,7 @@ func (s *metricsService) Export(ctx context.Context, req pmetricotlp.ExportReque
 	if md.DataPointCount() == 0 {
 		return pmetricotlp.NewExportResponse(), nil
 	}
-	err := s.consumer.ConsumeMetrics(ctx, md)
-	return pmetricotlp.NewExportResponse(), err
+	return consumeMetrics(ctx, s.consumer, s.monitored, md)
 }
 
 type logsService struct {
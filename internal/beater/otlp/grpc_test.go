@@ -25,6 +25,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/plog"
 	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
 	"go.opentelemetry.io/collector/pdata/pmetric"
@@ -126,7 +127,8 @@ func TestConsumeMetricsGRPC(t *testing.T) {
 		actual[key] = value
 	})
 	assert.Equal(t, map[string]interface{}{
-		"consumer.unsupported_dropped": int64(0),
+		"consumer.unsupported_dropped":  int64(0),
+		"consumer.rejected_data_points": int64(0),
 
 		"request.count":                int64(2),
 		"response.count":               int64(2),
@@ -185,6 +187,62 @@ func TestConsumeLogsGRPC(t *testing.T) {
 	}, actual)
 }
 
+func TestConsumePartialSuccessGRPC(t *testing.T) {
+	var batches []model.Batch
+	var batchProcessor model.ProcessBatchFunc = func(ctx context.Context, batch *model.Batch) error {
+		batches = append(batches, *batch)
+		return nil
+	}
+	conn := newGRPCServer(t, batchProcessor)
+
+	// Spans with empty trace or span IDs are indexed as before,
+	// and are not reported as rejected.
+	traces := ptrace.NewTraces()
+	spans := traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	valid := spans.AppendEmpty()
+	valid.SetTraceID(pcommon.TraceID{1})
+	valid.SetSpanID(pcommon.SpanID{1})
+	spans.AppendEmpty().SetTraceID(pcommon.TraceID{1}) // empty span ID
+	spans.AppendEmpty().SetSpanID(pcommon.SpanID{2})   // empty trace ID
+	tracesResp, err := ptraceotlp.NewGRPCClient(conn).Export(context.Background(), ptraceotlp.NewExportRequestFromTraces(traces))
+	require.NoError(t, err)
+	assert.Zero(t, tracesResp.PartialSuccess().RejectedSpans())
+	assert.Equal(t, "", tracesResp.PartialSuccess().ErrorMessage())
+	require.Len(t, batches, 1)
+	assert.Len(t, batches[0], 3)
+
+	metrics := pmetric.NewMetrics()
+	metric := metrics.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	metric.SetName("exponential")
+	metric.SetEmptyExponentialHistogram()
+	metric.ExponentialHistogram().DataPoints().AppendEmpty()
+	metricsResp, err := pmetricotlp.NewGRPCClient(conn).Export(context.Background(), pmetricotlp.NewExportRequestFromMetrics(metrics))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), metricsResp.PartialSuccess().RejectedDataPoints())
+	assert.Equal(t, "unsupported metric type ExponentialHistogram (1)", metricsResp.PartialSuccess().ErrorMessage())
+	assert.Len(t, batches, 1) // nothing left to consume
+
+	// Log records with a span ID but no trace ID are indexed as before.
+	logs := plog.NewLogs()
+	record := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+	record.SetSpanID(pcommon.SpanID{1})
+	record.Body().SetStr("message")
+	logsResp, err := plogotlp.NewGRPCClient(conn).Export(context.Background(), plogotlp.NewExportRequestFromLogs(logs))
+	require.NoError(t, err)
+	assert.Zero(t, logsResp.PartialSuccess().RejectedLogRecords())
+	require.Len(t, batches, 2)
+	require.Len(t, batches[1], 1)
+	assert.Equal(t, "0100000000000000", batches[1][0].Span.ID)
+	assert.Equal(t, "message", batches[1][0].Message)
+
+	actual := map[string]interface{}{}
+	monitoring.GetRegistry("apm-server.otlp.grpc.metrics").Do(monitoring.Full, func(key string, value interface{}) {
+		actual[key] = value
+	})
+	assert.Equal(t, int64(1), actual["consumer.rejected_data_points"])
+	assert.Equal(t, int64(1), actual["consumer.unsupported_dropped"])
+}
+
 func newGRPCServer(t *testing.T, batchProcessor model.BatchProcessor) *grpc.ClientConn {
 	lis, err := net.Listen("tcp", "localhost:0")
 	require.NoError(t, err)
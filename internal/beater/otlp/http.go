@@ -18,9 +18,12 @@
 package otlp
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
 
 	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
 	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
@@ -28,10 +31,13 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/elastic/apm-data/input/otlp"
 	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 )
@@ -60,115 +66,212 @@ func NewHTTPHandlers(logger *zap.Logger, processor model.BatchProcessor) HTTPHan
 		Logger:    logger,
 	})
 	httpMonitoredConsumer.set(consumer)
-	return HTTPHandlers{consumer: consumer}
+	return HTTPHandlers{consumer: consumer, monitored: &httpMonitoredConsumer}
 }
 
 // HTTPHandlers encapsulates http.HandlerFuncs for handling traces, metrics, and logs.
 type HTTPHandlers struct {
-	consumer *otlp.Consumer
+	consumer  *otlp.Consumer
+	monitored *monitoredConsumer
 }
 
-// HandleTraces is an http.HandlerFunc that receives a protobuf-encoded traces export
-// request, and processes it with the handler's OTLP consumer.
+// HandleTraces is an http.HandlerFunc that receives a protobuf or JSON encoded
+// traces export request, and processes it with the handler's OTLP consumer.
 func (h HTTPHandlers) HandleTraces(w http.ResponseWriter, r *http.Request) {
+	enc, err := requestEncoding(r)
+	if err != nil {
+		h.writeError(w, enc, err, http.StatusUnsupportedMediaType)
+		return
+	}
 	req := ptraceotlp.NewExportRequest()
-	if err := h.readRequest(r, req); err != nil {
-		h.writeError(w, err, http.StatusBadRequest)
+	if err := h.readRequest(r, enc, req); err != nil {
+		h.writeError(w, enc, err, http.StatusBadRequest)
 		return
 	}
 	if err := h.consumer.ConsumeTraces(r.Context(), req.Traces()); err != nil {
-		h.writeError(w, err, http.StatusInternalServerError)
+		h.writeConsumeError(w, enc, err)
 		return
 	}
-	if err := h.writeResponse(w, ptraceotlp.NewExportResponse()); err != nil {
-		h.writeError(w, err, http.StatusInternalServerError)
+	if err := h.writeResponse(w, enc, ptraceotlp.NewExportResponse()); err != nil {
+		h.writeError(w, enc, err, http.StatusInternalServerError)
 		return
 	}
 }
 
-// HandleMetrics is an http.HandlerFunc that receives a protobuf-encoded metrics export
-// request, and processes it with the handler's OTLP consumer.
+// HandleMetrics is an http.HandlerFunc that receives a protobuf or JSON encoded
+// metrics export request, and processes it with the handler's OTLP consumer.
 func (h HTTPHandlers) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	enc, err := requestEncoding(r)
+	if err != nil {
+		h.writeError(w, enc, err, http.StatusUnsupportedMediaType)
+		return
+	}
 	req := pmetricotlp.NewExportRequest()
-	if err := h.readRequest(r, req); err != nil {
-		h.writeError(w, err, http.StatusBadRequest)
+	if err := h.readRequest(r, enc, req); err != nil {
+		h.writeError(w, enc, err, http.StatusBadRequest)
 		return
 	}
-	if err := h.consumer.ConsumeMetrics(r.Context(), req.Metrics()); err != nil {
-		h.writeError(w, err, http.StatusInternalServerError)
+	resp, err := consumeMetrics(r.Context(), h.consumer, h.monitored, req.Metrics())
+	if err != nil {
+		h.writeConsumeError(w, enc, err)
 		return
 	}
-	if err := h.writeResponse(w, pmetricotlp.NewExportResponse()); err != nil {
-		h.writeError(w, err, http.StatusInternalServerError)
+	if err := h.writeResponse(w, enc, resp); err != nil {
+		h.writeError(w, enc, err, http.StatusInternalServerError)
 		return
 	}
 }
 
-// HandleLogs is an http.HandlerFunc that receives a protobuf-encoded logs export
-// request, and processes it with the handler's OTLP consumer.
+// HandleLogs is an http.HandlerFunc that receives a protobuf or JSON encoded
+// logs export request, and processes it with the handler's OTLP consumer.
 func (h HTTPHandlers) HandleLogs(w http.ResponseWriter, r *http.Request) {
+	enc, err := requestEncoding(r)
+	if err != nil {
+		h.writeError(w, enc, err, http.StatusUnsupportedMediaType)
+		return
+	}
 	req := plogotlp.NewExportRequest()
-	if err := h.readRequest(r, req); err != nil {
-		h.writeError(w, err, http.StatusBadRequest)
+	if err := h.readRequest(r, enc, req); err != nil {
+		h.writeError(w, enc, err, http.StatusBadRequest)
 		return
 	}
 	if err := h.consumer.ConsumeLogs(r.Context(), req.Logs()); err != nil {
-		h.writeError(w, err, http.StatusInternalServerError)
+		h.writeConsumeError(w, enc, err)
 		return
 	}
-	if err := h.writeResponse(w, plogotlp.NewExportResponse()); err != nil {
-		h.writeError(w, err, http.StatusInternalServerError)
+	if err := h.writeResponse(w, enc, plogotlp.NewExportResponse()); err != nil {
+		h.writeError(w, enc, err, http.StatusInternalServerError)
 		return
 	}
 }
 
-type protoUnmarshaler interface {
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// encoding identifies the encoding of an OTLP/HTTP request body.
+// Responses are written with the same encoding as the request.
+type encoding int
+
+const (
+	encodingProtobuf encoding = iota
+	encodingJSON
+)
+
+func (e encoding) contentType() string {
+	if e == encodingJSON {
+		return contentTypeJSON
+	}
+	return contentTypeProtobuf
+}
+
+// requestEncoding returns the encoding of the request body, according to its
+// Content-Type. Protobuf is assumed if no Content-Type is specified.
+//
+// If the Content-Type is not supported, an error is returned along with
+// encodingProtobuf, which should be used for encoding the error response.
+func requestEncoding(r *http.Request) (encoding, error) {
+	contentType := r.Header.Get(headers.ContentType)
+	if contentType == "" {
+		return encodingProtobuf, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		switch mediaType {
+		case contentTypeProtobuf:
+			return encodingProtobuf, nil
+		case contentTypeJSON:
+			return encodingJSON, nil
+		}
+	}
+	return encodingProtobuf, fmt.Errorf(
+		"unsupported content type %q, expected %q or %q",
+		contentType, contentTypeProtobuf, contentTypeJSON,
+	)
+}
+
+type unmarshaler interface {
 	UnmarshalProto([]byte) error
+	UnmarshalJSON([]byte) error
 }
 
-type protoMarshaler interface {
+type marshaler interface {
 	MarshalProto() ([]byte, error)
+	MarshalJSON() ([]byte, error)
 }
 
-func (h HTTPHandlers) readRequest(req *http.Request, out protoUnmarshaler) error {
+func (h HTTPHandlers) readRequest(req *http.Request, enc encoding, out unmarshaler) error {
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
 		return fmt.Errorf("failed to read request body: %w", err)
 	}
-	if err := out.UnmarshalProto(body); err != nil {
+	if enc == encodingJSON {
+		err = out.UnmarshalJSON(body)
+	} else {
+		err = out.UnmarshalProto(body)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to unmarshal request body: %w", err)
 	}
 	return nil
 }
 
-func (h HTTPHandlers) writeResponse(w http.ResponseWriter, m protoMarshaler) error {
-	body, err := m.MarshalProto()
+func (h HTTPHandlers) writeResponse(w http.ResponseWriter, enc encoding, m marshaler) error {
+	var body []byte
+	var err error
+	if enc == encodingJSON {
+		body, err = m.MarshalJSON()
+	} else {
+		body, err = m.MarshalProto()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to marshal response: %w", err)
 	}
-	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set(headers.ContentType, enc.contentType())
 	w.WriteHeader(http.StatusOK)
 	w.Write(body)
 	return nil
 }
 
-func (h HTTPHandlers) writeError(w http.ResponseWriter, err error, statusCode int) {
+// writeConsumeError writes an error returned by the consumer. Rate limit
+// errors are reported with 429 Too Many Requests, and with Retry-After if
+// a quota was exceeded; other errors are reported as internal errors.
+func (h HTTPHandlers) writeConsumeError(w http.ResponseWriter, enc encoding, err error) {
+	if !errors.Is(err, ratelimit.ErrRateLimitExceeded) {
+		h.writeError(w, enc, err, http.StatusInternalServerError)
+		return
+	}
+	var quotaErr *ratelimit.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		w.Header().Set(headers.RetryAfter, strconv.Itoa(quotaErr.RetryAfterSeconds()))
+	}
+	h.writeError(w, enc, status.Error(codes.ResourceExhausted, err.Error()), http.StatusTooManyRequests)
+}
+
+// writeError writes err as a google.rpc.Status message, encoded with enc.
+func (h HTTPHandlers) writeError(w http.ResponseWriter, enc encoding, err error, statusCode int) {
 	s, ok := status.FromError(err)
 	if !ok {
-		if statusCode == http.StatusBadRequest {
+		if statusCode == http.StatusBadRequest || statusCode == http.StatusUnsupportedMediaType {
 			s = status.New(codes.InvalidArgument, err.Error())
 		} else {
 			s = status.New(codes.Unknown, err.Error())
 		}
 	}
-	msg, err := proto.Marshal(s.Proto())
+	var msg []byte
+	if enc == encodingJSON {
+		msg, err = protojson.Marshal(s.Proto())
+	} else {
+		msg, err = proto.Marshal(s.Proto())
+	}
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(headers.ContentType, contentTypeJSON)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(`{"code": 13, "message": "failed to marshal error message"}`))
 		return
 	}
-	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set(headers.ContentType, enc.contentType())
 	w.WriteHeader(statusCode)
 	w.Write(msg)
 }
@@ -20,13 +20,18 @@ package otlp_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/plog"
 	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
 	"go.opentelemetry.io/collector/pdata/pmetric"
@@ -123,7 +128,8 @@ func TestConsumeMetricsHTTP(t *testing.T) {
 		actual[key] = value
 	})
 	assert.Equal(t, map[string]interface{}{
-		"consumer.unsupported_dropped": int64(0),
+		"consumer.unsupported_dropped":  int64(0),
+		"consumer.rejected_data_points": int64(0),
 
 		"request.count":                int64(1),
 		"response.count":               int64(1),
@@ -179,6 +185,224 @@ func TestConsumeLogsHTTP(t *testing.T) {
 	}, actual)
 }
 
+func TestConsumeHTTPQuotaExceeded(t *testing.T) {
+	var batchProcessor model.ProcessBatchFunc = func(ctx context.Context, batch *model.Batch) error {
+		return &ratelimit.QuotaExceededError{
+			Kind:       ratelimit.QuotaKindService,
+			Value:      "opbeans-go",
+			RetryAfter: 100 * time.Millisecond,
+		}
+	}
+	addr := newHTTPServer(t, batchProcessor)
+
+	logs := plog.NewLogs()
+	logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+	request, err := plogotlp.NewExportRequestFromLogs(logs).MarshalProto()
+	require.NoError(t, err)
+	rsp, err := http.Post(fmt.Sprintf("http://%s/v1/logs", addr), "application/x-protobuf", bytes.NewReader(request))
+	require.NoError(t, err)
+	assert.NoError(t, rsp.Body.Close())
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode)
+	assert.Equal(t, "1", rsp.Header.Get("Retry-After"))
+}
+
+// Payloads below are as sent by the OpenTelemetry JavaScript SDK's
+// OTLP/HTTP JSON exporters, with IDs encoded as hex strings and 64-bit
+// integers encoded as strings.
+const (
+	otlpJSONTraces = `{"resourceSpans":[{"resource":{"attributes":[
+		{"key":"service.name","value":{"stringValue":"frontend"}},
+		{"key":"telemetry.sdk.language","value":{"stringValue":"webjs"}},
+		{"key":"telemetry.sdk.name","value":{"stringValue":"opentelemetry"}},
+		{"key":"telemetry.sdk.version","value":{"stringValue":"1.13.0"}}
+	],"droppedAttributesCount":0},"scopeSpans":[{"scope":{"name":"@opentelemetry/instrumentation-fetch","version":"0.39.1"},"spans":[{
+		"traceId":"5982fe77008310cc80f1da5e10147517",
+		"spanId":"bd7a977555f6b982",
+		"name":"HTTP GET",
+		"kind":3,
+		"startTimeUnixNano":"1684325486532000000",
+		"endTimeUnixNano":"1684325486612000000",
+		"attributes":[
+			{"key":"http.method","value":{"stringValue":"GET"}},
+			{"key":"http.url","value":{"stringValue":"https://example.com/api"}},
+			{"key":"http.status_code","value":{"intValue":200}}
+		],
+		"droppedAttributesCount":0,
+		"events":[],
+		"droppedEventsCount":0,
+		"status":{"code":0},
+		"links":[],
+		"droppedLinksCount":0
+	}]}]}]}`
+
+	otlpJSONMetrics = `{"resourceMetrics":[{"resource":{"attributes":[
+		{"key":"service.name","value":{"stringValue":"frontend"}}
+	],"droppedAttributesCount":0},"scopeMetrics":[{"scope":{"name":"example-meter","version":""},"metrics":[{
+		"name":"requests",
+		"description":"",
+		"unit":"",
+		"sum":{"dataPoints":[{
+			"attributes":[{"key":"route","value":{"stringValue":"/api"}}],
+			"startTimeUnixNano":"1684325486532000000",
+			"timeUnixNano":"1684325496532000000",
+			"asInt":"3"
+		}],"aggregationTemporality":2,"isMonotonic":true}
+	}]}]}]}`
+
+	otlpJSONLogs = `{"resourceLogs":[{"resource":{"attributes":[
+		{"key":"service.name","value":{"stringValue":"frontend"}}
+	],"droppedAttributesCount":0},"scopeLogs":[{"scope":{"name":"example-logger"},"logRecords":[{
+		"timeUnixNano":"1684325486532000000",
+		"observedTimeUnixNano":"1684325486532000000",
+		"severityNumber":9,
+		"severityText":"INFO",
+		"body":{"stringValue":"hello"},
+		"attributes":[],
+		"droppedAttributesCount":0,
+		"traceId":"5982fe77008310cc80f1da5e10147517",
+		"spanId":"bd7a977555f6b982"
+	}]}]}]}`
+)
+
+func TestConsumeHTTPJSON(t *testing.T) {
+	var batches []model.Batch
+	var batchProcessor model.ProcessBatchFunc = func(ctx context.Context, batch *model.Batch) error {
+		batches = append(batches, *batch)
+		return nil
+	}
+	addr := newHTTPServer(t, batchProcessor)
+
+	for _, tc := range []struct {
+		path    string
+		payload string
+	}{
+		{"/v1/traces", otlpJSONTraces},
+		{"/v1/metrics", otlpJSONMetrics},
+		{"/v1/logs", otlpJSONLogs},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			batches = nil
+			rsp, err := http.Post(
+				fmt.Sprintf("http://%s%s", addr, tc.path),
+				"application/json; charset=utf-8",
+				strings.NewReader(tc.payload),
+			)
+			require.NoError(t, err)
+			body, err := io.ReadAll(rsp.Body)
+			require.NoError(t, err)
+			assert.NoError(t, rsp.Body.Close())
+
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+			assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+			assert.True(t, json.Valid(body), string(body))
+			require.Len(t, batches, 1)
+			require.NotEmpty(t, batches[0])
+			assert.Equal(t, "frontend", batches[0][0].Service.Name)
+		})
+	}
+}
+
+func TestConsumeHTTPInvalidJSON(t *testing.T) {
+	var batchProcessor model.ProcessBatchFunc = func(ctx context.Context, batch *model.Batch) error {
+		return nil
+	}
+	addr := newHTTPServer(t, batchProcessor)
+
+	rsp, err := http.Post(fmt.Sprintf("http://%s/v1/traces", addr), "application/json", strings.NewReader(`{"resourceSpans":`))
+	require.NoError(t, err)
+	body, err := io.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.NoError(t, rsp.Body.Close())
+
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	var status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	require.NoError(t, json.Unmarshal(body, &status))
+	assert.Equal(t, 3, status.Code) // InvalidArgument
+	assert.Contains(t, status.Message, "failed to unmarshal request body")
+}
+
+func TestConsumeHTTPUnsupportedMediaType(t *testing.T) {
+	var batchProcessor model.ProcessBatchFunc = func(ctx context.Context, batch *model.Batch) error {
+		return nil
+	}
+	addr := newHTTPServer(t, batchProcessor)
+
+	for _, path := range []string{"/v1/traces", "/v1/metrics", "/v1/logs"} {
+		for _, contentType := range []string{"text/plain", "application/grpc", "invalid;;"} {
+			rsp, err := http.Post(fmt.Sprintf("http://%s%s", addr, path), contentType, strings.NewReader(otlpJSONTraces))
+			require.NoError(t, err)
+			assert.NoError(t, rsp.Body.Close())
+			assert.Equal(t, http.StatusUnsupportedMediaType, rsp.StatusCode, "%s %s", path, contentType)
+			assert.Equal(t, "application/x-protobuf", rsp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestConsumePartialSuccessHTTP(t *testing.T) {
+	var batches []model.Batch
+	var batchProcessor model.ProcessBatchFunc = func(ctx context.Context, batch *model.Batch) error {
+		batches = append(batches, *batch)
+		return nil
+	}
+	addr := newHTTPServer(t, batchProcessor)
+
+	// Spans with empty trace or span IDs are indexed as before,
+	// and are not reported as rejected.
+	traces := ptrace.NewTraces()
+	spans := traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	valid := spans.AppendEmpty()
+	valid.SetTraceID(pcommon.TraceID{1})
+	valid.SetSpanID(pcommon.SpanID{1})
+	spans.AppendEmpty().SetSpanID(pcommon.SpanID{2}) // empty trace ID
+	request, err := ptraceotlp.NewExportRequestFromTraces(traces).MarshalProto()
+	require.NoError(t, err)
+
+	rsp, err := http.Post(fmt.Sprintf("http://%s/v1/traces", addr), "application/x-protobuf", bytes.NewReader(request))
+	require.NoError(t, err)
+	body, err := io.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.NoError(t, rsp.Body.Close())
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	tracesResp := ptraceotlp.NewExportResponse()
+	require.NoError(t, tracesResp.UnmarshalProto(body))
+	assert.Zero(t, tracesResp.PartialSuccess().RejectedSpans())
+	require.Len(t, batches, 1)
+	assert.Len(t, batches[0], 2)
+
+	// Partial success is reported for rejected data points,
+	// including for JSON-encoded requests.
+	rsp, err = http.Post(fmt.Sprintf("http://%s/v1/metrics", addr), "application/json", strings.NewReader(
+		`{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"g","gauge":{"dataPoints":[{"asInt":"1"},{}]}}]}]}]}`,
+	))
+	require.NoError(t, err)
+	body, err = io.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.NoError(t, rsp.Body.Close())
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.JSONEq(t, `{"partialSuccess":{"rejectedDataPoints":"1","errorMessage":"number data point without value (1)"}}`, string(body))
+	require.Len(t, batches, 2)
+
+	// Log records with a span ID but no trace ID are indexed as before.
+	rsp, err = http.Post(fmt.Sprintf("http://%s/v1/logs", addr), "application/json", strings.NewReader(
+		`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"spanId":"0100000000000000","body":{"stringValue":"message"}}]}]}]}`,
+	))
+	require.NoError(t, err)
+	body, err = io.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.NoError(t, rsp.Body.Close())
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.JSONEq(t, `{"partialSuccess":{}}`, string(body))
+	require.Len(t, batches, 3)
+	require.Len(t, batches[2], 1)
+	assert.Equal(t, "0100000000000000", batches[2][0].Span.ID)
+	assert.Equal(t, "message", batches[2][0].Message)
+}
+
 func newHTTPServer(t *testing.T, batchProcessor model.BatchProcessor) string {
 	lis, err := net.Listen("tcp", "localhost:0")
 	require.NoError(t, err)
@@ -187,7 +411,7 @@ func newHTTPServer(t *testing.T, batchProcessor model.BatchProcessor) string {
 	ratelimitStore, _ := ratelimit.NewStore(1000, 1000, 1000)
 	router, err := api.NewMux(
 		cfg, batchProcessor, auth, agentcfg.NewDirectFetcher(nil),
-		ratelimitStore, nil, func() bool { return true })
+		ratelimitStore, nil, nil, nil, nil, func() bool { return true })
 	require.NoError(t, err)
 	srv := http.Server{Handler: router}
 	t.Cleanup(func() {
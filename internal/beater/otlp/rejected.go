@@ -0,0 +1,129 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// rejections records the number of records rejected from an export request,
+// and the reasons for rejecting them.
+type rejections struct {
+	count   int64
+	reasons []string
+	counts  map[string]int64
+}
+
+func (r *rejections) add(reason string, n int64) {
+	if n == 0 {
+		return
+	}
+	if r.counts == nil {
+		r.counts = make(map[string]int64)
+	}
+	if _, ok := r.counts[reason]; !ok {
+		r.reasons = append(r.reasons, reason)
+	}
+	r.counts[reason] += n
+	r.count += n
+}
+
+// message returns a description of the rejections, suitable for reporting
+// in the error_message field of an OTLP partial success response.
+func (r *rejections) message() string {
+	var sb strings.Builder
+	for i, reason := range r.reasons {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		fmt.Fprintf(&sb, "%s (%d)", reason, r.counts[reason])
+	}
+	return sb.String()
+}
+
+// rejectDataPoints removes metric data points which cannot be consumed from
+// metrics, returning the rejections and the number of metrics with at least
+// one data point rejected.
+//
+// Data points are rejected where the consumer would otherwise drop them:
+// data points of unsupported metric types, number data points without a
+// finite value, and histogram data points without explicit bounds or with
+// mismatched bucket counts.
+func rejectDataPoints(metrics pmetric.Metrics) (r rejections, unsupportedMetrics int64) {
+	resourceMetrics := metrics.ResourceMetrics()
+	for i := 0; i < resourceMetrics.Len(); i++ {
+		scopeMetrics := resourceMetrics.At(i).ScopeMetrics()
+		for j := 0; j < scopeMetrics.Len(); j++ {
+			scopeMetrics.At(j).Metrics().RemoveIf(func(metric pmetric.Metric) bool {
+				before := r.count
+				var remaining int
+				switch metric.Type() {
+				case pmetric.MetricTypeGauge:
+					remaining = rejectNumberDataPoints(metric.Gauge().DataPoints(), &r)
+				case pmetric.MetricTypeSum:
+					remaining = rejectNumberDataPoints(metric.Sum().DataPoints(), &r)
+				case pmetric.MetricTypeHistogram:
+					dps := metric.Histogram().DataPoints()
+					dps.RemoveIf(func(dp pmetric.HistogramDataPoint) bool {
+						bounds := dp.ExplicitBounds().Len()
+						if bounds == 0 || dp.BucketCounts().Len() != bounds+1 {
+							r.add("histogram data point without explicit bounds or with mismatched bucket counts", 1)
+							return true
+						}
+						return false
+					})
+					remaining = dps.Len()
+				case pmetric.MetricTypeExponentialHistogram:
+					r.add("unsupported metric type ExponentialHistogram", int64(metric.ExponentialHistogram().DataPoints().Len()))
+				default:
+					// Summaries are supported, and metrics without
+					// data points are reported by the consumer.
+					return false
+				}
+				if r.count == before {
+					return false
+				}
+				unsupportedMetrics++
+				return remaining == 0
+			})
+		}
+	}
+	return r, unsupportedMetrics
+}
+
+func rejectNumberDataPoints(dps pmetric.NumberDataPointSlice, r *rejections) int {
+	dps.RemoveIf(func(dp pmetric.NumberDataPoint) bool {
+		switch dp.ValueType() {
+		case pmetric.NumberDataPointValueTypeInt:
+			return false
+		case pmetric.NumberDataPointValueTypeDouble:
+			if v := dp.DoubleValue(); !math.IsNaN(v) && !math.IsInf(v, 0) {
+				return false
+			}
+			r.add("number data point with non-finite value", 1)
+		default:
+			r.add("number data point without value", 1)
+		}
+		return true
+	})
+	return dps.Len()
+}
@@ -0,0 +1,94 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestRejectDataPoints(t *testing.T) {
+	metrics := pmetric.NewMetrics()
+	ms := metrics.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+
+	gauge := ms.AppendEmpty()
+	gauge.SetName("gauge")
+	gauge.SetEmptyGauge()
+	gauge.Gauge().DataPoints().AppendEmpty().SetIntValue(1)
+	gauge.Gauge().DataPoints().AppendEmpty().SetDoubleValue(math.NaN())
+
+	sum := ms.AppendEmpty()
+	sum.SetName("sum")
+	sum.SetEmptySum()
+	sum.Sum().DataPoints().AppendEmpty() // no value
+	sum.Sum().DataPoints().AppendEmpty().SetDoubleValue(math.Inf(1))
+
+	histogram := ms.AppendEmpty()
+	histogram.SetName("histogram")
+	histogram.SetEmptyHistogram()
+	dp := histogram.Histogram().DataPoints().AppendEmpty()
+	dp.ExplicitBounds().FromRaw([]float64{1, 2})
+	dp.BucketCounts().FromRaw([]uint64{1, 2, 3})
+	dp = histogram.Histogram().DataPoints().AppendEmpty()
+	dp.BucketCounts().FromRaw([]uint64{1})
+
+	exponential := ms.AppendEmpty()
+	exponential.SetName("exponential")
+	exponential.SetEmptyExponentialHistogram()
+	exponential.ExponentialHistogram().DataPoints().AppendEmpty()
+	exponential.ExponentialHistogram().DataPoints().AppendEmpty()
+
+	summary := ms.AppendEmpty()
+	summary.SetName("summary")
+	summary.SetEmptySummary()
+	summary.Summary().DataPoints().AppendEmpty()
+
+	r, unsupportedMetrics := rejectDataPoints(metrics)
+	assert.Equal(t, int64(6), r.count)
+	assert.Equal(t, int64(4), unsupportedMetrics)
+	assert.Equal(t, "number data point with non-finite value (2); "+
+		"number data point without value (1); "+
+		"histogram data point without explicit bounds or with mismatched bucket counts (1); "+
+		"unsupported metric type ExponentialHistogram (2)",
+		r.message(),
+	)
+
+	var names []string
+	for i := 0; i < ms.Len(); i++ {
+		names = append(names, ms.At(i).Name())
+	}
+	assert.Equal(t, []string{"gauge", "histogram", "summary"}, names)
+	assert.Equal(t, 3, metrics.DataPointCount())
+}
+
+func TestRejectNone(t *testing.T) {
+	metrics := pmetric.NewMetrics()
+	gauge := metrics.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	gauge.SetName("gauge")
+	gauge.SetEmptyGauge()
+	gauge.Gauge().DataPoints().AppendEmpty().SetDoubleValue(1)
+
+	r, unsupportedMetrics := rejectDataPoints(metrics)
+	assert.Zero(t, r.count)
+	assert.Zero(t, unsupportedMetrics)
+	assert.Equal(t, "", r.message())
+	assert.Equal(t, 1, metrics.DataPointCount())
+}
@@ -26,11 +26,19 @@ import (
 	"time"
 
 	"go.elastic.co/fastjson"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/elastic/apm-data/model"
 	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/geoip"
+	"github.com/elastic/apm-server/internal/logs"
+	srvmodelprocessor "github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/apm-server/internal/publish"
 	"github.com/elastic/apm-server/internal/version"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/paths"
 	"github.com/elastic/go-docappender"
 )
 
@@ -52,18 +60,137 @@ func authorizeEventIngestProcessor(ctx context.Context, batch *model.Batch) erro
 	return nil
 }
 
-// rateLimitBatchProcessor is a model.BatchProcessor that rate limits based on
-// the batch size. This will be invoked after decoding events, but before sending
-// on to the libbeat publisher.
-func rateLimitBatchProcessor(ctx context.Context, batch *model.Batch) error {
-	if limiter, ok := ratelimit.FromContext(ctx); ok {
-		ctx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
-		defer cancel()
-		if err := limiter.WaitN(ctx, len(*batch)); err != nil {
-			return ratelimit.ErrRateLimitExceeded
+// newRateLimitBatchProcessor returns a model.BatchProcessor that rate limits
+// based on the batch size. This will be invoked after decoding events, but
+// before sending on to the libbeat publisher.
+//
+// Anonymous clients are rate limited by the rate.Limiter in the context, if any.
+// If quotas is non-nil, all clients are additionally subject to the quotas for
+// the events' service names, and the client's API Key or secret token.
+func newRateLimitBatchProcessor(quotas *ratelimit.Quotas) model.ProcessBatchFunc {
+	return func(ctx context.Context, batch *model.Batch) error {
+		if limiter, ok := ratelimit.FromContext(ctx); ok {
+			ctx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
+			defer cancel()
+			if err := limiter.WaitN(ctx, len(*batch)); err != nil {
+				return ratelimit.ErrRateLimitExceeded
+			}
 		}
+		if quotas != nil {
+			return quotas.Allow(quotaUsage(ctx, batch, quotas.MeasureBytes()))
+		}
+		return nil
 	}
-	return nil
+}
+
+// newQuotas returns ratelimit.Quotas for the configured quota rules,
+// or nil if there are none.
+func newQuotas(cfg config.QuotaConfig) (*ratelimit.Quotas, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+	quotas := make([]ratelimit.Quota, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		quota := ratelimit.Quota{
+			EventsPerSecond: rule.EventsPerSecond,
+			BytesPerSecond:  rule.BytesPerSecond,
+		}
+		switch {
+		case rule.Service != "":
+			quota.Kind, quota.Value = ratelimit.QuotaKindService, rule.Service
+		case rule.APIKeyID != "":
+			quota.Kind, quota.Value = ratelimit.QuotaKindAPIKey, rule.APIKeyID
+		case rule.SecretToken != "":
+			quota.Kind, quota.Value = ratelimit.QuotaKindSecretToken, rule.SecretToken
+		}
+		quotas[i] = quota
+	}
+	return ratelimit.NewQuotas(quotas, cfg.BurstMultiplier, cfg.CacheSize)
+}
+
+// newFilterBatchProcessor returns a model.BatchProcessor that drops events
+// matching the configured filter rules, or nil if there are none.
+func newFilterBatchProcessor(rules []config.FilterRule) (model.BatchProcessor, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	filterRules := make([]srvmodelprocessor.FilterRule, len(rules))
+	for i, rule := range rules {
+		filterRules[i] = srvmodelprocessor.FilterRule{
+			Name:            rule.Name,
+			ServiceName:     rule.Match.Service.Name,
+			TransactionName: rule.Match.Transaction.Name,
+			TransactionType: rule.Match.Transaction.Type,
+			URLPath:         rule.Match.URL.Path,
+			ProcessorEvent:  rule.Match.Processor.Event,
+			Labels:          rule.Match.Labels,
+			SampleRate:      rule.SampleRate,
+		}
+	}
+	return srvmodelprocessor.NewFilter(filterRules, filtersMonitoringRegistry)
+}
+
+// newRedactionBatchProcessor returns a model.BatchProcessor that redacts
+// personal data according to the configured rules, or nil if there are none.
+func newRedactionBatchProcessor(rules []config.RedactionRule) (model.BatchProcessor, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	redactionRules := make([]srvmodelprocessor.RedactionRule, len(rules))
+	for i, rule := range rules {
+		redactionRules[i] = srvmodelprocessor.RedactionRule{
+			Name:     rule.Name,
+			Fields:   rule.Fields,
+			Names:    rule.Names,
+			Patterns: rule.Patterns,
+			Hash:     rule.Hash(),
+		}
+	}
+	return srvmodelprocessor.NewRedactor(redactionRules, redactionMonitoringRegistry)
+}
+
+// quotaUsage returns the quota usage of the events in batch, per service name,
+// and for the client's API Key or secret token. If measureBytes is true, the
+// size of each event is measured as the size of its JSON encoding.
+func quotaUsage(ctx context.Context, batch *model.Batch, measureBytes bool) []ratelimit.Usage {
+	var usage []ratelimit.Usage
+	var total ratelimit.Usage
+	var w fastjson.Writer
+	services := make(map[string]int)
+	for i := range *batch {
+		event := &(*batch)[i]
+		var size int
+		if measureBytes {
+			w.Reset()
+			if err := event.MarshalFastJSON(&w); err == nil {
+				size = w.Size()
+			}
+		}
+		index, ok := services[event.Service.Name]
+		if !ok {
+			index = len(usage)
+			services[event.Service.Name] = index
+			usage = append(usage, ratelimit.Usage{
+				Kind:  ratelimit.QuotaKindService,
+				Value: event.Service.Name,
+			})
+		}
+		usage[index].Events++
+		usage[index].Bytes += size
+		total.Events++
+		total.Bytes += size
+	}
+	if details, ok := auth.AuthenticationDetailsFromContext(ctx); ok {
+		switch {
+		case details.Method == auth.MethodAPIKey && details.APIKey != nil:
+			total.Kind, total.Value = ratelimit.QuotaKindAPIKey, details.APIKey.ID
+			usage = append(usage, total)
+		case details.Method == auth.MethodSecretToken && details.SecretToken != nil:
+			total.Kind, total.Value = ratelimit.QuotaKindSecretToken, details.SecretToken.Name
+			usage = append(usage, total)
+		}
+	}
+	return usage
 }
 
 // newObserverBatchProcessor returns a model.BatchProcessor that sets
@@ -81,18 +208,89 @@ func newObserverBatchProcessor() model.ProcessBatchFunc {
 	}
 }
 
-func newDocappenderBatchProcessor(a *docappender.Appender) model.ProcessBatchFunc {
+// newGeoIPEnricher returns a geoip.Enricher for the databases configured
+// for RUM and backend events, and a function for reloading the databases
+// when they are modified. If GeoIP enrichment is not enabled, newGeoIPEnricher
+// returns nils.
+func newGeoIPEnricher(cfg *config.Config) (publish.JSONEnricher, func(context.Context) error, error) {
+	rumEnabled := cfg.RumConfig.Enabled && cfg.RumConfig.GeoIP.IsEnabled()
+	if !rumEnabled && !cfg.GeoIP.IsEnabled() {
+		return nil, nil, nil
+	}
+
+	// Databases are shared by RUM and backend events when they are
+	// configured with the same path, and checked for modifications at
+	// the shortest of the configured intervals.
+	logger := logp.NewLogger(logs.GeoIP)
+	databases := make(map[string]*geoip.Database)
+	intervals := make(map[string]time.Duration)
+	openDatabase := func(path string, interval time.Duration) (*geoip.Database, error) {
+		if path == "" {
+			return nil, nil
+		}
+		path = paths.Resolve(paths.Config, path)
+		if db, ok := databases[path]; ok {
+			if interval < intervals[path] {
+				intervals[path] = interval
+			}
+			return db, nil
+		}
+		db, err := geoip.OpenDatabase(path, logger)
+		if err != nil {
+			return nil, err
+		}
+		databases[path] = db
+		intervals[path] = interval
+		return db, nil
+	}
+	openDatabases := func(cfg config.GeoIPConfig) (dbs geoip.Databases, err error) {
+		if dbs.City, err = openDatabase(cfg.Database, cfg.ReloadInterval); err != nil {
+			return dbs, err
+		}
+		if dbs.ASN, err = openDatabase(cfg.ASNDatabase, cfg.ReloadInterval); err != nil {
+			return dbs, err
+		}
+		return dbs, nil
+	}
+
+	var rum geoip.Databases
+	if rumEnabled {
+		var err error
+		if rum, err = openDatabases(cfg.RumConfig.GeoIP); err != nil {
+			return nil, nil, err
+		}
+	}
+	backend, err := openDatabases(cfg.GeoIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	run := func(ctx context.Context) error {
+		g, ctx := errgroup.WithContext(ctx)
+		for path, db := range databases {
+			db, interval := db, intervals[path]
+			g.Go(func() error { return db.Run(ctx, interval) })
+		}
+		return g.Wait()
+	}
+	return geoip.NewEnricher(rum, backend), run, nil
+}
+
+func newDocappenderBatchProcessor(a *docappender.Appender, enricher publish.JSONEnricher) model.ProcessBatchFunc {
 	var pool sync.Pool
 	pool.New = func() any {
 		return &pooledReader{pool: &pool}
 	}
 	return func(ctx context.Context, b *model.Batch) error {
-		for _, event := range *b {
+		for i := range *b {
+			event := &(*b)[i]
 			r := pool.Get().(*pooledReader)
 			if err := event.MarshalFastJSON(&r.jsonw); err != nil {
 				r.reset()
 				return err
 			}
+			if enricher != nil {
+				enricher.EnrichJSON(&r.jsonw, event)
+			}
 			r.indexBuilder.WriteString(event.DataStream.Type)
 			r.indexBuilder.WriteByte('-')
 			r.indexBuilder.WriteString(event.DataStream.Dataset)
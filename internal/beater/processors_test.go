@@ -26,6 +26,8 @@ import (
 	"golang.org/x/time/rate"
 
 	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 )
 
@@ -37,13 +39,64 @@ func TestRateLimitBatchProcessor(t *testing.T) {
 	for i := range batch {
 		batch[i].Transaction = &model.Transaction{}
 	}
+	processor := newRateLimitBatchProcessor(nil)
 	for i := 0; i < 2; i++ {
-		err := rateLimitBatchProcessor(ctx, &batch)
+		err := processor(ctx, &batch)
 		require.NoError(t, err)
 	}
 
 	// After the second batch, the rate limiter burst has been exhausted,
 	// and the limit is not high enough to allow another one.
-	err := rateLimitBatchProcessor(ctx, &batch)
+	err := processor(ctx, &batch)
 	assert.Equal(t, ratelimit.ErrRateLimitExceeded, err)
 }
+
+func TestRateLimitBatchProcessorQuotas(t *testing.T) {
+	quotas, err := newQuotas(config.QuotaConfig{
+		BurstMultiplier: 1,
+		CacheSize:       1,
+		Rules: []config.QuotaRule{
+			{Service: "opbeans-go", EventsPerSecond: 10},
+			{APIKeyID: "*", EventsPerSecond: 15},
+		},
+	})
+	require.NoError(t, err)
+	processor := newRateLimitBatchProcessor(quotas)
+
+	batch := make(model.Batch, 5)
+	for i := range batch {
+		batch[i].Service.Name = "opbeans-go"
+		batch[i].Transaction = &model.Transaction{}
+	}
+	ctx := auth.ContextWithAuthenticationDetails(context.Background(), auth.AuthenticationDetails{
+		Method: auth.MethodAPIKey,
+		APIKey: &auth.APIKeyAuthenticationDetails{ID: "abc"},
+	})
+	for i := 0; i < 2; i++ {
+		require.NoError(t, processor(ctx, &batch))
+	}
+
+	// The service's quota has been exhausted.
+	err = processor(ctx, &batch)
+	var quotaErr *ratelimit.QuotaExceededError
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, ratelimit.QuotaKindService, quotaErr.Kind)
+	assert.Equal(t, "opbeans-go", quotaErr.Value)
+
+	// Other services are subject only to the API Key's quota, which has
+	// capacity for only 5 more events.
+	for i := range batch {
+		batch[i].Service.Name = "opbeans-java"
+	}
+	require.NoError(t, processor(ctx, &batch))
+	err = processor(ctx, &batch)
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, ratelimit.QuotaKindAPIKey, quotaErr.Kind)
+	assert.Equal(t, "abc", quotaErr.Value)
+	assert.ErrorIs(t, err, ratelimit.ErrRateLimitExceeded)
+
+	// Quotas are not enforced if there are no rules.
+	quotas, err = newQuotas(config.DefaultConfig().Quotas)
+	require.NoError(t, err)
+	assert.Nil(t, quotas)
+}
@@ -0,0 +1,319 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// quotaRegistry holds per-quota metrics.
+var quotaRegistry = monitoring.Default.NewRegistry("apm-server.quotas")
+
+// QuotaKind identifies the client attribute by which a quota is keyed.
+type QuotaKind string
+
+const (
+	// QuotaKindService identifies quotas keyed by service name.
+	QuotaKindService QuotaKind = "service"
+
+	// QuotaKindAPIKey identifies quotas keyed by API Key ID.
+	QuotaKindAPIKey QuotaKind = "api_key"
+
+	// QuotaKindSecretToken identifies quotas keyed by secret token name.
+	QuotaKindSecretToken QuotaKind = "secret_token"
+)
+
+// QuotaWildcard is the Quota.Value which applies the quota to each distinct
+// value of a QuotaKind that does not have its own quota.
+const QuotaWildcard = "*"
+
+// Quota holds event and byte rate limits for a service name, API Key ID,
+// or secret token name.
+type Quota struct {
+	Kind  QuotaKind
+	Value string
+
+	// EventsPerSecond holds the maximum number of events per second,
+	// or zero if the number of events is not limited.
+	EventsPerSecond float64
+
+	// BytesPerSecond holds the maximum number of bytes per second,
+	// or zero if the number of bytes is not limited.
+	BytesPerSecond float64
+}
+
+// Usage holds the number of events and bytes to be checked against the
+// quota for a service name, API Key ID, or secret token name.
+type Usage struct {
+	Kind   QuotaKind
+	Value  string
+	Events int
+	Bytes  int
+}
+
+// QuotaExceededError is returned by Quotas.Allow when a quota is exceeded.
+// QuotaExceededError wraps ErrRateLimitExceeded.
+type QuotaExceededError struct {
+	Kind  QuotaKind
+	Value string
+
+	// RetryAfter holds the time after which the request may be retried.
+	RetryAfter time.Duration
+}
+
+// Error returns the error message.
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: %s quota exceeded for %q", ErrRateLimitExceeded, e.Kind, e.Value)
+}
+
+// RetryAfterSeconds returns RetryAfter rounded up to whole seconds, for
+// the HTTP Retry-After response header.
+func (e *QuotaExceededError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// Unwrap returns ErrRateLimitExceeded.
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrRateLimitExceeded
+}
+
+// Quotas enforces ingestion quotas.
+type Quotas struct {
+	burstMultiplier int
+	measureBytes    bool
+	quotas          map[quotaKey]*quotaEntry
+	wildcards       map[QuotaKind]*quotaEntry
+}
+
+type quotaKey struct {
+	kind  QuotaKind
+	value string
+}
+
+type quotaEntry struct {
+	Quota
+	limiter  *quotaLimiter  // nil for wildcard quotas
+	wildcard *wildcardQuota // nil for non-wildcard quotas
+
+	eventsAccepted *monitoring.Int
+	eventsRejected *monitoring.Int
+	bytesAccepted  *monitoring.Int
+	bytesRejected  *monitoring.Int
+}
+
+// wildcardQuota maintains distinct rate limiters for each value in an LRU
+// cache. As with Store, evicted rate limiters are reused for the new value,
+// to avoid bypassing quotas by cycling through values.
+type wildcardQuota struct {
+	cache          simplelru.LRU
+	mu             sync.Mutex // guards limiter in cache
+	evictedLimiter *quotaLimiter
+}
+
+type quotaLimiter struct {
+	events *rate.Limiter // nil if events are not limited
+	bytes  *rate.Limiter // nil if bytes are not limited
+}
+
+// NewQuotas returns a new Quotas, enforcing the given quotas.
+//
+// Each quota may consume up to burstMultiplier times its rates in a burst.
+// Wildcard quotas maintain up to cacheSize distinct rate limiters.
+func NewQuotas(quotas []Quota, burstMultiplier, cacheSize int) (*Quotas, error) {
+	if burstMultiplier <= 0 || cacheSize <= 0 {
+		return nil, errors.New("quotas initialization: burst multiplier and cache size must be greater than zero")
+	}
+	q := &Quotas{
+		burstMultiplier: burstMultiplier,
+		quotas:          make(map[quotaKey]*quotaEntry),
+		wildcards:       make(map[QuotaKind]*quotaEntry),
+	}
+	for _, cfg := range quotas {
+		key := quotaKey{kind: cfg.Kind, value: cfg.Value}
+		if _, ok := q.quotas[key]; ok {
+			return nil, errors.Errorf("duplicate %s quota for %q", cfg.Kind, cfg.Value)
+		}
+		metricPrefix := string(cfg.Kind) + "." + strings.ReplaceAll(cfg.Value, ".", "_") + "."
+		quota := &quotaEntry{
+			Quota:          cfg,
+			eventsAccepted: quotaCounter(metricPrefix + "events.accepted"),
+			eventsRejected: quotaCounter(metricPrefix + "events.rejected"),
+			bytesAccepted:  quotaCounter(metricPrefix + "bytes.accepted"),
+			bytesRejected:  quotaCounter(metricPrefix + "bytes.rejected"),
+		}
+		if cfg.BytesPerSecond > 0 {
+			q.measureBytes = true
+		}
+		if cfg.Value == QuotaWildcard {
+			wildcard, err := newWildcardQuota(cacheSize)
+			if err != nil {
+				return nil, err
+			}
+			quota.wildcard = wildcard
+			q.wildcards[cfg.Kind] = quota
+		} else {
+			quota.limiter = q.newLimiter(cfg)
+		}
+		q.quotas[key] = quota
+	}
+	return q, nil
+}
+
+func newWildcardQuota(cacheSize int) (*wildcardQuota, error) {
+	w := &wildcardQuota{}
+	var onEvicted = func(_ interface{}, value interface{}) {
+		w.evictedLimiter = *value.(**quotaLimiter)
+	}
+	c, err := simplelru.NewLRU(cacheSize, simplelru.EvictCallback(onEvicted))
+	if err != nil {
+		return nil, err
+	}
+	w.cache = *c
+	return w, nil
+}
+
+// quotaCounter returns the quota metric with the given name, creating it
+// if it does not exist. Metrics may already exist if the server has been
+// reloaded.
+func quotaCounter(name string) *monitoring.Int {
+	if counter, ok := quotaRegistry.Get(name).(*monitoring.Int); ok {
+		return counter
+	}
+	return monitoring.NewInt(quotaRegistry, name)
+}
+
+func (q *Quotas) newLimiter(cfg Quota) *quotaLimiter {
+	newLimiter := func(limit float64) *rate.Limiter {
+		if limit <= 0 {
+			return nil
+		}
+		burst := int(math.Ceil(limit * float64(q.burstMultiplier)))
+		return rate.NewLimiter(rate.Limit(limit), burst)
+	}
+	return &quotaLimiter{
+		events: newLimiter(cfg.EventsPerSecond),
+		bytes:  newLimiter(cfg.BytesPerSecond),
+	}
+}
+
+// MeasureBytes reports whether any quota limits the number of bytes,
+// and so whether Usage.Bytes must be measured.
+func (q *Quotas) MeasureBytes() bool {
+	return q.measureBytes
+}
+
+// Allow checks the given usage against the matching quotas, consuming
+// the usage from them if all of them allow it. If any quota is exceeded,
+// no usage is consumed and a *QuotaExceededError is returned.
+//
+// Usage exceeding a quota's burst consumes the entire burst, so that
+// large batches may still be accepted when the quota is not in use.
+func (q *Quotas) Allow(usage []Usage) error {
+	now := time.Now()
+	var reservations []*rate.Reservation
+	cancel := func() {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+	reserve := func(limiter *rate.Limiter, n int) time.Duration {
+		if limiter == nil || n <= 0 {
+			return 0
+		}
+		if burst := limiter.Burst(); n > burst {
+			n = burst
+		}
+		r := limiter.ReserveN(now, n)
+		if delay := r.DelayFrom(now); delay > 0 {
+			r.CancelAt(now)
+			return delay
+		}
+		reservations = append(reservations, r)
+		return 0
+	}
+
+	quotas := make([]*quotaEntry, len(usage))
+	for i, u := range usage {
+		quota, limiter := q.lookup(u.Kind, u.Value)
+		if quota == nil {
+			continue
+		}
+		quotas[i] = quota
+		retryAfter := reserve(limiter.events, u.Events)
+		if retryAfter == 0 {
+			retryAfter = reserve(limiter.bytes, u.Bytes)
+		}
+		if retryAfter > 0 {
+			cancel()
+			quota.eventsRejected.Add(int64(u.Events))
+			quota.bytesRejected.Add(int64(u.Bytes))
+			return &QuotaExceededError{Kind: u.Kind, Value: u.Value, RetryAfter: retryAfter}
+		}
+	}
+	for i, quota := range quotas {
+		if quota != nil {
+			quota.eventsAccepted.Add(int64(usage[i].Events))
+			quota.bytesAccepted.Add(int64(usage[i].Bytes))
+		}
+	}
+	return nil
+}
+
+// lookup returns the quota and rate limiters for the given kind and value,
+// or nil if there is no matching quota.
+func (q *Quotas) lookup(kind QuotaKind, value string) (*quotaEntry, *quotaLimiter) {
+	if quota, ok := q.quotas[quotaKey{kind: kind, value: value}]; ok && quota.limiter != nil {
+		return quota, quota.limiter
+	}
+	quota, ok := q.wildcards[kind]
+	if !ok {
+		return nil, nil
+	}
+	return quota, quota.wildcard.forValue(value, func() *quotaLimiter {
+		return q.newLimiter(quota.Quota)
+	})
+}
+
+func (w *wildcardQuota) forValue(value string, newLimiter func() *quotaLimiter) *quotaLimiter {
+	// lock get and add action for cache to allow proper eviction handling
+	// without race conditions.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if l, ok := w.cache.Get(value); ok {
+		return *l.(**quotaLimiter)
+	}
+
+	var limiter *quotaLimiter
+	if evicted := w.cache.Add(value, &limiter); evicted {
+		limiter = w.evictedLimiter
+	} else {
+		limiter = newLimiter()
+	}
+	return limiter
+}
@@ -0,0 +1,110 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotasInitFails(t *testing.T) {
+	_, err := NewQuotas(nil, 0, 1)
+	assert.Error(t, err)
+	_, err = NewQuotas(nil, 1, 0)
+	assert.Error(t, err)
+	_, err = NewQuotas([]Quota{
+		{Kind: QuotaKindService, Value: "a", EventsPerSecond: 1},
+		{Kind: QuotaKindService, Value: "a", EventsPerSecond: 2},
+	}, 1, 1)
+	assert.EqualError(t, err, `duplicate service quota for "a"`)
+}
+
+func TestQuotasAllow(t *testing.T) {
+	quotas, err := NewQuotas([]Quota{
+		{Kind: QuotaKindService, Value: "opbeans-go", EventsPerSecond: 1},
+		{Kind: QuotaKindSecretToken, Value: "default", BytesPerSecond: 100},
+	}, 10, 1)
+	require.NoError(t, err)
+	assert.True(t, quotas.MeasureBytes())
+
+	service := quotas.quotas[quotaKey{QuotaKindService, "opbeans-go"}]
+	secretToken := quotas.quotas[quotaKey{QuotaKindSecretToken, "default"}]
+	eventsAccepted := service.eventsAccepted.Get()
+	eventsRejected := service.eventsRejected.Get()
+	bytesAccepted := secretToken.bytesAccepted.Get()
+
+	// Usage without matching quotas is always allowed.
+	assert.NoError(t, quotas.Allow([]Usage{{Kind: QuotaKindService, Value: "opbeans-java", Events: 1000}}))
+	assert.NoError(t, quotas.Allow([]Usage{{Kind: QuotaKindAPIKey, Value: "abc", Events: 1000}}))
+
+	assert.NoError(t, quotas.Allow([]Usage{
+		{Kind: QuotaKindService, Value: "opbeans-go", Events: 5, Bytes: 50},
+		{Kind: QuotaKindSecretToken, Value: "default", Events: 5, Bytes: 50},
+	}))
+
+	// The secret token's byte quota is exceeded, so no usage is consumed
+	// from the service's event quota.
+	err = quotas.Allow([]Usage{
+		{Kind: QuotaKindService, Value: "opbeans-go", Events: 5, Bytes: 951},
+		{Kind: QuotaKindSecretToken, Value: "default", Events: 5, Bytes: 951},
+	})
+	var quotaErr *QuotaExceededError
+	require.True(t, errors.As(err, &quotaErr))
+	assert.True(t, errors.Is(err, ErrRateLimitExceeded))
+	assert.Equal(t, QuotaKindSecretToken, quotaErr.Kind)
+	assert.Equal(t, "default", quotaErr.Value)
+	assert.InDelta(t, float64(time.Second/100), float64(quotaErr.RetryAfter), float64(10*time.Millisecond))
+	assert.EqualError(t, err, `rate limit exceeded: secret_token quota exceeded for "default"`)
+	assert.NoError(t, quotas.Allow([]Usage{{Kind: QuotaKindService, Value: "opbeans-go", Events: 5}}))
+
+	// The service's event quota is now exhausted.
+	err = quotas.Allow([]Usage{{Kind: QuotaKindService, Value: "opbeans-go", Events: 1}})
+	require.True(t, errors.As(err, &quotaErr))
+	assert.Equal(t, QuotaKindService, quotaErr.Kind)
+
+	assert.Equal(t, eventsAccepted+10, service.eventsAccepted.Get())
+	assert.Equal(t, eventsRejected+1, service.eventsRejected.Get())
+	assert.Equal(t, bytesAccepted+50, secretToken.bytesAccepted.Get())
+}
+
+func TestQuotasWildcard(t *testing.T) {
+	quotas, err := NewQuotas([]Quota{
+		{Kind: QuotaKindAPIKey, Value: QuotaWildcard, EventsPerSecond: 1},
+		{Kind: QuotaKindAPIKey, Value: "unlimited", BytesPerSecond: 1e9},
+	}, 1, 2)
+	require.NoError(t, err)
+	assert.True(t, quotas.MeasureBytes())
+
+	// Each API Key has its own rate limiter. Usage exceeding the burst
+	// consumes the entire burst.
+	assert.NoError(t, quotas.Allow([]Usage{{Kind: QuotaKindAPIKey, Value: "a", Events: 5}}))
+	assert.NoError(t, quotas.Allow([]Usage{{Kind: QuotaKindAPIKey, Value: "b", Events: 1}}))
+	assert.Error(t, quotas.Allow([]Usage{{Kind: QuotaKindAPIKey, Value: "a", Events: 1}}))
+
+	// API Keys with their own quota are not subject to the wildcard quota.
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, quotas.Allow([]Usage{{Kind: QuotaKindAPIKey, Value: "unlimited", Events: 1}}))
+	}
+
+	// Evicted rate limiters are reused.
+	assert.Error(t, quotas.Allow([]Usage{{Kind: QuotaKindAPIKey, Value: "c", Events: 1}}))
+}
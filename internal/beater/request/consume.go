@@ -0,0 +1,55 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package request
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/publish"
+)
+
+// ConsumeErrorResultID returns the ResultID for an error returned
+// when processing a batch of events decoded from a request.
+func ConsumeErrorResultID(err error) ResultID {
+	switch {
+	case errors.Is(err, ratelimit.ErrRateLimitExceeded):
+		return IDResponseErrorsRateLimit
+	case errors.Is(err, auth.ErrUnauthorized):
+		return IDResponseErrorsForbidden
+	case errors.Is(err, publish.ErrFull):
+		return IDResponseErrorsFullQueue
+	case errors.Is(err, publish.ErrChannelClosed):
+		return IDResponseErrorsShuttingDown
+	}
+	return IDResponseErrorsInternal
+}
+
+// SetConsumeError sets c.Result according to an error returned when
+// processing a batch of events decoded from the request. If a quota
+// was exceeded, the Retry-After response header is set.
+func (c *Context) SetConsumeError(err error) {
+	var quotaErr *ratelimit.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		c.ResponseWriter.Header().Set(headers.RetryAfter, strconv.Itoa(quotaErr.RetryAfterSeconds()))
+	}
+	c.Result.SetWithError(ConsumeErrorResultID(err), err)
+}
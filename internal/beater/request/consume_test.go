@@ -0,0 +1,66 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/publish"
+)
+
+func TestConsumeErrorResultID(t *testing.T) {
+	for err, expected := range map[error]ResultID{
+		ratelimit.ErrRateLimitExceeded:                  IDResponseErrorsRateLimit,
+		&ratelimit.QuotaExceededError{}:                 IDResponseErrorsRateLimit,
+		fmt.Errorf("wrapped: %w", auth.ErrUnauthorized): IDResponseErrorsForbidden,
+		publish.ErrFull:                                 IDResponseErrorsFullQueue,
+		publish.ErrChannelClosed:                        IDResponseErrorsShuttingDown,
+		errors.New("boom"):                              IDResponseErrorsInternal,
+	} {
+		assert.Equal(t, expected, ConsumeErrorResultID(err), err.Error())
+	}
+}
+
+func TestContextSetConsumeError(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := NewContext()
+	c.Reset(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	c.SetConsumeError(&ratelimit.QuotaExceededError{
+		Kind:       ratelimit.QuotaKindService,
+		Value:      "opbeans",
+		RetryAfter: 1500 * time.Millisecond,
+	})
+	assert.Equal(t, IDResponseErrorsRateLimit, c.Result.ID)
+	assert.Equal(t, http.StatusTooManyRequests, c.Result.StatusCode)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	c.Reset(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	c.SetConsumeError(publish.ErrFull)
+	assert.Equal(t, IDResponseErrorsFullQueue, c.Result.ID)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+}
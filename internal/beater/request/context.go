@@ -20,6 +20,7 @@ package request
 import (
 	"compress/gzip"
 	"compress/zlib"
+	"crypto/x509"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -57,6 +58,10 @@ type Context struct {
 	gzipReader                  *gzip.Reader
 	zlibReader                  zlibReadCloseResetter
 
+	// trustedProxies holds the proxies which are trusted to report the
+	// originating client address in Forwarded, X-Forwarded-For, etc.
+	trustedProxies netutil.TrustedProxies
+
 	Request        *http.Request
 	Logger         *logp.Logger
 	Authentication auth.AuthenticationDetails
@@ -93,15 +98,25 @@ type Context struct {
 	// UserAgent holds the User-Agent request header value.
 	UserAgent string
 
+	// ClientCertificate holds the verified TLS client certificate of the
+	// network peer, or nil if the peer did not present a certificate or
+	// TLS is not enabled.
+	ClientCertificate *x509.Certificate
+
 	// ResponseWriter is exported to enable passing Context to OTLP handlers
 	// An alternate solution would be to implement context.WriteHeaders()
 	ResponseWriter http.ResponseWriter
 	writeAttempts  int
 }
 
-// NewContext creates an empty Context struct
+// NewContext creates an empty Context struct, which trusts any network
+// peer to report the originating client address.
 func NewContext() *Context {
-	return &Context{}
+	return newContext(netutil.TrustAllProxies)
+}
+
+func newContext(trustedProxies netutil.TrustedProxies) *Context {
+	return &Context{trustedProxies: trustedProxies}
 }
 
 // Reset allows to reuse a context by removing all request specific information.
@@ -135,6 +150,8 @@ func (c *Context) Reset(w http.ResponseWriter, r *http.Request) {
 		// Reuse gzip and zlib reader buffers.
 		gzipReader: c.gzipReader,
 		zlibReader: c.zlibReader,
+
+		trustedProxies: c.trustedProxies,
 	}
 	c.Result.Reset()
 
@@ -147,11 +164,14 @@ func (c *Context) setRequest(r *http.Request) {
 	c.Timestamp = time.Now()
 	c.Request = r
 	c.UserAgent = strings.Join(r.Header["User-Agent"], ", ")
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		c.ClientCertificate = r.TLS.VerifiedChains[0][0]
+	}
 
 	ip, port := netutil.SplitAddrPort(r.RemoteAddr)
 	c.SourceIP, c.ClientIP = ip, ip
 	c.SourcePort, c.ClientPort = int(port), int(port)
-	if ip, port := netutil.ClientAddrFromHeaders(r.Header); ip.IsValid() {
+	if ip, port := netutil.ClientAddrFromHeaders(r.Header, ip, c.trustedProxies); ip.IsValid() {
 		c.SourceNATIP = c.ClientIP
 		c.SourceIP, c.ClientIP = ip, ip
 		c.SourcePort, c.ClientPort = int(port), int(port)
@@ -257,6 +277,10 @@ func (c *Context) decodeRequestBody() error {
 		reader, err = c.resetZlib(c.Request.Body)
 	case "gzip":
 		reader, err = c.resetGzip(c.Request.Body)
+	case "snappy":
+		// Snappy-compressed bodies, such as those of Prometheus remote
+		// write requests, are decompressed by the request handler.
+		return nil
 	default:
 		// Sniff encoding from payload by looking at the first two bytes.
 		// This produces much less garbage than opportunistically calling
@@ -20,6 +20,8 @@ package request
 import (
 	"net/http"
 	"sync"
+
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 // ContextPool provides a pool of Context objects, and a
@@ -29,10 +31,13 @@ type ContextPool struct {
 }
 
 // NewContextPool returns a new ContextPool.
-func NewContextPool() *ContextPool {
+//
+// The originating client address will be taken from Forwarded,
+// X-Forwarded-For, etc. only if the network peer is in trustedProxies.
+func NewContextPool(trustedProxies netutil.TrustedProxies) *ContextPool {
 	pool := ContextPool{}
 	pool.p.New = func() interface{} {
-		return NewContext()
+		return newContext(trustedProxies)
 	}
 	return &pool
 }
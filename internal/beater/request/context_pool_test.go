@@ -24,6 +24,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 func TestContextPool(t *testing.T) {
@@ -31,7 +33,7 @@ func TestContextPool(t *testing.T) {
 	// Request stored inside a context is always set fresh.
 	// The test is important to avoid mixing up separate requests in a reused context.
 
-	p := NewContextPool()
+	p := NewContextPool(netutil.TrustAllProxies)
 
 	// mockhHandler adds the context and its request to dedicated slices
 	var contexts, requests []interface{}
@@ -21,7 +21,15 @@ import (
 	"bytes"
 	"compress/gzip"
 	"compress/zlib"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"io"
+	"math/big"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -40,6 +48,7 @@ import (
 
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 func TestContext_Reset(t *testing.T) {
@@ -78,7 +87,8 @@ func TestContext_Reset(t *testing.T) {
 	before := time.Now()
 	c := Context{
 		Request: r1, ResponseWriter: w1,
-		Logger: logp.NewLogger(""),
+		Logger:         logp.NewLogger(""),
+		trustedProxies: netutil.TrustAllProxies,
 		Result: Result{
 			StatusCode: http.StatusServiceUnavailable,
 			Err:        errors.New("foo"),
@@ -127,6 +137,8 @@ func TestContext_Reset(t *testing.T) {
 			assert.Nil(t, c.zlibReader)
 		case "gzipReader":
 			assert.Nil(t, c.gzipReader)
+		case "trustedProxies":
+			assert.Equal(t, netutil.TrustAllProxies, c.trustedProxies)
 		default:
 			assert.Empty(t, cVal.Field(i).Interface(), cType.Field(i).Name)
 		}
@@ -199,6 +211,7 @@ func BenchmarkContextReset(b *testing.B) {
 					Request:        r,
 					ResponseWriter: w,
 					Logger:         l,
+					trustedProxies: netutil.TrustAllProxies,
 					Result: Result{
 						StatusCode: http.StatusOK,
 						Err:        err,
@@ -212,6 +225,104 @@ func BenchmarkContextReset(b *testing.B) {
 	}
 }
 
+func TestContextResetTrustedProxies(t *testing.T) {
+	trustedProxies, err := netutil.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	for name, test := range map[string]struct {
+		remoteAddr string
+		clientIP   string
+	}{
+		"trusted":   {remoteAddr: "10.1.2.3:1234", clientIP: "192.168.0.2"},
+		"untrusted": {remoteAddr: "172.16.1.2:1234", clientIP: "172.16.1.2"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			r.Header.Set("X-Forwarded-For", "192.168.0.1, 192.168.0.2, 10.0.0.1")
+
+			c := newContext(trustedProxies)
+			c.Reset(httptest.NewRecorder(), r)
+			assert.Equal(t, netip.MustParseAddr(test.clientIP), c.ClientIP)
+			assert.Equal(t, netip.MustParseAddr(test.clientIP), c.SourceIP)
+
+			// trustedProxies must be retained across resets.
+			c.Reset(nil, nil)
+			assert.Equal(t, trustedProxies, c.trustedProxies)
+		})
+	}
+}
+
+func TestContextResetClientCertificate(t *testing.T) {
+	caCert, caKey := newTestCertificate(t, "ca", nil, nil)
+	clientCert, clientKey := newTestCertificate(t, "opbeans", caCert, caKey)
+	otherCert, otherKey := newTestCertificate(t, "opbeans", nil, nil) // self-signed
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+
+	var clientCertificates []*x509.Certificate
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := NewContext()
+		c.Reset(w, r)
+		clientCertificates = append(clientCertificates, c.ClientCertificate)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cert *tls.Certificate) error {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	require.NoError(t, get(&tls.Certificate{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}))
+	require.NoError(t, get(nil))
+	// Certificates not signed by a trusted CA fail verification,
+	// and never reach the handler.
+	require.Error(t, get(&tls.Certificate{Certificate: [][]byte{otherCert.Raw}, PrivateKey: otherKey}))
+
+	require.Len(t, clientCertificates, 2)
+	require.NotNil(t, clientCertificates[0])
+	assert.Equal(t, clientCert.Raw, clientCertificates[0].Raw)
+	assert.Equal(t, "CN=opbeans", clientCertificates[0].Subject.String())
+	assert.Nil(t, clientCertificates[1])
+}
+
+// newTestCertificate returns a certificate for commonName, signed by parent
+// and parentKey, or self-signed CA certificate if parent is nil.
+func newTestCertificate(t testing.TB, commonName string, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
 func TestContextResetContentEncoding(t *testing.T) {
 	test := func(
 		name string,
@@ -249,6 +360,9 @@ func TestContextResetContentEncoding(t *testing.T) {
 	test("gzip_sniff", "", bytes.NewReader(gzipCompressed), "contents", "gzip")
 	test("deflate", "deflate", bytes.NewReader(deflateCompressed), "contents", "deflate")
 	test("deflate_sniff", "", bytes.NewReader(deflateCompressed), "contents", "deflate")
+	// Snappy-compressed bodies are left to handlers, and must not be
+	// mistaken for deflate by sniffing.
+	test("snappy", "snappy", strings.NewReader("\x78snappy"), "\x78snappy", "snappy")
 }
 
 func BenchmarkContextResetContentEncoding(b *testing.B) {
@@ -64,6 +64,8 @@ const (
 	IDResponseErrorsDecode ResultID = "response.errors.decode"
 	// IDResponseErrorsValidate identifies responses for invalid requests
 	IDResponseErrorsValidate ResultID = "response.errors.validate"
+	// IDResponseErrorsUnsupportedMediaType identifies responses for requests with an unsupported content type
+	IDResponseErrorsUnsupportedMediaType ResultID = "response.errors.unsupportedmediatype"
 	// IDResponseErrorsRateLimit identifies responses for rate limited requests
 	IDResponseErrorsRateLimit ResultID = "response.errors.ratelimit"
 	// IDResponseErrorsTimeout identifies responses for timed out requests
@@ -83,23 +85,24 @@ const (
 var (
 	// MapResultIDToStatus takes a ResultID and maps it to a status
 	MapResultIDToStatus = map[ResultID]Status{
-		IDResponseValidOK:                  {Code: http.StatusOK, Keyword: "request ok"},
-		IDResponseValidAccepted:            {Code: http.StatusAccepted, Keyword: "request accepted"},
-		IDResponseValidNotModified:         {Code: http.StatusNotModified, Keyword: "not modified"},
-		IDResponseErrorsForbidden:          {Code: http.StatusForbidden, Keyword: "forbidden request"},
-		IDResponseErrorsUnauthorized:       {Code: http.StatusUnauthorized, Keyword: "unauthorized"},
-		IDResponseErrorsNotFound:           {Code: http.StatusNotFound, Keyword: "404 page not found"},
-		IDResponseErrorsRequestTooLarge:    {Code: http.StatusRequestEntityTooLarge, Keyword: "request body too large"},
-		IDResponseErrorsInvalidQuery:       {Code: http.StatusBadRequest, Keyword: "invalid query"},
-		IDResponseErrorsDecode:             {Code: http.StatusBadRequest, Keyword: "data decoding error"},
-		IDResponseErrorsValidate:           {Code: http.StatusBadRequest, Keyword: "data validation error"},
-		IDResponseErrorsMethodNotAllowed:   {Code: http.StatusMethodNotAllowed, Keyword: "method not supported"},
-		IDResponseErrorsRateLimit:          {Code: http.StatusTooManyRequests, Keyword: "too many requests"},
-		IDResponseErrorsTimeout:            {Code: http.StatusServiceUnavailable, Keyword: "request timed out"},
-		IDResponseErrorsFullQueue:          {Code: http.StatusServiceUnavailable, Keyword: "queue is full"},
-		IDResponseErrorsShuttingDown:       {Code: http.StatusServiceUnavailable, Keyword: "server is shutting down"},
-		IDResponseErrorsServiceUnavailable: {Code: http.StatusServiceUnavailable, Keyword: "service unavailable"},
-		IDResponseErrorsInternal:           {Code: http.StatusInternalServerError, Keyword: "internal error"},
+		IDResponseValidOK:                    {Code: http.StatusOK, Keyword: "request ok"},
+		IDResponseValidAccepted:              {Code: http.StatusAccepted, Keyword: "request accepted"},
+		IDResponseValidNotModified:           {Code: http.StatusNotModified, Keyword: "not modified"},
+		IDResponseErrorsForbidden:            {Code: http.StatusForbidden, Keyword: "forbidden request"},
+		IDResponseErrorsUnauthorized:         {Code: http.StatusUnauthorized, Keyword: "unauthorized"},
+		IDResponseErrorsNotFound:             {Code: http.StatusNotFound, Keyword: "404 page not found"},
+		IDResponseErrorsRequestTooLarge:      {Code: http.StatusRequestEntityTooLarge, Keyword: "request body too large"},
+		IDResponseErrorsInvalidQuery:         {Code: http.StatusBadRequest, Keyword: "invalid query"},
+		IDResponseErrorsDecode:               {Code: http.StatusBadRequest, Keyword: "data decoding error"},
+		IDResponseErrorsValidate:             {Code: http.StatusBadRequest, Keyword: "data validation error"},
+		IDResponseErrorsUnsupportedMediaType: {Code: http.StatusUnsupportedMediaType, Keyword: "unsupported media type"},
+		IDResponseErrorsMethodNotAllowed:     {Code: http.StatusMethodNotAllowed, Keyword: "method not supported"},
+		IDResponseErrorsRateLimit:            {Code: http.StatusTooManyRequests, Keyword: "too many requests"},
+		IDResponseErrorsTimeout:              {Code: http.StatusServiceUnavailable, Keyword: "request timed out"},
+		IDResponseErrorsFullQueue:            {Code: http.StatusServiceUnavailable, Keyword: "queue is full"},
+		IDResponseErrorsShuttingDown:         {Code: http.StatusServiceUnavailable, Keyword: "server is shutting down"},
+		IDResponseErrorsServiceUnavailable:   {Code: http.StatusServiceUnavailable, Keyword: "service unavailable"},
+		IDResponseErrorsInternal:             {Code: http.StatusInternalServerError, Keyword: "internal error"},
 	}
 
 	// DefaultResultIDs is a list of the default result IDs used by the package.
@@ -36,6 +36,7 @@ import (
 	"github.com/elastic/apm-data/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/agentcfg"
 	"github.com/elastic/apm-server/internal/beater/api"
+	"github.com/elastic/apm-server/internal/beater/api/admin"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/jaeger"
@@ -43,6 +44,7 @@ import (
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/elasticsearch"
 	"github.com/elastic/apm-server/internal/kibana"
+	"github.com/elastic/apm-server/internal/r8"
 	"github.com/elastic/apm-server/internal/sourcemap"
 )
 
@@ -98,6 +100,18 @@ type ServerParams struct {
 	// mapping is disabled.
 	SourcemapFetcher sourcemap.Fetcher
 
+	// SourcemapUploader holds a sourcemap.Uploader, or nil if source
+	// mapping is disabled.
+	SourcemapUploader sourcemap.Uploader
+
+	// R8Uploader holds an r8.Uploader, or nil if deobfuscation
+	// is disabled.
+	R8Uploader r8.Uploader
+
+	// TailSampler holds an admin.TailSampler for the tail-sampling
+	// admin API, or nil if tail-based sampling is disabled.
+	TailSampler admin.TailSampler
+
 	// AgentConfig holds an interface for fetching agent configuration.
 	AgentConfig agentcfg.Fetcher
 
@@ -170,7 +184,7 @@ func newServer(args ServerParams, listener net.Listener) (server, error) {
 	router, err := api.NewMux(
 		args.Config, args.BatchProcessor,
 		args.Authenticator, args.AgentConfig, args.RateLimitStore,
-		args.SourcemapFetcher, publishReady,
+		args.SourcemapFetcher, args.SourcemapUploader, args.R8Uploader, args.TailSampler, publishReady,
 	)
 	if err != nil {
 		return server{}, err
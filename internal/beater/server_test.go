@@ -19,10 +19,20 @@ package beater_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -44,6 +54,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
@@ -305,31 +316,168 @@ func TestServerOTLPGRPC(t *testing.T) {
 
 	baseURL, err := url.Parse(srv.URL)
 	require.NoError(t, err)
-	invokeExport := func(ctx context.Context, conn *grpc.ClientConn) error {
-		// We can't use go.opentelemetry.io/otel, as it has its own generated protobuf packages
-		// which which conflict with opentelemetry-collector's. Instead, use the types registered
-		// by the opentelemetry-collector packages.
-		requestType := proto.MessageType("opentelemetry.proto.collector.trace.v1.ExportTraceServiceRequest")
-		responseType := proto.MessageType("opentelemetry.proto.collector.trace.v1.ExportTraceServiceResponse")
-		request := reflect.New(requestType.Elem()).Interface()
-		response := reflect.New(responseType.Elem()).Interface()
-		return conn.Invoke(ctx, "/opentelemetry.proto.collector.trace.v1.TraceService/Export", request, response)
-	}
-
 	conn, err := grpc.Dial(baseURL.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	require.NoError(t, err)
 	defer conn.Close()
 
 	ctx := context.Background()
-	err = invokeExport(ctx, conn)
+	err = invokeOTLPTraceExport(ctx, conn)
 	assert.Error(t, err)
 	assert.Equal(t, codes.Unauthenticated, status.Code(err))
 
 	ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("Authorization", "Bearer abc123"))
-	err = invokeExport(ctx, conn)
+	err = invokeOTLPTraceExport(ctx, conn)
 	assert.NoError(t, err)
 }
 
+func invokeOTLPTraceExport(ctx context.Context, conn *grpc.ClientConn) error {
+	// We can't use go.opentelemetry.io/otel, as it has its own generated protobuf packages
+	// which which conflict with opentelemetry-collector's. Instead, use the types registered
+	// by the opentelemetry-collector packages.
+	requestType := proto.MessageType("opentelemetry.proto.collector.trace.v1.ExportTraceServiceRequest")
+	responseType := proto.MessageType("opentelemetry.proto.collector.trace.v1.ExportTraceServiceResponse")
+	request := reflect.New(requestType.Elem()).Interface()
+	response := reflect.New(responseType.Elem()).Interface()
+	return conn.Invoke(ctx, "/opentelemetry.proto.collector.trace.v1.TraceService/Export", request, response)
+}
+
+func TestServerClientCertificateAuth(t *testing.T) {
+	certs := newTestCertificates(t)
+	srv := beatertest.NewServer(t, beatertest.WithConfig(agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"apm-server.ssl": map[string]interface{}{
+			"enabled":                 true,
+			"certificate":             certs.serverCertFile,
+			"key":                     certs.serverKeyFile,
+			"certificate_authorities": []string{certs.caFile},
+			"client_authentication":   "optional",
+		},
+		"apm-server.auth.client_certificate": map[string]interface{}{
+			"enabled": true,
+			"rules":   []map[string]interface{}{{"subject": "CN=opbeans"}},
+		},
+	})))
+	baseURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	// clientTLSConfig returns a TLS config which presents the client
+	// certificate for commonName, or no certificate if commonName is empty.
+	clientTLSConfig := func(commonName string) *tls.Config {
+		var cert tls.Certificate
+		if commonName != "" {
+			cert = certs.newClientCertificate(t, commonName)
+		}
+		return &tls.Config{
+			RootCAs: certs.caPool,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &cert, nil
+			},
+		}
+	}
+
+	t.Run("http", func(t *testing.T) {
+		for commonName, expectedStatus := range map[string]int{
+			"opbeans": http.StatusAccepted,
+			"other":   http.StatusUnauthorized,
+			"":        http.StatusUnauthorized,
+		} {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig(commonName)}}
+			resp, err := client.Do(makeTransactionRequest(t, "https://"+baseURL.Host))
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, expectedStatus, resp.StatusCode, commonName)
+		}
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		for commonName, expectedCode := range map[string]codes.Code{
+			"opbeans": codes.OK,
+			"other":   codes.Unauthenticated,
+			"":        codes.Unauthenticated,
+		} {
+			conn, err := grpc.Dial(baseURL.Host, grpc.WithTransportCredentials(
+				credentials.NewTLS(clientTLSConfig(commonName)),
+			))
+			require.NoError(t, err)
+			err = invokeOTLPTraceExport(context.Background(), conn)
+			conn.Close()
+			assert.Equal(t, expectedCode, status.Code(err), commonName)
+		}
+	})
+}
+
+// testCertificates holds a CA certificate, and a server certificate signed
+// by the CA, written to files for use in APM Server configuration.
+type testCertificates struct {
+	caFile         string
+	serverCertFile string
+	serverKeyFile  string
+	caPool         *x509.CertPool
+	caCert         *x509.Certificate
+	caKey          crypto.Signer
+}
+
+func newTestCertificates(t testing.TB) *testCertificates {
+	dir := t.TempDir()
+	certs := &testCertificates{
+		caFile:         filepath.Join(dir, "ca.pem"),
+		serverCertFile: filepath.Join(dir, "server.pem"),
+		serverKeyFile:  filepath.Join(dir, "server-key.pem"),
+		caPool:         x509.NewCertPool(),
+	}
+	certs.caCert, certs.caKey = newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	certs.caPool.AddCert(certs.caCert)
+	serverCert, serverKey := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "apm-server"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, certs.caCert, certs.caKey)
+
+	serverKeyDER, err := x509.MarshalPKCS8PrivateKey(serverKey)
+	require.NoError(t, err)
+	for file, block := range map[string]*pem.Block{
+		certs.caFile:         {Type: "CERTIFICATE", Bytes: certs.caCert.Raw},
+		certs.serverCertFile: {Type: "CERTIFICATE", Bytes: serverCert.Raw},
+		certs.serverKeyFile:  {Type: "PRIVATE KEY", Bytes: serverKeyDER},
+	} {
+		require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(block), 0600))
+	}
+	return certs
+}
+
+// newClientCertificate returns a client certificate for commonName, signed by the CA.
+func (c *testCertificates) newClientCertificate(t testing.TB, commonName string) tls.Certificate {
+	cert, key := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, c.caCert, c.caKey)
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+// newTestCertificate creates a certificate from template, signed by parent
+// and parentKey, or self-signed if parent is nil.
+func newTestCertificate(t testing.TB, template, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage |= x509.KeyUsageDigitalSignature
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
 func TestServerWaitForIntegrationKibana(t *testing.T) {
 	var requests int64
 	requestCh := make(chan struct{})
@@ -702,6 +850,81 @@ func TestServerElasticsearchOutput(t *testing.T) {
 	}, snapshot)
 }
 
+func TestServerElasticsearchOutputGeoIP(t *testing.T) {
+	bulkCh := make(chan []byte, 1)
+	elasticsearchServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.URL.Path != "/_bulk" {
+			fmt.Fprintln(w, `{"version":{"number":"1.2.3"}}`)
+			return
+		}
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gzr, err := gzip.NewReader(body)
+			require.NoError(t, err)
+			body = gzr
+		}
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+		select {
+		case bulkCh <- data:
+		default:
+		}
+		fmt.Fprintln(w, `{"items":[]}`)
+	}))
+	defer elasticsearchServer.Close()
+
+	database, err := filepath.Abs("../../testing/docker/elasticsearch/ingest-geoip/GeoLite2-City.mmdb")
+	require.NoError(t, err)
+	srv := beatertest.NewServer(t, beatertest.WithConfig(agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"apm-server.rum": map[string]interface{}{
+			"enabled":                true,
+			"source_mapping.enabled": false,
+			"geoip.database":         database,
+		},
+		"output.elasticsearch": map[string]interface{}{
+			"hosts":          []string{elasticsearchServer.URL},
+			"flush_interval": "1ms",
+		},
+	})))
+
+	payload := `{"metadata":{"service":{"name":"rum","agent":{"name":"rum-js","version":"1.0.0"}}}}
+{"error":{"id":"cafebabe","log":{"message":"boom"}}}
+`
+	req, err := http.NewRequest(http.MethodPost, srv.URL+api.IntakeRUMPath, strings.NewReader(payload))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("X-Forwarded-For", "89.160.20.128")
+	resp, err := srv.Client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode, body(t, resp))
+
+	var bulk []byte
+	select {
+	case bulk = <-bulkCh:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for bulk request")
+	}
+	lines := bytes.Split(bytes.TrimSpace(bulk), []byte("\n"))
+	require.Len(t, lines, 2)
+	var doc struct {
+		Client map[string]interface{} `json:"client"`
+	}
+	require.NoError(t, json.Unmarshal(lines[1], &doc))
+	assert.Equal(t, map[string]interface{}{
+		"ip": "89.160.20.128",
+		"geo": map[string]interface{}{
+			"city_name":        "Linköping",
+			"continent_name":   "Europe",
+			"country_iso_code": "SE",
+			"country_name":     "Sweden",
+			"location":         map[string]interface{}{"lat": 58.4167, "lon": 15.6167},
+			"region_iso_code":  "SE-E",
+			"region_name":      "Östergötland County",
+		},
+	}, doc.Client)
+}
+
 func TestServerPProf(t *testing.T) {
 	srv := beatertest.NewServer(t, beatertest.WithConfig(agentconfig.MustNewConfigFrom(`{"apm-server.pprof.enabled": true}`)))
 	for _, path := range []string{
@@ -48,6 +48,9 @@ func newTracerServer(cfg *config.Config, listener net.Listener, logger *logp.Log
 		agentConfigFetcher,
 		ratelimitStore,
 		nil,                         // no sourcemap store
+		nil,                         // no sourcemap uploads
+		nil,                         // no mapping file uploads
+		nil,                         // no tail-sampling admin
 		func() bool { return true }, // ready for publishing
 	)
 	if err != nil {
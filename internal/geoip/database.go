@@ -0,0 +1,111 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// Database is a MaxMind database (mmdb) file, which is reloaded
+// by Run whenever the file is modified.
+//
+// The database is read fully into memory, rather than memory-mapped,
+// so that lookups may continue to use a previously loaded database
+// while a new one is being loaded.
+type Database struct {
+	path   string
+	logger *logp.Logger
+	reader atomic.Pointer[maxminddb.Reader]
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// OpenDatabase opens the MaxMind database file at path.
+func OpenDatabase(path string, logger *logp.Logger) (*Database, error) {
+	db := &Database{path: path, logger: logger}
+	if _, err := db.reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Run periodically checks whether the database file has been modified,
+// reloading it if so, until ctx is cancelled. If reloading fails, the
+// previously loaded database continues to be used.
+func (db *Database) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		reloaded, err := db.reload()
+		if err != nil {
+			db.logger.Errorf("failed to reload GeoIP database %q: %s", db.path, err)
+		} else if reloaded {
+			db.logger.Infof("reloaded GeoIP database %q", db.path)
+		}
+	}
+}
+
+// reload loads the database file if it has been modified since it was
+// last loaded, and reports whether it was loaded.
+func (db *Database) reload() (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat GeoIP database: %w", err)
+	}
+	if db.reader.Load() != nil && info.ModTime().Equal(db.modTime) && info.Size() == db.size {
+		return false, nil
+	}
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to load GeoIP database %q: %w", db.path, err)
+	}
+	db.reader.Store(reader)
+	db.modTime = info.ModTime()
+	db.size = info.Size()
+	return true, nil
+}
+
+// lookup looks up ip in the database, decoding the record into result,
+// and reports whether a record was found.
+func (db *Database) lookup(ip net.IP, result interface{}) bool {
+	_, ok, err := db.reader.Load().LookupNetwork(ip, result)
+	return ok && err == nil
+}
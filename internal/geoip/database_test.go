@@ -0,0 +1,98 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package geoip_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/fastjson"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/geoip"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+func TestOpenDatabaseErrors(t *testing.T) {
+	_, err := geoip.OpenDatabase(filepath.Join(t.TempDir(), "missing.mmdb"), logp.NewLogger(""))
+	assert.ErrorContains(t, err, "failed to stat GeoIP database")
+
+	path := filepath.Join(t.TempDir(), "invalid.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("invalid"), 0644))
+	_, err = geoip.OpenDatabase(path, logp.NewLogger(""))
+	assert.ErrorContains(t, err, "failed to load GeoIP database")
+}
+
+func TestDatabaseReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2.mmdb")
+	copyFile(t, filepath.Join(testdataDir, "GeoLite2-Country.mmdb"), path)
+	db, err := geoip.OpenDatabase(path, logp.NewLogger(""))
+	require.NoError(t, err)
+	enricher := geoip.NewEnricher(geoip.Databases{}, geoip.Databases{City: db})
+
+	cityName := func() string {
+		event := model.APMEvent{Client: model.Client{IP: netip.MustParseAddr("89.160.20.128")}}
+		var w fastjson.Writer
+		require.NoError(t, event.MarshalFastJSON(&w))
+		enricher.EnrichJSON(&w, &event)
+		var doc struct {
+			Client struct {
+				Geo struct {
+					CityName string `json:"city_name"`
+				} `json:"geo"`
+			} `json:"client"`
+		}
+		require.NoError(t, json.Unmarshal(w.Bytes(), &doc))
+		return doc.Client.Geo.CityName
+	}
+	assert.Equal(t, "", cityName())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- db.Run(ctx, 10*time.Millisecond) }()
+
+	// Replacing the database with an invalid file should not
+	// affect lookups, as the previous database continues to be used.
+	require.NoError(t, os.WriteFile(path+".tmp", []byte("invalid"), 0644))
+	require.NoError(t, os.Rename(path+".tmp", path))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "", cityName())
+
+	copyFile(t, filepath.Join(testdataDir, "GeoLite2-City.mmdb"), path+".tmp")
+	require.NoError(t, os.Rename(path+".tmp", path))
+	assert.Eventually(t, func() bool {
+		return cityName() == "Linköping"
+	}, 10*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func copyFile(t testing.TB, from, to string) {
+	data, err := os.ReadFile(from)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(to, data, 0644))
+}
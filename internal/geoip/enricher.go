@@ -0,0 +1,418 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package geoip provides enrichment of events with the geographical
+// location and autonomous system of client.ip, using local MaxMind
+// databases.
+package geoip
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+
+	"go.elastic.co/fastjson"
+
+	"github.com/elastic/apm-data/model"
+)
+
+// Databases holds the databases used for enriching a class of events.
+type Databases struct {
+	// City holds an optional GeoIP2 or GeoLite2 City or Country database,
+	// used for setting client.geo.*.
+	City *Database
+
+	// ASN holds an optional GeoIP2 ISP or GeoLite2 ASN database,
+	// used for setting source.as.*.
+	ASN *Database
+}
+
+// Enricher adds client.geo.* and source.as.* fields to JSON-encoded events,
+// by looking up their client.ip in local MaxMind databases.
+//
+// Events from RUM agents are enriched using the RUM databases, and all other
+// events are enriched using the backend databases.
+type Enricher struct {
+	rum     Databases
+	backend Databases
+	pool    sync.Pool
+}
+
+// NewEnricher returns a new Enricher using the given databases.
+func NewEnricher(rum, backend Databases) *Enricher {
+	return &Enricher{rum: rum, backend: backend}
+}
+
+// EnrichJSON adds client.geo.* and source.as.* fields to the JSON object
+// encoding of event in w, if the event's client.ip is found in the databases.
+//
+// The fields are inserted into the existing encoding, rather than
+// re-encoding the event, as model.APMEvent has no fields for them.
+// If w does not hold a JSON object, it is left unchanged.
+func (e *Enricher) EnrichJSON(w *fastjson.Writer, event *model.APMEvent) {
+	if !event.Client.IP.IsValid() {
+		return
+	}
+	dbs := &e.backend
+	if isRUMAgent(event.Agent.Name) {
+		dbs = &e.rum
+	}
+	if dbs.City == nil && dbs.ASN == nil {
+		return
+	}
+
+	ip := net.IP(event.Client.IP.Unmap().AsSlice())
+	var city cityRecord
+	var asn asnRecord
+	hasGeo := dbs.City != nil && dbs.City.lookup(ip, &city) && !city.isEmpty()
+	hasAS := dbs.ASN != nil && dbs.ASN.lookup(ip, &asn) && asn.Number != 0
+	if !hasGeo && !hasAS {
+		return
+	}
+
+	buf, _ := e.pool.Get().(*[]byte)
+	if buf == nil {
+		buf = new([]byte)
+	}
+	defer e.pool.Put(buf)
+	doc := append((*buf)[:0], w.Bytes()...)
+	*buf = doc
+	w.Reset()
+
+	// Insert the fields into the existing client and source objects,
+	// or add the objects to the end of the document if they don't exist.
+	var inserts [2]insert
+	n := 0
+	var err error
+	if hasGeo {
+		inserts[n], err = newInsert(doc, "client", city.writeGeo)
+		n++
+	}
+	if hasAS && err == nil {
+		inserts[n], err = newInsert(doc, "source", asn.writeAS)
+		n++
+	}
+	if err != nil {
+		w.RawBytes(doc)
+		return
+	}
+	if n == 2 {
+		if inserts[1].offset < inserts[0].offset {
+			inserts[0], inserts[1] = inserts[1], inserts[0]
+		} else if inserts[1].offset == inserts[0].offset {
+			// Both objects are being added to the end of
+			// the document, following one another.
+			inserts[1].empty = false
+		}
+	}
+	var pos int
+	for _, insert := range inserts[:n] {
+		w.RawBytes(doc[pos:insert.offset])
+		insert.writeTo(w)
+		pos = insert.offset
+	}
+	w.RawBytes(doc[pos:])
+}
+
+// insert describes an object member to insert into a JSON document.
+type insert struct {
+	offset int
+	key    string
+	create bool
+	empty  bool // the object (or document, if create) has no members
+	write  func(*fastjson.Writer)
+}
+
+// newInsert returns an insert for adding a member to the top-level
+// object with the given key in doc, creating the object if necessary.
+func newInsert(doc []byte, key string, write func(*fastjson.Writer)) (insert, error) {
+	member, end, err := scanObject(doc, key)
+	if err != nil {
+		return insert{}, err
+	}
+	if member < 0 {
+		return insert{offset: end, key: key, create: true, empty: isEmptyObject(doc, end), write: write}, nil
+	}
+	return insert{offset: member, key: key, empty: isEmptyObject(doc, member), write: write}, nil
+}
+
+// isEmptyObject reports whether the object closed by the brace
+// at offset end in doc has no members.
+func isEmptyObject(doc []byte, end int) bool {
+	i := end - 1
+	for i >= 0 && isSpace(doc[i]) {
+		i--
+	}
+	return i >= 0 && doc[i] == '{'
+}
+
+func (i insert) writeTo(w *fastjson.Writer) {
+	if !i.empty {
+		w.RawByte(',')
+	}
+	if !i.create {
+		i.write(w)
+		return
+	}
+	w.String(i.key)
+	w.RawString(":{")
+	i.write(w)
+	w.RawByte('}')
+}
+
+func isRUMAgent(name string) bool {
+	switch name {
+	case "rum-js", "js-base":
+		return true
+	}
+	return false
+}
+
+type names struct {
+	En string `maxminddb:"en"`
+}
+
+type cityRecord struct {
+	City struct {
+		Names names `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Continent struct {
+		Names names `maxminddb:"names"`
+	} `maxminddb:"continent"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+		Names   names  `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+		Names   names  `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+func (r *cityRecord) isEmpty() bool {
+	return r.City.Names.En == "" && r.Continent.Names.En == "" &&
+		r.Country.ISOCode == "" && r.Country.Names.En == "" &&
+		(r.Location.Latitude == nil || r.Location.Longitude == nil) &&
+		len(r.Subdivisions) == 0
+}
+
+// writeGeo writes the "geo" object member, with the same fields
+// as set by the Elasticsearch geoip ingest processor.
+func (r *cityRecord) writeGeo(w *fastjson.Writer) {
+	w.RawString(`"geo":{`)
+	first := true
+	writeString := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if !first {
+			w.RawByte(',')
+		}
+		first = false
+		w.String(key)
+		w.RawByte(':')
+		w.String(value)
+	}
+	writeString("city_name", r.City.Names.En)
+	writeString("continent_name", r.Continent.Names.En)
+	writeString("country_iso_code", r.Country.ISOCode)
+	writeString("country_name", r.Country.Names.En)
+	if r.Location.Latitude != nil && r.Location.Longitude != nil {
+		if !first {
+			w.RawByte(',')
+		}
+		first = false
+		w.RawString(`"location":{"lat":`)
+		w.Float64(*r.Location.Latitude)
+		w.RawString(`,"lon":`)
+		w.Float64(*r.Location.Longitude)
+		w.RawByte('}')
+	}
+	if len(r.Subdivisions) > 0 {
+		subdivision := r.Subdivisions[0]
+		if r.Country.ISOCode != "" && subdivision.ISOCode != "" {
+			writeString("region_iso_code", r.Country.ISOCode+"-"+subdivision.ISOCode)
+		}
+		writeString("region_name", subdivision.Names.En)
+	}
+	w.RawByte('}')
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// writeAS writes the "as" object member.
+func (r *asnRecord) writeAS(w *fastjson.Writer) {
+	w.RawString(`"as":{"number":`)
+	w.Uint64(uint64(r.Number))
+	if r.Organization != "" {
+		w.RawString(`,"organization":{"name":`)
+		w.String(r.Organization)
+		w.RawByte('}')
+	}
+	w.RawByte('}')
+}
+
+var errMalformed = errors.New("malformed JSON object")
+
+// scanObject scans the top-level JSON object in doc, returning the offset
+// of the closing brace of the object value for key, and the offset of the
+// closing brace of the top-level object. If there is no member with the key,
+// member is -1. An error is returned if doc is not a JSON object, or if the
+// member with the key is not an object.
+func scanObject(doc []byte, key string) (member, end int, err error) {
+	member = -1
+	i := skipSpace(doc, 0)
+	if i == len(doc) || doc[i] != '{' {
+		return -1, -1, errMalformed
+	}
+	i = skipSpace(doc, i+1)
+	if i < len(doc) && doc[i] == '}' {
+		return -1, i, nil
+	}
+	for {
+		keyStart := i
+		if i, err = skipString(doc, i); err != nil {
+			return -1, -1, err
+		}
+		keyEnd := i
+		i = skipSpace(doc, i)
+		if i == len(doc) || doc[i] != ':' {
+			return -1, -1, errMalformed
+		}
+		valueStart := skipSpace(doc, i+1)
+		if i, err = skipValue(doc, valueStart); err != nil {
+			return -1, -1, err
+		}
+		if stringEquals(doc[keyStart:keyEnd], key) {
+			if doc[valueStart] != '{' {
+				return -1, -1, errMalformed
+			}
+			member = i - 1
+		}
+		i = skipSpace(doc, i)
+		if i == len(doc) {
+			return -1, -1, errMalformed
+		}
+		switch doc[i] {
+		case ',':
+			i = skipSpace(doc, i+1)
+		case '}':
+			if skipSpace(doc, i+1) != len(doc) {
+				return -1, -1, errMalformed
+			}
+			return member, i, nil
+		default:
+			return -1, -1, errMalformed
+		}
+	}
+}
+
+// stringEquals reports whether the JSON string s is equal to value.
+func stringEquals(s []byte, value string) bool {
+	if bytes.IndexByte(s, '\\') < 0 {
+		return len(s) == len(value)+2 && string(s[1:len(s)-1]) == value
+	}
+	var unquoted string
+	return json.Unmarshal(s, &unquoted) == nil && unquoted == value
+}
+
+// skipValue returns the offset immediately following the JSON value
+// starting at offset i in doc.
+func skipValue(doc []byte, i int) (int, error) {
+	if i == len(doc) {
+		return -1, errMalformed
+	}
+	switch doc[i] {
+	case '"':
+		return skipString(doc, i)
+	case '{', '[':
+		var depth int
+		for i < len(doc) {
+			switch doc[i] {
+			case '"':
+				var err error
+				if i, err = skipString(doc, i); err != nil {
+					return -1, err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return i + 1, nil
+				}
+			}
+			i++
+		}
+		return -1, errMalformed
+	}
+	// Numbers, true, false, and null.
+	start := i
+	for i < len(doc) && !isSpace(doc[i]) {
+		switch doc[i] {
+		case ',', '}', ']':
+			if i == start {
+				return -1, errMalformed
+			}
+			return i, nil
+		}
+		i++
+	}
+	if i == start {
+		return -1, errMalformed
+	}
+	return i, nil
+}
+
+// skipString returns the offset immediately following the JSON string
+// starting at offset i in doc.
+func skipString(doc []byte, i int) (int, error) {
+	if i == len(doc) || doc[i] != '"' {
+		return -1, errMalformed
+	}
+	for i++; i < len(doc); i++ {
+		switch doc[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return -1, errMalformed
+}
+
+// skipSpace returns the offset of the first byte at or after
+// offset i in doc that is not insignificant whitespace.
+func skipSpace(doc []byte, i int) int {
+	for i < len(doc) && isSpace(doc[i]) {
+		i++
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
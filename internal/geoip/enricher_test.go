@@ -0,0 +1,376 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package geoip_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/fastjson"
+
+	"github.com/elastic/apm-data/input/elasticapm"
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/geoip"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+var testdataDir = filepath.Join("..", "..", "testing", "docker", "elasticsearch", "ingest-geoip")
+
+func TestEnricher(t *testing.T) {
+	city := openTestDatabase(t, "GeoLite2-City.mmdb")
+	country := openTestDatabase(t, "GeoLite2-Country.mmdb")
+	asn := openTestDatabase(t, "GeoLite2-ASN.mmdb")
+	enricher := geoip.NewEnricher(
+		geoip.Databases{City: country},
+		geoip.Databases{City: city, ASN: asn},
+	)
+
+	type m = map[string]interface{}
+	for name, test := range map[string]struct {
+		event  model.APMEvent
+		expect m
+	}{
+		"backend": {
+			event: model.APMEvent{
+				Agent:  model.Agent{Name: "go"},
+				Client: model.Client{IP: netip.MustParseAddr("89.160.20.128")},
+			},
+			expect: m{
+				"client": m{
+					"ip": "89.160.20.128",
+					"geo": m{
+						"city_name":        "Linköping",
+						"continent_name":   "Europe",
+						"country_iso_code": "SE",
+						"country_name":     "Sweden",
+						"location":         m{"lat": 58.4167, "lon": 15.6167},
+						"region_iso_code":  "SE-E",
+						"region_name":      "Östergötland County",
+					},
+				},
+				"source": m{
+					"as": m{
+						"number":       29518.0,
+						"organization": m{"name": "Bredband2 AB"},
+					},
+				},
+			},
+		},
+		"backend_existing_source": {
+			event: model.APMEvent{
+				Agent:  model.Agent{Name: "go"},
+				Client: model.Client{IP: netip.MustParseAddr("::ffff:89.160.20.128"), Port: 1234},
+				Source: model.Source{IP: netip.MustParseAddr("10.1.1.1"), Port: 4321},
+			},
+			expect: m{
+				"client": m{
+					"ip":   "::ffff:89.160.20.128",
+					"port": 1234.0,
+					"geo": m{
+						"city_name":        "Linköping",
+						"continent_name":   "Europe",
+						"country_iso_code": "SE",
+						"country_name":     "Sweden",
+						"location":         m{"lat": 58.4167, "lon": 15.6167},
+						"region_iso_code":  "SE-E",
+						"region_name":      "Östergötland County",
+					},
+				},
+				"source": m{
+					"ip":   "10.1.1.1",
+					"port": 4321.0,
+					"as": m{
+						"number":       29518.0,
+						"organization": m{"name": "Bredband2 AB"},
+					},
+				},
+			},
+		},
+		"backend_geo_only": {
+			event: model.APMEvent{
+				Agent:  model.Agent{Name: "go"},
+				Client: model.Client{IP: netip.MustParseAddr("81.2.69.142")},
+			},
+			expect: m{
+				"client": m{
+					"ip": "81.2.69.142",
+					"geo": m{
+						"city_name":        "London",
+						"continent_name":   "Europe",
+						"country_iso_code": "GB",
+						"country_name":     "United Kingdom",
+						"location":         m{"lat": 51.5142, "lon": -0.0931},
+						"region_iso_code":  "GB-ENG",
+						"region_name":      "England",
+					},
+				},
+			},
+		},
+		"rum": {
+			event: model.APMEvent{
+				Agent:  model.Agent{Name: "rum-js"},
+				Client: model.Client{IP: netip.MustParseAddr("89.160.20.128")},
+			},
+			expect: m{
+				"client": m{
+					"ip": "89.160.20.128",
+					"geo": m{
+						"continent_name":   "Europe",
+						"country_iso_code": "SE",
+						"country_name":     "Sweden",
+					},
+				},
+			},
+		},
+		"not_found": {
+			event: model.APMEvent{
+				Agent:  model.Agent{Name: "go"},
+				Client: model.Client{IP: netip.MustParseAddr("10.1.1.1")},
+			},
+			expect: m{
+				"client": m{"ip": "10.1.1.1"},
+			},
+		},
+		"no_client_ip": {
+			event: model.APMEvent{
+				Agent: model.Agent{Name: "go"},
+			},
+			expect: m{},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var w fastjson.Writer
+			require.NoError(t, test.event.MarshalFastJSON(&w))
+			enricher.EnrichJSON(&w, &test.event)
+
+			var doc map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Bytes(), &doc))
+			assert.Equal(t, test.expect["client"], doc["client"])
+			assert.Equal(t, test.expect["source"], doc["source"])
+			assert.Equal(t, m{"name": test.event.Agent.Name}, doc["agent"])
+		})
+	}
+}
+
+func TestEnricherRUMDisabled(t *testing.T) {
+	enricher := geoip.NewEnricher(
+		geoip.Databases{},
+		geoip.Databases{City: openTestDatabase(t, "GeoLite2-City.mmdb")},
+	)
+	event := model.APMEvent{
+		Agent:  model.Agent{Name: "js-base"},
+		Client: model.Client{IP: netip.MustParseAddr("89.160.20.128")},
+		Labels: model.Labels{"client": {Value: `"client":{}`}},
+	}
+	var w fastjson.Writer
+	require.NoError(t, event.MarshalFastJSON(&w))
+	before := string(w.Bytes())
+	enricher.EnrichJSON(&w, &event)
+	assert.Equal(t, before, string(w.Bytes()))
+}
+
+func TestEnricherEscapedStrings(t *testing.T) {
+	enricher := geoip.NewEnricher(
+		geoip.Databases{},
+		geoip.Databases{ASN: openTestDatabase(t, "GeoLite2-ASN.mmdb")},
+	)
+	event := model.APMEvent{
+		Agent:  model.Agent{Name: "go"},
+		Client: model.Client{IP: netip.MustParseAddr("89.160.20.128")},
+		Labels: model.Labels{
+			"source": {Value: `{"source":{"ip":"1.2.3.4"}}`},
+			"quote":  {Value: `\"`},
+		},
+		Message: `"source":{`,
+	}
+	var w fastjson.Writer
+	require.NoError(t, event.MarshalFastJSON(&w))
+	enricher.EnrichJSON(&w, &event)
+
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Bytes(), &doc))
+	assert.Equal(t, map[string]interface{}{
+		"as": map[string]interface{}{
+			"number":       29518.0,
+			"organization": map[string]interface{}{"name": "Bredband2 AB"},
+		},
+	}, doc["source"])
+	assert.Equal(t, `"source":{`, doc["message"])
+	assert.Equal(t, map[string]interface{}{
+		"source": `{"source":{"ip":"1.2.3.4"}}`,
+		"quote":  `\"`,
+	}, doc["labels"])
+}
+
+// TestEnricherIntakeEvents checks that enriching the encoding of each
+// kind of event decoded from the intake testdata only adds the expected
+// fields, leaving the rest of the document intact.
+func TestEnricherIntakeEvents(t *testing.T) {
+	enricher := geoip.NewEnricher(
+		geoip.Databases{City: openTestDatabase(t, "GeoLite2-Country.mmdb")},
+		geoip.Databases{
+			City: openTestDatabase(t, "GeoLite2-City.mmdb"),
+			ASN:  openTestDatabase(t, "GeoLite2-ASN.mmdb"),
+		},
+	)
+	clientIP := netip.MustParseAddr("89.160.20.128")
+
+	processorEvents := make(map[string]bool)
+	for _, filename := range []string{
+		"errors.ndjson",
+		"errors_rum.ndjson",
+		"events.ndjson",
+		"logs.ndjson",
+		"metricsets.ndjson",
+		"otel-bridge.ndjson",
+		"span-links.ndjson",
+		"spans.ndjson",
+		"transactions.ndjson",
+		"transactions_spans_rum.ndjson",
+	} {
+		events := decodeIntakeEvents(t, filename)
+		for i, event := range events {
+			event.Client.IP = clientIP
+			processorEvents[event.Processor.Event] = true
+
+			var w fastjson.Writer
+			require.NoError(t, event.MarshalFastJSON(&w))
+			var expect map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Bytes(), &expect))
+			enricher.EnrichJSON(&w, &event)
+			var doc map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Bytes(), &doc), "%s[%d]: %s", filename, i, w.Bytes())
+
+			client := doc["client"].(map[string]interface{})
+			require.Contains(t, client, "geo", "%s[%d]", filename, i)
+			delete(client, "geo")
+			if event.Agent.Name != "rum-js" && event.Agent.Name != "js-base" {
+				source := doc["source"].(map[string]interface{})
+				require.Contains(t, source, "as", "%s[%d]", filename, i)
+				delete(source, "as")
+				if len(source) == 0 {
+					delete(doc, "source")
+				}
+			}
+			assert.Equal(t, expect, doc, "%s[%d]", filename, i)
+		}
+	}
+	assert.Equal(t, map[string]bool{
+		"error":       true,
+		"log":         true,
+		"metric":      true,
+		"span":        true,
+		"transaction": true,
+	}, processorEvents)
+}
+
+func TestEnricherWhitespace(t *testing.T) {
+	enricher := geoip.NewEnricher(
+		geoip.Databases{},
+		geoip.Databases{
+			City: openTestDatabase(t, "GeoLite2-City.mmdb"),
+			ASN:  openTestDatabase(t, "GeoLite2-ASN.mmdb"),
+		},
+	)
+	event := model.APMEvent{
+		Agent:  model.Agent{Name: "go"},
+		Client: model.Client{IP: netip.MustParseAddr("89.160.20.128")},
+	}
+	for name, in := range map[string]string{
+		"indented": "{\n  \"agent\": {\"name\": \"go\"},\n  \"client\" : {\n    \"ip\": \"89.160.20.128\"\n  }\n}\n",
+		"escaped":  `{"agent":{"name":"go"},"\u0063lient":{"ip":"89.160.20.128"},"source":{ }}`,
+		"empty":    ` { } `,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var w fastjson.Writer
+			w.RawString(in)
+			enricher.EnrichJSON(&w, &event)
+
+			var doc map[string]map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Bytes(), &doc), string(w.Bytes()))
+			assert.Contains(t, doc["client"], "geo")
+			assert.Contains(t, doc["source"], "as")
+		})
+	}
+}
+
+func TestEnricherMalformed(t *testing.T) {
+	enricher := geoip.NewEnricher(
+		geoip.Databases{},
+		geoip.Databases{
+			City: openTestDatabase(t, "GeoLite2-City.mmdb"),
+			ASN:  openTestDatabase(t, "GeoLite2-ASN.mmdb"),
+		},
+	)
+	event := model.APMEvent{
+		Agent:  model.Agent{Name: "go"},
+		Client: model.Client{IP: netip.MustParseAddr("89.160.20.128")},
+	}
+	for _, in := range []string{
+		``,
+		`[]`,
+		`{"client":{"ip":"89.160.20.128"}`,
+		`{"client":"89.160.20.128"}`,
+		`{"client":{"ip":"89.160.20.128"},}`,
+		`{"client":{"ip":"89.160.20.128"}}{}`,
+		`{"message":"unterminated}`,
+		`{"count":}`,
+	} {
+		var w fastjson.Writer
+		w.RawString(in)
+		enricher.EnrichJSON(&w, &event)
+		assert.Equal(t, in, string(w.Bytes()))
+	}
+}
+
+func decodeIntakeEvents(t testing.TB, filename string) []model.APMEvent {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "intake-v2", filename))
+	require.NoError(t, err)
+
+	var events []model.APMEvent
+	var result elasticapm.Result
+	processor := elasticapm.NewProcessor(elasticapm.Config{
+		MaxEventSize: 1024 * 1024,
+		Semaphore:    make(chan struct{}, 1),
+	})
+	err = processor.HandleStream(
+		context.Background(), false, model.APMEvent{}, bytes.NewReader(data), 10,
+		model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+			events = append(events, (*batch)...)
+			return nil
+		}),
+		&result,
+	)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	require.NotEmpty(t, events)
+	return events
+}
+
+func openTestDatabase(t testing.TB, name string) *geoip.Database {
+	db, err := geoip.OpenDatabase(filepath.Join(testdataDir, name), logp.NewLogger(""))
+	require.NoError(t, err)
+	return db
+}
@@ -21,6 +21,7 @@ package logs
 const (
 	Beater                    = "beater"
 	Config                    = "config"
+	GeoIP                     = "geoip"
 	Handler                   = "handler"
 	Ilm                       = "ilm"
 	IndexManagement           = "index-management"
@@ -37,6 +38,7 @@ const (
 	ServiceTransactionMetrics = "servicetxmetrics"
 	ServiceSummaryMetrics     = "servicesummarymetrics"
 	SpanMetrics               = "spanmetrics"
+	ErrorMetrics              = "errormetrics"
 	Transform                 = "transform"
 	Sampling                  = "sampling"
 	Processor                 = "processor"
@@ -0,0 +1,179 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package modeldimension provides access to model.APMEvent fields which
+// may be configured as additional metrics aggregation dimensions.
+package modeldimension
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/apm-data/model"
+)
+
+const (
+	labelsFieldPrefix        = "labels."
+	numericLabelsFieldPrefix = "numeric_labels."
+)
+
+// Dimension is an event field which may be configured as an additional
+// aggregation dimension.
+//
+// Field values are represented as strings in aggregation keys. Events
+// with a zero value for the field are grouped together, and the field
+// is left unset in the published metricset.
+type Dimension struct {
+	// Get returns the field value of the event, or "" if unset.
+	Get func(*model.APMEvent) string
+
+	// Set sets the field of the event to a value returned by Get.
+	Set func(*model.APMEvent, string)
+}
+
+// fields holds the supported dimensions, keyed by field path. Labels are
+// additionally supported with the field paths "labels.<key>" and
+// "numeric_labels.<key>".
+var fields = map[string]Dimension{
+	"client.domain": stringDimension(func(e *model.APMEvent) *string {
+		return &e.Client.Domain
+	}),
+	"device.model.name": stringDimension(func(e *model.APMEvent) *string {
+		return &e.Device.Model.Name
+	}),
+	"host.architecture": stringDimension(func(e *model.APMEvent) *string {
+		return &e.Host.Architecture
+	}),
+	"http.request.method": {
+		Get: func(e *model.APMEvent) string {
+			if e.HTTP.Request == nil {
+				return ""
+			}
+			return e.HTTP.Request.Method
+		},
+		Set: func(e *model.APMEvent, v string) {
+			e.HTTP.Request = &model.HTTPRequest{Method: v}
+		},
+	},
+	"http.response.status_code": {
+		Get: func(e *model.APMEvent) string {
+			if e.HTTP.Response == nil || e.HTTP.Response.StatusCode == 0 {
+				return ""
+			}
+			return strconv.Itoa(e.HTTP.Response.StatusCode)
+		},
+		Set: func(e *model.APMEvent, v string) {
+			statusCode, _ := strconv.Atoi(v)
+			e.HTTP.Response = &model.HTTPResponse{StatusCode: statusCode}
+		},
+	},
+	"http.version": stringDimension(func(e *model.APMEvent) *string {
+		return &e.HTTP.Version
+	}),
+	"network.connection.subtype": stringDimension(func(e *model.APMEvent) *string {
+		return &e.Network.Connection.Subtype
+	}),
+	"network.connection.type": stringDimension(func(e *model.APMEvent) *string {
+		return &e.Network.Connection.Type
+	}),
+	"service.framework.name": stringDimension(func(e *model.APMEvent) *string {
+		return &e.Service.Framework.Name
+	}),
+	"service.framework.version": stringDimension(func(e *model.APMEvent) *string {
+		return &e.Service.Framework.Version
+	}),
+	"url.domain": stringDimension(func(e *model.APMEvent) *string {
+		return &e.URL.Domain
+	}),
+	"url.scheme": stringDimension(func(e *model.APMEvent) *string {
+		return &e.URL.Scheme
+	}),
+	"user_agent.name": stringDimension(func(e *model.APMEvent) *string {
+		return &e.UserAgent.Name
+	}),
+}
+
+// Parse returns the Dimension for the given field path.
+func Parse(field string) (Dimension, error) {
+	if dimension, ok := fields[field]; ok {
+		return dimension, nil
+	}
+	if key := strings.TrimPrefix(field, labelsFieldPrefix); key != field && key != "" {
+		return labelDimension(key), nil
+	}
+	if key := strings.TrimPrefix(field, numericLabelsFieldPrefix); key != field && key != "" {
+		return numericLabelDimension(key), nil
+	}
+	return Dimension{}, errors.Errorf("unsupported field %q", field)
+}
+
+func stringDimension(field func(*model.APMEvent) *string) Dimension {
+	return Dimension{
+		Get: func(e *model.APMEvent) string { return *field(e) },
+		Set: func(e *model.APMEvent, v string) { *field(e) = v },
+	}
+}
+
+// labelDimension returns an Dimension for a label, global or not.
+// Only labels with a single value are considered.
+func labelDimension(key string) Dimension {
+	return Dimension{
+		Get: func(e *model.APMEvent) string {
+			return e.Labels[key].Value
+		},
+		Set: func(e *model.APMEvent, v string) {
+			if _, ok := e.Labels[key]; ok {
+				// Global labels are already set from the aggregation key.
+				return
+			}
+			// Labels may be shared with the aggregation key, so copy them.
+			labels := e.Labels.Clone()
+			labels.Set(key, v)
+			e.Labels = labels
+		},
+	}
+}
+
+// numericLabelDimension returns an Dimension for a numeric label,
+// global or not. Only labels with a single value are considered.
+func numericLabelDimension(key string) Dimension {
+	return Dimension{
+		Get: func(e *model.APMEvent) string {
+			label, ok := e.NumericLabels[key]
+			if !ok || len(label.Values) > 0 {
+				return ""
+			}
+			return strconv.FormatFloat(label.Value, 'g', -1, 64)
+		},
+		Set: func(e *model.APMEvent, v string) {
+			if _, ok := e.NumericLabels[key]; ok {
+				// Global labels are already set from the aggregation key.
+				return
+			}
+			value, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return
+			}
+			// Labels may be shared with the aggregation key, so copy them.
+			labels := e.NumericLabels.Clone()
+			labels.Set(key, value)
+			e.NumericLabels = labels
+		},
+	}
+}
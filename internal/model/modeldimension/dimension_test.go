@@ -0,0 +1,63 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modeldimension
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-data/model"
+)
+
+func TestParse(t *testing.T) {
+	for field, value := range map[string]string{
+		"client.domain":              "example.com",
+		"device.model.name":          "Pixel 7",
+		"host.architecture":          "arm64",
+		"http.request.method":        "POST",
+		"http.response.status_code":  "503",
+		"http.version":               "2",
+		"network.connection.subtype": "LTE",
+		"network.connection.type":    "cell",
+		"service.framework.name":     "gin",
+		"service.framework.version":  "1.9.0",
+		"url.domain":                 "example.com",
+		"url.scheme":                 "https",
+		"user_agent.name":            "Firefox",
+		"labels.tier":                "gold",
+		"numeric_labels.shard":       "1.5",
+	} {
+		dimension, err := Parse(field)
+		require.NoError(t, err, field)
+
+		var event model.APMEvent
+		assert.Equal(t, "", dimension.Get(&event), field)
+		dimension.Set(&event, value)
+		assert.Equal(t, value, dimension.Get(&event), field)
+	}
+	assert.Len(t, fields, 13)
+}
+
+func TestParseUnsupported(t *testing.T) {
+	for _, field := range []string{"", "transaction.name", "labels.", "numeric_labels."} {
+		_, err := Parse(field)
+		assert.EqualError(t, err, "unsupported field \""+field+"\"")
+	}
+}
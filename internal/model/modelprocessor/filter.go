@@ -0,0 +1,179 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-data/model"
+)
+
+// FilterRule holds a rule for dropping events matching a set of conditions.
+//
+// Empty conditions match all events; an event must match all non-empty
+// conditions to match the rule.
+type FilterRule struct {
+	// Name identifies the rule, and is used for naming its metrics.
+	Name string
+
+	// ServiceName, if non-empty, is matched against service.name.
+	ServiceName string
+
+	// TransactionName, if non-empty, is matched against transaction.name.
+	TransactionName string
+
+	// TransactionType, if non-empty, is matched against transaction.type.
+	TransactionType string
+
+	// URLPath, if non-empty, holds a glob pattern matched against url.path.
+	// See path.Match for the pattern syntax.
+	URLPath string
+
+	// ProcessorEvent, if non-empty, is matched against processor.event.
+	ProcessorEvent string
+
+	// Labels holds labels which must all be present with the given values.
+	Labels map[string]string
+
+	// SampleRate holds the fraction of matching events which are kept.
+	// If SampleRate is zero, all matching events are dropped.
+	//
+	// The representative count of kept transactions and spans is scaled
+	// by 1/SampleRate, so metrics derived from them remain accurate.
+	SampleRate float64
+}
+
+// Filter is a model.BatchProcessor that drops events matching configured
+// rules, or keeps only a fraction of them.
+//
+// Rules are evaluated in order, and only the first rule matching an event
+// is applied. The number of events dropped by each rule is recorded as the
+// metric `<name>.dropped` under the given monitoring.Registry.
+type Filter struct {
+	rules []filterRule
+}
+
+type filterRule struct {
+	FilterRule
+	dropped *monitoring.Int
+}
+
+// NewFilter returns a Filter that applies the given rules, recording metrics
+// under registry.
+func NewFilter(rules []FilterRule, registry *monitoring.Registry) (*Filter, error) {
+	filter := &Filter{rules: make([]filterRule, len(rules))}
+	for i, rule := range rules {
+		if rule.URLPath != "" {
+			if _, err := path.Match(rule.URLPath, ""); err != nil {
+				return nil, fmt.Errorf("invalid url.path pattern %q for filter %q: %w", rule.URLPath, rule.Name, err)
+			}
+		}
+		// Metric may exist in the registry if the server has been
+		// reloaded, so first check if it exists before attempting
+		// to create.
+		name := rule.Name + ".dropped"
+		dropped, ok := registry.Get(name).(*monitoring.Int)
+		if !ok {
+			dropped = monitoring.NewInt(registry, name)
+		}
+		filter.rules[i] = filterRule{FilterRule: rule, dropped: dropped}
+	}
+	return filter, nil
+}
+
+// ProcessBatch drops events in b which match a rule, preserving the order
+// of the remaining events.
+func (f *Filter) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	events := *b
+	n := 0
+	for i := range events {
+		if f.drop(&events[i]) {
+			continue
+		}
+		events[n] = events[i]
+		n++
+	}
+	*b = events[:n]
+	return nil
+}
+
+func (f *Filter) drop(event *model.APMEvent) bool {
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if !rule.matches(event) {
+			continue
+		}
+		if rule.SampleRate > 0 && rand.Float64() < rule.SampleRate {
+			if rule.SampleRate < 1 {
+				scaleRepresentativeCount(event, 1/rule.SampleRate)
+			}
+			return false
+		}
+		rule.dropped.Inc()
+		return true
+	}
+	return false
+}
+
+// scaleRepresentativeCount multiplies the representative count of
+// transaction and span events by factor.
+func scaleRepresentativeCount(event *model.APMEvent, factor float64) {
+	if event.Transaction != nil {
+		event.Transaction.RepresentativeCount *= factor
+	}
+	if event.Span != nil {
+		event.Span.RepresentativeCount *= factor
+	}
+}
+
+func (r *filterRule) matches(event *model.APMEvent) bool {
+	if r.ServiceName != "" && r.ServiceName != event.Service.Name {
+		return false
+	}
+	if r.TransactionName != "" || r.TransactionType != "" {
+		if event.Transaction == nil {
+			return false
+		}
+		if r.TransactionName != "" && r.TransactionName != event.Transaction.Name {
+			return false
+		}
+		if r.TransactionType != "" && r.TransactionType != event.Transaction.Type {
+			return false
+		}
+	}
+	if r.URLPath != "" {
+		if ok, _ := path.Match(r.URLPath, event.URL.Path); !ok {
+			return false
+		}
+	}
+	if r.ProcessorEvent != "" && r.ProcessorEvent != event.Processor.Event {
+		return false
+	}
+	for k, v := range r.Labels {
+		label, ok := event.Labels[k]
+		if !ok || len(label.Values) > 0 || label.Value != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestFilter(t *testing.T) {
+	healthcheck := model.APMEvent{
+		Service:     model.Service{Name: "opbeans-go"},
+		Processor:   model.TransactionProcessor,
+		Transaction: &model.Transaction{Name: "GET /healthz", Type: "request"},
+		URL:         model.URL{Path: "/healthz"},
+	}
+	metrics := model.APMEvent{
+		Service:     model.Service{Name: "opbeans-java"},
+		Processor:   model.TransactionProcessor,
+		Transaction: &model.Transaction{Name: "GET /metrics", Type: "request"},
+		URL:         model.URL{Path: "/internal/metrics"},
+		Labels:      model.Labels{"team": {Value: "ops"}},
+	}
+	span := model.APMEvent{
+		Service:   model.Service{Name: "opbeans-go"},
+		Processor: model.SpanProcessor,
+		Span:      &model.Span{Name: "SELECT FROM products"},
+	}
+	errorEvent := model.APMEvent{
+		Service:   model.Service{Name: "opbeans-java"},
+		Processor: model.ErrorProcessor,
+		Error:     &model.Error{},
+		Labels:    model.Labels{"team": {Values: []string{"ops"}}},
+	}
+	batch := model.Batch{healthcheck, span, metrics, errorEvent}
+
+	registry := monitoring.NewRegistry()
+	filter, err := modelprocessor.NewFilter([]modelprocessor.FilterRule{{
+		Name:            "healthz",
+		ServiceName:     "opbeans-go",
+		TransactionName: "GET /healthz",
+		TransactionType: "request",
+	}, {
+		Name:           "metrics",
+		URLPath:        "/*/metrics",
+		ProcessorEvent: "transaction",
+		Labels:         map[string]string{"team": "ops"},
+	}, {
+		// Multi-valued labels are not matched.
+		Name:   "team",
+		Labels: map[string]string{"team": "ops"},
+	}, {
+		Name:            "unmatched",
+		TransactionType: "request",
+	}}, registry)
+	require.NoError(t, err)
+
+	err = filter.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	assert.Equal(t, model.Batch{span, errorEvent}, batch)
+
+	expected := monitoring.MakeFlatSnapshot()
+	expected.Ints["healthz.dropped"] = 1
+	expected.Ints["metrics.dropped"] = 1
+	expected.Ints["team.dropped"] = 0
+	expected.Ints["unmatched.dropped"] = 0
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, expected, snapshot)
+}
+
+func TestFilterFirstMatch(t *testing.T) {
+	registry := monitoring.NewRegistry()
+	filter, err := modelprocessor.NewFilter([]modelprocessor.FilterRule{
+		{Name: "keep", ProcessorEvent: "span", SampleRate: 1},
+		{Name: "drop", ServiceName: "opbeans-go"},
+	}, registry)
+	require.NoError(t, err)
+
+	batch := model.Batch{
+		{Service: model.Service{Name: "opbeans-go"}, Processor: model.SpanProcessor},
+		{Service: model.Service{Name: "opbeans-go"}, Processor: model.TransactionProcessor},
+	}
+	err = filter.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	assert.Equal(t, model.Batch{
+		{Service: model.Service{Name: "opbeans-go"}, Processor: model.SpanProcessor},
+	}, batch)
+}
+
+func TestFilterSampleRate(t *testing.T) {
+	registry := monitoring.NewRegistry()
+	filter, err := modelprocessor.NewFilter([]modelprocessor.FilterRule{
+		{Name: "half", ServiceName: "opbeans-go", SampleRate: 0.5},
+	}, registry)
+	require.NoError(t, err)
+
+	batch := make(model.Batch, 1000)
+	for i := range batch {
+		batch[i].Service.Name = "opbeans-go"
+		switch i % 3 {
+		case 0:
+			batch[i].Processor = model.TransactionProcessor
+			batch[i].Transaction = &model.Transaction{RepresentativeCount: 1}
+		case 1:
+			batch[i].Processor = model.SpanProcessor
+			batch[i].Span = &model.Span{RepresentativeCount: 2}
+		case 2:
+			batch[i].Processor = model.ErrorProcessor
+			batch[i].Error = &model.Error{}
+		}
+	}
+	err = filter.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	dropped := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false).Ints["half.dropped"]
+	assert.Equal(t, int64(1000-len(batch)), dropped)
+	assert.InDelta(t, 500, len(batch), 100)
+
+	// Kept transactions and spans represent the dropped ones.
+	for _, event := range batch {
+		switch event.Processor {
+		case model.TransactionProcessor:
+			assert.Equal(t, 2.0, event.Transaction.RepresentativeCount)
+		case model.SpanProcessor:
+			assert.Equal(t, 4.0, event.Span.RepresentativeCount)
+		}
+	}
+}
+
+func TestFilterInvalidURLPath(t *testing.T) {
+	_, err := modelprocessor.NewFilter([]modelprocessor.FilterRule{
+		{Name: "invalid", URLPath: "/[healthz"},
+	}, monitoring.NewRegistry())
+	assert.EqualError(t, err, `invalid url.path pattern "/[healthz" for filter "invalid": syntax error in pattern`)
+}
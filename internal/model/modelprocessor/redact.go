@@ -0,0 +1,521 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-data/model"
+)
+
+// Event fields which may be redacted by Redactor.
+const (
+	RedactionFieldURLQuery            = "url.query"
+	RedactionFieldHTTPRequestHeaders  = "http.request.headers"
+	RedactionFieldHTTPRequestCookies  = "http.request.cookies"
+	RedactionFieldHTTPResponseHeaders = "http.response.headers"
+	RedactionFieldLabels              = "labels"
+	RedactionFieldUserEmail           = "user.email"
+	RedactionFieldDBStatement         = "span.db.statement"
+)
+
+// redactionMask replaces masked values, matching the replacement
+// used by Elastic APM agents for sanitized fields.
+const redactionMask = "[REDACTED]"
+
+// keyedRedactionFields holds the fields whose values are identified by
+// a name, such as a header or label name, which may be matched by the
+// names of a redaction rule.
+var keyedRedactionFields = map[string]bool{
+	RedactionFieldURLQuery:            true,
+	RedactionFieldHTTPRequestHeaders:  true,
+	RedactionFieldHTTPRequestCookies:  true,
+	RedactionFieldHTTPResponseHeaders: true,
+	RedactionFieldLabels:              true,
+	RedactionFieldUserEmail:           false,
+	RedactionFieldDBStatement:         false,
+}
+
+// RedactionRule holds a rule for redacting event fields.
+type RedactionRule struct {
+	// Name identifies the rule, and is used for naming its metrics.
+	Name string
+
+	// Fields holds the event fields to which the rule applies.
+	// See the RedactionField* constants.
+	Fields []string
+
+	// Names holds case-insensitive glob patterns matched against the names
+	// of URL query parameters, HTTP headers and cookies, and labels. Values
+	// with a matching name are redacted entirely.
+	Names []string
+
+	// Patterns holds regular expressions matched against field values.
+	// Matching substrings are redacted.
+	//
+	// If Patterns is empty, user.email is redacted entirely, and all
+	// literals in span.db.statement are redacted. Otherwise only values
+	// and literals matching a pattern are redacted.
+	Patterns []string
+
+	// Hash controls whether redacted values are replaced with their
+	// hex-encoded SHA-256 hash, rather than masked.
+	Hash bool
+}
+
+// Redactor is a model.BatchProcessor that redacts personal data from events,
+// masking or hashing the values of configured fields.
+//
+// All rules are applied to each event, in order. The number of events
+// redacted by each rule is recorded as the metric `<name>.redacted`
+// under the given monitoring.Registry.
+type Redactor struct {
+	rules []redactionRule
+}
+
+type redactionRule struct {
+	name     string
+	fields   map[string]bool
+	names    []string
+	pattern  *regexp.Regexp
+	hash     bool
+	redacted *monitoring.Int
+}
+
+// NewRedactor returns a Redactor that applies the given rules, recording
+// metrics under registry.
+func NewRedactor(rules []RedactionRule, registry *monitoring.Registry) (*Redactor, error) {
+	redactor := &Redactor{rules: make([]redactionRule, len(rules))}
+	for i, rule := range rules {
+		r := redactionRule{
+			name:   rule.Name,
+			fields: make(map[string]bool),
+			hash:   rule.Hash,
+		}
+		if len(rule.Fields) == 0 {
+			return nil, fmt.Errorf("redaction rule %q: no fields specified", rule.Name)
+		}
+		for _, field := range rule.Fields {
+			keyed, ok := keyedRedactionFields[field]
+			if !ok {
+				return nil, fmt.Errorf("redaction rule %q: unknown field %q", rule.Name, field)
+			}
+			if keyed && len(rule.Names) == 0 && len(rule.Patterns) == 0 {
+				return nil, fmt.Errorf("redaction rule %q: names or patterns must be specified for field %q", rule.Name, field)
+			}
+			r.fields[field] = true
+		}
+		for _, name := range rule.Names {
+			name = strings.ToLower(name)
+			if _, err := path.Match(name, ""); err != nil {
+				return nil, fmt.Errorf("redaction rule %q: invalid name pattern %q: %w", rule.Name, name, err)
+			}
+			r.names = append(r.names, name)
+		}
+		if len(rule.Patterns) > 0 {
+			// Patterns are combined so that values are redacted in a single
+			// pass, and redacted values are not matched by later patterns.
+			patterns := make([]string, len(rule.Patterns))
+			for j, pattern := range rule.Patterns {
+				if _, err := regexp.Compile(pattern); err != nil {
+					return nil, fmt.Errorf("redaction rule %q: invalid pattern: %w", rule.Name, err)
+				}
+				patterns[j] = "(?:" + pattern + ")"
+			}
+			r.pattern = regexp.MustCompile(strings.Join(patterns, "|"))
+		}
+		// Metric may exist in the registry if the server has been
+		// reloaded, so first check if it exists before attempting
+		// to create.
+		name := rule.Name + ".redacted"
+		redacted, ok := registry.Get(name).(*monitoring.Int)
+		if !ok {
+			redacted = monitoring.NewInt(registry, name)
+		}
+		r.redacted = redacted
+		redactor.rules[i] = r
+	}
+	return redactor, nil
+}
+
+// ProcessBatch redacts the configured fields of events in b.
+//
+// Maps and structs referenced by events are copied before being modified,
+// as they may be shared between events.
+func (r *Redactor) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	for i := range *b {
+		event := &(*b)[i]
+		for j := range r.rules {
+			rule := &r.rules[j]
+			if rule.redactEvent(event) {
+				rule.redacted.Inc()
+			}
+		}
+	}
+	return nil
+}
+
+func (r *redactionRule) redactEvent(event *model.APMEvent) bool {
+	var redacted bool
+	if r.fields[RedactionFieldURLQuery] && r.redactURL(&event.URL) {
+		redacted = true
+	}
+	if event.HTTP.Request != nil && r.redactHTTPRequest(&event.HTTP.Request) {
+		redacted = true
+	}
+	if event.HTTP.Response != nil && r.fields[RedactionFieldHTTPResponseHeaders] {
+		if headers, ok := r.redactMap(event.HTTP.Response.Headers, r.redactValues); ok {
+			response := *event.HTTP.Response
+			response.Headers = headers
+			event.HTTP.Response = &response
+			redacted = true
+		}
+	}
+	if r.fields[RedactionFieldLabels] && r.redactLabels(&event.Labels) {
+		redacted = true
+	}
+	if r.fields[RedactionFieldUserEmail] && event.User.Email != "" {
+		if r.pattern == nil {
+			event.User.Email = r.replace(event.User.Email)
+			redacted = true
+		} else if email, ok := r.redactPattern(event.User.Email); ok {
+			event.User.Email = email
+			redacted = true
+		}
+	}
+	if r.fields[RedactionFieldDBStatement] && event.Span != nil && event.Span.DB != nil {
+		if statement, ok := r.redactSQLLiterals(event.Span.DB.Statement); ok {
+			db := *event.Span.DB
+			db.Statement = statement
+			event.Span.DB = &db
+			redacted = true
+		}
+	}
+	return redacted
+}
+
+func (r *redactionRule) redactURL(u *model.URL) bool {
+	var redacted bool
+	if query, ok := r.redactQuery(u.Query); ok {
+		u.Query = query
+		redacted = true
+	}
+	if full, ok := r.redactURLQuery(u.Full); ok {
+		u.Full = full
+		redacted = true
+	}
+	if original, ok := r.redactURLQuery(u.Original); ok {
+		u.Original = original
+		redacted = true
+	}
+	return redacted
+}
+
+// redactURLQuery redacts the query of a URL, or URL path, string.
+func (r *redactionRule) redactURLQuery(s string) (string, bool) {
+	i := strings.IndexByte(s, '?')
+	if i == -1 {
+		return s, false
+	}
+	query, fragment := s[i+1:], ""
+	if j := strings.IndexByte(query, '#'); j != -1 {
+		query, fragment = query[:j], query[j:]
+	}
+	query, ok := r.redactQuery(query)
+	if !ok {
+		return s, false
+	}
+	return s[:i+1] + query + fragment, true
+}
+
+// redactQuery redacts the parameter values of a URL query string. Patterns
+// are matched against the raw, percent-encoded, parameter values.
+func (r *redactionRule) redactQuery(query string) (string, bool) {
+	if query == "" {
+		return query, false
+	}
+	var redacted bool
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		if value, ok := r.redactKeyed(name, value); ok {
+			params[i] = name + "=" + value
+			redacted = true
+		}
+	}
+	if !redacted {
+		return query, false
+	}
+	return strings.Join(params, "&"), true
+}
+
+func (r *redactionRule) redactHTTPRequest(request **model.HTTPRequest) bool {
+	var redacted bool
+	req := **request
+	if r.fields[RedactionFieldHTTPRequestHeaders] {
+		if headers, ok := r.redactMap(req.Headers, r.redactValues); ok {
+			req.Headers = headers
+			redacted = true
+		}
+	}
+	if r.fields[RedactionFieldHTTPRequestCookies] {
+		if cookies, ok := r.redactMap(req.Cookies, r.redactValues); ok {
+			req.Cookies = cookies
+			redacted = true
+		}
+		// The Cookie header holds the same cookies as Cookies.
+		if headers, ok := r.redactMap(req.Headers, r.redactCookieHeader); ok {
+			req.Headers = headers
+			redacted = true
+		}
+	}
+	if redacted {
+		*request = &req
+	}
+	return redacted
+}
+
+// redactMap calls redact for each entry in m, returning a copy of m with the
+// redacted values if any were redacted.
+func (r *redactionRule) redactMap(m map[string]any, redact func(string, any) (any, bool)) (map[string]any, bool) {
+	var out map[string]any
+	for k, v := range m {
+		v, ok := redact(k, v)
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]any, len(m))
+			for k, v := range m {
+				out[k] = v
+			}
+		}
+		out[k] = v
+	}
+	return out, out != nil
+}
+
+// redactValues redacts a header or cookie value, which may be a string or a
+// slice of strings.
+func (r *redactionRule) redactValues(name string, value any) (any, bool) {
+	return redactStrings(value, func(s string) (string, bool) {
+		return r.redactKeyed(name, s)
+	})
+}
+
+// redactCookieHeader redacts the cookie values in a Cookie header.
+func (r *redactionRule) redactCookieHeader(name string, value any) (any, bool) {
+	if !strings.EqualFold(name, "cookie") {
+		return value, false
+	}
+	return redactStrings(value, func(s string) (string, bool) {
+		var redacted bool
+		cookies := strings.Split(s, ";")
+		for i, cookie := range cookies {
+			name, value, ok := strings.Cut(cookie, "=")
+			if !ok {
+				continue
+			}
+			if value, ok := r.redactKeyed(strings.TrimSpace(name), value); ok {
+				cookies[i] = name + "=" + value
+				redacted = true
+			}
+		}
+		return strings.Join(cookies, ";"), redacted
+	})
+}
+
+func redactStrings(value any, redact func(string) (string, bool)) (any, bool) {
+	switch value := value.(type) {
+	case string:
+		return redact(value)
+	case []string:
+		var out []string
+		for i, s := range value {
+			if s, ok := redact(s); ok {
+				if out == nil {
+					out = append([]string(nil), value...)
+				}
+				out[i] = s
+			}
+		}
+		return out, out != nil
+	case []any:
+		var out []any
+		for i, v := range value {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			if s, ok := redact(s); ok {
+				if out == nil {
+					out = append([]any(nil), value...)
+				}
+				out[i] = s
+			}
+		}
+		return out, out != nil
+	}
+	return value, false
+}
+
+func (r *redactionRule) redactLabels(labels *model.Labels) bool {
+	var out model.Labels
+	for k, v := range *labels {
+		var redacted bool
+		if v.Values != nil {
+			if values, ok := redactStrings(v.Values, func(s string) (string, bool) {
+				return r.redactKeyed(k, s)
+			}); ok {
+				v.Values = values.([]string)
+				redacted = true
+			}
+		} else if value, ok := r.redactKeyed(k, v.Value); ok {
+			v.Value = value
+			redacted = true
+		}
+		if !redacted {
+			continue
+		}
+		if out == nil {
+			out = (*labels).Clone()
+		}
+		out[k] = v
+	}
+	if out == nil {
+		return false
+	}
+	*labels = out
+	return true
+}
+
+// redactKeyed redacts a value identified by name: entirely if the name
+// matches one of the rule's names, otherwise substrings matching patterns.
+func (r *redactionRule) redactKeyed(name, value string) (string, bool) {
+	if len(r.names) > 0 {
+		name = strings.ToLower(name)
+		for _, pattern := range r.names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return r.replace(value), true
+			}
+		}
+	}
+	return r.redactPattern(value)
+}
+
+func (r *redactionRule) redactPattern(value string) (string, bool) {
+	if r.pattern == nil || !r.pattern.MatchString(value) {
+		return value, false
+	}
+	return r.pattern.ReplaceAllStringFunc(value, r.replace), true
+}
+
+func (r *redactionRule) replace(value string) string {
+	if r.hash {
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	return redactionMask
+}
+
+// redactSQLLiterals redacts string and numeric literals in a SQL statement.
+// Masked literals are replaced with "?", and hashed literals are replaced
+// with a string literal holding the hash.
+func (r *redactionRule) redactSQLLiterals(statement string) (string, bool) {
+	var out strings.Builder
+	var redacted bool
+	last := 0
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		var end int
+		var literal string
+		switch {
+		case c == '\'':
+			var terminated bool
+			end, terminated = scanSQLString(statement, i)
+			literal = statement[i+1 : end]
+			if terminated {
+				literal = statement[i+1 : end-1]
+			}
+		case isDigit(c) && (i == 0 || !isSQLIdentifierByte(statement[i-1])):
+			end = i + 1
+			for end < len(statement) && (isDigit(statement[end]) || statement[end] == '.') {
+				end++
+			}
+			literal = statement[i:end]
+		default:
+			i++
+			continue
+		}
+		if r.pattern == nil || r.pattern.MatchString(literal) {
+			out.WriteString(statement[last:i])
+			if r.hash {
+				out.WriteString("'" + r.replace(literal) + "'")
+			} else {
+				out.WriteByte('?')
+			}
+			last = end
+			redacted = true
+		}
+		i = end
+	}
+	if !redacted {
+		return statement, false
+	}
+	out.WriteString(statement[last:])
+	return out.String(), true
+}
+
+// scanSQLString returns the index following the string literal starting at
+// statement[start], handling doubled and backslash-escaped quotes, and whether
+// the literal is terminated. Unterminated literals extend to the end of the
+// statement.
+func scanSQLString(statement string, start int) (int, bool) {
+	for i := start + 1; i < len(statement); i++ {
+		switch statement[i] {
+		case '\\':
+			i++
+		case '\'':
+			if i+1 < len(statement) && statement[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, true
+		}
+	}
+	return len(statement), false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSQLIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c == '.' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
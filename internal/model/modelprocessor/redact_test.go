@@ -0,0 +1,227 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestRedactorKeyedFields(t *testing.T) {
+	sharedLabels := model.Labels{
+		"customer_token": {Value: "abc"},
+		"team":           {Value: "ops"},
+		"emails":         {Values: []string{"alice@example.com", "none"}},
+	}
+	request := &model.HTTPRequest{
+		Headers: map[string]any{
+			"Authorization": []string{"Bearer abc"},
+			"Cookie":        []any{"session_id=123; theme=dark"},
+			"Accept":        "*/*",
+		},
+		Cookies: map[string]any{"session_id": "123", "theme": "dark"},
+	}
+	event := model.APMEvent{
+		URL: model.URL{
+			Original: "/search?q=shoes&token=abc#results",
+			Full:     "https://example.com/search?q=shoes&token=abc#results",
+			Query:    "q=shoes&token=abc",
+		},
+		HTTP: model.HTTP{
+			Request: request,
+			Response: &model.HTTPResponse{
+				Headers: map[string]any{"X-Auth-Token": []string{"abc"}},
+			},
+		},
+		Labels: sharedLabels,
+	}
+	batch := model.Batch{event}
+
+	registry := monitoring.NewRegistry()
+	redactor, err := modelprocessor.NewRedactor([]modelprocessor.RedactionRule{{
+		Name: "secrets",
+		Fields: []string{
+			modelprocessor.RedactionFieldURLQuery,
+			modelprocessor.RedactionFieldHTTPRequestHeaders,
+			modelprocessor.RedactionFieldHTTPRequestCookies,
+			modelprocessor.RedactionFieldHTTPResponseHeaders,
+			modelprocessor.RedactionFieldLabels,
+		},
+		Names: []string{"authorization", "*token*", "SESSION_*"},
+	}, {
+		Name:     "emails",
+		Fields:   []string{modelprocessor.RedactionFieldLabels},
+		Patterns: []string{`[^@\s]+@[^@\s]+`},
+	}, {
+		Name:   "unmatched",
+		Fields: []string{modelprocessor.RedactionFieldLabels},
+		Names:  []string{"password"},
+	}}, registry)
+	require.NoError(t, err)
+	require.NoError(t, redactor.ProcessBatch(context.Background(), &batch))
+
+	assert.Equal(t, model.URL{
+		Original: "/search?q=shoes&token=[REDACTED]#results",
+		Full:     "https://example.com/search?q=shoes&token=[REDACTED]#results",
+		Query:    "q=shoes&token=[REDACTED]",
+	}, batch[0].URL)
+	assert.Equal(t, &model.HTTPRequest{
+		Headers: map[string]any{
+			"Authorization": []string{"[REDACTED]"},
+			"Cookie":        []any{"session_id=[REDACTED]; theme=dark"},
+			"Accept":        "*/*",
+		},
+		Cookies: map[string]any{"session_id": "[REDACTED]", "theme": "dark"},
+	}, batch[0].HTTP.Request)
+	assert.Equal(t, map[string]any{"X-Auth-Token": []string{"[REDACTED]"}}, batch[0].HTTP.Response.Headers)
+	assert.Equal(t, model.Labels{
+		"customer_token": {Value: "[REDACTED]"},
+		"team":           {Value: "ops"},
+		"emails":         {Values: []string{"[REDACTED]", "none"}},
+	}, batch[0].Labels)
+
+	// Shared values must not be modified.
+	assert.Equal(t, "abc", sharedLabels["customer_token"].Value)
+	assert.Equal(t, []string{"Bearer abc"}, request.Headers["Authorization"])
+	assert.Equal(t, "123", request.Cookies["session_id"])
+
+	expected := monitoring.MakeFlatSnapshot()
+	expected.Ints["secrets.redacted"] = 1
+	expected.Ints["emails.redacted"] = 1
+	expected.Ints["unmatched.redacted"] = 0
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, expected, snapshot)
+}
+
+func TestRedactorUserEmail(t *testing.T) {
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	for _, test := range []struct {
+		rule   modelprocessor.RedactionRule
+		expect string
+	}{{
+		rule:   modelprocessor.RedactionRule{Name: "mask"},
+		expect: "[REDACTED]",
+	}, {
+		rule:   modelprocessor.RedactionRule{Name: "hash", Hash: true},
+		expect: hash("alice@example.com"),
+	}, {
+		rule:   modelprocessor.RedactionRule{Name: "domain", Patterns: []string{`@.*$`}},
+		expect: "alice[REDACTED]",
+	}, {
+		rule:   modelprocessor.RedactionRule{Name: "unmatched", Patterns: []string{`@elastic\.co$`}},
+		expect: "alice@example.com",
+	}} {
+		t.Run(test.rule.Name, func(t *testing.T) {
+			test.rule.Fields = []string{modelprocessor.RedactionFieldUserEmail}
+			redactor, err := modelprocessor.NewRedactor(
+				[]modelprocessor.RedactionRule{test.rule}, monitoring.NewRegistry(),
+			)
+			require.NoError(t, err)
+			batch := model.Batch{{User: model.User{Email: "alice@example.com"}}}
+			require.NoError(t, redactor.ProcessBatch(context.Background(), &batch))
+			assert.Equal(t, test.expect, batch[0].User.Email)
+		})
+	}
+}
+
+func TestRedactorDBStatement(t *testing.T) {
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return "'" + hex.EncodeToString(sum[:]) + "'"
+	}
+	for _, test := range []struct {
+		name      string
+		rule      modelprocessor.RedactionRule
+		statement string
+		expect    string
+	}{{
+		name:      "mask",
+		statement: "SELECT * FROM users u1 WHERE u1.email = 'alice@example.com' AND age > 21.5",
+		expect:    "SELECT * FROM users u1 WHERE u1.email = ? AND age > ?",
+	}, {
+		name:      "escaped quotes",
+		statement: `INSERT INTO t VALUES ('it''s', 'a\'b', 42)`,
+		expect:    "INSERT INTO t VALUES (?, ?, ?)",
+	}, {
+		name:      "unterminated",
+		statement: "SELECT 'abc",
+		expect:    "SELECT ?",
+	}, {
+		name:      "hash",
+		rule:      modelprocessor.RedactionRule{Hash: true},
+		statement: "SELECT * FROM users WHERE email = 'alice@example.com'",
+		expect:    "SELECT * FROM users WHERE email = " + hash("alice@example.com"),
+	}, {
+		name:      "patterns",
+		rule:      modelprocessor.RedactionRule{Patterns: []string{"@"}},
+		statement: "SELECT * FROM users WHERE email = 'alice@example.com' LIMIT 10",
+		expect:    "SELECT * FROM users WHERE email = ? LIMIT 10",
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			test.rule.Name = "db"
+			test.rule.Fields = []string{modelprocessor.RedactionFieldDBStatement}
+			redactor, err := modelprocessor.NewRedactor(
+				[]modelprocessor.RedactionRule{test.rule}, monitoring.NewRegistry(),
+			)
+			require.NoError(t, err)
+			db := &model.DB{Statement: test.statement}
+			batch := model.Batch{{Span: &model.Span{DB: db}}}
+			require.NoError(t, redactor.ProcessBatch(context.Background(), &batch))
+			assert.Equal(t, test.expect, batch[0].Span.DB.Statement)
+			assert.Equal(t, test.statement, db.Statement)
+		})
+	}
+}
+
+func TestRedactorInvalidRules(t *testing.T) {
+	for _, test := range []struct {
+		rule   modelprocessor.RedactionRule
+		expect string
+	}{{
+		rule:   modelprocessor.RedactionRule{Name: "a"},
+		expect: `redaction rule "a": no fields specified`,
+	}, {
+		rule:   modelprocessor.RedactionRule{Name: "a", Fields: []string{"user.name"}},
+		expect: `redaction rule "a": unknown field "user.name"`,
+	}, {
+		rule:   modelprocessor.RedactionRule{Name: "a", Fields: []string{"labels"}},
+		expect: `redaction rule "a": names or patterns must be specified for field "labels"`,
+	}, {
+		rule:   modelprocessor.RedactionRule{Name: "a", Fields: []string{"labels"}, Names: []string{"[a"}},
+		expect: `redaction rule "a": invalid name pattern "[a": syntax error in pattern`,
+	}, {
+		rule:   modelprocessor.RedactionRule{Name: "a", Fields: []string{"labels"}, Patterns: []string{"("}},
+		expect: "redaction rule \"a\": invalid pattern: error parsing regexp: missing closing ): `(`",
+	}} {
+		_, err := modelprocessor.NewRedactor([]modelprocessor.RedactionRule{test.rule}, monitoring.NewRegistry())
+		assert.EqualError(t, err, test.expect)
+	}
+}
@@ -18,56 +18,133 @@
 package netutil
 
 import (
+	"fmt"
 	"net/http"
 	"net/netip"
 	"strconv"
 	"strings"
 )
 
+// TrustedProxies holds the network prefixes of proxies which are trusted to
+// report the address of the client in forwarding headers.
+type TrustedProxies []netip.Prefix
+
+// TrustAllProxies is a TrustedProxies containing all IPv4 and IPv6 addresses.
+var TrustAllProxies = TrustedProxies{
+	netip.MustParsePrefix("0.0.0.0/0"),
+	netip.MustParsePrefix("::/0"),
+}
+
+// ParseTrustedProxies parses a list of CIDR network prefixes, such as
+// "10.0.0.0/8", or IP addresses, which are treated as single-address prefixes.
+func ParseTrustedProxies(in []string) (TrustedProxies, error) {
+	trusted := make(TrustedProxies, 0, len(in))
+	for _, s := range in {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+			}
+			addr = addr.Unmap()
+			trusted = append(trusted, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+	return trusted, nil
+}
+
+// Contains reports whether ip is the address of a trusted proxy.
+func (t TrustedProxies) Contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // ClientAddrFromHeaders returns the IP address, and optionally port, of the client
 // for an HTTP request from one of various headers in order: Forwarded, X-Real-IP,
-// X-Forwarded-For. For the multi-valued Forwarded and X-Forwarded-For headers, the
-// first value in the list is returned. If the port is unknown, it will be zero.
+// X-Forwarded-For. If the port is unknown, it will be zero.
 //
 // If the client is able to control the headers, they can control the result of this
-// function. The result should therefore not necessarily be trusted to be correct;
-// that depends on the presence and configuration of proxies in front of apm-server.
-func ClientAddrFromHeaders(header http.Header) (ip netip.Addr, port uint16) {
+// function. The headers are therefore only considered if peer, the address of the
+// network peer, is a trusted proxy. Peers without an IP address, such as those
+// connected over a Unix domain socket, are local and always trusted.
+//
+// For the multi-valued Forwarded and X-Forwarded-For headers, the values are walked
+// from right to left past those of trusted proxies, and the first untrusted value is
+// returned. If all values are trusted, the first value in the list is returned.
+func ClientAddrFromHeaders(header http.Header, peer netip.Addr, trusted TrustedProxies) (ip netip.Addr, port uint16) {
+	if peer.IsValid() && !trusted.Contains(peer) {
+		return netip.Addr{}, 0
+	}
 	for _, parse := range parseHeadersInOrder {
-		if ip, port := parse(header); ip.IsValid() {
+		if ip, port := parse(header, trusted); ip.IsValid() {
 			return ip, port
 		}
 	}
 	return netip.Addr{}, 0
 }
 
-var parseHeadersInOrder = []func(http.Header) (netip.Addr, uint16){
+var parseHeadersInOrder = []func(http.Header, TrustedProxies) (netip.Addr, uint16){
 	parseForwardedHeader,
 	parseXRealIP,
 	parseXForwardedFor,
 }
 
-func parseForwardedHeader(header http.Header) (netip.Addr, uint16) {
-	forwarded := parseForwarded(getHeader(header, "Forwarded", "forwarded"))
-	if forwarded.For == "" {
+func parseForwardedHeader(header http.Header, trusted TrustedProxies) (netip.Addr, uint16) {
+	forwarded := getHeaderList(header, "Forwarded", "forwarded")
+	if forwarded == "" {
 		return netip.Addr{}, 0
 	}
-
-	return SplitAddrPort(forwarded.For)
+	return lastUntrustedHop(strings.Split(forwarded, ","), trusted, func(hop string) (netip.Addr, uint16) {
+		forwarded := parseForwarded(hop)
+		if forwarded.For == "" {
+			return netip.Addr{}, 0
+		}
+		return SplitAddrPort(forwarded.For)
+	})
 }
 
-func parseXRealIP(header http.Header) (netip.Addr, uint16) {
+func parseXRealIP(header http.Header, _ TrustedProxies) (netip.Addr, uint16) {
 	return SplitAddrPort(getHeader(header, "X-Real-Ip", "x-real-ip"))
 }
 
-func parseXForwardedFor(header http.Header) (netip.Addr, uint16) {
-	if xff := getHeader(header, "X-Forwarded-For", "x-forwarded-for"); xff != "" {
-		if sep := strings.IndexRune(xff, ','); sep > 0 {
-			xff = xff[:sep]
+func parseXForwardedFor(header http.Header, trusted TrustedProxies) (netip.Addr, uint16) {
+	xff := getHeaderList(header, "X-Forwarded-For", "x-forwarded-for")
+	if xff == "" {
+		return netip.Addr{}, 0
+	}
+	return lastUntrustedHop(strings.Split(xff, ","), trusted, func(hop string) (netip.Addr, uint16) {
+		return SplitAddrPort(strings.TrimSpace(hop))
+	})
+}
+
+// lastUntrustedHop parses hops from right to left, returning the address of the
+// first hop which is not a trusted proxy, or the address of the first hop in the
+// list if all hops are trusted. If a hop cannot be parsed, the walk stops and an
+// invalid address is returned.
+func lastUntrustedHop(
+	hops []string, trusted TrustedProxies,
+	parse func(string) (netip.Addr, uint16),
+) (ip netip.Addr, port uint16) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, port = parse(hops[i])
+		if !ip.IsValid() {
+			return netip.Addr{}, 0
+		}
+		if !trusted.Contains(ip) {
+			break
 		}
-		return SplitAddrPort(strings.TrimSpace(xff))
 	}
-	return netip.Addr{}, 0
+	return ip, port
 }
 
 func getHeader(header http.Header, key, keyLower string) string {
@@ -84,6 +161,17 @@ func getHeader(header http.Header, key, keyLower string) string {
 	return ""
 }
 
+// getHeaderList returns the values of a multi-valued header, which may be
+// split across multiple header lines, as a single comma-separated list.
+func getHeaderList(header http.Header, key, keyLower string) string {
+	values := header.Values(key)
+	if len(values) == 0 {
+		// See getHeader for why the lowercase key name is used.
+		values = header[keyLower]
+	}
+	return strings.Join(values, ",")
+}
+
 // forwardedHeader holds information extracted from a "Forwarded" HTTP header.
 type forwardedHeader struct {
 	For   string
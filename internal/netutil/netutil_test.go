@@ -19,6 +19,7 @@ package netutil
 
 import (
 	"net/http"
+	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -87,7 +88,7 @@ func TestClientAddrFromHeaders(t *testing.T) {
 		},
 	} {
 		t.Run(name, func(t *testing.T) {
-			ip, port := ClientAddrFromHeaders(tc.header)
+			ip, port := ClientAddrFromHeaders(tc.header, netip.MustParseAddr("10.1.2.3"), TrustAllProxies)
 			if tc.ip == "" {
 				assert.False(t, ip.IsValid())
 			} else {
@@ -99,6 +100,89 @@ func TestClientAddrFromHeaders(t *testing.T) {
 	}
 }
 
+func TestClientAddrFromHeadersTrustedProxies(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		peer   string
+		header http.Header
+		ip     string
+	}{
+		"untrusted peer": {
+			peer:   "1.2.3.4",
+			header: http.Header{headerXRealIP: []string{"123.0.0.1"}},
+		},
+		"trusted peer": {
+			peer:   "10.1.2.3",
+			header: http.Header{headerXRealIP: []string{"123.0.0.1"}},
+			ip:     "123.0.0.1",
+		},
+		"trusted IPv4-mapped IPv6 peer": {
+			peer:   "::ffff:192.168.1.1",
+			header: http.Header{headerXRealIP: []string{"123.0.0.1"}},
+			ip:     "123.0.0.1",
+		},
+		"unix socket peer": {
+			header: http.Header{headerXRealIP: []string{"123.0.0.1"}},
+			ip:     "123.0.0.1",
+		},
+		"X-Forwarded-For spoofed": {
+			peer:   "10.1.2.3",
+			header: http.Header{headerXForwardedFor: []string{"5.6.7.8, 123.0.0.1, 10.0.0.2"}},
+			ip:     "123.0.0.1",
+		},
+		"X-Forwarded-For multiple lines": {
+			peer:   "10.1.2.3",
+			header: http.Header{headerXForwardedFor: []string{"5.6.7.8, 123.0.0.1", "10.0.0.2"}},
+			ip:     "123.0.0.1",
+		},
+		"X-Forwarded-For all trusted": {
+			peer:   "10.1.2.3",
+			header: http.Header{headerXForwardedFor: []string{"10.0.0.3, 10.0.0.2"}},
+			ip:     "10.0.0.3",
+		},
+		"X-Forwarded-For invalid hop": {
+			peer:   "10.1.2.3",
+			header: http.Header{headerXForwardedFor: []string{"123.0.0.1, client.invalid, 10.0.0.2"}},
+		},
+		"Forwarded spoofed": {
+			peer:   "10.1.2.3",
+			header: http.Header{headerForwarded: []string{`for=5.6.7.8, for="123.0.0.1:4711";proto=https, for=10.0.0.2`}},
+			ip:     "123.0.0.1",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var peer netip.Addr
+			if tc.peer != "" {
+				peer = netip.MustParseAddr(tc.peer)
+			}
+			ip, _ := ClientAddrFromHeaders(tc.header, peer, trusted)
+			if tc.ip == "" {
+				assert.False(t, ip.IsValid())
+			} else {
+				assert.Equal(t, tc.ip, ip.String())
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.1.2.3/8", "192.168.1.1", "::ffff:172.16.0.1", "2001:db8::/32"})
+	require.NoError(t, err)
+	assert.Equal(t, TrustedProxies{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.1/32"),
+		netip.MustParsePrefix("172.16.0.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, trusted)
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.EqualError(t, err, `invalid trusted proxy "10.0.0.0/33": netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`)
+	_, err = ParseTrustedProxies([]string{"proxy.invalid"})
+	assert.Error(t, err)
+}
+
 func TestParseForwarded(t *testing.T) {
 	type test struct {
 		name   string
@@ -45,9 +45,10 @@ type Reporter func(context.Context, PendingReq) error
 // number of events active in the system can exceed the queue size. Only the number of
 // concurrent HTTP requests trying to publish at the same time is limited.
 type Publisher struct {
-	stopped chan struct{}
-	tracer  *apm.Tracer
-	client  beat.Client
+	stopped  chan struct{}
+	tracer   *apm.Tracer
+	client   beat.Client
+	enricher JSONEnricher
 
 	mu              sync.RWMutex
 	stopping        bool
@@ -63,6 +64,12 @@ type Transformer interface {
 	Transform(context.Context) []beat.Event
 }
 
+// JSONEnricher is an interface implemented by types that add fields
+// to the JSON encoding of events, such as client.geo.*.
+type JSONEnricher interface {
+	EnrichJSON(*fastjson.Writer, *model.APMEvent)
+}
+
 var (
 	ErrFull          = errors.New("queue is full")
 	ErrChannelClosed = errors.New("can't send batch, publisher is being stopped")
@@ -72,11 +79,14 @@ var (
 //
 // GOMAXPROCS goroutines are started for forwarding events to libbeat.
 // Stop must be called to close the beat.Client and free resources.
-func NewPublisher(pipeline beat.Pipeline, tracer *apm.Tracer) (*Publisher, error) {
+//
+// If enricher is non-nil, it is used to add fields to each event.
+func NewPublisher(pipeline beat.Pipeline, tracer *apm.Tracer, enricher JSONEnricher) (*Publisher, error) {
 	processingCfg := beat.ProcessingConfig{}
 	p := &Publisher{
-		tracer:  tracer,
-		stopped: make(chan struct{}),
+		tracer:   tracer,
+		enricher: enricher,
+		stopped:  make(chan struct{}),
 
 		// One request will be actively processed by the
 		// worker, while the other concurrent requests will be buffered in the queue.
@@ -142,7 +152,7 @@ func (p *Publisher) Stop(ctx context.Context) error {
 func (p *Publisher) ProcessBatch(ctx context.Context, batch *model.Batch) error {
 	b := make(model.Batch, len(*batch))
 	copy(b, *batch)
-	return p.Send(ctx, PendingReq{Transformable: batchTransformer(b)})
+	return p.Send(ctx, PendingReq{Transformable: batchTransformer{batch: b, enricher: p.enricher}})
 }
 
 // Send tries to forward pendingReq to the publishers worker. If the queue is full,
@@ -177,12 +187,16 @@ func (p *Publisher) run() {
 	}
 }
 
-type batchTransformer model.Batch
+type batchTransformer struct {
+	batch    model.Batch
+	enricher JSONEnricher
+}
 
 func (t batchTransformer) Transform(context.Context) []beat.Event {
-	out := make([]beat.Event, 0, len(t))
+	out := make([]beat.Event, 0, len(t.batch))
 	var w fastjson.Writer
-	for _, event := range t {
+	for i := range t.batch {
+		event := &t.batch[i]
 		// Encode the event to JSON, then decode into a map.
 		// This is probably a bit horrifying, but enables us to
 		// remove the libbeat dependency from our data model.
@@ -197,6 +211,9 @@ func (t batchTransformer) Transform(context.Context) []beat.Event {
 		if err := event.MarshalFastJSON(&w); err != nil {
 			continue
 		}
+		if t.enricher != nil {
+			t.enricher.EnrichJSON(&w, event)
+		}
 		beatEvent := beat.Event{Timestamp: event.Timestamp}
 		if err := json.Unmarshal(w.Bytes(), &beatEvent.Fields); err != nil {
 			continue
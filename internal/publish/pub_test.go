@@ -31,6 +31,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/fastjson"
 
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/idxmgmt"
@@ -51,7 +52,7 @@ func TestPublisherStop(t *testing.T) {
 	// Create a pipeline with a limited queue size and no outputs,
 	// so we can simulate a pipeline that blocks indefinitely.
 	pipeline, client := newBlockingPipeline(t)
-	publisher, err := publish.NewPublisher(pipeline, apmtest.DiscardTracer)
+	publisher, err := publish.NewPublisher(pipeline, apmtest.DiscardTracer, nil)
 	require.NoError(t, err)
 	defer func() {
 		cancelledContext, cancel := context.WithCancel(context.Background())
@@ -87,7 +88,7 @@ func TestPublisherStop(t *testing.T) {
 
 func TestPublisherStopShutdownInactive(t *testing.T) {
 	pipeline, _ := newBlockingPipeline(t)
-	publisher, err := publish.NewPublisher(pipeline, apmtest.DiscardTracer)
+	publisher, err := publish.NewPublisher(pipeline, apmtest.DiscardTracer, nil)
 	require.NoError(t, err)
 
 	// There are no active events, so the publisher should stop immediately
@@ -95,6 +96,32 @@ func TestPublisherStopShutdownInactive(t *testing.T) {
 	assert.NoError(t, publisher.Stop(context.Background()))
 }
 
+func TestPublisherEnrichJSON(t *testing.T) {
+	pipeline := &capturingPipeline{events: make(chan []beat.Event, 1)}
+	publisher, err := publish.NewPublisher(pipeline, apmtest.DiscardTracer, enricherFunc(
+		func(w *fastjson.Writer, event *model.APMEvent) {
+			w.Rewind(w.Size() - 1)
+			w.RawString(`,"enriched":`)
+			w.String(event.Service.Name)
+			w.RawByte('}')
+		},
+	))
+	require.NoError(t, err)
+	defer publisher.Stop(context.Background())
+
+	require.NoError(t, publisher.ProcessBatch(context.Background(), &model.Batch{{
+		Service: model.Service{Name: "service_name"},
+	}}))
+	select {
+	case events := <-pipeline.events:
+		require.Len(t, events, 1)
+		assert.Equal(t, "service_name", events[0].Fields["enriched"])
+		assert.Equal(t, map[string]interface{}{"name": "service_name"}, events[0].Fields["service"])
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for events")
+	}
+}
+
 func BenchmarkPublisher(b *testing.B) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -148,6 +175,7 @@ func BenchmarkPublisher(b *testing.B) {
 	publisher, err := publish.NewPublisher(
 		pipetool.WithACKer(pipeline, acker),
 		apmtest.DiscardTracer,
+		nil,
 	)
 	require.NoError(b, err)
 
@@ -210,6 +238,28 @@ func (f transformableFunc) Transform(ctx context.Context) []beat.Event {
 	return f(ctx)
 }
 
+type enricherFunc func(*fastjson.Writer, *model.APMEvent)
+
+func (f enricherFunc) EnrichJSON(w *fastjson.Writer, event *model.APMEvent) {
+	f(w, event)
+}
+
+type capturingPipeline struct {
+	events chan []beat.Event
+}
+
+func (p *capturingPipeline) Connect() (beat.Client, error) {
+	return p.ConnectWith(beat.ClientConfig{})
+}
+
+func (p *capturingPipeline) ConnectWith(beat.ClientConfig) (beat.Client, error) {
+	return p, nil
+}
+
+func (p *capturingPipeline) Publish(event beat.Event)       { p.PublishAll([]beat.Event{event}) }
+func (p *capturingPipeline) PublishAll(events []beat.Event) { p.events <- events }
+func (p *capturingPipeline) Close() error                   { return nil }
+
 type mockClient struct {
 	unblock chan struct{}
 }
@@ -0,0 +1,105 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+// MappingFetcher is an interface for fetching a parsed R8/ProGuard mapping
+// file with a given service name and service version.
+type MappingFetcher interface {
+	// FetchMapping fetches the parsed mapping file for a given service
+	// name and service version.
+	//
+	// If there is no such mapping file available, FetchMapping returns nil.
+	FetchMapping(ctx context.Context, name, version string) (*Mapping, error)
+}
+
+// CachingFetcher wraps a Fetcher, caching parsed mapping files in memory and
+// fetching from the wrapped Fetcher on cache misses or expired entries.
+type CachingFetcher struct {
+	cache      *lru.Cache
+	backend    Fetcher
+	expiration time.Duration
+	logger     *logp.Logger
+}
+
+type cacheEntry struct {
+	mapping *Mapping
+	expires time.Time
+}
+
+// NewCachingFetcher returns a CachingFetcher that wraps backend,
+// caching up to cacheSize parsed mapping files for the given expiration.
+//
+// Results reporting that no mapping file exists are also cached, so mapping
+// files uploaded for a service version will be picked up after at most one
+// expiration period.
+func NewCachingFetcher(backend Fetcher, cacheSize int, expiration time.Duration) (*CachingFetcher, error) {
+	logger := logp.NewLogger(logs.Stacktrace)
+	lruCache, err := lru.NewWithEvict(cacheSize, func(key, value interface{}) {
+		logger.Debugf("Removed id %v", key)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lru cache for caching fetcher: %w", err)
+	}
+	return &CachingFetcher{
+		cache:      lruCache,
+		backend:    backend,
+		expiration: expiration,
+		logger:     logger,
+	}, nil
+}
+
+// FetchMapping fetches a parsed mapping file from the cache, or fetches and
+// parses it using the wrapped backend.
+func (s *CachingFetcher) FetchMapping(ctx context.Context, name, version string) (*Mapping, error) {
+	key := identifier{name: name, version: version}
+	if val, found := s.cache.Get(key); found {
+		entry := val.(cacheEntry)
+		if time.Now().Before(entry.expires) {
+			return entry.mapping, nil
+		}
+		s.cache.Remove(key)
+	}
+
+	// fetch from the store, and cache all successful results
+	content, err := s.backend.Fetch(ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+	var mapping *Mapping
+	if content != nil {
+		if mapping, err = ParseMapping(bytes.NewReader(content)); err != nil {
+			return nil, fmt.Errorf("failed to parse mapping file: %w", err)
+		}
+	}
+	s.cache.Add(key, cacheEntry{mapping: mapping, expires: time.Now().Add(s.expiration)})
+	s.logger.Debugf("Added id %v. Cache now has %v entries.", key, s.cache.Len())
+	return mapping, nil
+}
@@ -0,0 +1,88 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingFetcher(t *testing.T) {
+	var calls int
+	var result []byte
+	var resultErr error
+	backend := fetcherFunc(func(ctx context.Context, name, version string) ([]byte, error) {
+		calls++
+		return result, resultErr
+	})
+
+	fetcher, err := NewCachingFetcher(backend, 10, time.Hour)
+	require.NoError(t, err)
+
+	// Errors are not cached.
+	resultErr = errors.New("boom")
+	_, err = fetcher.FetchMapping(context.Background(), "name", "1.0")
+	assert.EqualError(t, err, "boom")
+	resultErr = nil
+
+	// Missing mapping files are cached.
+	mapping, err := fetcher.FetchMapping(context.Background(), "name", "1.0")
+	require.NoError(t, err)
+	assert.Nil(t, mapping)
+	result = []byte(testMapping)
+	mapping, err = fetcher.FetchMapping(context.Background(), "name", "1.0")
+	require.NoError(t, err)
+	assert.Nil(t, mapping)
+	assert.Equal(t, 2, calls)
+
+	// Other service versions are fetched separately.
+	mapping, err = fetcher.FetchMapping(context.Background(), "name", "2.0")
+	require.NoError(t, err)
+	require.NotNil(t, mapping)
+	assert.Equal(t, 3, calls)
+
+	// The parsed mapping is cached, and is not parsed again.
+	cached, err := fetcher.FetchMapping(context.Background(), "name", "2.0")
+	require.NoError(t, err)
+	assert.Same(t, mapping, cached)
+	assert.Equal(t, 3, calls)
+}
+
+func TestCachingFetcherExpiration(t *testing.T) {
+	var calls int
+	backend := fetcherFunc(func(ctx context.Context, name, version string) ([]byte, error) {
+		calls++
+		return []byte(testMapping), nil
+	})
+
+	fetcher, err := NewCachingFetcher(backend, 10, time.Nanosecond)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		mapping, err := fetcher.FetchMapping(context.Background(), "name", "1.0")
+		require.NoError(t, err)
+		assert.NotNil(t, mapping)
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 2, calls)
+}
@@ -18,7 +18,6 @@
 package r8
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"regexp"
@@ -39,29 +38,28 @@ var (
 // Deobfuscate mutates the stacktrace by searching for those items through the mapFile, looking
 // for their de-obfuscated names and replacing the ones in the original stacktrace by their real names found within the mapFile.
 // Note that not all the stacktrace items might be present in the mapFile, for those cases, those frames will remain untouched.
+//
+// Deobfuscate parses the whole mapFile; use ParseMapping and Mapping.Deobfuscate
+// to deobfuscate multiple stacktraces using the same mapping file.
 func Deobfuscate(stacktrace *model.Stacktrace, mapFile io.Reader) error {
-	types, err := groupUniqueTypes(stacktrace)
+	mapping, err := ParseMapping(mapFile)
 	if err != nil {
 		return err
 	}
-	err = resolveMappings(types, mapFile)
-	if err != nil {
-		return err
-	}
-
+	mapping.Deobfuscate(stacktrace)
 	return nil
 }
 
 // Iterates over the stacktrace and groups the frames by classname, along with its methods, which are grouped by
 // the method name.
-func groupUniqueTypes(stacktrace *model.Stacktrace) (map[string]StacktraceType, error) {
+func groupUniqueTypes(stacktrace *model.Stacktrace) map[string]StacktraceType {
 	var types = make(map[string]StacktraceType)
 
 	for _, frame := range *stacktrace {
 		typeName := frame.Classname
 		methodName := frame.Function
 		sourceFileName := frame.Filename
-		if sourceFileName == "SourceFile" {
+		if sourceFileName == "SourceFile" && frame.Lineno != nil {
 			// Sometimes a method call in the stacktrace might end with (SourceFile:N), where N is an int. When this happens,
 			// it means that the de-obfuscated version of this method starts with "N:N" in the map file. So in those cases,
 			// we append the N to the method name M so that M:N becomes a "method reference" that we can later spot
@@ -84,75 +82,5 @@ func groupUniqueTypes(stacktrace *model.Stacktrace) (map[string]StacktraceType,
 		types[typeName] = typeItem
 	}
 
-	return types, nil
-}
-
-// Iterates over the classes and methods found in the map file while looking for classes previously found in the stacktrace.
-// When it finds a class from the stacktrace, it replaces the obfuscated class name and method names by
-// the ones found in the map.
-func resolveMappings(types map[string]StacktraceType, mapReader io.Reader) error {
-	scanner := bufio.NewScanner(mapReader)
-	var currentType *StacktraceType
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		typeMatch := typePattern.FindStringSubmatch(line)
-		if typeMatch != nil {
-			// Found a class declaration within the map.
-			obfuscatedName := typeMatch[2]
-			stacktraceType, ok := types[obfuscatedName]
-			if ok {
-				// The class found is also in the stacktrace.
-				currentType = &stacktraceType
-				for _, frames := range stacktraceType.methods {
-					for _, frame := range frames {
-						// Multiple frames might point to the same class, so we need to deobfuscate the class name for them all.
-						frame.Original.Classname = obfuscatedName
-						frame.Classname = typeMatch[1]
-						frame.SourcemapUpdated = true
-					}
-				}
-			} else {
-				// The class found is not part of the stacktrace. We need to clear the current type to avoid looping
-				// through this class' methods, as R8 maps list the classes' methods right below the class definition.
-				currentType = nil
-			}
-		} else if currentType != nil {
-			// We found a class in the map that is also in the stacktrace, so we enter here to loop through its methods.
-			methodMatch := methodPattern.FindStringSubmatch(line)
-			if methodMatch != nil {
-				// We found a method definition.
-				sourceFileStart := methodMatch[1]
-				sourceFileEnd := methodMatch[2]
-				methodObfuscatedName := methodMatch[4]
-				methodRealName := methodMatch[3]
-				methodKey := methodObfuscatedName
-				if sourceFileStart != "" && sourceFileStart == sourceFileEnd {
-					// This method might be compressed, in other words, its deobfuscated form might have multiple lines.
-					methodKey += ":" + sourceFileStart
-				}
-				frames, ok := currentType.methods[methodKey]
-				if ok {
-					// We found this method in the stacktrace too. Since a method might be referenced multiple times
-					// in a single stacktrace, we must make sure to deobfuscate them all.
-					for _, frame := range frames {
-						if frame.Original.Function == "" {
-							frame.Original.Function = methodObfuscatedName
-							frame.Function = methodRealName
-						} else {
-							// If it enters here, it means that this method is compressed and its first line was set
-							// previously, so now we have to append extra lines to it.
-							frame.Function += "\n" + methodRealName
-						}
-					}
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return err
-	}
-
-	return nil
+	return types
 }
@@ -0,0 +1,110 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+type esFetcher struct {
+	client *elasticsearch.Client
+	index  string
+	logger *logp.Logger
+}
+
+type esGetMappingResponse struct {
+	Found  bool `json:"found"`
+	Source struct {
+		Content string `json:"content"`
+	} `json:"_source"`
+}
+
+// NewElasticsearchFetcher returns a Fetcher for fetching mapping files stored
+// in Elasticsearch.
+func NewElasticsearchFetcher(c *elasticsearch.Client, index string) Fetcher {
+	logger := logp.NewLogger(logs.Stacktrace)
+	return &esFetcher{c, index, logger}
+}
+
+// Fetch fetches a mapping file from Elasticsearch.
+func (s *esFetcher) Fetch(ctx context.Context, name, version string) ([]byte, error) {
+	req := esapi.GetRequest{
+		Index:      s.index,
+		DocumentID: url.PathEscape(documentID(name, version)),
+	}
+	resp, err := req.Do(ctx, s.client)
+	if err != nil {
+		return nil, fmt.Errorf("failure querying ES: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		// Either the index or the document does not exist.
+		s.logger.Debugf("no mapping file found for %s %s", name, version)
+		return nil, nil
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ES response body: %w", err)
+		}
+		return nil, fmt.Errorf("ES returned unexpected status code: %s: %s", resp.Status(), string(b))
+	}
+
+	var result esGetMappingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode mapping file: %w", err)
+	}
+	if !result.Found || result.Source.Content == "" {
+		return nil, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(result.Source.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to base64 decode string: %w", err)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(decoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zlib reader: %w", err)
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mapping file content: %w", err)
+	}
+	return content, nil
+}
+
+func documentID(name, version string) string {
+	return name + "-" + version
+}
@@ -0,0 +1,105 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+func TestElasticsearchRoundTrip(t *testing.T) {
+	var indexed []byte
+	client := newMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/apm-r8/_doc/opbeans-android-1.0", r.URL.EscapedPath())
+		switch r.Method {
+		case http.MethodPut, http.MethodPost:
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			indexed = body
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			if indexed == nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]interface{}{"found": false})
+				return
+			}
+			var source json.RawMessage = indexed
+			json.NewEncoder(w).Encode(map[string]interface{}{"found": true, "_source": source})
+		}
+	})
+
+	fetcher := NewElasticsearchFetcher(client, "apm-r8")
+	content, err := fetcher.Fetch(context.Background(), "opbeans-android", "1.0")
+	require.NoError(t, err)
+	assert.Nil(t, content)
+
+	uploader := NewElasticsearchUploader(client, "apm-r8")
+	err = uploader.Upload(context.Background(), "opbeans-android", "1.0", []byte(testMapping))
+	require.NoError(t, err)
+
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(indexed, &doc))
+	assert.Equal(t, map[string]interface{}{"name": "opbeans-android", "version": "1.0"}, doc["service"])
+	assert.NotEmpty(t, doc["content_sha256"])
+
+	content, err = fetcher.Fetch(context.Background(), "opbeans-android", "1.0")
+	require.NoError(t, err)
+	assert.Equal(t, testMapping, string(content))
+}
+
+func TestElasticsearchFetcherError(t *testing.T) {
+	client := newMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	fetcher := NewElasticsearchFetcher(client, "apm-r8")
+	_, err := fetcher.Fetch(context.Background(), "opbeans-android", "1.0")
+	assert.EqualError(t, err, "ES returned unexpected status code: 403 Forbidden: ")
+}
+
+func TestUploaderMalformed(t *testing.T) {
+	client := newMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("unexpected request")
+	})
+	uploader := NewElasticsearchUploader(client, "apm-r8")
+	err := uploader.Upload(context.Background(), "opbeans-android", "1.0", []byte("not a mapping file"))
+	assert.ErrorIs(t, err, ErrMalformedMapping)
+}
+
+func newMockElasticsearchClient(t testing.TB, h http.HandlerFunc) *elasticsearch.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	config := elasticsearch.DefaultConfig()
+	config.Backoff.Init = time.Nanosecond
+	config.Hosts = []string{srv.URL}
+	client, err := elasticsearch.NewClient(config)
+	require.NoError(t, err)
+	return client
+}
@@ -0,0 +1,60 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrMalformedMapping is returned when mapping file content does not contain
+// any class mappings.
+var ErrMalformedMapping = errors.New("mapping file malformed")
+
+// Fetcher is an interface for fetching an R8/ProGuard mapping file with a
+// given service name and service version.
+type Fetcher interface {
+	// Fetch fetches the mapping file content for a given service name and
+	// service version.
+	//
+	// If there is no such mapping file available, Fetch returns nil.
+	Fetch(ctx context.Context, name, version string) ([]byte, error)
+}
+
+type identifier struct {
+	name    string
+	version string
+}
+
+// validateMapping checks that content looks like an R8/ProGuard mapping file,
+// i.e. it contains at least one class mapping.
+func validateMapping(content []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if typePattern.Match(scanner.Bytes()) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedMapping, err)
+	}
+	return fmt.Errorf("%w: no class mappings found", ErrMalformedMapping)
+}
@@ -0,0 +1,126 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/elastic/apm-data/model"
+)
+
+// Mapping holds a parsed R8/ProGuard mapping file, indexed by obfuscated
+// class name, so multiple stack traces can be deobfuscated without
+// rescanning the mapping file.
+type Mapping struct {
+	classes map[string]*mappedClass
+}
+
+type mappedClass struct {
+	name string
+	// methods holds the class' methods in the order they appear in the
+	// mapping file, which matters for compressed methods spanning multiple
+	// lines.
+	methods []mappedMethod
+}
+
+type mappedMethod struct {
+	// key holds the obfuscated method name, suffixed with ":N" for methods
+	// which might be compressed. See groupUniqueTypes.
+	key            string
+	obfuscatedName string
+	name           string
+}
+
+// ParseMapping parses the mapping file read from r.
+func ParseMapping(r io.Reader) (*Mapping, error) {
+	m := &Mapping{classes: make(map[string]*mappedClass)}
+	scanner := bufio.NewScanner(r)
+	var currentClass *mappedClass
+	for scanner.Scan() {
+		line := scanner.Text()
+		if typeMatch := typePattern.FindStringSubmatch(line); typeMatch != nil {
+			// Found a class declaration; R8 maps list the classes'
+			// methods right below the class definition.
+			obfuscatedName := typeMatch[2]
+			currentClass = m.classes[obfuscatedName]
+			if currentClass == nil {
+				currentClass = &mappedClass{}
+				m.classes[obfuscatedName] = currentClass
+			}
+			currentClass.name = typeMatch[1]
+			continue
+		}
+		if currentClass == nil {
+			continue
+		}
+		if methodMatch := methodPattern.FindStringSubmatch(line); methodMatch != nil {
+			sourceFileStart := methodMatch[1]
+			sourceFileEnd := methodMatch[2]
+			method := mappedMethod{
+				key:            methodMatch[4],
+				obfuscatedName: methodMatch[4],
+				name:           methodMatch[3],
+			}
+			if sourceFileStart != "" && sourceFileStart == sourceFileEnd {
+				// This method might be compressed, in other words, its
+				// deobfuscated form might have multiple lines.
+				method.key += ":" + sourceFileStart
+			}
+			currentClass.methods = append(currentClass.methods, method)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Deobfuscate mutates the stacktrace, replacing obfuscated class and method
+// names with the real names found in the mapping. Frames which are not found
+// in the mapping remain untouched.
+func (m *Mapping) Deobfuscate(stacktrace *model.Stacktrace) {
+	for obfuscatedName, stacktraceType := range groupUniqueTypes(stacktrace) {
+		class, ok := m.classes[obfuscatedName]
+		if !ok {
+			continue
+		}
+		for _, frames := range stacktraceType.methods {
+			for _, frame := range frames {
+				// Multiple frames might point to the same class, so we need to deobfuscate the class name for them all.
+				frame.Original.Classname = obfuscatedName
+				frame.Classname = class.name
+				frame.SourcemapUpdated = true
+			}
+		}
+		for _, method := range class.methods {
+			// A method might be referenced multiple times in a single
+			// stacktrace, so we must make sure to deobfuscate them all.
+			for _, frame := range stacktraceType.methods[method.key] {
+				if frame.Original.Function == "" {
+					frame.Original.Function = method.obfuscatedName
+					frame.Function = method.name
+				} else {
+					// This method is compressed and its first line was set
+					// previously, so now we have to append extra lines to it.
+					frame.Function += "\n" + method.name
+				}
+			}
+		}
+	}
+}
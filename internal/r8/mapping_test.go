@@ -0,0 +1,76 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-data/model"
+)
+
+func TestMappingDeobfuscate(t *testing.T) {
+	mapping, err := ParseMapping(strings.NewReader(`# compiler: R8
+com.example.MainActivity -> a.b:
+    void onCreate(android.os.Bundle) -> a
+    1:1:void com.example.Inlined.first():10:10 -> c
+    1:1:void onResume():20 -> c
+com.example.Other -> a.c:
+    void run() -> b
+`))
+	require.NoError(t, err)
+
+	// The same parsed mapping may be used for multiple stacktraces.
+	for i := 0; i < 2; i++ {
+		stacktrace := model.Stacktrace{
+			createStacktraceFrame(4, "Unknown Source", "a.b", "a"),
+			createStacktraceFrame(1, "SourceFile", "a.b", "c"),
+			createStacktraceFrame(5, "Unknown Source", "a.c", "b"),
+			createStacktraceFrame(6, "Unknown Source", "a.d", "e"),
+		}
+		mapping.Deobfuscate(&stacktrace)
+		verifyFrames(t, stacktrace, []FrameValidation{{
+			updated:           true,
+			classname:         "com.example.MainActivity",
+			function:          "onCreate",
+			originalClassname: "a.b",
+			originalFunction:  "a",
+			lineno:            4,
+		}, {
+			updated:           true,
+			classname:         "com.example.MainActivity",
+			function:          "com.example.Inlined.first\nonResume",
+			originalClassname: "a.b",
+			originalFunction:  "c",
+			lineno:            1,
+		}, {
+			updated:           true,
+			classname:         "com.example.Other",
+			function:          "run",
+			originalClassname: "a.c",
+			originalFunction:  "b",
+			lineno:            5,
+		}, {
+			classname: "a.d",
+			function:  "e",
+			lineno:    6,
+		}})
+	}
+}
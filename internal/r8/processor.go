@@ -0,0 +1,122 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"context"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-data/model"
+)
+
+// androidAgentName is the agent name reported by the Elastic APM Android agent.
+const androidAgentName = "android/java"
+
+// BatchProcessor is a model.BatchProcessor that deobfuscates the exception
+// and log stack traces of errors reported by Android agents, using the R8 or
+// ProGuard mapping file for the service name and version.
+//
+// Any errors fetching mapping files, including the timeout expiring, will
+// result in the StacktraceFrame.SourcemapError field being set; the error
+// will not be returned.
+type BatchProcessor struct {
+	// Fetcher is the MappingFetcher to use for fetching mapping files.
+	Fetcher MappingFetcher
+
+	// Timeout holds a timeout for each ProcessBatch call, to limit how
+	// much time is spent fetching mapping files.
+	//
+	// If Timeout is <= 0, it will be ignored.
+	Timeout time.Duration
+
+	Logger *logp.Logger
+}
+
+// ProcessBatch processes Android errors, deobfuscating their stack traces.
+func (p BatchProcessor) ProcessBatch(ctx context.Context, batch *model.Batch) error {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	for _, event := range *batch {
+		if event.Error == nil || event.Agent.Name != androidAgentName {
+			continue
+		}
+		if event.Service.Name == "" || event.Service.Version == "" {
+			continue
+		}
+		stacktrace := errorStacktraceFrames(event.Error)
+		if len(stacktrace) == 0 {
+			continue
+		}
+		p.processStacktrace(ctx, &event.Service, stacktrace)
+	}
+	return nil
+}
+
+func (p BatchProcessor) processStacktrace(ctx context.Context, service *model.Service, stacktrace model.Stacktrace) {
+	mapping, err := p.Fetcher.FetchMapping(ctx, service.Name, service.Version)
+	if err != nil {
+		for _, frame := range stacktrace {
+			frame.SourcemapError = err.Error()
+		}
+		p.Logger.Debugf("failed to fetch mapping file for %s %s: %s", service.Name, service.Version, err)
+		return
+	}
+	if mapping == nil {
+		return
+	}
+	mapping.Deobfuscate(&stacktrace)
+}
+
+// errorStacktraceFrames returns the unique frames of the error's log and
+// exception stack traces, including those of exception causes, so they can
+// be deobfuscated with a single pass over the mapping file.
+//
+// Frames may be shared between an exception and its causes, so frames are
+// deduplicated to ensure they are deobfuscated at most once.
+func errorStacktraceFrames(e *model.Error) model.Stacktrace {
+	var frames model.Stacktrace
+	seen := make(map[*model.StacktraceFrame]struct{})
+	add := func(stacktrace model.Stacktrace) {
+		for _, frame := range stacktrace {
+			if _, ok := seen[frame]; ok || frame == nil {
+				continue
+			}
+			seen[frame] = struct{}{}
+			frames = append(frames, frame)
+		}
+	}
+	var addException func(*model.Exception)
+	addException = func(exception *model.Exception) {
+		add(exception.Stacktrace)
+		for i := range exception.Cause {
+			addException(&exception.Cause[i])
+		}
+	}
+	if e.Log != nil {
+		add(e.Log.Stacktrace)
+	}
+	if e.Exception != nil {
+		addException(e.Exception)
+	}
+	return frames
+}
@@ -0,0 +1,147 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-data/model"
+)
+
+const testMapping = `# compiler: R8
+com.example.MainActivity -> a.b:
+    void onCreate(android.os.Bundle) -> a
+com.example.Other -> a.c:
+    void run() -> b
+`
+
+func TestBatchProcessor(t *testing.T) {
+	var fetched []identifier
+	fetcher := fetcherFunc(func(ctx context.Context, name, version string) ([]byte, error) {
+		fetched = append(fetched, identifier{name: name, version: version})
+		return []byte(testMapping), nil
+	})
+
+	sharedFrame := createStacktraceFrame(4, "Unknown Source", "a.b", "a")
+	logFrame := createStacktraceFrame(5, "Unknown Source", "a.c", "b")
+	service := model.Service{Name: "opbeans-android", Version: "1.0"}
+	android := model.Agent{Name: "android/java"}
+	batch := model.Batch{{
+		Agent:   android,
+		Service: service,
+		Error: &model.Error{
+			Log: &model.ErrorLog{Stacktrace: model.Stacktrace{logFrame}},
+			Exception: &model.Exception{
+				Stacktrace: model.Stacktrace{sharedFrame},
+				Cause: []model.Exception{{
+					Stacktrace: model.Stacktrace{sharedFrame},
+				}},
+			},
+		},
+	}, {
+		// Non-Android errors are not deobfuscated.
+		Agent:   model.Agent{Name: "java"},
+		Service: service,
+		Error: &model.Error{
+			Exception: &model.Exception{
+				Stacktrace: model.Stacktrace{createStacktraceFrame(4, "Unknown Source", "a.b", "a")},
+			},
+		},
+	}, {
+		// Errors without service version are not deobfuscated.
+		Agent:   android,
+		Service: model.Service{Name: "opbeans-android"},
+		Error: &model.Error{
+			Exception: &model.Exception{
+				Stacktrace: model.Stacktrace{createStacktraceFrame(4, "Unknown Source", "a.b", "a")},
+			},
+		},
+	}, {
+		Agent:       android,
+		Service:     service,
+		Transaction: &model.Transaction{},
+	}}
+
+	processor := newTestBatchProcessor(t, fetcher)
+	err := processor.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	assert.Equal(t, []identifier{{name: "opbeans-android", version: "1.0"}}, fetched)
+	verifyFrame(t, sharedFrame, FrameValidation{
+		updated:           true,
+		classname:         "com.example.MainActivity",
+		function:          "onCreate",
+		originalClassname: "a.b",
+		originalFunction:  "a",
+		lineno:            4,
+	})
+	verifyFrame(t, logFrame, FrameValidation{
+		updated:           true,
+		classname:         "com.example.Other",
+		function:          "run",
+		originalClassname: "a.c",
+		originalFunction:  "b",
+		lineno:            5,
+	})
+	for _, event := range batch[1:3] {
+		frame := event.Error.Exception.Stacktrace[0]
+		assert.False(t, frame.SourcemapUpdated)
+		assert.Equal(t, "a.b", frame.Classname)
+	}
+}
+
+func TestBatchProcessorFetchError(t *testing.T) {
+	fetcher := fetcherFunc(func(ctx context.Context, name, version string) ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	frame := createStacktraceFrame(4, "Unknown Source", "a.b", "a")
+	batch := model.Batch{{
+		Agent:   model.Agent{Name: "android/java"},
+		Service: model.Service{Name: "opbeans-android", Version: "1.0"},
+		Error: &model.Error{
+			Exception: &model.Exception{Stacktrace: model.Stacktrace{frame}},
+		},
+	}}
+
+	processor := newTestBatchProcessor(t, fetcher)
+	err := processor.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	assert.Equal(t, "boom", frame.SourcemapError)
+	assert.False(t, frame.SourcemapUpdated)
+	assert.Equal(t, "a.b", frame.Classname)
+}
+
+func newTestBatchProcessor(t testing.TB, backend Fetcher) BatchProcessor {
+	fetcher, err := NewCachingFetcher(backend, 10, time.Hour)
+	require.NoError(t, err)
+	return BatchProcessor{Fetcher: fetcher, Logger: logp.NewLogger("")}
+}
+
+type fetcherFunc func(ctx context.Context, name, version string) ([]byte, error)
+
+func (f fetcherFunc) Fetch(ctx context.Context, name, version string) ([]byte, error) {
+	return f(ctx, name, version)
+}
@@ -0,0 +1,126 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package r8
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+// Uploader is an interface for storing an R8/ProGuard mapping file with a
+// given service name and service version.
+type Uploader interface {
+	// Upload stores the given mapping file content.
+	//
+	// If content is not a valid mapping file, Upload returns an error
+	// wrapping ErrMalformedMapping.
+	Upload(ctx context.Context, name, version string, content []byte) error
+}
+
+type esUploader struct {
+	client *elasticsearch.Client
+	index  string
+	logger *logp.Logger
+}
+
+// esMappingDocument holds the document stored for a mapping file. This must
+// be kept in sync with the fields read by esFetcher.
+type esMappingDocument struct {
+	Created time.Time `json:"created"`
+	Service struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"service"`
+	Content     string `json:"content"`
+	ContentHash string `json:"content_sha256"`
+}
+
+// NewElasticsearchUploader returns an Uploader for storing mapping files in
+// Elasticsearch, such that they can be fetched by the Fetcher returned by
+// NewElasticsearchFetcher.
+func NewElasticsearchUploader(c *elasticsearch.Client, index string) Uploader {
+	logger := logp.NewLogger(logs.Stacktrace)
+	return &esUploader{c, index, logger}
+}
+
+// Upload validates and stores a mapping file in Elasticsearch, replacing any
+// existing mapping file with the same service name and version.
+func (s *esUploader) Upload(ctx context.Context, name, version string, content []byte) error {
+	if err := validateMapping(content); err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(content); err != nil {
+		return fmt.Errorf("failed to compress mapping file: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress mapping file: %w", err)
+	}
+	contentHash := sha256.Sum256(content)
+
+	var doc esMappingDocument
+	doc.Created = time.Now().UTC()
+	doc.Service.Name = name
+	doc.Service.Version = version
+	doc.Content = base64.StdEncoding.EncodeToString(compressed.Bytes())
+	doc.ContentHash = hex.EncodeToString(contentHash[:])
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode mapping file document: %w", err)
+	}
+	id := documentID(name, version)
+	req := esapi.IndexRequest{
+		Index:      s.index,
+		DocumentID: url.PathEscape(id),
+		Body:       bytes.NewReader(body),
+	}
+	resp, err := req.Do(ctx, s.client)
+	if err != nil {
+		return fmt.Errorf("failure indexing mapping file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read ES response body: %w", err)
+		}
+		return fmt.Errorf("ES returned unexpected status code: %s: %s", resp.Status(), string(b))
+	}
+	s.logger.Debugf("Uploaded mapping file %s", id)
+	return nil
+}
@@ -124,14 +124,21 @@ func (s *esFetcher) Fetch(ctx context.Context, name, version, path string) (*sou
 }
 
 func (s *esFetcher) runSearchQuery(ctx context.Context, name, version, path string) (*esapi.Response, error) {
-	id := name + "-" + version + "-" + path
 	req := esapi.GetRequest{
 		Index:      s.index,
-		DocumentID: url.PathEscape(id),
+		DocumentID: documentID(name, version, path),
 	}
 	return req.Do(ctx, s.client)
 }
 
+// documentID returns the ID of the source map document for the given
+// service name, version and bundle path, escaped for use as a request
+// path segment. esapi does not escape document IDs, so this must be
+// escaped exactly once here for Elasticsearch to store the original ID.
+func documentID(name, version, path string) string {
+	return url.PathEscape(name + "-" + version + "-" + path)
+}
+
 func parse(body io.ReadCloser, name, version, path string, logger *logp.Logger) (string, error) {
 	var esSourcemapResponse esGetSourcemapResponse
 	if err := json.NewDecoder(body).Decode(&esSourcemapResponse); err != nil {
@@ -27,8 +27,10 @@ import (
 )
 
 var (
-	errFetcherUnvailable  = errors.New("fetcher unavailable")
-	errMalformedSourcemap = errors.New("sourcemap malformed")
+	errFetcherUnvailable = errors.New("fetcher unavailable")
+
+	// ErrMalformedSourcemap is returned when source map content cannot be parsed.
+	ErrMalformedSourcemap = errors.New("sourcemap malformed")
 )
 
 // Fetcher is an interface for fetching a source map with a given service name, service version,
@@ -110,7 +112,7 @@ func parseSourceMap(data []byte) (*sourcemap.Consumer, error) {
 	}
 	consumer, err := sourcemap.Parse("", data)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", errMalformedSourcemap, err)
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSourcemap, err)
 	}
 	return consumer, nil
 }
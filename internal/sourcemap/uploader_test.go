@@ -0,0 +1,123 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sourcemap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+func TestESUploaderRoundTrip(t *testing.T) {
+	var indexed []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		switch r.Method {
+		case http.MethodPut, http.MethodPost:
+			assert.Equal(t, "/apm-sourcemap/_doc/service-1.0-http:%2F%2Flocalhost%2Fbundle.js", r.URL.EscapedPath())
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			indexed = body
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			assert.Equal(t, "/apm-sourcemap/_doc/service-1.0-http:%2F%2Flocalhost%2Fbundle.js", r.URL.EscapedPath())
+			var source json.RawMessage = indexed
+			json.NewEncoder(w).Encode(map[string]interface{}{"found": indexed != nil, "_source": source})
+		}
+	}))
+	defer srv.Close()
+
+	cfg := elasticsearch.DefaultConfig()
+	cfg.Backoff.Init = time.Nanosecond
+	cfg.Hosts = []string{srv.URL}
+	client, err := elasticsearch.NewClient(cfg)
+	require.NoError(t, err)
+
+	uploader := NewElasticsearchUploader(client, "apm-sourcemap")
+	err = uploader.Upload(context.Background(), "service", "1.0", "http://localhost/bundle.js", []byte(validSourcemap))
+	require.NoError(t, err)
+
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(indexed, &doc))
+	assert.Equal(t, map[string]interface{}{"name": "service", "version": "1.0"}, doc["service"])
+	assert.Equal(t, map[string]interface{}{"path": "http://localhost/bundle.js"}, doc["file"])
+	assert.NotEmpty(t, doc["content_sha256"])
+	assert.NotEmpty(t, doc["created"])
+
+	consumer, err := testESFetcher(client).Fetch(context.Background(), "service", "1.0", "http://localhost/bundle.js")
+	require.NoError(t, err)
+	require.NotNil(t, consumer)
+	assert.Equal(t, "bundle.js", consumer.File())
+}
+
+func TestESUploaderDocumentID(t *testing.T) {
+	// Elasticsearch decodes the request path once, so document
+	// IDs must be escaped exactly once to be stored unmodified.
+	for _, path := range []string{
+		"http://localhost/bundle.js",
+		"http://localhost/bundle%20v1.js",
+		"/static/js/my bundle.js?v=1#hash",
+	} {
+		var docIDs []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Elastic-Product", "Elasticsearch")
+			docIDs = append(docIDs, strings.TrimPrefix(r.URL.Path, "/apm-sourcemap/_doc/"))
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer srv.Close()
+
+		cfg := elasticsearch.DefaultConfig()
+		cfg.Hosts = []string{srv.URL}
+		client, err := elasticsearch.NewClient(cfg)
+		require.NoError(t, err)
+
+		uploader := NewElasticsearchUploader(client, "apm-sourcemap")
+		err = uploader.Upload(context.Background(), "service", "1.0", path, []byte(validSourcemap))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"service-1.0-" + path}, docIDs)
+	}
+}
+
+func TestESUploaderMalformed(t *testing.T) {
+	client := newUnavailableElasticsearchClient(t)
+	uploader := NewElasticsearchUploader(client, "apm-sourcemap")
+
+	err := uploader.Upload(context.Background(), "service", "1.0", "bundle.js", nil)
+	assert.ErrorIs(t, err, ErrMalformedSourcemap)
+
+	err = uploader.Upload(context.Background(), "service", "1.0", "bundle.js", []byte("{invalid"))
+	assert.ErrorIs(t, err, ErrMalformedSourcemap)
+}
+
+func TestESUploaderError(t *testing.T) {
+	client := newMockElasticsearchClient(t, http.StatusForbidden, nil)
+	uploader := NewElasticsearchUploader(client, "apm-sourcemap")
+
+	err := uploader.Upload(context.Background(), "service", "1.0", "bundle.js", []byte(validSourcemap))
+	assert.EqualError(t, err, "ES returned unexpected status code: 403 Forbidden: ")
+}
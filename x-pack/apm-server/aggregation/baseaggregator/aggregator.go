@@ -5,6 +5,7 @@
 package baseaggregator
 
 import (
+	"bytes"
 	"context"
 	"sync"
 	"time"
@@ -12,11 +13,25 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/snapshot"
 )
 
+// stateFileMu serialises access to state files, so that an aggregator
+// being stopped and its replacement (e.g. after a config reload) do not
+// both read the same state file.
+var stateFileMu sync.Mutex
+
 // PublishFunc encapsulates the metric publishing function
 type PublishFunc func(context.Context, time.Duration) error
 
+// EncodeStateFunc encodes the in-flight aggregation state for an interval.
+type EncodeStateFunc func(time.Duration, *snapshot.Encoder)
+
+// DecodeStateFunc decodes aggregation state for an interval, previously
+// encoded by an EncodeStateFunc, and merges it into the in-flight state.
+type DecodeStateFunc func(time.Duration, *snapshot.Decoder) error
+
 // AggregatorConfig defined the common aggregator configuration.
 type AggregatorConfig struct {
 	// PublishFunc is the function that performs the actual publishing
@@ -38,6 +53,28 @@ type AggregatorConfig struct {
 	// There may be additional metrics reported at arbitrary times if the
 	// aggregation groups fill up.
 	Interval time.Duration
+
+	// StateFile holds the path of a file to which in-flight aggregation
+	// state is written when the aggregator is stopped, in place of
+	// publishing metrics for incomplete intervals. The state is restored
+	// by LoadState, and by Run whenever the file is (re)created.
+	//
+	// If StateFile is empty, state is not persisted.
+	StateFile string
+
+	// EncodeStateFunc encodes in-flight state for writing to StateFile.
+	// EncodeStateFunc must be specified if StateFile is non-empty.
+	EncodeStateFunc EncodeStateFunc
+
+	// DecodeStateFunc decodes state read from StateFile.
+	// DecodeStateFunc must be specified if StateFile is non-empty.
+	DecodeStateFunc DecodeStateFunc
+
+	// StateHeader holds optional data identifying the configuration
+	// affecting the encoding of state, such as aggregation dimensions.
+	// StateHeader is written to StateFile, and state written with a
+	// different StateHeader is discarded rather than decoded.
+	StateHeader []byte
 }
 
 // Validate validates the aggregator config.
@@ -45,6 +82,9 @@ func (cfg AggregatorConfig) Validate() error {
 	if cfg.PublishFunc == nil {
 		return errors.New("PublishFunc unspecified")
 	}
+	if cfg.StateFile != "" && (cfg.EncodeStateFunc == nil || cfg.DecodeStateFunc == nil) {
+		return errors.New("EncodeStateFunc and DecodeStateFunc must be specified with StateFile")
+	}
 	if cfg.Interval <= 0 {
 		return errors.New("Interval unspecified or negative")
 	}
@@ -110,6 +150,18 @@ func (a *Aggregator) Run() error {
 			stop = true
 		case <-ticker.C:
 			ticks++
+			// Another aggregator may have written its state after this one
+			// was created, such as when the server is reloaded.
+			a.LoadState()
+		}
+		if stop && a.config.StateFile != "" {
+			err := a.saveState()
+			if err == nil {
+				continue
+			}
+			a.config.Logger.With(logp.Error(err)).Warnf(
+				"failed to persist aggregation state, publishing metrics instead: %s", err,
+			)
 		}
 		// Publish the metricsets for all configured intervals.
 		for _, interval := range a.Intervals {
@@ -132,6 +184,8 @@ func (a *Aggregator) Run() error {
 
 // Stop stops the Aggregator if it is running, waiting for it to flush any
 // aggregated metrics and return, or for the context to be cancelled.
+// If a state file is configured, in-flight state is written to the file
+// rather than being published.
 //
 // After Stop has been called the aggregator cannot be reused, as the Run
 // method will always return immediately.
@@ -153,3 +207,97 @@ func (a *Aggregator) Stop(ctx context.Context) error {
 	}
 	return nil
 }
+
+// LoadState restores in-flight aggregation state from the configured
+// state file, if it exists, and removes the file. LoadState should be
+// called after the aggregator's state has been initialised, and may be
+// called concurrently with aggregation.
+//
+// Errors are logged rather than returned: state that cannot be restored
+// is discarded.
+func (a *Aggregator) LoadState() {
+	if a.config.StateFile == "" {
+		return
+	}
+	stateFileMu.Lock()
+	defer stateFileMu.Unlock()
+	if err := a.loadState(); err != nil {
+		a.config.Logger.With(logp.Error(err)).Warnf(
+			"failed to restore aggregation state from %s: %s", a.config.StateFile, err,
+		)
+	}
+}
+
+// loadState reads and decodes the state file. stateFileMu must be held.
+func (a *Aggregator) loadState() error {
+	data, err := snapshot.ReadFile(a.config.StateFile)
+	if err != nil || data == nil {
+		return err
+	}
+	d := snapshot.NewDecoder(data)
+	header := d.Bytes()
+	if err := d.Err(); err != nil {
+		return err
+	}
+	if !bytes.Equal(header, a.config.StateHeader) {
+		a.config.Logger.Infof(
+			"discarding aggregation state from %s written with a different configuration",
+			a.config.StateFile,
+		)
+		return nil
+	}
+	for d.Len() > 0 {
+		interval := time.Duration(d.Varint())
+		state := d.Bytes()
+		if err := d.Err(); err != nil {
+			return err
+		}
+		if !a.hasInterval(interval) {
+			a.config.Logger.Debugf("discarding aggregation state for unconfigured %s interval", interval)
+			continue
+		}
+		if err := a.config.DecodeStateFunc(interval, snapshot.NewDecoder(state)); err != nil {
+			return errors.Wrapf(err, "failed to decode %s aggregation state", interval)
+		}
+	}
+	a.config.Logger.Infof("restored aggregation state from %s", a.config.StateFile)
+	return nil
+}
+
+// saveState writes in-flight state for all intervals to the state file,
+// first merging any state that has been written to the file since it was
+// last loaded.
+func (a *Aggregator) saveState() error {
+	stateFileMu.Lock()
+	defer stateFileMu.Unlock()
+	if err := a.loadState(); err != nil {
+		a.config.Logger.With(logp.Error(err)).Warnf(
+			"failed to restore aggregation state from %s: %s", a.config.StateFile, err,
+		)
+	}
+	var e snapshot.Encoder
+	e.PutBytes(a.config.StateHeader)
+	for _, interval := range a.Intervals {
+		var state snapshot.Encoder
+		a.config.EncodeStateFunc(interval, &state)
+		if err := state.Err(); err != nil {
+			return errors.Wrapf(err, "failed to encode %s aggregation state", interval)
+		}
+		e.PutVarint(int64(interval))
+		e.PutBytes(state.Bytes())
+	}
+	if err := snapshot.WriteFile(a.config.StateFile, e.Bytes()); err != nil {
+		return errors.Wrap(err, "failed to write aggregation state")
+	}
+	a.config.Logger.Infof("wrote aggregation state to %s", a.config.StateFile)
+	return nil
+}
+
+func (a *Aggregator) hasInterval(interval time.Duration) bool {
+	for _, v := range a.Intervals {
+		if v == interval {
+			return true
+		}
+	}
+	return false
+}
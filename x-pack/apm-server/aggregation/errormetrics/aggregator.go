@@ -0,0 +1,439 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package errormetrics
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axiomhq/hyperloglog"
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/baseaggregator"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/interval"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/labels"
+)
+
+const (
+	metricsetName       = "error"
+	overflowServiceName = "_other"
+
+	// dataStreamType and dataStreamDatasetPrefix are used for routing error
+	// metrics to their own data streams. The default routing would otherwise
+	// treat them as internal metrics, as they have no transaction or span.
+	dataStreamType          = "metrics"
+	dataStreamDatasetPrefix = "apm.error."
+)
+
+// AggregatorConfig holds configuration for creating an Aggregator.
+type AggregatorConfig struct {
+	// BatchProcessor is a model.BatchProcessor for asynchronously
+	// processing metrics documents.
+	BatchProcessor model.BatchProcessor
+
+	// Logger is the logger for logging metrics aggregation/publishing.
+	//
+	// If Logger is nil, a new logger will be constructed.
+	Logger *logp.Logger
+
+	// RollUpIntervals are additional MetricsInterval for the aggregator to
+	// compute and publish metrics for. Each additional interval is constrained
+	// to the same rules as MetricsInterval, and will result in additional
+	// memory to be allocated.
+	RollUpIntervals []time.Duration
+
+	// Interval is the interval between publishing of aggregated metrics.
+	Interval time.Duration
+
+	// MaxGroups is the maximum number of distinct error metrics to store
+	// within an aggregation period. Once this number of groups is reached,
+	// any new aggregation keys will be aggregated in a dedicated service
+	// group identified by `_other`.
+	MaxGroups int
+
+	// StateFile, if non-empty, holds the path of a file to which in-flight
+	// aggregation state is written when the aggregator is stopped, and from
+	// which it is restored when a new aggregator is created.
+	StateFile string
+}
+
+// Validate validates the aggregator config.
+func (config AggregatorConfig) Validate() error {
+	if config.BatchProcessor == nil {
+		return errors.New("BatchProcessor unspecified")
+	}
+	if config.MaxGroups <= 0 {
+		return errors.New("MaxGroups unspecified or negative")
+	}
+	return nil
+}
+
+// Aggregator aggregates errors, periodically publishing error metrics.
+type Aggregator struct {
+	*baseaggregator.Aggregator
+	config  AggregatorConfig
+	metrics *aggregatorMetrics
+
+	mu sync.RWMutex
+	// These two metricsBuffer are set to the same size and act as buffers
+	// for caching and then publishing the metrics as batches.
+	active, inactive map[time.Duration]*metricsBuffer
+}
+
+type aggregatorMetrics struct {
+	activeGroups int64
+	overflow     int64
+}
+
+// NewAggregator returns a new Aggregator with the given config.
+func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid aggregator config")
+	}
+	if config.Logger == nil {
+		config.Logger = logp.NewLogger(logs.ErrorMetrics)
+	}
+	aggregator := Aggregator{
+		config:   config,
+		metrics:  &aggregatorMetrics{},
+		active:   make(map[time.Duration]*metricsBuffer),
+		inactive: make(map[time.Duration]*metricsBuffer),
+	}
+	base, err := baseaggregator.New(baseaggregator.AggregatorConfig{
+		PublishFunc:     aggregator.publish, // inject local publish
+		Logger:          config.Logger,
+		Interval:        config.Interval,
+		RollUpIntervals: config.RollUpIntervals,
+		StateFile:       config.StateFile,
+		EncodeStateFunc: aggregator.encodeState,
+		DecodeStateFunc: aggregator.decodeState,
+	})
+	if err != nil {
+		return nil, err
+	}
+	aggregator.Aggregator = base
+	for _, interval := range aggregator.Intervals {
+		aggregator.active[interval] = newMetricsBuffer(config.MaxGroups)
+		aggregator.inactive[interval] = newMetricsBuffer(config.MaxGroups)
+	}
+	aggregator.LoadState()
+	return &aggregator, nil
+}
+
+// CollectMonitoring may be called to collect monitoring metrics from the
+// aggregation. It is intended to be used with libbeat/monitoring.NewFunc.
+//
+// The metrics should be added to the "apm-server.aggregation.errormetrics" registry.
+func (a *Aggregator) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	activeGroups := int64(atomic.LoadInt64(&a.metrics.activeGroups))
+	overflowed := int64(atomic.LoadInt64(&a.metrics.overflow))
+	monitoring.ReportInt(V, "active_groups", activeGroups)
+	monitoring.ReportInt(V, "overflowed.total", overflowed)
+}
+
+func (a *Aggregator) publish(ctx context.Context, period time.Duration) error {
+	// We hold a.mu only long enough to swap the error metrics. This will
+	// be blocked by error metrics updates, which is OK, as we prefer not
+	// to block error metrics updaters. After the lock is released nothing
+	// will be accessing a.inactive.
+	a.mu.Lock()
+
+	// EXPLAIN: We swap active <-> inactive, so that we're only working on the
+	// inactive property while publish is running. `a.active` is the buffer that
+	// receives/stores/updates the metricsets, once swapped, we're working on the
+	// `a.inactive` which we're going to process and publish.
+	current := a.active[period]
+	a.active[period], a.inactive[period] = a.inactive[period], current
+	a.mu.Unlock()
+
+	if current.entries == 0 {
+		a.config.Logger.Debugf("no error metrics to publish")
+		return nil
+	}
+
+	size := current.entries
+	if current.other != nil {
+		size++
+	}
+
+	isMetricsPeriod := period == a.config.Interval
+	intervalStr := interval.FormatDuration(period)
+	batch := make(model.Batch, 0, size)
+	for key, metrics := range current.m {
+		for _, entry := range metrics {
+			// Record the metricset interval as metricset.interval.
+			m := makeMetricset(entry.aggregationKey, entry.errorMetrics, intervalStr)
+			batch = append(batch, m)
+		}
+		delete(current.m, key)
+	}
+	if current.other != nil {
+		overflowCount := current.otherCardinalityEstimator.Estimate()
+		if isMetricsPeriod {
+			atomic.AddInt64(&a.metrics.activeGroups, int64(current.entries))
+			atomic.AddInt64(&a.metrics.overflow, int64(overflowCount))
+		}
+		entry := current.other
+		// Record the metricset interval as metricset.interval.
+		m := makeMetricset(entry.aggregationKey, entry.errorMetrics, intervalStr)
+		m.Metricset.Samples = append(m.Metricset.Samples, model.MetricsetSample{
+			Name:  "error.aggregation.overflow_count",
+			Value: float64(overflowCount),
+		})
+		batch = append(batch, m)
+		current.other = nil
+		current.otherCardinalityEstimator = nil
+	}
+	current.entries = 0
+	a.config.Logger.Debugf("publishing %d metricsets", len(batch))
+	return a.config.BatchProcessor.ProcessBatch(ctx, &batch)
+}
+
+// ProcessBatch aggregates all error metrics.
+//
+// To contain cardinality of the aggregated metrics the following
+// limits are considered:
+//
+//   - MaxGroups: Limits the total number of error groups that the
+//     error metrics aggregator produces. Once this limit is breached
+//     the metrics are aggregated in a dedicated bucket with
+//     `service.name` as `_other`.
+func (a *Aggregator) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, event := range *b {
+		if event.Processor == model.ErrorProcessor && event.Error != nil {
+			a.processError(&event)
+		}
+	}
+	return nil
+}
+
+func (a *Aggregator) processError(event *model.APMEvent) {
+	for _, interval := range a.Intervals {
+		key := makeAggregationKey(event, interval)
+		a.active[interval].storeOrUpdate(key, errorMetrics{count: 1}, interval, a.config.Logger)
+	}
+}
+
+type metricsBuffer struct {
+	mu                        sync.RWMutex
+	m                         map[uint64][]*metricsMapEntry
+	other                     *metricsMapEntry
+	otherCardinalityEstimator *hyperloglog.Sketch
+	space                     []metricsMapEntry
+	entries                   int
+
+	maxSize int
+}
+
+func newMetricsBuffer(maxSize int) *metricsBuffer {
+	return &metricsBuffer{
+		maxSize: maxSize,
+		// keep one reserved entry for overflow bucket
+		space: make([]metricsMapEntry, maxSize+1),
+		m:     make(map[uint64][]*metricsMapEntry),
+	}
+}
+
+type metricsMapEntry struct {
+	aggregationKey
+	errorMetrics
+}
+
+func (mb *metricsBuffer) storeOrUpdate(
+	key aggregationKey,
+	metrics errorMetrics,
+	interval time.Duration,
+	logger *logp.Logger,
+) {
+	// hash does not use the errorMetrics so it is safe to call concurrently.
+	hash := key.hash()
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	entry := mb.getOrCreateEntry(hash, key, interval, logger)
+	entry.recordMetrics(metrics)
+}
+
+// getOrCreateEntry returns the entry for key, creating it if it does not
+// exist, or returns the overflow entry if the group limit has been reached.
+// The caller must hold mb.mu for writing.
+func (mb *metricsBuffer) getOrCreateEntry(
+	hash uint64,
+	key aggregationKey,
+	interval time.Duration,
+	logger *logp.Logger,
+) *metricsMapEntry {
+	var entry *metricsMapEntry
+	entries, ok := mb.m[hash]
+	if ok {
+		for offset, old := range entries {
+			if old.aggregationKey.equal(key) {
+				entry = entries[offset]
+				break
+			}
+		}
+	}
+	if entry == nil && mb.entries >= mb.maxSize && mb.other != nil {
+		entry = mb.other
+		// axiomhq/hyerloglog uses metrohash but here we are using
+		// xxhash. Metrohash has better performance but since we are
+		// already calculating xxhash we can use it directly.
+		mb.otherCardinalityEstimator.InsertHash(hash)
+	}
+	if entry != nil {
+		return entry
+	}
+	if mb.entries >= mb.maxSize {
+		logger.Warnf(`
+Error aggregation group limit of %d reached, new metric documents will be grouped
+under a dedicated bucket identified by service name '%s'.`[1:], mb.maxSize, overflowServiceName)
+		mb.other = &mb.space[len(mb.space)-1]
+		mb.otherCardinalityEstimator = hyperloglog.New14()
+		mb.otherCardinalityEstimator.InsertHash(hash)
+		entry = mb.other
+		key = makeOverflowAggregationKey(interval)
+	} else {
+		entry = &mb.space[mb.entries]
+		mb.m[hash] = append(entries, entry)
+		mb.entries++
+	}
+	entry.aggregationKey = key
+	entry.errorMetrics = errorMetrics{}
+	return entry
+}
+
+type aggregationKey struct {
+	labels.AggregatedGlobalLabels
+	comparable
+}
+
+func (k *aggregationKey) hash() uint64 {
+	var h xxhash.Digest
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(k.timestamp.UnixNano()))
+	h.Write(buf[:])
+
+	k.AggregatedGlobalLabels.Write(&h)
+	h.WriteString(k.agentName)
+	h.WriteString(k.serviceEnvironment)
+	h.WriteString(k.serviceName)
+	h.WriteString(k.serviceLanguageName)
+	h.WriteString(k.errorGroupingKey)
+	h.WriteString(k.errorExceptionType)
+	h.WriteString(k.errorCulprit)
+	return h.Sum64()
+}
+
+func (k *aggregationKey) equal(key aggregationKey) bool {
+	return k.comparable == key.comparable &&
+		k.AggregatedGlobalLabels.Equals(&key.AggregatedGlobalLabels)
+}
+
+type comparable struct {
+	timestamp time.Time
+
+	agentName           string
+	serviceName         string
+	serviceEnvironment  string
+	serviceLanguageName string
+	errorGroupingKey    string
+	errorExceptionType  string
+	errorCulprit        string
+}
+
+func makeAggregationKey(event *model.APMEvent, interval time.Duration) aggregationKey {
+	key := aggregationKey{
+		comparable: comparable{
+			// Group metrics by time interval.
+			timestamp: event.Timestamp.Truncate(interval),
+
+			agentName:           event.Agent.Name,
+			serviceName:         event.Service.Name,
+			serviceEnvironment:  event.Service.Environment,
+			serviceLanguageName: event.Service.Language.Name,
+			errorGroupingKey:    event.Error.GroupingKey,
+			errorCulprit:        event.Error.Culprit,
+		},
+	}
+	if event.Error.Exception != nil {
+		key.errorExceptionType = event.Error.Exception.Type
+	}
+	key.AggregatedGlobalLabels.Read(event)
+	return key
+}
+
+func makeOverflowAggregationKey(interval time.Duration) aggregationKey {
+	return aggregationKey{
+		comparable: comparable{
+			// We are using `time.Now` here to align the overflow aggregation to
+			// the evaluation time rather than event time. This prevents us from
+			// cases of bad timestamps when the server receives some events with
+			// old timestamp and these events overflow causing the indexed event
+			// to have old timestamp too.
+			timestamp:   time.Now().Truncate(interval),
+			serviceName: overflowServiceName,
+		},
+	}
+}
+
+type errorMetrics struct {
+	count int64
+}
+
+func (m *errorMetrics) recordMetrics(other errorMetrics) {
+	m.count += other.count
+}
+
+// makeMetricset creates a metricset with key, metrics, and interval.
+// The number of errors is recorded as the metricset's DocCount.
+func makeMetricset(key aggregationKey, metrics errorMetrics, interval string) model.APMEvent {
+	event := model.APMEvent{
+		Timestamp: key.timestamp,
+		Service: model.Service{
+			Name:        key.serviceName,
+			Environment: key.serviceEnvironment,
+			Language: model.Language{
+				Name: key.serviceLanguageName,
+			},
+		},
+		Agent: model.Agent{
+			Name: key.agentName,
+		},
+		Labels:        key.Labels,
+		NumericLabels: key.NumericLabels,
+		Processor:     model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			DocCount: metrics.count,
+			Name:     metricsetName,
+			Interval: interval,
+		},
+		Error: &model.Error{
+			GroupingKey: key.errorGroupingKey,
+			Culprit:     key.errorCulprit,
+		},
+		DataStream: model.DataStream{
+			Type:    dataStreamType,
+			Dataset: dataStreamDatasetPrefix + interval,
+		},
+	}
+	if key.errorExceptionType != "" {
+		event.Error.Exception = &model.Exception{Type: key.errorExceptionType}
+	}
+	return event
+}
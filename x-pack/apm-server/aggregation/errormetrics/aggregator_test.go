@@ -0,0 +1,363 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package errormetrics
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestNewAggregatorConfigInvalid(t *testing.T) {
+	report := makeErrBatchProcessor(nil)
+
+	type test struct {
+		config AggregatorConfig
+		err    string
+	}
+
+	for _, test := range []test{{
+		config: AggregatorConfig{},
+		err:    "BatchProcessor unspecified",
+	}, {
+		config: AggregatorConfig{
+			BatchProcessor: report,
+		},
+		err: "MaxGroups unspecified or negative",
+	}, {
+		config: AggregatorConfig{
+			BatchProcessor: report,
+			MaxGroups:      1,
+		},
+		err: "Interval unspecified or negative",
+	}} {
+		agg, err := NewAggregator(test.config)
+		require.Error(t, err)
+		require.Nil(t, agg)
+		assert.EqualError(t, err, "invalid aggregator config: "+test.err)
+	}
+}
+
+func TestAggregatorRun(t *testing.T) {
+	batches := make(chan model.Batch, 3)
+	config := AggregatorConfig{
+		BatchProcessor:  makeChanBatchProcessor(batches),
+		Interval:        10 * time.Millisecond,
+		RollUpIntervals: []time.Duration{200 * time.Millisecond, time.Second},
+		MaxGroups:       1000,
+	}
+	agg, err := NewAggregator(config)
+	require.NoError(t, err)
+
+	errorWithLabels := makeError("backend", "dev", "abc", "java.lang.NullPointerException", "Main.run")
+	errorWithLabels.Labels = model.Labels{
+		"department_name": model.LabelValue{Global: true, Value: "apm"},
+		"organization":    model.LabelValue{Value: "observability"},
+	}
+	errorWithLabels.NumericLabels = model.NumericLabels{
+		"cost_center": model.NumericLabelValue{Global: true, Value: 10},
+	}
+	logError := makeError("backend", "dev", "def", "", "Main.log")
+	logError.Error.Exception = nil
+	logError.Error.Log = &model.ErrorLog{Message: "oops"}
+
+	batch := model.Batch{
+		errorWithLabels,
+		errorWithLabels,
+		makeError("backend", "dev", "abc", "java.lang.NullPointerException", "Main.run"),
+		makeError("backend", "prod", "abc", "java.lang.NullPointerException", "Main.run"),
+		logError,
+		{
+			Processor:   model.TransactionProcessor,
+			Service:     model.Service{Name: "backend"},
+			Transaction: &model.Transaction{Name: "T", RepresentativeCount: 1},
+		},
+		{
+			// Errors without an error are ignored.
+			Processor: model.ErrorProcessor,
+			Service:   model.Service{Name: "backend"},
+		},
+	}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	assert.Empty(t, batchMetricsets(t, batch))
+
+	// Start the aggregator after processing to ensure metrics are aggregated deterministically.
+	go agg.Run()
+	defer agg.Stop(context.Background())
+	// Stop the aggregator to ensure all metrics are published.
+	assert.NoError(t, agg.Stop(context.Background()))
+
+	for _, interval := range append([]time.Duration{config.Interval}, config.RollUpIntervals...) {
+		intervalStr := fmt.Sprintf("%.0fs", interval.Seconds())
+		makeMetricset := func(environment, groupingKey, exceptionType, culprit string, count int64) model.APMEvent {
+			m := model.APMEvent{
+				Processor: model.MetricsetProcessor,
+				Metricset: &model.Metricset{
+					Name: "error", Interval: intervalStr, DocCount: count,
+				},
+				Service: model.Service{Name: "backend", Environment: environment, Language: model.Language{Name: "java"}},
+				Agent:   model.Agent{Name: "java"},
+				Error:   &model.Error{GroupingKey: groupingKey, Culprit: culprit},
+				DataStream: model.DataStream{
+					Type:    "metrics",
+					Dataset: "apm.error." + intervalStr,
+				},
+			}
+			if exceptionType != "" {
+				m.Error.Exception = &model.Exception{Type: exceptionType}
+			}
+			return m
+		}
+		withLabels := makeMetricset("dev", "abc", "java.lang.NullPointerException", "Main.run", 2)
+		withLabels.Labels = model.Labels{"department_name": model.LabelValue{Value: "apm"}}
+		withLabels.NumericLabels = model.NumericLabels{"cost_center": model.NumericLabelValue{Value: 10}}
+		expected := []model.APMEvent{
+			withLabels,
+			makeMetricset("dev", "abc", "java.lang.NullPointerException", "Main.run", 1),
+			makeMetricset("prod", "abc", "java.lang.NullPointerException", "Main.run", 1),
+			makeMetricset("dev", "def", "", "Main.log", 1),
+		}
+
+		metricsets := batchMetricsets(t, expectBatch(t, batches))
+		assert.Empty(t, cmp.Diff(expected, metricsets,
+			cmpopts.IgnoreTypes(netip.Addr{}, time.Time{}),
+			cmpopts.EquateEmpty(),
+			cmpopts.SortSlices(func(e1 model.APMEvent, e2 model.APMEvent) bool {
+				if e1.Service.Environment != e2.Service.Environment {
+					return e1.Service.Environment < e2.Service.Environment
+				}
+				if e1.Error.GroupingKey != e2.Error.GroupingKey {
+					return e1.Error.GroupingKey < e2.Error.GroupingKey
+				}
+				return len(e1.Labels) < len(e2.Labels)
+			}),
+		))
+	}
+
+	select {
+	case <-batches:
+		t.Fatal("unexpected publish")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAggregateTimestamp(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor: makeChanBatchProcessor(batches),
+		Interval:       30 * time.Second,
+		MaxGroups:      1000,
+	})
+	require.NoError(t, err)
+
+	t0 := time.Unix(0, 0)
+	for _, ts := range []time.Time{t0, t0.Add(15 * time.Second), t0.Add(30 * time.Second)} {
+		e := makeError("service_name", "", "grouping_key", "type", "culprit")
+		e.Timestamp = ts
+		batch := model.Batch{e}
+		require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	}
+
+	go agg.Run()
+	require.NoError(t, agg.Stop(context.Background())) // stop to flush
+
+	metricsets := batchMetricsets(t, expectBatch(t, batches))
+	require.Len(t, metricsets, 2)
+	sort.Slice(metricsets, func(i, j int) bool {
+		return metricsets[i].Timestamp.Before(metricsets[j].Timestamp)
+	})
+	assert.Equal(t, t0, metricsets[0].Timestamp)
+	assert.Equal(t, int64(2), metricsets[0].Metricset.DocCount)
+	assert.Equal(t, t0.Add(30*time.Second), metricsets[1].Timestamp)
+	assert.Equal(t, int64(1), metricsets[1].Metricset.DocCount)
+}
+
+func TestAggregatorOverflow(t *testing.T) {
+	maxGrps := 4
+	overflowCount := 100
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor: makeChanBatchProcessor(batches),
+		Interval:       10 * time.Second,
+		MaxGroups:      maxGrps,
+	})
+	require.NoError(t, err)
+
+	batch := make(model.Batch, maxGrps+overflowCount) // cause overflow
+	for i := 0; i < len(batch); i++ {
+		batch[i] = makeError("svc", "", fmt.Sprintf("grouping_key%d", i), "type", "culprit")
+	}
+	go func(t *testing.T) {
+		t.Helper()
+		require.NoError(t, agg.Run())
+	}(t)
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, agg.Stop(context.Background()))
+	metricsets := batchMetricsets(t, expectBatch(t, batches))
+	require.Len(t, metricsets, maxGrps+1) // only one `other` metric should overflow
+
+	// assert monitoring
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "errormetrics", agg.CollectMonitoring)
+	expectedMonitoring := monitoring.MakeFlatSnapshot()
+	expectedMonitoring.Ints["errormetrics.active_groups"] = int64(maxGrps)
+	expectedMonitoring.Ints["errormetrics.overflowed.total"] = int64(overflowCount)
+	assert.Equal(t, expectedMonitoring, monitoring.CollectFlatSnapshot(
+		registry, monitoring.Full, false,
+	))
+
+	var overflowEvent *model.APMEvent
+	for i := range metricsets {
+		m := metricsets[i]
+		if m.Service.Name == "_other" {
+			if overflowEvent != nil {
+				require.Fail(t, "only one service should overflow")
+			}
+			overflowEvent = &m
+		}
+	}
+	require.NotNil(t, overflowEvent)
+	assert.Empty(t, cmp.Diff(model.APMEvent{
+		Service: model.Service{
+			Name: "_other",
+		},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     "error",
+			Interval: "10s",
+			DocCount: int64(overflowCount),
+			Samples: []model.MetricsetSample{
+				{
+					Name:  "error.aggregation.overflow_count",
+					Value: float64(overflowCount),
+				},
+			},
+		},
+		Error: &model.Error{},
+		DataStream: model.DataStream{
+			Type:    "metrics",
+			Dataset: "apm.error.10s",
+		},
+	}, *overflowEvent, cmpopts.IgnoreTypes(netip.Addr{}, time.Time{})))
+}
+
+func TestAggregatorPersistState(t *testing.T) {
+	const maxGroups = 4
+	stateFile := filepath.Join(t.TempDir(), "errormetrics.state")
+	batches := make(chan model.Batch, 1)
+	newAggregator := func(stateFile string) *Aggregator {
+		agg, err := NewAggregator(AggregatorConfig{
+			BatchProcessor: makeChanBatchProcessor(batches),
+			Interval:       10 * time.Second,
+			MaxGroups:      maxGroups,
+			StateFile:      stateFile,
+		})
+		require.NoError(t, err)
+		return agg
+	}
+	batch := make(model.Batch, maxGroups+10) // cause overflow
+	for i := 0; i < len(batch); i++ {
+		batch[i] = makeError(fmt.Sprintf("svc%d", i%(maxGroups+2)), "", "grouping_key", "type", "culprit")
+	}
+
+	agg := newAggregator(stateFile)
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	go agg.Run()
+	require.NoError(t, agg.Stop(context.Background()))
+	assert.FileExists(t, stateFile)
+	select {
+	case <-batches:
+		t.Fatal("unexpected publish")
+	default:
+	}
+
+	// Restore the state, and compare the published metrics against
+	// those of an aggregator that processed the same events.
+	restored := newAggregator(stateFile)
+	assert.NoFileExists(t, stateFile)
+	require.NoError(t, restored.publish(context.Background(), 10*time.Second))
+	actual := batchMetricsets(t, expectBatch(t, batches))
+
+	agg = newAggregator("")
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, agg.publish(context.Background(), 10*time.Second))
+	expected := batchMetricsets(t, expectBatch(t, batches))
+	require.Len(t, expected, maxGroups+1)
+
+	for _, metricsets := range [][]model.APMEvent{expected, actual} {
+		sort.Slice(metricsets, func(i, j int) bool {
+			return metricsets[i].Service.Name < metricsets[j].Service.Name
+		})
+	}
+	assert.Empty(t, cmp.Diff(expected, actual, cmpopts.IgnoreTypes(netip.Addr{}, time.Time{})))
+}
+
+func makeError(serviceName, serviceEnvironment, groupingKey, exceptionType, culprit string) model.APMEvent {
+	return model.APMEvent{
+		Agent: model.Agent{Name: "java"},
+		Service: model.Service{
+			Name:        serviceName,
+			Environment: serviceEnvironment,
+			Language:    model.Language{Name: "java"},
+		},
+		Processor: model.ErrorProcessor,
+		Error: &model.Error{
+			ID:          "error_id",
+			GroupingKey: groupingKey,
+			Culprit:     culprit,
+			Exception:   &model.Exception{Type: exceptionType, Message: "message"},
+		},
+	}
+}
+
+func makeErrBatchProcessor(err error) model.BatchProcessor {
+	return model.ProcessBatchFunc(func(context.Context, *model.Batch) error { return err })
+}
+
+func makeChanBatchProcessor(ch chan<- model.Batch) model.BatchProcessor {
+	return model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ch <- *batch:
+			return nil
+		}
+	})
+}
+
+func expectBatch(t *testing.T, ch <-chan model.Batch) model.Batch {
+	t.Helper()
+	select {
+	case batch := <-ch:
+		return batch
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected publish")
+	}
+	panic("unreachable")
+}
+
+func batchMetricsets(t testing.TB, batch model.Batch) []model.APMEvent {
+	var metricsets []model.APMEvent
+	for _, event := range batch {
+		if event.Metricset == nil {
+			continue
+		}
+		metricsets = append(metricsets, event)
+	}
+	return metricsets
+}
@@ -0,0 +1,175 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+// Package histogram provides alternative representations for the duration
+// histograms published by the aggregators.
+//
+// Durations are aggregated in HDR histograms, and converted to the
+// configured representation when metrics are published. All representations
+// are published as Elasticsearch histogram fields, i.e. as counts and values.
+package histogram
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Representation identifies how aggregated histograms are represented
+// in published metrics.
+type Representation int
+
+const (
+	// HDR represents histograms with the buckets of the HDR histogram
+	// used for aggregation, with each value being the upper limit of a
+	// bucket. This is the default representation.
+	HDR Representation = iota
+
+	// Exponential represents histograms with the buckets of an OpenTelemetry
+	// exponential histogram with the fixed scale ExponentialScale, with each
+	// value being the midpoint of a bucket. The result is still published as
+	// an Elasticsearch histogram, not as an exponential histogram, so it is
+	// not directly combinable with OTLP exponential histogram data points.
+	Exponential
+
+	// TDigest represents histograms with the centroids of a T-Digest with
+	// compression TDigestCompression, with each value being the mean of
+	// a centroid.
+	TDigest
+)
+
+const (
+	// ExponentialScale is the scale of exponential histograms, giving
+	// buckets with bounds that grow by a factor of 2^(2^-ExponentialScale).
+	ExponentialScale = 4
+
+	// TDigestCompression is the compression parameter of T-Digests,
+	// which bounds the number of centroids.
+	TDigestCompression = 200
+)
+
+// ParseRepresentation parses s as a Representation: "hdr", "exponential",
+// or "tdigest". An empty string is parsed as HDR.
+func ParseRepresentation(s string) (Representation, error) {
+	switch s {
+	case "", "hdr":
+		return HDR, nil
+	case "exponential":
+		return Exponential, nil
+	case "tdigest":
+		return TDigest, nil
+	}
+	return -1, errors.Errorf("unknown histogram representation %q", s)
+}
+
+// String returns the name of r, as accepted by ParseRepresentation.
+func (r Representation) String() string {
+	switch r {
+	case HDR:
+		return "hdr"
+	case Exponential:
+		return "exponential"
+	case TDigest:
+		return "tdigest"
+	}
+	return "unknown"
+}
+
+// Convert converts the HDR histogram buckets defined by counts and values
+// to the representation r. Values must be non-negative and in ascending
+// order. The total count is unchanged by conversion.
+//
+// The returned slices may share memory with counts and values.
+func (r Representation) Convert(counts []int64, values []float64) ([]int64, []float64) {
+	switch r {
+	case Exponential:
+		return toExponential(counts, values, ExponentialScale)
+	case TDigest:
+		return toTDigest(counts, values, TDigestCompression)
+	}
+	return counts, values
+}
+
+// toExponential merges the buckets into those of an OpenTelemetry exponential
+// histogram with the given scale, and its zero bucket.
+//
+// As in OpenTelemetry, bucket i has the upper-inclusive bounds
+// (base^i, base^(i+1)], where base is 2^(2^-scale).
+func toExponential(counts []int64, values []float64, scale int) ([]int64, []float64) {
+	outCounts := make([]int64, 0, len(counts))
+	outValues := make([]float64, 0, len(values))
+	scaleFactor := math.Ldexp(1, scale)
+	var lastIndex int
+	for i, v := range values {
+		index := math.MinInt // zero bucket
+		var midpoint float64
+		if v > 0 {
+			index = int(math.Ceil(math.Log2(v)*scaleFactor)) - 1
+			lower := math.Exp2(float64(index) / scaleFactor)
+			upper := math.Exp2(float64(index+1) / scaleFactor)
+			midpoint = lower + (upper-lower)/2
+		}
+		if n := len(outCounts); n > 0 && index == lastIndex {
+			outCounts[n-1] += counts[i]
+			continue
+		}
+		lastIndex = index
+		outCounts = append(outCounts, counts[i])
+		outValues = append(outValues, midpoint)
+	}
+	return outCounts, outValues
+}
+
+// toTDigest merges the buckets into the centroids of a merging T-Digest with
+// the given compression, using the k1 scale function. Centroids at the tails
+// of the distribution are kept small, to preserve accuracy of the extreme
+// percentiles.
+func toTDigest(counts []int64, values []float64, compression float64) ([]int64, []float64) {
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	if total == 0 || len(counts) == 0 {
+		return counts, values
+	}
+	k := func(q float64) float64 {
+		return compression / (2 * math.Pi) * math.Asin(2*q-1)
+	}
+	kInverse := func(k float64) float64 {
+		if k >= compression/4 {
+			return 1
+		}
+		return (math.Sin(k*2*math.Pi/compression) + 1) / 2
+	}
+	weightLimit := func(weightSoFar int64) float64 {
+		return float64(total) * kInverse(k(float64(weightSoFar)/float64(total))+1)
+	}
+
+	outCounts := make([]int64, 0, len(counts))
+	outValues := make([]float64, 0, len(values))
+	var weightSoFar int64
+	limit := weightLimit(0)
+	centroidCount, centroidSum := counts[0], values[0]*float64(counts[0])
+	emit := func() {
+		var mean float64
+		if centroidCount > 0 {
+			mean = centroidSum / float64(centroidCount)
+		}
+		outCounts = append(outCounts, centroidCount)
+		outValues = append(outValues, mean)
+		weightSoFar += centroidCount
+	}
+	for i := 1; i < len(counts); i++ {
+		if float64(weightSoFar+centroidCount+counts[i]) <= limit {
+			centroidCount += counts[i]
+			centroidSum += values[i] * float64(counts[i])
+			continue
+		}
+		emit()
+		limit = weightLimit(weightSoFar)
+		centroidCount, centroidSum = counts[i], values[i]*float64(counts[i])
+	}
+	emit()
+	return outCounts, outValues
+}
@@ -0,0 +1,143 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package histogram
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/go-hdrhistogram"
+)
+
+func TestParseRepresentation(t *testing.T) {
+	for _, r := range []Representation{HDR, Exponential, TDigest} {
+		parsed, err := ParseRepresentation(r.String())
+		require.NoError(t, err)
+		assert.Equal(t, r, parsed)
+	}
+	parsed, err := ParseRepresentation("")
+	require.NoError(t, err)
+	assert.Equal(t, HDR, parsed)
+
+	_, err = ParseRepresentation("linear")
+	assert.EqualError(t, err, `unknown histogram representation "linear"`)
+}
+
+func TestConvertExponentialBuckets(t *testing.T) {
+	// With scale 0, bucket i has bounds (2^i, 2^(i+1)].
+	counts, values := toExponential(
+		[]int64{1, 2, 3, 4, 5, 6},
+		[]float64{0, 1, 2, 3, 4, 5},
+		0,
+	)
+	assert.Equal(t, []int64{1, 2, 3, 9, 6}, counts)
+	assert.Equal(t, []float64{0, 0.75, 1.5, 3, 6}, values)
+}
+
+func TestConvertEmpty(t *testing.T) {
+	for _, r := range []Representation{HDR, Exponential, TDigest} {
+		counts, values := r.Convert(nil, nil)
+		assert.Empty(t, counts, r.String())
+		assert.Empty(t, values, r.String())
+	}
+}
+
+// TestConvertPercentileAccuracy records a log-normal distribution of
+// durations in an HDR histogram, as the aggregators do, and compares the
+// percentiles of each representation against the exact percentiles.
+func TestConvertPercentileAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	durations := make([]float64, 100000)
+	h := hdrhistogram.New(0, time.Hour.Microseconds(), 2)
+	for i := range durations {
+		// Median of 50ms.
+		d := int64(math.Exp(rng.NormFloat64()*1.5 + math.Log(50000)))
+		if d < 1 {
+			d = 1
+		}
+		durations[i] = float64(d)
+		require.NoError(t, h.RecordValue(d))
+	}
+	sort.Float64s(durations)
+
+	var counts []int64
+	var values []float64
+	for _, b := range h.Distribution() {
+		if b.Count > 0 {
+			counts = append(counts, b.Count)
+			values = append(values, float64(b.To))
+		}
+	}
+
+	for _, test := range []struct {
+		representation Representation
+		maxBuckets     int
+		// maxError holds the maximum relative error for each percentile.
+		maxError map[float64]float64
+	}{{
+		representation: HDR,
+		maxBuckets:     len(counts),
+		maxError:       map[float64]float64{0.5: 0.01, 0.9: 0.01, 0.99: 0.01, 0.999: 0.01},
+	}, {
+		representation: Exponential,
+		maxBuckets:     300,
+		maxError:       map[float64]float64{0.5: 0.02, 0.9: 0.02, 0.99: 0.02, 0.999: 0.02},
+	}, {
+		representation: TDigest,
+		maxBuckets:     TDigestCompression,
+		maxError:       map[float64]float64{0.5: 0.01, 0.9: 0.01, 0.99: 0.03, 0.999: 0.1},
+	}} {
+		t.Run(test.representation.String(), func(t *testing.T) {
+			outCounts, outValues := test.representation.Convert(
+				append([]int64(nil), counts...),
+				append([]float64(nil), values...),
+			)
+			require.Len(t, outValues, len(outCounts))
+			assert.LessOrEqual(t, len(outCounts), test.maxBuckets)
+			assert.True(t, sort.Float64sAreSorted(outValues))
+			assert.Equal(t, sum(counts), sum(outCounts))
+
+			for q, maxError := range test.maxError {
+				expected := durations[int(math.Ceil(q*float64(len(durations))))-1]
+				actual := percentile(outCounts, outValues, q)
+				assert.InEpsilon(t, expected, actual, maxError, "p%v", q*100)
+			}
+		})
+	}
+}
+
+// percentile returns the q-quantile of the histogram, interpolating
+// linearly between bucket values.
+func percentile(counts []int64, values []float64, q float64) float64 {
+	target := q * float64(sum(counts))
+	var cumulative float64
+	for i, count := range counts {
+		mid := cumulative + float64(count)/2
+		if target <= mid {
+			if i == 0 {
+				return values[0]
+			}
+			prevMid := cumulative - float64(counts[i-1])/2
+			f := (target - prevMid) / (mid - prevMid)
+			return values[i-1] + f*(values[i]-values[i-1])
+		}
+		cumulative += float64(count)
+	}
+	return values[len(values)-1]
+}
+
+func sum(counts []int64) int64 {
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
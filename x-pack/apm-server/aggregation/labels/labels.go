@@ -11,6 +11,7 @@ import (
 	"sort"
 
 	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/snapshot"
 )
 
 type AggregatedGlobalLabels struct {
@@ -90,6 +91,68 @@ func (a *AggregatedGlobalLabels) Read(event *model.APMEvent) {
 	sort.Strings(a.numericLabelKeys)
 }
 
+// Encode encodes the labels for persisting aggregation state.
+func (a *AggregatedGlobalLabels) Encode(e *snapshot.Encoder) {
+	e.PutUvarint(uint64(len(a.labelKeys)))
+	for _, key := range a.labelKeys {
+		label := a.Labels[key]
+		e.PutString(key)
+		e.PutString(label.Value)
+		e.PutUvarint(uint64(len(label.Values)))
+		for _, v := range label.Values {
+			e.PutString(v)
+		}
+	}
+	e.PutUvarint(uint64(len(a.numericLabelKeys)))
+	for _, key := range a.numericLabelKeys {
+		label := a.NumericLabels[key]
+		e.PutString(key)
+		e.PutFloat64(label.Value)
+		e.PutUvarint(uint64(len(label.Values)))
+		for _, v := range label.Values {
+			e.PutFloat64(v)
+		}
+	}
+}
+
+// Decode decodes labels encoded by Encode.
+func (a *AggregatedGlobalLabels) Decode(d *snapshot.Decoder) {
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		key := d.String()
+		value := d.String()
+		var values []string
+		for m := d.Uvarint(); m > 0 && d.Err() == nil; m-- {
+			values = append(values, d.String())
+		}
+		if a.Labels == nil {
+			a.Labels = make(model.Labels)
+		}
+		if len(values) > 0 {
+			a.Labels.SetSlice(key, values)
+		} else {
+			a.Labels.Set(key, value)
+		}
+		a.labelKeys = append(a.labelKeys, key)
+	}
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		key := d.String()
+		value := d.Float64()
+		var values []float64
+		for m := d.Uvarint(); m > 0 && d.Err() == nil; m-- {
+			values = append(values, d.Float64())
+		}
+		if a.NumericLabels == nil {
+			a.NumericLabels = make(model.NumericLabels)
+		}
+		if len(values) > 0 {
+			a.NumericLabels.SetSlice(key, values)
+		} else {
+			a.NumericLabels.Set(key, value)
+		}
+		a.numericLabelKeys = append(a.numericLabelKeys, key)
+	}
+}
+
 func (a *AggregatedGlobalLabels) Equals(x *AggregatedGlobalLabels) bool {
 	return equalLabels(a.Labels, x.Labels) && equalNumericLabels(a.NumericLabels, x.NumericLabels)
 }
@@ -8,8 +8,10 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/snapshot"
 )
 
 func TestLabelsEqual(t *testing.T) {
@@ -183,3 +185,27 @@ func TestNumericLabelsEqual(t *testing.T) {
 		assert.False(t, equalNumericLabels(b, a))
 	})
 }
+
+func TestAggregatedGlobalLabelsEncodeDecode(t *testing.T) {
+	var labels AggregatedGlobalLabels
+	labels.Read(&model.APMEvent{
+		Labels: model.Labels{
+			"b":     model.LabelValue{Global: true, Value: "b"},
+			"a":     model.LabelValue{Global: true, Values: []string{"1", "2"}},
+			"local": model.LabelValue{Value: "ignored"},
+		},
+		NumericLabels: model.NumericLabels{
+			"y": model.NumericLabelValue{Global: true, Value: 1.5},
+			"x": model.NumericLabelValue{Global: true, Values: []float64{1, 2}},
+		},
+	})
+
+	var e snapshot.Encoder
+	labels.Encode(&e)
+	var decoded AggregatedGlobalLabels
+	d := snapshot.NewDecoder(e.Bytes())
+	decoded.Decode(d)
+	require.NoError(t, d.Err())
+	assert.Zero(t, d.Len())
+	assert.Equal(t, labels, decoded)
+}
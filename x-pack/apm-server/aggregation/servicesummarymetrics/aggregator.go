@@ -55,6 +55,11 @@ type AggregatorConfig struct {
 	// reached, any new aggregation keys will be aggregated in a dedicated
 	// service group identified by `_other`.
 	MaxGroups int
+
+	// StateFile, if non-empty, holds the path of a file to which in-flight
+	// aggregation state is written when the aggregator is stopped, and from
+	// which it is restored when a new aggregator is created.
+	StateFile string
 }
 
 // Validate validates the aggregator config.
@@ -104,6 +109,9 @@ func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
 		Logger:          config.Logger,
 		Interval:        config.Interval,
 		RollUpIntervals: config.RollUpIntervals,
+		StateFile:       config.StateFile,
+		EncodeStateFunc: aggregator.encodeState,
+		DecodeStateFunc: aggregator.decodeState,
 	})
 	if err != nil {
 		return nil, err
@@ -113,6 +121,7 @@ func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
 		aggregator.active[interval] = newMetricsBuffer(config.MaxGroups)
 		aggregator.inactive[interval] = newMetricsBuffer(config.MaxGroups)
 	}
+	aggregator.LoadState()
 	return &aggregator, nil
 }
 
@@ -0,0 +1,81 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package servicesummarymetrics
+
+import (
+	"time"
+
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/snapshot"
+)
+
+// encodeState encodes the in-flight service summary groups for interval.
+func (a *Aggregator) encodeState(interval time.Duration, e *snapshot.Encoder) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mb := a.active[interval]
+	for _, entries := range mb.m {
+		for _, key := range entries {
+			e.PutBool(false) // not overflow
+			key.encode(e)
+		}
+	}
+	if mb.other != nil {
+		e.PutBool(true) // overflow
+		mb.other.encode(e)
+		e.PutSketch(mb.otherCardinalityEstimator)
+	}
+}
+
+// decodeState merges service summary groups encoded by encodeState into
+// the in-flight groups for interval. Restored groups are subject to the
+// configured limits, which may have changed since the state was persisted.
+func (a *Aggregator) decodeState(interval time.Duration, d *snapshot.Decoder) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mb := a.active[interval]
+	for d.Len() > 0 {
+		overflow := d.Bool()
+		var key aggregationKey
+		key.decode(d)
+		if !overflow {
+			if err := d.Err(); err != nil {
+				return err
+			}
+			mb.storeOrUpdate(key, interval, a.config.Logger)
+			continue
+		}
+		sketch := d.Sketch()
+		if err := d.Err(); err != nil {
+			return err
+		}
+		if mb.other == nil {
+			mb.other = &key
+			mb.otherCardinalityEstimator = sketch
+			continue
+		}
+		if err := mb.otherCardinalityEstimator.Merge(sketch); err != nil {
+			return err
+		}
+	}
+	return d.Err()
+}
+
+func (k *aggregationKey) encode(e *snapshot.Encoder) {
+	k.AggregatedGlobalLabels.Encode(e)
+	e.PutTime(k.timestamp)
+	e.PutString(k.agentName)
+	e.PutString(k.serviceName)
+	e.PutString(k.serviceEnvironment)
+	e.PutString(k.serviceLanguageName)
+}
+
+func (k *aggregationKey) decode(d *snapshot.Decoder) {
+	k.AggregatedGlobalLabels.Decode(d)
+	k.timestamp = d.Time()
+	k.agentName = d.String()
+	k.serviceName = d.String()
+	k.serviceEnvironment = d.String()
+	k.serviceLanguageName = d.String()
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/elastic/apm-data/model"
 	"github.com/elastic/apm-server/internal/logs"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/baseaggregator"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/histogram"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/interval"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/labels"
 )
@@ -69,6 +70,16 @@ type AggregatorConfig struct {
 	// to maintain in the HDR Histograms. HDRHistogramSignificantFigures
 	// must be in the range [1,5].
 	HDRHistogramSignificantFigures int
+
+	// HistogramRepresentation controls how transaction duration histograms
+	// are represented in published metrics. Durations are always aggregated
+	// in HDR histograms, and converted when metrics are published.
+	HistogramRepresentation histogram.Representation
+
+	// StateFile, if non-empty, holds the path of a file to which in-flight
+	// aggregation state is written when the aggregator is stopped, and from
+	// which it is restored when a new aggregator is created.
+	StateFile string
 }
 
 // Validate validates the aggregator config.
@@ -130,6 +141,9 @@ func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
 		Logger:          config.Logger,
 		Interval:        config.Interval,
 		RollUpIntervals: config.RollUpIntervals,
+		StateFile:       config.StateFile,
+		EncodeStateFunc: aggregator.encodeState,
+		DecodeStateFunc: aggregator.decodeState,
 	})
 	if err != nil {
 		return nil, err
@@ -139,6 +153,7 @@ func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
 		aggregator.active[interval] = newMetricsBuffer(config.MaxGroups, &aggregator.histogramPool)
 		aggregator.inactive[interval] = newMetricsBuffer(config.MaxGroups, &aggregator.histogramPool)
 	}
+	aggregator.LoadState()
 	return &aggregator, nil
 }
 
@@ -187,7 +202,7 @@ func (a *Aggregator) publish(ctx context.Context, period time.Duration) error {
 	for key, metrics := range current.m {
 		for _, entry := range metrics {
 			// Record the metricset interval as metricset.interval.
-			m := makeMetricset(entry.aggregationKey, entry.serviceTxMetrics, intervalStr)
+			m := makeMetricset(entry.aggregationKey, entry.serviceTxMetrics, intervalStr, a.config.HistogramRepresentation)
 			batch = append(batch, m)
 			entry.histogram.Reset()
 			a.histogramPool.Put(entry.histogram)
@@ -203,7 +218,7 @@ func (a *Aggregator) publish(ctx context.Context, period time.Duration) error {
 		}
 		entry := current.other
 		// Record the metricset interval as metricset.interval.
-		m := makeMetricset(entry.aggregationKey, entry.serviceTxMetrics, intervalStr)
+		m := makeMetricset(entry.aggregationKey, entry.serviceTxMetrics, intervalStr, a.config.HistogramRepresentation)
 		m.Metricset.Samples = append(m.Metricset.Samples, model.MetricsetSample{
 			Name:  "service_transaction.aggregation.overflow_count",
 			Value: float64(overflowCount),
@@ -292,7 +307,19 @@ func (mb *metricsBuffer) storeOrUpdate(
 	// Full lock because serviceTxMetrics cannot be updated atomically.
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
+	entry := mb.getOrCreateEntry(hash, key, interval, logger)
+	entry.recordMetrics(metrics)
+}
 
+// getOrCreateEntry returns the entry for key, creating it if it does not
+// exist, or returns the overflow entry if the group limit has been reached.
+// The caller must hold mb.mu for writing.
+func (mb *metricsBuffer) getOrCreateEntry(
+	hash uint64,
+	key aggregationKey,
+	interval time.Duration,
+	logger *logp.Logger,
+) *metricsMapEntry {
 	var entry *metricsMapEntry
 	entries, ok := mb.m[hash]
 	if ok {
@@ -311,8 +338,7 @@ func (mb *metricsBuffer) storeOrUpdate(
 		mb.otherCardinalityEstimator.InsertHash(hash)
 	}
 	if entry != nil {
-		entry.recordMetrics(metrics)
-		return
+		return entry
 	}
 	if mb.entries >= mb.maxSize {
 		logger.Warnf(`
@@ -332,7 +358,7 @@ under a dedicated bucket identified by service name '%s'.`[1:], mb.maxSize, over
 	entry.serviceTxMetrics = serviceTxMetrics{
 		histogram: mb.histogramPool.Get().(*hdrhistogram.Histogram),
 	}
-	entry.recordMetrics(metrics)
+	return entry
 }
 
 type aggregationKey struct {
@@ -455,12 +481,19 @@ func makeServiceTxMetrics(event *model.APMEvent) serviceTxMetrics {
 	return metrics
 }
 
-// makeMetricset creates a metricset with key, metrics, and interval.
+// makeMetricset creates a metricset with key, metrics, and interval, converting
+// the transaction duration histogram to the given representation.
 // It uses result from histogram for Transaction.DurationSummary and DocCount to avoid discrepancy and UI weirdness.
 // Event.SuccessCount will maintain separate counts, which may be different from histogram count,
 // but is acceptable since it is used for calculating error ratio.
-func makeMetricset(key aggregationKey, metrics serviceTxMetrics, interval string) model.APMEvent {
+func makeMetricset(
+	key aggregationKey,
+	metrics serviceTxMetrics,
+	interval string,
+	representation histogram.Representation,
+) model.APMEvent {
 	totalCount, counts, values := metrics.histogramBuckets()
+	counts, values = representation.Convert(counts, values)
 
 	transactionDurationSummary := model.SummaryMetric{
 		Count: totalCount,
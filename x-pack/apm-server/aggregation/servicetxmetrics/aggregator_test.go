@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"net/netip"
+	"path/filepath"
 	"sort"
 	"sync"
 	"testing"
@@ -354,6 +355,65 @@ func TestAggregatorOverflow(t *testing.T) {
 	}, *overflowEvent, cmpopts.IgnoreTypes(netip.Addr{}, time.Time{})))
 }
 
+func TestAggregatorPersistState(t *testing.T) {
+	const maxGroups = 4
+	stateFile := filepath.Join(t.TempDir(), "servicetxmetrics.state")
+	batches := make(chan model.Batch, 1)
+	newAggregator := func(stateFile string) *Aggregator {
+		agg, err := NewAggregator(AggregatorConfig{
+			BatchProcessor:                 makeChanBatchProcessor(batches),
+			Interval:                       10 * time.Second,
+			MaxGroups:                      maxGroups,
+			HDRHistogramSignificantFigures: 2,
+			StateFile:                      stateFile,
+		})
+		require.NoError(t, err)
+		return agg
+	}
+	batch := make(model.Batch, maxGroups+10) // cause overflow
+	for i := 0; i < len(batch); i++ {
+		outcome := "success"
+		if i%3 == 0 {
+			outcome = "failure"
+		}
+		batch[i] = makeTransaction(
+			fmt.Sprintf("svc%d", i%(maxGroups+5)), "go", "agent", "tx_type", outcome,
+			time.Duration(i+1)*time.Millisecond, 1.5,
+		)
+	}
+
+	agg := newAggregator(stateFile)
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	go agg.Run()
+	require.NoError(t, agg.Stop(context.Background()))
+	assert.FileExists(t, stateFile)
+	select {
+	case <-batches:
+		t.Fatal("unexpected publish")
+	default:
+	}
+
+	// Restore the state, and compare the published metrics against
+	// those of an aggregator that processed the same transactions.
+	restored := newAggregator(stateFile)
+	assert.NoFileExists(t, stateFile)
+	require.NoError(t, restored.publish(context.Background(), 10*time.Second))
+	actual := batchMetricsets(t, expectBatch(t, batches))
+
+	agg = newAggregator("")
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, agg.publish(context.Background(), 10*time.Second))
+	expected := batchMetricsets(t, expectBatch(t, batches))
+	require.Len(t, expected, maxGroups+1)
+
+	for _, metricsets := range [][]model.APMEvent{expected, actual} {
+		sort.Slice(metricsets, func(i, j int) bool {
+			return metricsets[i].Service.Name < metricsets[j].Service.Name
+		})
+	}
+	assert.Empty(t, cmp.Diff(expected, actual, cmpopts.IgnoreTypes(netip.Addr{}, time.Time{})))
+}
+
 func makeTransaction(
 	serviceName, serviceLanguageName, agentName, transactionType, outcome string,
 	duration time.Duration, count float64,
@@ -0,0 +1,113 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package servicetxmetrics
+
+import (
+	"time"
+
+	"github.com/axiomhq/hyperloglog"
+
+	"github.com/elastic/go-hdrhistogram"
+
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/snapshot"
+)
+
+// encodeState encodes the in-flight service transaction metrics for interval.
+func (a *Aggregator) encodeState(interval time.Duration, e *snapshot.Encoder) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mb := a.active[interval]
+	for _, entries := range mb.m {
+		for _, entry := range entries {
+			e.PutBool(false) // not overflow
+			entry.aggregationKey.encode(e)
+			entry.serviceTxMetrics.encode(e)
+		}
+	}
+	if mb.other != nil {
+		e.PutBool(true) // overflow
+		mb.other.aggregationKey.encode(e)
+		e.PutSketch(mb.otherCardinalityEstimator)
+		mb.other.serviceTxMetrics.encode(e)
+	}
+}
+
+// decodeState merges service transaction metrics encoded by encodeState
+// into the in-flight metrics for interval. Restored groups are subject to
+// the configured limits, which may have changed since the state was persisted.
+func (a *Aggregator) decodeState(interval time.Duration, d *snapshot.Decoder) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mb := a.active[interval]
+	for d.Len() > 0 {
+		overflow := d.Bool()
+		var key aggregationKey
+		key.decode(d)
+		var sketch *hyperloglog.Sketch
+		if overflow {
+			sketch = d.Sketch()
+		}
+		if err := d.Err(); err != nil {
+			return err
+		}
+		var entry *metricsMapEntry
+		switch {
+		case !overflow:
+			entry = mb.getOrCreateEntry(key.hash(), key, interval, a.config.Logger)
+		case mb.other != nil:
+			if err := mb.otherCardinalityEstimator.Merge(sketch); err != nil {
+				return err
+			}
+			entry = mb.other
+		default:
+			entry = &mb.space[len(mb.space)-1]
+			entry.aggregationKey = key
+			entry.serviceTxMetrics = serviceTxMetrics{
+				histogram: mb.histogramPool.Get().(*hdrhistogram.Histogram),
+			}
+			mb.other = entry
+			mb.otherCardinalityEstimator = sketch
+		}
+		entry.serviceTxMetrics.decode(d)
+	}
+	return d.Err()
+}
+
+func (k *aggregationKey) encode(e *snapshot.Encoder) {
+	k.AggregatedGlobalLabels.Encode(e)
+	e.PutTime(k.timestamp)
+	e.PutString(k.agentName)
+	e.PutString(k.serviceName)
+	e.PutString(k.serviceEnvironment)
+	e.PutString(k.serviceLanguageName)
+	e.PutString(k.transactionType)
+}
+
+func (k *aggregationKey) decode(d *snapshot.Decoder) {
+	k.AggregatedGlobalLabels.Decode(d)
+	k.timestamp = d.Time()
+	k.agentName = d.String()
+	k.serviceName = d.String()
+	k.serviceEnvironment = d.String()
+	k.serviceLanguageName = d.String()
+	k.transactionType = d.String()
+}
+
+func (m *serviceTxMetrics) encode(e *snapshot.Encoder) {
+	e.PutFloat64(m.transactionDuration)
+	e.PutFloat64(m.transactionCount)
+	e.PutFloat64(m.failureCount)
+	e.PutFloat64(m.successCount)
+	e.PutHistogram(m.histogram)
+}
+
+// decode decodes metrics encoded by encode, adding them to m.
+func (m *serviceTxMetrics) decode(d *snapshot.Decoder) {
+	m.transactionDuration += d.Float64()
+	m.transactionCount += d.Float64()
+	m.failureCount += d.Float64()
+	m.successCount += d.Float64()
+	d.Histogram(m.histogram)
+}
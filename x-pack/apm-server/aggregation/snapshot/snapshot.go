@@ -0,0 +1,316 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+// Package snapshot provides a compact binary encoding for persisting
+// in-flight aggregation state, and functions for reading and writing
+// snapshot files.
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/axiomhq/hyperloglog"
+	"github.com/pkg/errors"
+
+	"github.com/elastic/go-hdrhistogram"
+)
+
+// fileHeader is written at the start of every snapshot file. The final
+// byte is the encoding version, which must be incremented whenever the
+// encoding changes incompatibly.
+var fileHeader = []byte("apmagg\x01")
+
+// errMalformed is returned when decoding truncated or otherwise invalid data.
+var errMalformed = errors.New("malformed aggregation snapshot")
+
+// Encoder encodes aggregation state.
+//
+// The zero value is ready to use.
+type Encoder struct {
+	buf []byte
+	err error
+}
+
+// Bytes returns the encoded data.
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+// Err returns the first error that occurred while encoding, if any.
+func (e *Encoder) Err() error {
+	return e.err
+}
+
+// PutUvarint encodes v as an unsigned varint.
+func (e *Encoder) PutUvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+// PutVarint encodes v as a signed varint.
+func (e *Encoder) PutVarint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+// PutBool encodes v as a single byte.
+func (e *Encoder) PutBool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// PutFloat64 encodes v as 8 bytes.
+func (e *Encoder) PutFloat64(v float64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+// PutString encodes v, prefixed by its length.
+func (e *Encoder) PutString(v string) {
+	e.PutUvarint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// PutBytes encodes v, prefixed by its length.
+func (e *Encoder) PutBytes(v []byte) {
+	e.PutUvarint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// PutTime encodes t with nanosecond precision, recording whether
+// it is in the local time zone; any other time zone is decoded as UTC.
+func (e *Encoder) PutTime(t time.Time) {
+	e.PutVarint(t.Unix())
+	e.PutUvarint(uint64(t.Nanosecond()))
+	e.PutBool(t.Location() == time.Local)
+}
+
+// PutHistogram encodes the non-empty buckets of h. Each bucket is encoded
+// as the delta of its highest equivalent value from that of the previous
+// bucket, followed by its count, so sparse histograms encode to a small
+// fraction of their in-memory size.
+func (e *Encoder) PutHistogram(h *hdrhistogram.Histogram) {
+	distribution := h.Distribution()
+	var n uint64
+	for _, b := range distribution {
+		if b.Count > 0 {
+			n++
+		}
+	}
+	e.PutUvarint(n)
+	var prev int64
+	for _, b := range distribution {
+		if b.Count <= 0 {
+			continue
+		}
+		e.PutUvarint(uint64(b.To - prev))
+		e.PutUvarint(uint64(b.Count))
+		prev = b.To
+	}
+}
+
+// PutSketch encodes the HyperLogLog sketch s.
+func (e *Encoder) PutSketch(s *hyperloglog.Sketch) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		if e.err == nil {
+			e.err = errors.Wrap(err, "failed to encode cardinality sketch")
+		}
+		return
+	}
+	e.PutBytes(data)
+}
+
+// Decoder decodes aggregation state encoded by Encoder.
+//
+// Decoding errors are sticky: once an error has occurred, all further
+// calls return zero values, and Err returns the error.
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+// NewDecoder returns a new Decoder for decoding data.
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{buf: data}
+}
+
+// Err returns the first error that occurred while decoding, if any.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Len returns the number of bytes remaining to be decoded.
+func (d *Decoder) Len() int {
+	return len(d.buf)
+}
+
+func (d *Decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.buf = nil
+}
+
+// Uvarint decodes an unsigned varint.
+func (d *Decoder) Uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail(errMalformed)
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// Varint decodes a signed varint.
+func (d *Decoder) Varint() int64 {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail(errMalformed)
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// Bool decodes a boolean.
+func (d *Decoder) Bool() bool {
+	if len(d.buf) < 1 {
+		d.fail(errMalformed)
+		return false
+	}
+	v := d.buf[0] != 0
+	d.buf = d.buf[1:]
+	return v
+}
+
+// Float64 decodes a float64.
+func (d *Decoder) Float64() float64 {
+	if len(d.buf) < 8 {
+		d.fail(errMalformed)
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+	d.buf = d.buf[8:]
+	return v
+}
+
+// String decodes a length-prefixed string.
+func (d *Decoder) String() string {
+	return string(d.Bytes())
+}
+
+// Bytes decodes a length-prefixed byte slice. The returned slice
+// refers to the Decoder's underlying data.
+func (d *Decoder) Bytes() []byte {
+	n := d.Uvarint()
+	if n > uint64(len(d.buf)) {
+		d.fail(errMalformed)
+		return nil
+	}
+	v := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+// Time decodes a time encoded by Encoder.PutTime.
+func (d *Decoder) Time() time.Time {
+	sec := d.Varint()
+	nsec := d.Uvarint()
+	t := time.Unix(sec, int64(nsec))
+	if !d.Bool() {
+		t = t.UTC()
+	}
+	return t
+}
+
+// Histogram decodes a histogram encoded by Encoder.PutHistogram,
+// recording its values in h.
+func (d *Decoder) Histogram(h *hdrhistogram.Histogram) {
+	var value int64
+	for n := d.Uvarint(); n > 0 && d.err == nil; n-- {
+		value += int64(d.Uvarint())
+		count := int64(d.Uvarint())
+		if d.err != nil {
+			return
+		}
+		if err := h.RecordValues(value, count); err != nil {
+			d.fail(errors.Wrap(err, "failed to decode histogram"))
+		}
+	}
+}
+
+// Sketch decodes a HyperLogLog sketch encoded by Encoder.PutSketch.
+func (d *Decoder) Sketch() *hyperloglog.Sketch {
+	data := d.Bytes()
+	if d.err != nil {
+		return nil
+	}
+	s := hyperloglog.New14()
+	if err := s.UnmarshalBinary(data); err != nil {
+		d.fail(errors.Wrap(err, "failed to decode cardinality sketch"))
+		return nil
+	}
+	return s
+}
+
+// WriteFile atomically writes a snapshot file containing data to path,
+// creating the parent directory if it does not exist.
+func WriteFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	if _, err := f.Write(fileHeader); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ReadFile reads and removes the snapshot file at path, returning its
+// data. The file is removed even if it is invalid, so that it is not
+// read again. If the file does not exist, ReadFile returns nil data
+// and no error.
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	if len(data) < len(fileHeader) || !bytes.HasPrefix(data, fileHeader[:len(fileHeader)-1]) {
+		return nil, errMalformed
+	}
+	if version := data[len(fileHeader)-1]; version != fileHeader[len(fileHeader)-1] {
+		return nil, errors.Errorf("unsupported aggregation snapshot version %d", version)
+	}
+	return data[len(fileHeader):], nil
+}
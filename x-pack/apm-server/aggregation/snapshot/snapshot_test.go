@@ -0,0 +1,131 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/hyperloglog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/go-hdrhistogram"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	sketch := hyperloglog.New14()
+	for i := uint64(0); i < 100; i++ {
+		sketch.InsertHash(i * 0x9e3779b97f4a7c15)
+	}
+
+	var e Encoder
+	e.PutUvarint(123)
+	e.PutVarint(-123)
+	e.PutBool(true)
+	e.PutFloat64(1.5)
+	e.PutString("foo")
+	e.PutBytes([]byte{1, 2, 3})
+	e.PutTime(now)
+	e.PutTime(now.UTC())
+	e.PutTime(time.Time{})
+	e.PutSketch(sketch)
+	require.NoError(t, e.Err())
+
+	d := NewDecoder(e.Bytes())
+	assert.Equal(t, uint64(123), d.Uvarint())
+	assert.Equal(t, int64(-123), d.Varint())
+	assert.True(t, d.Bool())
+	assert.Equal(t, 1.5, d.Float64())
+	assert.Equal(t, "foo", d.String())
+	assert.Equal(t, []byte{1, 2, 3}, d.Bytes())
+	assert.Equal(t, now, d.Time())
+	assert.Equal(t, now.UTC(), d.Time())
+	assert.Equal(t, time.Time{}, d.Time())
+	decodedSketch := d.Sketch()
+	require.NoError(t, d.Err())
+	assert.Equal(t, sketch.Estimate(), decodedSketch.Estimate())
+	assert.Zero(t, d.Len())
+}
+
+func TestEncodeDecodeHistogram(t *testing.T) {
+	h := hdrhistogram.New(0, time.Hour.Microseconds(), 2)
+	for i := int64(1); i <= 10; i++ {
+		require.NoError(t, h.RecordValues(i*i*1000, i))
+	}
+	require.NoError(t, h.RecordValues(time.Hour.Microseconds(), 1000))
+
+	var e Encoder
+	e.PutHistogram(h)
+	// Only the non-empty buckets are encoded.
+	assert.Less(t, len(e.Bytes()), 100)
+
+	decoded := hdrhistogram.New(0, time.Hour.Microseconds(), 2)
+	d := NewDecoder(e.Bytes())
+	d.Histogram(decoded)
+	require.NoError(t, d.Err())
+	assert.Equal(t, h.Distribution(), decoded.Distribution())
+	assert.Equal(t, h.TotalCount(), decoded.TotalCount())
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	var e Encoder
+	e.PutString("foo")
+	d := NewDecoder(e.Bytes()[:2])
+	assert.Equal(t, "", d.String())
+	assert.EqualError(t, d.Err(), "malformed aggregation snapshot")
+
+	// Errors are sticky.
+	assert.Zero(t, d.Uvarint())
+	assert.Zero(t, d.Float64())
+	assert.EqualError(t, d.Err(), "malformed aggregation snapshot")
+}
+
+func TestDecodeHistogramOutOfRange(t *testing.T) {
+	var e Encoder
+	e.PutUvarint(1)       // one bucket
+	e.PutUvarint(1 << 40) // value out of range
+	e.PutUvarint(1)       // count
+
+	d := NewDecoder(e.Bytes())
+	d.Histogram(hdrhistogram.New(0, 1000, 2))
+	assert.Error(t, d.Err())
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subdir", "state")
+
+	data, err := ReadFile(path)
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+
+	require.NoError(t, WriteFile(path, []byte("foo")))
+	require.NoError(t, WriteFile(path, []byte("bar"))) // replaces
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1) // no temporary files left behind
+
+	data, err = ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bar"), data)
+	assert.NoFileExists(t, path)
+}
+
+func TestReadFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+
+	require.NoError(t, os.WriteFile(path, []byte("apm"), 0600))
+	_, err := ReadFile(path)
+	assert.EqualError(t, err, "malformed aggregation snapshot")
+	assert.NoFileExists(t, path)
+
+	require.NoError(t, os.WriteFile(path, []byte("apmagg\x02data"), 0600))
+	_, err = ReadFile(path)
+	assert.EqualError(t, err, "unsupported aggregation snapshot version 2")
+	assert.NoFileExists(t, path)
+}
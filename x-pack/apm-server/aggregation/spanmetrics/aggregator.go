@@ -63,6 +63,11 @@ type AggregatorConfig struct {
 	// this, once MaxGroups becomes 50% full then we will stop aggregating
 	// on span.name.
 	MaxGroups int
+
+	// StateFile, if non-empty, holds the path of a file to which in-flight
+	// aggregation state is written when the aggregator is stopped, and from
+	// which it is restored when a new aggregator is created.
+	StateFile string
 }
 
 // Validate validates the aggregator config.
@@ -112,6 +117,9 @@ func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
 		Logger:          config.Logger,
 		Interval:        config.Interval,
 		RollUpIntervals: config.RollUpIntervals,
+		StateFile:       config.StateFile,
+		EncodeStateFunc: aggregator.encodeState,
+		DecodeStateFunc: aggregator.decodeState,
 	})
 	if err != nil {
 		return nil, err
@@ -121,6 +129,7 @@ func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
 		aggregator.active[interval] = newMetricsBuffer(config.MaxGroups)
 		aggregator.inactive[interval] = newMetricsBuffer(config.MaxGroups)
 	}
+	aggregator.LoadState()
 	return &aggregator, nil
 }
 
@@ -0,0 +1,113 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package spanmetrics
+
+import (
+	"time"
+
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/snapshot"
+)
+
+// encodeState encodes the in-flight span metrics for interval.
+func (a *Aggregator) encodeState(interval time.Duration, e *snapshot.Encoder) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mb := a.active[interval]
+	// Groups with a span name are encoded first: once half of the group
+	// limit is used, storeOrUpdate drops span names from new groups, so
+	// restoring unnamed groups first would strip names from the others.
+	for _, named := range []bool{true, false} {
+		for _, entries := range mb.m {
+			for _, entry := range entries {
+				if (entry.spanName != "") != named {
+					continue
+				}
+				e.PutBool(false) // not overflow
+				entry.aggregationKey.encode(e)
+				entry.spanMetrics.encode(e)
+			}
+		}
+	}
+	if mb.other != nil {
+		e.PutBool(true) // overflow
+		mb.other.aggregationKey.encode(e)
+		mb.other.spanMetrics.encode(e)
+		e.PutSketch(mb.otherCardinalityEstimator)
+	}
+}
+
+// decodeState merges span metrics encoded by encodeState into the
+// in-flight metrics for interval. Restored groups are subject to the
+// configured limits, which may have changed since the state was persisted.
+func (a *Aggregator) decodeState(interval time.Duration, d *snapshot.Decoder) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mb := a.active[interval]
+	for d.Len() > 0 {
+		overflow := d.Bool()
+		var key aggregationKey
+		var metrics spanMetrics
+		key.decode(d)
+		metrics.decode(d)
+		if !overflow {
+			if err := d.Err(); err != nil {
+				return err
+			}
+			mb.storeOrUpdate(key, metrics, interval, a.config.Logger)
+			continue
+		}
+		sketch := d.Sketch()
+		if err := d.Err(); err != nil {
+			return err
+		}
+		if mb.other == nil {
+			mb.other = &metricsMapEntry{aggregationKey: key, spanMetrics: metrics}
+			mb.otherCardinalityEstimator = sketch
+			continue
+		}
+		mb.other.count += metrics.count
+		mb.other.sum += metrics.sum
+		if err := mb.otherCardinalityEstimator.Merge(sketch); err != nil {
+			return err
+		}
+	}
+	return d.Err()
+}
+
+func (k *aggregationKey) encode(e *snapshot.Encoder) {
+	k.AggregatedGlobalLabels.Encode(e)
+	e.PutTime(k.timestamp)
+	e.PutString(k.serviceName)
+	e.PutString(k.serviceEnvironment)
+	e.PutString(k.agentName)
+	e.PutString(k.spanName)
+	e.PutString(k.outcome)
+	e.PutString(k.targetType)
+	e.PutString(k.targetName)
+	e.PutString(k.resource)
+}
+
+func (k *aggregationKey) decode(d *snapshot.Decoder) {
+	k.AggregatedGlobalLabels.Decode(d)
+	k.timestamp = d.Time()
+	k.serviceName = d.String()
+	k.serviceEnvironment = d.String()
+	k.agentName = d.String()
+	k.spanName = d.String()
+	k.outcome = d.String()
+	k.targetType = d.String()
+	k.targetName = d.String()
+	k.resource = d.String()
+}
+
+func (m *spanMetrics) encode(e *snapshot.Encoder) {
+	e.PutFloat64(m.count)
+	e.PutFloat64(m.sum)
+}
+
+func (m *spanMetrics) decode(d *snapshot.Decoder) {
+	m.count = d.Float64()
+	m.sum = d.Float64()
+}
@@ -21,7 +21,9 @@ import (
 
 	"github.com/elastic/apm-data/model"
 	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/model/modeldimension"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/baseaggregator"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/histogram"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/interval"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/labels"
 )
@@ -55,7 +57,8 @@ type Aggregator struct {
 	config  AggregatorConfig
 	metrics *aggregatorMetrics
 
-	overflowLogger *logp.Logger
+	extraDimensions []modeldimension.Dimension
+	overflowLogger  *logp.Logger
 
 	mu               sync.RWMutex
 	active, inactive map[time.Duration]*metrics
@@ -118,6 +121,22 @@ type AggregatorConfig struct {
 	// to maintain in the HDR Histograms. HDRHistogramSignificantFigures
 	// must be in the range [1,5].
 	HDRHistogramSignificantFigures int
+
+	// HistogramRepresentation controls how transaction duration histograms
+	// are represented in published metrics. Durations are always aggregated
+	// in HDR histograms, and converted when metrics are published.
+	HistogramRepresentation histogram.Representation
+
+	// ExtraDimensions holds event field paths, such as "http.request.method"
+	// or "labels.tenant", to aggregate transaction metrics by in addition to
+	// the default dimensions. Additional dimensions increase the number of
+	// transaction groups, which remain limited by MaxTransactionGroups.
+	ExtraDimensions []string
+
+	// StateFile, if non-empty, holds the path of a file to which in-flight
+	// aggregation state is written when the aggregator is stopped, and from
+	// which it is restored when a new aggregator is created.
+	StateFile string
 }
 
 // Validate validates the aggregator config.
@@ -137,6 +156,11 @@ func (config AggregatorConfig) Validate() error {
 	if n := config.HDRHistogramSignificantFigures; n < 1 || n > 5 {
 		return errors.Errorf("HDRHistogramSignificantFigures (%d) outside range [1,5]", n)
 	}
+	for i, field := range config.ExtraDimensions {
+		if _, err := modeldimension.Parse(field); err != nil {
+			return errors.Wrapf(err, "ExtraDimensions[%d]", i)
+		}
+	}
 	return nil
 }
 
@@ -148,12 +172,17 @@ func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
 	if config.Logger == nil {
 		config.Logger = logp.NewLogger(logs.TransactionMetrics)
 	}
+	extraDimensions := make([]modeldimension.Dimension, len(config.ExtraDimensions))
+	for i, field := range config.ExtraDimensions {
+		extraDimensions[i], _ = modeldimension.Parse(field) // validated above
+	}
 	aggregator := Aggregator{
-		config:         config,
-		metrics:        &aggregatorMetrics{},
-		overflowLogger: config.Logger.WithOptions(logs.WithRateLimit(overflowLoggerRateLimit)),
-		active:         make(map[time.Duration]*metrics),
-		inactive:       make(map[time.Duration]*metrics),
+		config:          config,
+		metrics:         &aggregatorMetrics{},
+		extraDimensions: extraDimensions,
+		overflowLogger:  config.Logger.WithOptions(logs.WithRateLimit(overflowLoggerRateLimit)),
+		active:          make(map[time.Duration]*metrics),
+		inactive:        make(map[time.Duration]*metrics),
 		histogramPool: sync.Pool{New: func() interface{} {
 			return hdrhistogram.New(
 				minDuration.Microseconds(),
@@ -167,6 +196,10 @@ func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
 		Logger:          config.Logger,
 		Interval:        config.MetricsInterval,
 		RollUpIntervals: config.RollUpIntervals,
+		StateFile:       config.StateFile,
+		EncodeStateFunc: aggregator.encodeState,
+		DecodeStateFunc: aggregator.decodeState,
+		StateHeader:     stateHeader(config.ExtraDimensions),
 	})
 	if err != nil {
 		return nil, err
@@ -176,6 +209,7 @@ func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
 		aggregator.active[interval] = newMetrics(config.MaxTransactionGroups, config.MaxServices)
 		aggregator.inactive[interval] = newMetrics(config.MaxTransactionGroups, config.MaxServices)
 	}
+	aggregator.LoadState()
 	return &aggregator, nil
 }
 
@@ -228,7 +262,7 @@ func (a *Aggregator) publish(ctx context.Context, period time.Duration) error {
 		for _, entries := range svcEntry.m {
 			for _, entry := range entries {
 				// Record the metricset interval as metricset.interval.
-				event := makeMetricset(entry.transactionAggregationKey, entry.transactionMetrics, intervalStr)
+				event := a.makeMetricset(entry.transactionAggregationKey, entry.transactionMetrics, intervalStr)
 				batch = append(batch, event)
 				entry.reset(&a.histogramPool)
 			}
@@ -246,7 +280,7 @@ func (a *Aggregator) publish(ctx context.Context, period time.Duration) error {
 			}
 			entry := svcEntry.other
 			// Record the metricset interval as metricset.interval.
-			m := makeMetricset(entry.transactionAggregationKey, entry.transactionMetrics, intervalStr)
+			m := a.makeMetricset(entry.transactionAggregationKey, entry.transactionMetrics, intervalStr)
 			m.Metricset.Samples = append(m.Metricset.Samples, model.MetricsetSample{
 				Name:  "transaction.aggregation.overflow_count",
 				Value: float64(overflowCount),
@@ -310,7 +344,7 @@ func (a *Aggregator) AggregateTransaction(event model.APMEvent) {
 		return
 	}
 	for _, interval := range a.Intervals {
-		key := makeTransactionAggregationKey(event, interval)
+		key := makeTransactionAggregationKey(event, interval, a.extraDimensions)
 		if err := a.updateTransactionMetrics(key, count, event.Event.Duration, interval); err != nil {
 			a.config.Logger.Errorf("failed to aggregate transaction: %w", err)
 		}
@@ -345,13 +379,30 @@ func (a *Aggregator) updateTransactionMetrics(key transactionAggregationKey, cou
 	// If cannot find a pre-existing transaction group then acquire a write lock in
 	// order to create a new transaction group. To protect against race conditions due
 	// to manual upgrade of lock search for the transaction group again.
-	var svc *svcMetricsMapEntry
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	svc, entry, _ = m.searchMetricsEntry(hash, key, offset)
+	entry, err := a.getOrCreateMetricsEntry(m, hash, key, offset, interval)
+	if err != nil {
+		return err
+	}
+	entry.recordDuration(duration, count)
+	return nil
+}
+
+// getOrCreateMetricsEntry returns the metrics entry for key, searching from
+// offset, and creating a new entry if none exists. New entries are subject
+// to the transaction group and service limits, and may be recorded in an
+// overflow bucket. The caller must hold m.mu for writing.
+func (a *Aggregator) getOrCreateMetricsEntry(
+	m *metrics,
+	hash uint64,
+	key transactionAggregationKey,
+	offset int,
+	interval time.Duration,
+) (*metricsMapEntry, error) {
+	svc, entry, _ := m.searchMetricsEntry(hash, key, offset)
 	if entry != nil {
-		entry.recordDuration(duration, count)
-		return nil
+		return entry, nil
 	}
 
 	// If all attempts to search for existing transaction group have failed then a new
@@ -373,7 +424,7 @@ func (a *Aggregator) updateTransactionMetrics(key transactionAggregationKey, cou
 		svcOverflow = m.services >= a.config.MaxServices
 		svc, err = m.newServiceEntry(key.serviceName, svcOverflow)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	perSvcTxnOverflow = svc.entries >= a.config.MaxTransactionGroupsPerService
@@ -387,12 +438,7 @@ func (a *Aggregator) updateTransactionMetrics(key transactionAggregationKey, cou
 		key = makeOverflowAggregationKey(key, svcOverflow, interval)
 		a.logOverflow(key.serviceName, interval, svcOverflow, perSvcTxnOverflow, txnOverflow)
 	}
-	entry, err = m.newMetricsEntry(svc, hash, key, &a.histogramPool, overflow)
-	if err != nil {
-		return err
-	}
-	entry.recordDuration(duration, count)
-	return nil
+	return m.newMetricsEntry(svc, hash, key, &a.histogramPool, overflow)
 }
 
 func (a *Aggregator) logOverflow(svcName string, interval time.Duration, svcOverflow, perSvcTxnOverflow, txnOverflow bool) {
@@ -457,7 +503,11 @@ func makeOverflowAggregationKey(
 	}
 }
 
-func makeTransactionAggregationKey(event model.APMEvent, interval time.Duration) transactionAggregationKey {
+func makeTransactionAggregationKey(
+	event model.APMEvent,
+	interval time.Duration,
+	extraDimensions []modeldimension.Dimension,
+) transactionAggregationKey {
 	key := transactionAggregationKey{
 		comparable: comparable{
 			// Group metrics by time interval.
@@ -501,16 +551,24 @@ func makeTransactionAggregationKey(event model.APMEvent, interval time.Duration)
 			faasTriggerType: event.FAAS.TriggerType,
 			faasName:        event.FAAS.Name,
 			faasVersion:     event.FAAS.Version,
+
+			extraDimensions: makeExtraDimensionsKey(&event, extraDimensions),
 		},
 	}
 	key.AggregatedGlobalLabels.Read(&event)
 	return key
 }
 
-// makeMetricset creates a metricset with key, metrics, and interval.
+// makeMetricset creates a metricset with key, metrics, and interval, and the
+// aggregator's extra dimensions and histogram representation.
 // It uses result from histogram for Transaction.DurationSummary and DocCount to avoid discrepancy and UI weirdness.
-func makeMetricset(key transactionAggregationKey, metrics transactionMetrics, interval string) model.APMEvent {
+func (a *Aggregator) makeMetricset(
+	key transactionAggregationKey,
+	metrics transactionMetrics,
+	interval string,
+) model.APMEvent {
 	totalCount, counts, values := metrics.histogramBuckets()
+	counts, values = a.config.HistogramRepresentation.Convert(counts, values)
 
 	var eventSuccessCount model.SummaryMetric
 	switch key.eventOutcome {
@@ -530,7 +588,7 @@ func makeMetricset(key transactionAggregationKey, metrics transactionMetrics, in
 		transactionDurationSummary.Sum += v * float64(counts[i])
 	}
 
-	return model.APMEvent{
+	event := model.APMEvent{
 		Timestamp:  key.timestamp,
 		Agent:      model.Agent{Name: key.agentName},
 		Container:  model.Container{ID: key.containerID},
@@ -598,6 +656,8 @@ func makeMetricset(key transactionAggregationKey, metrics transactionMetrics, in
 			DurationSummary: transactionDurationSummary,
 		},
 	}
+	setExtraDimensions(&event, a.extraDimensions, key.extraDimensions)
+	return event
 }
 
 type metrics struct {
@@ -822,6 +882,7 @@ type comparable struct {
 	hostHostname           string
 	hostName               string
 	containerID            string
+	extraDimensions        string
 	traceRoot              bool
 }
 
@@ -875,6 +936,7 @@ func (k *transactionAggregationKey) hash() uint64 {
 	h.WriteString(k.faasTriggerType)
 	h.WriteString(k.faasName)
 	h.WriteString(k.faasVersion)
+	h.WriteString(k.extraDimensions)
 	return h.Sum64()
 }
 
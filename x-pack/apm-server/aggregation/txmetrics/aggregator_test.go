@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"net/netip"
+	"path/filepath"
 	"sort"
 	"testing"
 	"time"
@@ -22,6 +23,7 @@ import (
 	"go.uber.org/zap/zaptest/observer"
 
 	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/histogram"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/txmetrics"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/monitoring"
@@ -75,6 +77,17 @@ func TestNewAggregatorConfigInvalid(t *testing.T) {
 			HDRHistogramSignificantFigures: 6,
 		},
 		err: "HDRHistogramSignificantFigures (6) outside range [1,5]",
+	}, {
+		config: txmetrics.AggregatorConfig{
+			BatchProcessor:                 batchProcessor,
+			MaxTransactionGroups:           1,
+			MaxTransactionGroupsPerService: 1,
+			MaxServices:                    1,
+			MetricsInterval:                time.Nanosecond,
+			HDRHistogramSignificantFigures: 5,
+			ExtraDimensions:                []string{"labels.tenant", "foo"},
+		},
+		err: `ExtraDimensions[1]: unsupported field "foo"`,
 	}} {
 		agg, err := txmetrics.NewAggregator(test.config)
 		require.Error(t, err)
@@ -491,6 +504,128 @@ func TestAggregatorRunPublishErrors(t *testing.T) {
 	}
 }
 
+func TestAggregatorPersistState(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "txmetrics.state")
+	newAggregator := func(stateFile string, batches chan model.Batch) *txmetrics.Aggregator {
+		agg, err := txmetrics.NewAggregator(txmetrics.AggregatorConfig{
+			BatchProcessor:                 makeChanBatchProcessor(batches),
+			MaxTransactionGroups:           2,
+			MaxTransactionGroupsPerService: 2,
+			MaxServices:                    2,
+			MetricsInterval:                100 * time.Millisecond,
+			HDRHistogramSignificantFigures: 2,
+			StateFile:                      stateFile,
+		})
+		require.NoError(t, err)
+		return agg
+	}
+	aggregate := func(agg *txmetrics.Aggregator, now time.Time) {
+		for i, name := range []string{"T-1", "T-2", "T-3", "T-1"} {
+			agg.AggregateTransaction(model.APMEvent{
+				Timestamp: now,
+				Event:     model.Event{Duration: time.Duration(i+1) * time.Millisecond},
+				Labels: model.Labels{
+					"department_name": model.LabelValue{Global: true, Value: "apm"},
+				},
+				NumericLabels: model.NumericLabels{
+					"cost_center": model.NumericLabelValue{Global: true, Values: []float64{1, 2}},
+				},
+				Processor:   model.TransactionProcessor,
+				Service:     model.Service{Name: "svc"},
+				Transaction: &model.Transaction{Name: name, RepresentativeCount: 1.5},
+			})
+		}
+	}
+	now := time.Now()
+
+	// Aggregate the same transactions in an aggregator without a state
+	// file, which publishes its metrics when stopped, for comparison.
+	expectedBatches := make(chan model.Batch, 1)
+	agg := newAggregator("", expectedBatches)
+	aggregate(agg, now)
+	go agg.Run()
+	require.NoError(t, agg.Stop(context.Background()))
+	expected := batchMetricsets(t, expectBatch(t, expectedBatches))
+	require.Len(t, expected, 3) // T-1, T-2, and overflow
+
+	// Stopping an aggregator with a state file should persist
+	// its state rather than publishing metrics.
+	batches := make(chan model.Batch, 1)
+	agg = newAggregator(stateFile, batches)
+	aggregate(agg, now)
+	go agg.Run()
+	require.NoError(t, agg.Stop(context.Background()))
+	select {
+	case <-batches:
+		t.Fatal("unexpected publish")
+	default:
+	}
+	assert.FileExists(t, stateFile)
+
+	// A new aggregator should restore the state, removing the
+	// file, and publish the restored metrics.
+	agg = newAggregator(stateFile, batches)
+	assert.NoFileExists(t, stateFile)
+	go agg.Run()
+	defer agg.Stop(context.Background())
+	actual := batchMetricsets(t, expectBatch(t, batches))
+
+	for _, metricsets := range [][]model.APMEvent{expected, actual} {
+		sort.Slice(metricsets, func(i, j int) bool {
+			return metricsets[i].Transaction.Name < metricsets[j].Transaction.Name
+		})
+		// Overflow buckets are timestamped at evaluation time.
+		overflow := &metricsets[len(metricsets)-1]
+		assert.Equal(t, "_other", overflow.Transaction.Name)
+		overflow.Timestamp = time.Time{}
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestAggregatorPersistStateExtraDimensionsChanged(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "txmetrics.state")
+	newAggregator := func(extraDimensions []string, batches chan model.Batch) *txmetrics.Aggregator {
+		agg, err := txmetrics.NewAggregator(txmetrics.AggregatorConfig{
+			BatchProcessor:                 makeChanBatchProcessor(batches),
+			MaxTransactionGroups:           2,
+			MaxTransactionGroupsPerService: 2,
+			MaxServices:                    2,
+			MetricsInterval:                100 * time.Millisecond,
+			HDRHistogramSignificantFigures: 2,
+			ExtraDimensions:                extraDimensions,
+			StateFile:                      stateFile,
+		})
+		require.NoError(t, err)
+		return agg
+	}
+
+	batches := make(chan model.Batch, 1)
+	agg := newAggregator([]string{"http.request.method"}, batches)
+	agg.AggregateTransaction(model.APMEvent{
+		Timestamp:   time.Now(),
+		Event:       model.Event{Duration: time.Millisecond},
+		HTTP:        model.HTTP{Request: &model.HTTPRequest{Method: "GET"}},
+		Processor:   model.TransactionProcessor,
+		Service:     model.Service{Name: "svc"},
+		Transaction: &model.Transaction{Name: "T-1", RepresentativeCount: 1},
+	})
+	go agg.Run()
+	require.NoError(t, agg.Stop(context.Background()))
+	assert.FileExists(t, stateFile)
+
+	// State persisted with different extra dimensions is discarded,
+	// rather than being restored into the wrong dimensions.
+	agg = newAggregator([]string{"url.scheme"}, batches)
+	assert.NoFileExists(t, stateFile)
+	go agg.Run()
+	defer agg.Stop(context.Background())
+	select {
+	case <-batches:
+		t.Fatal("unexpected publish")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
 func TestAggregateRepresentativeCount(t *testing.T) {
 	for _, tc := range []struct {
 		name                 string
@@ -639,6 +774,51 @@ func testHDRHistogramSignificantFigures(t *testing.T, sigfigs int) {
 	})
 }
 
+func TestHistogramRepresentation(t *testing.T) {
+	aggregate := func(representation histogram.Representation) model.APMEvent {
+		batches := make(chan model.Batch, 1)
+		agg, err := txmetrics.NewAggregator(txmetrics.AggregatorConfig{
+			BatchProcessor:                 makeChanBatchProcessor(batches),
+			MaxTransactionGroups:           1,
+			MaxTransactionGroupsPerService: 1,
+			MaxServices:                    1,
+			MetricsInterval:                time.Minute,
+			HDRHistogramSignificantFigures: 2,
+			HistogramRepresentation:        representation,
+		})
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			agg.AggregateTransaction(model.APMEvent{
+				Processor:   model.TransactionProcessor,
+				Event:       model.Event{Duration: time.Duration(i*i) * time.Microsecond},
+				Transaction: &model.Transaction{Name: "T", RepresentativeCount: 1},
+			})
+		}
+		go agg.Run()
+		require.NoError(t, agg.Stop(context.Background()))
+		metricsets := batchMetricsets(t, expectBatch(t, batches))
+		require.Len(t, metricsets, 1)
+		return metricsets[0]
+	}
+
+	hdr := aggregate(histogram.HDR)
+	for _, representation := range []histogram.Representation{histogram.Exponential, histogram.TDigest} {
+		t.Run(representation.String(), func(t *testing.T) {
+			m := aggregate(representation)
+			counts, values := representation.Convert(
+				hdr.Transaction.DurationHistogram.Counts,
+				hdr.Transaction.DurationHistogram.Values,
+			)
+			assert.Equal(t, counts, m.Transaction.DurationHistogram.Counts)
+			assert.Equal(t, values, m.Transaction.DurationHistogram.Values)
+			assert.Less(t, len(counts), len(hdr.Transaction.DurationHistogram.Counts))
+			assert.Equal(t, hdr.Metricset.DocCount, m.Metricset.DocCount)
+			assert.Equal(t, hdr.Transaction.DurationSummary.Count, m.Transaction.DurationSummary.Count)
+			assert.InEpsilon(t, hdr.Transaction.DurationSummary.Sum, m.Transaction.DurationSummary.Sum, 0.03)
+		})
+	}
+}
+
 func TestAggregationFields(t *testing.T) {
 	batches := make(chan model.Batch, 1)
 	agg, err := txmetrics.NewAggregator(txmetrics.AggregatorConfig{
@@ -764,6 +944,79 @@ func TestAggregationFields(t *testing.T) {
 	assert.ElementsMatch(t, expected, metricsets)
 }
 
+func TestAggregateExtraDimensions(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := txmetrics.NewAggregator(txmetrics.AggregatorConfig{
+		BatchProcessor:                 makeChanBatchProcessor(batches),
+		MaxTransactionGroups:           3,
+		MaxTransactionGroupsPerService: 3,
+		MaxServices:                    1,
+		MetricsInterval:                100 * time.Millisecond,
+		HDRHistogramSignificantFigures: 1,
+		ExtraDimensions:                []string{"http.request.method", "labels.tenant", "numeric_labels.shard"},
+	})
+	require.NoError(t, err)
+	go agg.Run()
+	defer agg.Stop(context.Background())
+
+	makeTransaction := func(method, tenant string, shard float64) model.APMEvent {
+		return model.APMEvent{
+			Processor:     model.TransactionProcessor,
+			Service:       model.Service{Name: "svc"},
+			HTTP:          model.HTTP{Request: &model.HTTPRequest{Method: method, Referrer: "ignored"}},
+			Labels:        model.Labels{"tenant": {Value: tenant}, "other": {Value: "ignored"}},
+			NumericLabels: model.NumericLabels{"shard": {Value: shard}},
+			Transaction:   &model.Transaction{Name: "T", RepresentativeCount: 1},
+		}
+	}
+	for _, event := range []model.APMEvent{
+		makeTransaction("GET", "a", 1),
+		makeTransaction("GET", "a", 1),
+		makeTransaction("POST", "a", 1),
+		makeTransaction("GET", "b", 2),
+		makeTransaction("PUT", "c", 3), // overflow
+	} {
+		agg.AggregateTransaction(event)
+	}
+
+	type group struct {
+		method   string
+		tenant   string
+		shard    float64
+		docCount int64
+	}
+	var groups []group
+	var overflow *model.APMEvent
+	for _, m := range batchMetricsets(t, expectBatch(t, batches)) {
+		m := m
+		if m.Transaction.Name == "_other" {
+			overflow = &m
+			continue
+		}
+		require.NotNil(t, m.HTTP.Request)
+		assert.Equal(t, model.HTTPRequest{Method: m.HTTP.Request.Method}, *m.HTTP.Request)
+		assert.Len(t, m.Labels, 1)
+		assert.Len(t, m.NumericLabels, 1)
+		groups = append(groups, group{
+			method:   m.HTTP.Request.Method,
+			tenant:   m.Labels["tenant"].Value,
+			shard:    m.NumericLabels["shard"].Value,
+			docCount: m.Metricset.DocCount,
+		})
+	}
+	assert.ElementsMatch(t, []group{
+		{method: "GET", tenant: "a", shard: 1, docCount: 2},
+		{method: "POST", tenant: "a", shard: 1, docCount: 1},
+		{method: "GET", tenant: "b", shard: 2, docCount: 1},
+	}, groups)
+
+	require.NotNil(t, overflow)
+	assert.Equal(t, int64(1), overflow.Metricset.DocCount)
+	assert.Nil(t, overflow.HTTP.Request)
+	assert.Empty(t, overflow.Labels)
+	assert.Empty(t, overflow.NumericLabels)
+}
+
 func BenchmarkAggregateTransaction(b *testing.B) {
 	agg, err := txmetrics.NewAggregator(txmetrics.AggregatorConfig{
 		BatchProcessor:                 makeErrBatchProcessor(nil),
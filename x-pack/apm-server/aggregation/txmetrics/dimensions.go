@@ -0,0 +1,47 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package txmetrics
+
+import (
+	"encoding/binary"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/internal/model/modeldimension"
+)
+
+// makeExtraDimensionsKey returns the values of the extra dimensions for
+// event, encoded as a string for inclusion in an aggregation key.
+func makeExtraDimensionsKey(event *model.APMEvent, dimensions []modeldimension.Dimension) string {
+	if len(dimensions) == 0 {
+		return ""
+	}
+	var buf []byte
+	for _, dimension := range dimensions {
+		value := dimension.Get(event)
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		buf = append(buf, value...)
+	}
+	return string(buf)
+}
+
+// setExtraDimensions sets the extra dimension fields of event from
+// values encoded by makeExtraDimensionsKey.
+func setExtraDimensions(event *model.APMEvent, dimensions []modeldimension.Dimension, key string) {
+	for _, dimension := range dimensions {
+		if key == "" {
+			// Overflow buckets have no extra dimensions.
+			return
+		}
+		n, size := binary.Uvarint([]byte(key))
+		if size <= 0 || uint64(len(key)-size) < n {
+			return
+		}
+		value := key[size : size+int(n)]
+		key = key[size+int(n):]
+		if value != "" {
+			dimension.Set(event, value)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package txmetrics
+
+import (
+	"time"
+
+	"github.com/axiomhq/hyperloglog"
+
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/snapshot"
+)
+
+// stateHeader returns the header for persisted state, recording the extra
+// dimensions whose values are encoded in aggregation keys. State persisted
+// with different extra dimensions is discarded, as its keys would otherwise
+// be decoded into the wrong dimensions.
+func stateHeader(extraDimensions []string) []byte {
+	var e snapshot.Encoder
+	e.PutUvarint(uint64(len(extraDimensions)))
+	for _, field := range extraDimensions {
+		e.PutString(field)
+	}
+	return e.Bytes()
+}
+
+// encodeState encodes the in-flight transaction metrics for interval.
+func (a *Aggregator) encodeState(interval time.Duration, e *snapshot.Encoder) {
+	// Take an exclusive lock to prevent concurrent histogram updates.
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, svc := range a.active[interval].m {
+		for _, entries := range svc.m {
+			for _, entry := range entries {
+				e.PutBool(false) // not overflow
+				entry.transactionAggregationKey.encode(e)
+				e.PutHistogram(entry.histogram)
+			}
+		}
+		if svc.other != nil {
+			e.PutBool(true) // overflow
+			svc.other.transactionAggregationKey.encode(e)
+			e.PutSketch(svc.otherCardinalityEstimator)
+			e.PutHistogram(svc.other.histogram)
+		}
+	}
+}
+
+// decodeState merges transaction metrics encoded by encodeState into
+// the in-flight metrics for interval.
+func (a *Aggregator) decodeState(interval time.Duration, d *snapshot.Decoder) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := a.active[interval]
+	for d.Len() > 0 {
+		overflow := d.Bool()
+		var key transactionAggregationKey
+		key.decode(d)
+		var sketch *hyperloglog.Sketch
+		if overflow {
+			sketch = d.Sketch()
+		}
+		if err := d.Err(); err != nil {
+			return err
+		}
+		entry, err := a.restoreMetricsEntry(m, key, sketch, interval)
+		if err != nil {
+			return err
+		}
+		d.Histogram(entry.histogram)
+	}
+	return d.Err()
+}
+
+// restoreMetricsEntry returns the metrics entry into which restored
+// metrics for key should be merged. If sketch is non-nil, key identifies
+// an overflow bucket and sketch holds its cardinality estimate.
+//
+// Restored transaction groups are subject to the configured limits,
+// which may have changed since the state was persisted.
+func (a *Aggregator) restoreMetricsEntry(
+	m *metrics,
+	key transactionAggregationKey,
+	sketch *hyperloglog.Sketch,
+	interval time.Duration,
+) (*metricsMapEntry, error) {
+	hash := key.hash()
+	if sketch == nil {
+		return a.getOrCreateMetricsEntry(m, hash, key, 0, interval)
+	}
+	svc, ok := m.m[key.serviceName]
+	if !ok {
+		svcOverflow := key.serviceName == overflowBucketName
+		if !svcOverflow && m.services >= a.config.MaxServices {
+			svcOverflow = true
+			key = makeOverflowAggregationKey(key, true, interval)
+		}
+		var err error
+		svc, err = m.newServiceEntry(key.serviceName, svcOverflow)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if svc.other != nil {
+		if err := svc.otherCardinalityEstimator.Merge(sketch); err != nil {
+			return nil, err
+		}
+		return svc.other, nil
+	}
+	entry, err := m.newMetricsEntry(svc, hash, key, &a.histogramPool, true)
+	if err != nil {
+		return nil, err
+	}
+	svc.otherCardinalityEstimator = sketch
+	return entry, nil
+}
+
+func (k *transactionAggregationKey) encode(e *snapshot.Encoder) {
+	k.AggregatedGlobalLabels.Encode(e)
+	e.PutTime(k.timestamp)
+	e.PutBool(k.traceRoot)
+	e.PutBool(k.faasColdstart.isSet)
+	e.PutBool(k.faasColdstart.val)
+	for _, field := range k.stringFields() {
+		e.PutString(*field)
+	}
+}
+
+func (k *transactionAggregationKey) decode(d *snapshot.Decoder) {
+	k.AggregatedGlobalLabels.Decode(d)
+	k.timestamp = d.Time()
+	k.traceRoot = d.Bool()
+	k.faasColdstart.isSet = d.Bool()
+	k.faasColdstart.val = d.Bool()
+	for _, field := range k.stringFields() {
+		*field = d.String()
+	}
+}
+
+// stringFields returns pointers to the key's string fields, in the order
+// in which they are encoded. Changing the fields changes the encoding of
+// persisted state, which requires the snapshot version to be incremented.
+func (k *comparable) stringFields() []*string {
+	return []*string{
+		&k.faasID,
+		&k.faasName,
+		&k.faasVersion,
+		&k.faasTriggerType,
+		&k.agentName,
+		&k.hostOSPlatform,
+		&k.hostHostname,
+		&k.hostName,
+		&k.containerID,
+		&k.kubernetesPodName,
+		&k.cloudProvider,
+		&k.cloudRegion,
+		&k.cloudAvailabilityZone,
+		&k.cloudServiceName,
+		&k.cloudAccountID,
+		&k.cloudAccountName,
+		&k.cloudMachineType,
+		&k.cloudProjectID,
+		&k.cloudProjectName,
+		&k.serviceEnvironment,
+		&k.serviceName,
+		&k.serviceVersion,
+		&k.serviceNodeName,
+		&k.serviceRuntimeName,
+		&k.serviceRuntimeVersion,
+		&k.serviceLanguageName,
+		&k.serviceLanguageVersion,
+		&k.transactionName,
+		&k.transactionResult,
+		&k.transactionType,
+		&k.eventOutcome,
+		&k.extraDimensions,
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -18,6 +19,8 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/elastic/beats/v7/libbeat/common/reload"
 	"github.com/elastic/beats/v7/x-pack/libbeat/management"
@@ -27,6 +30,7 @@ import (
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/elastic-agent-libs/paths"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
 
@@ -34,6 +38,10 @@ import (
 	"github.com/elastic/apm-data/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/beatcmd"
 	"github.com/elastic/apm-server/internal/beater"
+	"github.com/elastic/apm-server/internal/beater/api/admin"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/errormetrics"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/histogram"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/servicesummarymetrics"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/servicetxmetrics"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/spanmetrics"
@@ -41,10 +49,12 @@ import (
 	"github.com/elastic/apm-server/x-pack/apm-server/profiling"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/peers"
 )
 
 const (
 	tailSamplingStorageDir = "tail_sampling"
+	aggregationStateDir    = "aggregation"
 	metricsInterval        = time.Minute
 )
 
@@ -59,8 +69,11 @@ var (
 	badgerMu sync.Mutex
 	badgerDB *badger.DB
 
+	// storage holds the sharded read/writers for the badger database,
+	// keyed by storage codec. The codec may change when the config is
+	// reloaded; both codecs decode events encoded by either codec.
 	storageMu sync.Mutex
-	storage   *eventstorage.ShardedReadWriter
+	storage   = make(map[string]*eventstorage.ShardedReadWriter)
 
 	// samplerUUID is a UUID used to identify sampled trace ID documents
 	// published by this process.
@@ -106,8 +119,22 @@ type processor interface {
 // events in sequential order, prior to the events being published.
 func newProcessors(args beater.ServerParams) ([]namedProcessor, error) {
 	processors := make([]namedProcessor, 0, 5)
+
+	// aggregationStateFile returns the path of the file in which the named
+	// aggregator's state is persisted, or "" if persistence is disabled.
+	aggregationStateFile := func(name string) string {
+		if !args.Config.Aggregation.PersistState {
+			return ""
+		}
+		return paths.Resolve(paths.Data, filepath.Join(aggregationStateDir, name))
+	}
+
 	const txName = "transaction metrics aggregation"
 	args.Logger.Infof("creating %s with config: %+v", txName, args.Config.Aggregation.Transactions)
+	txHistogramRepresentation, err := histogram.ParseRepresentation(args.Config.Aggregation.Transactions.HistogramRepresentation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating %s", txName)
+	}
 	agg, err := txmetrics.NewAggregator(txmetrics.AggregatorConfig{
 		BatchProcessor:                 args.BatchProcessor,
 		MaxTransactionGroups:           args.Config.Aggregation.Transactions.MaxTransactionGroups,
@@ -116,6 +143,9 @@ func newProcessors(args beater.ServerParams) ([]namedProcessor, error) {
 		MaxTransactionGroupsPerService: int(math.Ceil(0.1 * float64(args.Config.Aggregation.Transactions.MaxTransactionGroups))),
 		MaxServices:                    args.Config.Aggregation.Transactions.MaxServices,
 		HDRHistogramSignificantFigures: args.Config.Aggregation.Transactions.HDRHistogramSignificantFigures,
+		HistogramRepresentation:        txHistogramRepresentation,
+		ExtraDimensions:                args.Config.Aggregation.Transactions.ExtraDimensions,
+		StateFile:                      aggregationStateFile("txmetrics"),
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "error creating %s", txName)
@@ -131,6 +161,7 @@ func newProcessors(args beater.ServerParams) ([]namedProcessor, error) {
 		Interval:        metricsInterval,
 		RollUpIntervals: rollUpMetricsIntervals,
 		MaxGroups:       args.Config.Aggregation.ServiceDestinations.MaxGroups,
+		StateFile:       aggregationStateFile("spanmetrics"),
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "error creating %s", spanName)
@@ -146,12 +177,18 @@ func newProcessors(args beater.ServerParams) ([]namedProcessor, error) {
 
 	const serviceTxName = "service transaction metrics aggregation"
 	args.Logger.Infof("creating %s with config: %+v", serviceTxName, args.Config.Aggregation.ServiceTransactions)
+	serviceTxHistogramRepresentation, err := histogram.ParseRepresentation(args.Config.Aggregation.ServiceTransactions.HistogramRepresentation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating %s", serviceTxName)
+	}
 	serviceTxAggregator, err := servicetxmetrics.NewAggregator(servicetxmetrics.AggregatorConfig{
 		BatchProcessor:                 args.BatchProcessor,
 		Interval:                       metricsInterval,
 		RollUpIntervals:                rollUpMetricsIntervals,
 		MaxGroups:                      args.Config.Aggregation.ServiceTransactions.MaxGroups,
 		HDRHistogramSignificantFigures: args.Config.Aggregation.ServiceTransactions.HDRHistogramSignificantFigures,
+		HistogramRepresentation:        serviceTxHistogramRepresentation,
+		StateFile:                      aggregationStateFile("servicetxmetrics"),
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "error creating %s", serviceTxName)
@@ -172,6 +209,7 @@ func newProcessors(args beater.ServerParams) ([]namedProcessor, error) {
 		Interval:        metricsInterval,
 		RollUpIntervals: rollUpMetricsIntervals,
 		MaxGroups:       args.Config.Aggregation.ServiceTransactions.MaxGroups,
+		StateFile:       aggregationStateFile("servicesummarymetrics"),
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "error creating %s", serviceSummaryName)
@@ -185,6 +223,27 @@ func newProcessors(args beater.ServerParams) ([]namedProcessor, error) {
 		monitoring.Report,
 	)
 
+	const errorName = "error metrics aggregation"
+	args.Logger.Infof("creating %s with config: %+v", errorName, args.Config.Aggregation.Errors)
+	errorAggregator, err := errormetrics.NewAggregator(errormetrics.AggregatorConfig{
+		BatchProcessor:  args.BatchProcessor,
+		Interval:        metricsInterval,
+		RollUpIntervals: rollUpMetricsIntervals,
+		MaxGroups:       args.Config.Aggregation.Errors.MaxGroups,
+		StateFile:       aggregationStateFile("errormetrics"),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating %s", errorName)
+	}
+	processors = append(processors, namedProcessor{name: errorName, processor: errorAggregator})
+	aggregationMonitoringRegistry.Remove("errormetrics")
+	monitoring.NewFunc(
+		aggregationMonitoringRegistry,
+		"errormetrics",
+		errorAggregator.CollectMonitoring,
+		monitoring.Report,
+	)
+
 	if args.Config.Sampling.Tail.Enabled {
 		const name = "tail sampler"
 		sampler, err := newTailSamplingProcessor(args)
@@ -210,21 +269,35 @@ func newTailSamplingProcessor(args beater.ServerParams) (*sampling.Processor, er
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get Badger database")
 	}
-	readWriters := getStorage(badgerDB)
+	readWriters := getStorage(badgerDB, tailSamplingConfig.StorageCodec)
 
 	policies := make([]sampling.Policy, len(tailSamplingConfig.Policies))
 	for i, in := range tailSamplingConfig.Policies {
 		policies[i] = sampling.Policy{
 			PolicyCriteria: sampling.PolicyCriteria{
-				ServiceName:        in.Service.Name,
-				ServiceEnvironment: in.Service.Environment,
-				TraceName:          in.Trace.Name,
-				TraceOutcome:       in.Trace.Outcome,
+				ServiceName:         in.Service.Name,
+				ServiceEnvironment:  in.Service.Environment,
+				TraceName:           in.Trace.Name,
+				TraceOutcome:        in.Trace.Outcome,
+				TraceType:           in.Trace.Type,
+				TraceMinDuration:    in.Trace.MinDuration,
+				TraceLabels:         in.Trace.Labels,
+				TraceHTTPStatusCode: in.Trace.HTTPStatusCode,
+				TraceAnySpanFailure: in.Trace.AnySpanFailure,
 			},
 			SampleRate: in.SampleRate,
 		}
 	}
 
+	var peersPubsub sampling.Pubsub
+	if len(tailSamplingConfig.Peers.Hosts) > 0 {
+		ps, err := newTailSamplingPeersPubsub(args)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create peers pubsub for tail-sampling")
+		}
+		peersPubsub = ps
+	}
+
 	return sampling.NewProcessor(sampling.Config{
 		BatchProcessor: args.BatchProcessor,
 		LocalSamplingConfig: sampling.LocalSamplingConfig{
@@ -234,6 +307,7 @@ func newTailSamplingProcessor(args beater.ServerParams) (*sampling.Processor, er
 			IngestRateDecayFactor: tailSamplingConfig.IngestRateDecayFactor,
 		},
 		RemoteSamplingConfig: sampling.RemoteSamplingConfig{
+			Pubsub:           peersPubsub,
 			CompressionLevel: tailSamplingConfig.ESConfig.CompressionLevel,
 			Elasticsearch:    es,
 			SampledTracesDataStream: sampling.DataStreamConfig{
@@ -254,6 +328,74 @@ func newTailSamplingProcessor(args beater.ServerParams) (*sampling.Processor, er
 	})
 }
 
+// newTailSamplingPeersPubsub returns a peers.Pubsub for sharing sampling
+// decisions directly with the configured peers, registering its gRPC
+// service with args.GRPCServer.
+func newTailSamplingPeersPubsub(args beater.ServerParams) (*peers.Pubsub, error) {
+	peersConfig := args.Config.Sampling.Tail.Peers
+	var dialOptions []grpc.DialOption
+	if peersConfig.TLS.IsEnabled() {
+		tlsConfig, err := tlscommon.LoadTLSConfig(peersConfig.TLS)
+		if err != nil {
+			return nil, err
+		}
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(
+			credentials.NewTLS(tlsConfig.BuildModuleClientConfig("")),
+		))
+	}
+	var authorization string
+	switch {
+	case peersConfig.SecretToken != "":
+		authorization = headers.Bearer + " " + peersConfig.SecretToken
+	case peersConfig.APIKey != "":
+		authorization = headers.APIKey + " " + peersConfig.APIKey
+	}
+	return peers.New(peers.Config{
+		Peers:         peersConfig.Hosts,
+		GRPCServer:    args.GRPCServer,
+		DialOptions:   dialOptions,
+		Authorization: authorization,
+		ServerID:      samplerUUID.String(),
+		FlushInterval: time.Second,
+		Logger:        args.Logger.Named("sampling.peers"),
+	})
+}
+
+// tailSamplingAdmin adapts a *sampling.Processor to admin.TailSampler.
+type tailSamplingAdmin struct {
+	*sampling.Processor
+}
+
+func (a tailSamplingAdmin) TraceStatus(traceID string) (admin.TraceStatus, error) {
+	status, err := a.Processor.TraceStatus(traceID)
+	if err != nil {
+		return admin.TraceStatus{}, err
+	}
+	decision := admin.DecisionPending
+	if status.Decided {
+		decision = admin.DecisionUnsampled
+		if status.Sampled {
+			decision = admin.DecisionSampled
+		}
+	}
+	return admin.TraceStatus{Decision: decision, BufferedEvents: status.BufferedEvents}, nil
+}
+
+func (a tailSamplingAdmin) GroupStats() []admin.GroupStats {
+	stats := a.Processor.GroupStats()
+	out := make([]admin.GroupStats, len(stats))
+	for i, s := range stats {
+		out[i] = admin.GroupStats{
+			Policy:        s.PolicyIndex,
+			ServiceName:   s.ServiceName,
+			SampleRate:    s.SampleRate,
+			ReservoirSize: s.ReservoirSize,
+			IngestRate:    s.IngestRate,
+		}
+	}
+	return out
+}
+
 func getBadgerDB(storageDir string) (*badger.DB, error) {
 	badgerMu.Lock()
 	defer badgerMu.Unlock()
@@ -267,14 +409,19 @@ func getBadgerDB(storageDir string) (*badger.DB, error) {
 	return badgerDB, nil
 }
 
-func getStorage(db *badger.DB) *eventstorage.ShardedReadWriter {
+func getStorage(db *badger.DB, codec string) *eventstorage.ShardedReadWriter {
 	storageMu.Lock()
 	defer storageMu.Unlock()
-	if storage == nil {
-		eventCodec := eventstorage.JSONCodec{}
-		storage = eventstorage.New(db, eventCodec).NewShardedReadWriter()
+	rw, ok := storage[codec]
+	if !ok {
+		var eventCodec eventstorage.Codec = eventstorage.JSONCodec{}
+		if codec == "binary" {
+			eventCodec = eventstorage.BinaryCodec{}
+		}
+		rw = eventstorage.New(db, eventCodec).NewShardedReadWriter()
+		storage[codec] = rw
 	}
-	return storage
+	return rw
 }
 
 // runServerWithProcessors runs the APM Server and the given list of processors.
@@ -432,6 +579,13 @@ func wrapServer(args beater.ServerParams, runServer beater.RunServerFunc) (beate
 	processorChain[len(processors)] = args.BatchProcessor
 	args.BatchProcessor = processorChain
 
+	// Expose the tail-sampling processor, if any, through the admin API.
+	for _, p := range processors {
+		if sampler, ok := p.processor.(*sampling.Processor); ok {
+			args.TailSampler = tailSamplingAdmin{sampler}
+		}
+	}
+
 	wrappedRunServer := func(ctx context.Context, args beater.ServerParams) error {
 		if args.Config.Profiling.Enabled {
 			profilingCollector, cleanup, err := newProfilingCollector(args)
@@ -464,8 +618,8 @@ func closeBadger() error {
 }
 
 func closeStorage() {
-	if storage != nil {
-		storage.Close()
+	for _, rw := range storage {
+		rw.Close()
 	}
 }
 
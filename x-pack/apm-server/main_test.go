@@ -28,6 +28,7 @@ import (
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/elasticsearch"
 	"github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
 )
 
 func TestMonitoring(t *testing.T) {
@@ -48,6 +49,7 @@ func TestMonitoring(t *testing.T) {
 	cfg.Aggregation.Transactions.MaxTransactionGroups = 10000
 	cfg.Aggregation.Transactions.MaxServices = 10000
 	cfg.Aggregation.ServiceTransactions.MaxGroups = 10000
+	cfg.Aggregation.Errors.MaxGroups = 10000
 
 	// Wrap & run the server twice, to ensure metric registration does not panic.
 	runServerError := errors.New("runServer")
@@ -164,3 +166,23 @@ type errTransport struct{}
 func (errTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 	return nil, fmt.Errorf("connection closed")
 }
+
+func TestGetStorageCodecChange(t *testing.T) {
+	db, err := eventstorage.OpenBadger(t.TempDir(), -1)
+	require.NoError(t, err)
+	defer db.Close()
+	defer func() {
+		closeStorage()
+		storage = make(map[string]*eventstorage.ShardedReadWriter)
+	}()
+
+	jsonRW := getStorage(db, "json")
+	assert.Same(t, jsonRW, getStorage(db, "json"))
+
+	// Changing the codec, e.g. on config reload, must
+	// not return the read/writer for the previous codec.
+	binaryRW := getStorage(db, "binary")
+	assert.NotSame(t, jsonRW, binaryRW)
+	assert.Same(t, binaryRW, getStorage(db, "binary"))
+	assert.Same(t, jsonRW, getStorage(db, "json"))
+}
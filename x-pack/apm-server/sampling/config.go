@@ -5,6 +5,7 @@
 package sampling
 
 import (
+	"context"
 	"time"
 
 	"github.com/dgraph-io/badger/v2"
@@ -60,6 +61,14 @@ type LocalSamplingConfig struct {
 // RemoteSamplingConfig holds Processor configuration related to publishing and
 // subscribing to remote sampling decisions.
 type RemoteSamplingConfig struct {
+	// Pubsub holds an optional Pubsub for publishing and subscribing to
+	// remote sampling decisions.
+	//
+	// If Pubsub is nil, remote sampling decisions will be published to and
+	// subscribed from Elasticsearch, using CompressionLevel, Elasticsearch,
+	// and SampledTracesDataStream.
+	Pubsub Pubsub
+
 	// CompressionLevel holds the gzip compression level to use when bulk
 	// indexing sampled trace IDs.
 	CompressionLevel int
@@ -82,6 +91,25 @@ type RemoteSamplingConfig struct {
 	UUID string
 }
 
+// Pubsub provides a means of publishing and subscribing to sampled trace IDs,
+// for sharing sampling decisions between APM Servers.
+type Pubsub interface {
+	// PublishSampledTraceIDs publishes trace IDs received from the traceIDs
+	// channel, returning when traceIDs is closed or ctx is cancelled.
+	PublishSampledTraceIDs(ctx context.Context, traceIDs <-chan string) error
+
+	// SubscribeSampledTraceIDs subscribes to sampled trace IDs after the given
+	// position, sending them to the traceIDs channel until ctx is cancelled.
+	// If the implementation supports resuming, the most recently observed
+	// position is sent to the positions channel on change.
+	SubscribeSampledTraceIDs(
+		ctx context.Context,
+		pos pubsub.SubscriberPosition,
+		traceIDs chan<- string,
+		positions chan<- pubsub.SubscriberPosition,
+	) error
+}
+
 // DataStreamConfig holds configuration to identify a data stream.
 type DataStreamConfig struct {
 	// Type holds the data stream's type.
@@ -167,6 +195,56 @@ type PolicyCriteria struct {
 	// from the same service) will be grouped together for sampling purposes,
 	// similar to head-based sampling.
 	TraceName string
+
+	// TraceType holds the root transaction type for which this policy
+	// applies.
+	//
+	// If unspecified, root transactions with differing types will be
+	// grouped together for sampling purposes.
+	TraceType string
+
+	// TraceMinDuration holds the minimum root transaction duration for
+	// which this policy applies.
+	//
+	// If unspecified, root transactions will be matched regardless of
+	// their duration.
+	TraceMinDuration time.Duration
+
+	// TraceLabels holds string labels which the root transaction must
+	// have for this policy to apply. All labels must match.
+	//
+	// If unspecified, root transactions will be matched regardless of
+	// their labels.
+	TraceLabels map[string]string
+
+	// TraceHTTPStatusCode holds the HTTP response status code of the root
+	// transaction for which this policy applies.
+	//
+	// If unspecified, root transactions will be matched regardless of
+	// their HTTP response status code.
+	TraceHTTPStatusCode int
+
+	// TraceAnySpanFailure, if true, restricts this policy to traces in
+	// which any span or non-root transaction has the outcome "failure".
+	//
+	// This is a trace-level criterion: it is evaluated against the trace
+	// events stored locally at the time the root transaction is received.
+	// Events received after the root transaction, or by other servers,
+	// are not considered.
+	TraceAnySpanFailure bool
+}
+
+// isEmpty reports whether c has no criteria, and so matches all traces.
+func (c PolicyCriteria) isEmpty() bool {
+	return c.ServiceName == "" &&
+		c.ServiceEnvironment == "" &&
+		c.TraceOutcome == "" &&
+		c.TraceName == "" &&
+		c.TraceType == "" &&
+		c.TraceMinDuration == 0 &&
+		len(c.TraceLabels) == 0 &&
+		c.TraceHTTPStatusCode == 0 &&
+		!c.TraceAnySpanFailure
 }
 
 // Validate validates the configuration.
@@ -201,7 +279,7 @@ func (config LocalSamplingConfig) validate() error {
 		if err := policy.validate(); err != nil {
 			return errors.Wrapf(err, "Policy %d invalid", i)
 		}
-		if policy.PolicyCriteria == (PolicyCriteria{}) {
+		if policy.PolicyCriteria.isEmpty() {
 			anyDefaultPolicy = true
 		}
 	}
@@ -215,14 +293,16 @@ func (config LocalSamplingConfig) validate() error {
 }
 
 func (config RemoteSamplingConfig) validate() error {
-	if config.CompressionLevel < -1 || config.CompressionLevel > 9 {
-		return errors.New("CompressionLevel out of range [-1,9]")
-	}
-	if config.Elasticsearch == nil {
-		return errors.New("Elasticsearch unspecified")
-	}
-	if err := config.SampledTracesDataStream.validate(); err != nil {
-		return errors.New("SampledTracesDataStream unspecified or invalid")
+	if config.Pubsub == nil {
+		if config.CompressionLevel < -1 || config.CompressionLevel > 9 {
+			return errors.New("CompressionLevel out of range [-1,9]")
+		}
+		if config.Elasticsearch == nil {
+			return errors.New("Elasticsearch unspecified")
+		}
+		if err := config.SampledTracesDataStream.validate(); err != nil {
+			return errors.New("SampledTracesDataStream unspecified or invalid")
+		}
 	}
 	if config.UUID == "" {
 		return errors.New("UUID unspecified")
@@ -257,5 +337,11 @@ func (p Policy) validate() error {
 	if p.SampleRate < 0 || p.SampleRate > 1 {
 		return errors.New("SampleRate unspecified or out of range [0,1]")
 	}
+	if p.TraceMinDuration < 0 {
+		return errors.New("TraceMinDuration negative")
+	}
+	if p.TraceHTTPStatusCode < 0 || p.TraceHTTPStatusCode > 999 {
+		return errors.New("TraceHTTPStatusCode out of range [0,999]")
+	}
 	return nil
 }
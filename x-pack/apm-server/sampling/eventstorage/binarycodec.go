@@ -0,0 +1,758 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package eventstorage
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sync"
+
+	"github.com/elastic/apm-data/model"
+)
+
+const (
+	// NOTE these values (and their meanings) must remain stable
+	// over time, to avoid misinterpreting historical data.
+
+	// headerMagic is the first byte of events encoded with a versioned
+	// header. Events encoded by JSONCodec have no header, and always
+	// begin with '{'.
+	headerMagic = 0xff
+
+	// binaryCodecV1 identifies version 1 of the BinaryCodec encoding.
+	binaryCodecV1 = 1
+
+	headerSize = 2
+)
+
+// Wire types, as in Protocol Buffers. Each struct field is encoded as a
+// key holding the field's tag and wire type, followed by the field value.
+// The wire type allows unknown fields to be skipped when decoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// Types of dynamically typed (interface) values.
+const (
+	anyNil = iota
+	anyBool
+	anyString
+	anyFloat64
+	anyInt64
+	anyNumber
+	anySlice
+	anyMap
+)
+
+var (
+	errMalformed = errors.New("malformed binary-encoded event")
+
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+	apmEventCodecOnce sync.Once
+	apmEventCodec     *structCodec
+	apmEventCodecErr  error
+)
+
+// BinaryCodec is an implementation of Codec, using a compact binary encoding.
+//
+// Encoded events begin with a versioned header. Events without a header are
+// assumed to have been encoded by JSONCodec, e.g. before upgrading, and are
+// decoded as JSON.
+//
+// Struct fields with zero values are omitted. The remaining fields are
+// identified by a hash of their name rather than by position, so events
+// remain decodable when fields are added to, removed from, or reordered
+// in model.APMEvent; unknown fields are skipped when decoding.
+type BinaryCodec struct{}
+
+// DecodeEvent decodes data into event, according to its header.
+func (BinaryCodec) DecodeEvent(data []byte, event *model.APMEvent) error {
+	return decodeEvent(data, event)
+}
+
+// EncodeEvent encodes event using the binary encoding, with a versioned header.
+func (BinaryCodec) EncodeEvent(event *model.APMEvent) ([]byte, error) {
+	c, err := getAPMEventCodec()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 512)
+	buf = append(buf, headerMagic, binaryCodecV1)
+	return c.encodeFields(buf, reflect.ValueOf(event).Elem())
+}
+
+// decodeEvent decodes data encoded by any of the codecs in this package,
+// according to its header.
+func decodeEvent(data []byte, event *model.APMEvent) error {
+	if len(data) == 0 || data[0] != headerMagic {
+		return decodeJSON(data, event)
+	}
+	if len(data) < headerSize {
+		return errMalformed
+	}
+	switch version := data[1]; version {
+	case binaryCodecV1:
+		c, err := getAPMEventCodec()
+		if err != nil {
+			return err
+		}
+		return c.decodeFields(data[headerSize:], reflect.ValueOf(event).Elem())
+	default:
+		return fmt.Errorf("unsupported event encoding version %d", version)
+	}
+}
+
+func getAPMEventCodec() (*structCodec, error) {
+	apmEventCodecOnce.Do(func() {
+		cb := codecBuilder{codecs: make(map[reflect.Type]*typeCodec)}
+		var c *typeCodec
+		c, apmEventCodecErr = cb.build(reflect.TypeOf(model.APMEvent{}))
+		if apmEventCodecErr == nil {
+			apmEventCodec = c.structCodec
+		}
+	})
+	return apmEventCodec, apmEventCodecErr
+}
+
+// typeCodec encodes and decodes values of a specific type.
+type typeCodec struct {
+	wireType uint64
+
+	// omit reports whether a struct field holding v should be omitted.
+	// If omit is nil, the field is omitted if it encodes to an empty
+	// struct.
+	omit func(v reflect.Value) bool
+
+	// encode appends the encoding of v to buf.
+	encode func(buf []byte, v reflect.Value) ([]byte, error)
+
+	// decode decodes the value at the start of data into v, which must
+	// be addressable, and returns the remaining data.
+	decode func(data []byte, v reflect.Value) ([]byte, error)
+
+	// structCodec is non-nil for struct types.
+	structCodec *structCodec
+}
+
+type structCodec struct {
+	fields []structField
+	byTag  map[uint64]*structField
+}
+
+type structField struct {
+	name  string
+	index int
+	key   uint64 // tag<<3 | wire type
+	codec *typeCodec
+}
+
+// codecBuilder builds typeCodecs, memoizing them to support recursive types.
+type codecBuilder struct {
+	codecs map[reflect.Type]*typeCodec
+}
+
+// build returns a typeCodec for t. For recursive types, the returned
+// typeCodec's wireType and omit fields are set before building the codecs
+// of its elements or fields.
+func (cb *codecBuilder) build(t reflect.Type) (*typeCodec, error) {
+	if c, ok := cb.codecs[t]; ok {
+		return c, nil
+	}
+	c := &typeCodec{}
+	cb.codecs[t] = c
+	if err := cb.init(c, t); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (cb *codecBuilder) init(c *typeCodec, t reflect.Type) error {
+	if t.Implements(binaryMarshalerType) && reflect.PointerTo(t).Implements(binaryUnmarshalerType) {
+		// e.g. time.Time and netip.Addr
+		c.wireType = wireBytes
+		c.omit = reflect.Value.IsZero
+		c.encode = encodeBinaryMarshaler
+		c.decode = decodeBinaryUnmarshaler
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		c.wireType = wireVarint
+		c.omit = func(v reflect.Value) bool { return !v.Bool() }
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			var x uint64
+			if v.Bool() {
+				x = 1
+			}
+			return binary.AppendUvarint(buf, x), nil
+		}
+		c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+			x, data, err := readUvarint(data)
+			v.SetBool(x != 0)
+			return data, err
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c.wireType = wireVarint
+		c.omit = func(v reflect.Value) bool { return v.Int() == 0 }
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return binary.AppendVarint(buf, v.Int()), nil
+		}
+		c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+			x, n := binary.Varint(data)
+			if n <= 0 {
+				return nil, errMalformed
+			}
+			v.SetInt(x)
+			return data[n:], nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		c.wireType = wireVarint
+		c.omit = func(v reflect.Value) bool { return v.Uint() == 0 }
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return binary.AppendUvarint(buf, v.Uint()), nil
+		}
+		c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+			x, data, err := readUvarint(data)
+			v.SetUint(x)
+			return data, err
+		}
+	case reflect.Float32, reflect.Float64:
+		c.wireType = wireFixed64
+		c.omit = func(v reflect.Value) bool { return v.Float() == 0 }
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+		}
+		c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+			if len(data) < 8 {
+				return nil, errMalformed
+			}
+			v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(data)))
+			return data[8:], nil
+		}
+	case reflect.String:
+		c.wireType = wireBytes
+		c.omit = func(v reflect.Value) bool { return v.Len() == 0 }
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return appendString(buf, v.String()), nil
+		}
+		c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+			s, data, err := readBytes(data)
+			if err != nil {
+				return nil, err
+			}
+			v.SetString(string(s))
+			return data, nil
+		}
+	case reflect.Slice:
+		return cb.initSlice(c, t)
+	case reflect.Map:
+		return cb.initMap(c, t)
+	case reflect.Pointer:
+		return cb.initPointer(c, t)
+	case reflect.Struct:
+		return cb.initStruct(c, t)
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return fmt.Errorf("cannot encode non-empty interface type %s", t)
+		}
+		c.wireType = wireBytes
+		c.omit = reflect.Value.IsNil
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			buf, start := beginLengthPrefixed(buf)
+			buf, err := appendAny(buf, v.Interface())
+			if err != nil {
+				return nil, err
+			}
+			return endLengthPrefixed(buf, start), nil
+		}
+		c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+			b, data, err := readBytes(data)
+			if err != nil {
+				return nil, err
+			}
+			x, _, err := readAny(b)
+			if err != nil {
+				return nil, err
+			}
+			if x != nil {
+				v.Set(reflect.ValueOf(x))
+			}
+			return data, nil
+		}
+	default:
+		return fmt.Errorf("cannot encode type %s", t)
+	}
+	return nil
+}
+
+func (cb *codecBuilder) initSlice(c *typeCodec, t reflect.Type) error {
+	c.wireType = wireBytes
+	c.omit = func(v reflect.Value) bool { return v.Len() == 0 }
+	if t.Elem().Kind() == reflect.Uint8 {
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return appendBytes(buf, v.Bytes()), nil
+		}
+		c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+			b, data, err := readBytes(data)
+			if err != nil {
+				return nil, err
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return data, nil
+		}
+		return nil
+	}
+	elem, err := cb.build(t.Elem())
+	if err != nil {
+		return err
+	}
+	c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+		buf, start := beginLengthPrefixed(buf)
+		n := v.Len()
+		buf = binary.AppendUvarint(buf, uint64(n))
+		for i := 0; i < n; i++ {
+			var err error
+			if buf, err = elem.encode(buf, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return endLengthPrefixed(buf, start), nil
+	}
+	c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+		b, data, err := readBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		n, b, err := readUvarint(b)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(b)) {
+			// Each element is encoded with at least one byte.
+			return nil, errMalformed
+		}
+		s := reflect.MakeSlice(t, int(n), int(n))
+		for i := 0; i < int(n); i++ {
+			if b, err = elem.decode(b, s.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		v.Set(s)
+		return data, nil
+	}
+	return nil
+}
+
+func (cb *codecBuilder) initMap(c *typeCodec, t reflect.Type) error {
+	c.wireType = wireBytes
+	c.omit = func(v reflect.Value) bool { return v.Len() == 0 }
+	key, err := cb.build(t.Key())
+	if err != nil {
+		return err
+	}
+	elem, err := cb.build(t.Elem())
+	if err != nil {
+		return err
+	}
+	c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+		buf, start := beginLengthPrefixed(buf)
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			var err error
+			if buf, err = key.encode(buf, iter.Key()); err != nil {
+				return nil, err
+			}
+			if buf, err = elem.encode(buf, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return endLengthPrefixed(buf, start), nil
+	}
+	c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+		b, data, err := readBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		n, b, err := readUvarint(b)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(b)) {
+			// Each entry is encoded with at least two bytes.
+			return nil, errMalformed
+		}
+		m := reflect.MakeMapWithSize(t, int(n))
+		for i := 0; i < int(n); i++ {
+			k := reflect.New(t.Key()).Elem()
+			if b, err = key.decode(b, k); err != nil {
+				return nil, err
+			}
+			e := reflect.New(t.Elem()).Elem()
+			if b, err = elem.decode(b, e); err != nil {
+				return nil, err
+			}
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+		return data, nil
+	}
+	return nil
+}
+
+// initPointer initialises c for a pointer type. Pointers are encoded as
+// their length-prefixed element, with a zero length for nil pointers;
+// encoded elements are never empty.
+func (cb *codecBuilder) initPointer(c *typeCodec, t reflect.Type) error {
+	c.wireType = wireBytes
+	c.omit = reflect.Value.IsNil
+	elem, err := cb.build(t.Elem())
+	if err != nil {
+		return err
+	}
+	c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		buf, start := beginLengthPrefixed(buf)
+		buf, err := elem.encode(buf, v.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return endLengthPrefixed(buf, start), nil
+	}
+	c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+		b, data, err := readBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 0 {
+			v.Set(reflect.Zero(t))
+			return data, nil
+		}
+		p := reflect.New(t.Elem())
+		if _, err := elem.decode(b, p.Elem()); err != nil {
+			return nil, err
+		}
+		v.Set(p)
+		return data, nil
+	}
+	return nil
+}
+
+func (cb *codecBuilder) initStruct(c *typeCodec, t reflect.Type) error {
+	sc := &structCodec{byTag: make(map[uint64]*structField)}
+	c.structCodec = sc
+	c.wireType = wireBytes
+	c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+		buf, start := beginLengthPrefixed(buf)
+		buf, err := sc.encodeFields(buf, v)
+		if err != nil {
+			return nil, err
+		}
+		return endLengthPrefixed(buf, start), nil
+	}
+	c.decode = func(data []byte, v reflect.Value) ([]byte, error) {
+		b, data, err := readBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		return data, sc.decodeFields(b, v)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fc, err := cb.build(f.Type)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", t, f.Name, err)
+		}
+		sc.fields = append(sc.fields, structField{
+			name:  f.Name,
+			index: i,
+			key:   fieldTag(f.Name)<<3 | fc.wireType,
+			codec: fc,
+		})
+	}
+	for i := range sc.fields {
+		f := &sc.fields[i]
+		tag := f.key >> 3
+		if other, ok := sc.byTag[tag]; ok {
+			return fmt.Errorf("%s: fields %s and %s have the same tag", t, other.name, f.name)
+		}
+		sc.byTag[tag] = f
+	}
+	return nil
+}
+
+// encodeFields appends the encoding of the non-zero fields of v to buf.
+func (sc *structCodec) encodeFields(buf []byte, v reflect.Value) ([]byte, error) {
+	for i := range sc.fields {
+		f := &sc.fields[i]
+		fv := v.Field(f.index)
+		if f.codec.omit != nil && f.codec.omit(fv) {
+			continue
+		}
+		pos := len(buf)
+		buf = binary.AppendUvarint(buf, f.key)
+		valuePos := len(buf)
+		var err error
+		if buf, err = f.codec.encode(buf, fv); err != nil {
+			return nil, err
+		}
+		if f.codec.omit == nil && len(buf) == valuePos+1 && buf[valuePos] == 0 {
+			// Omit empty structs.
+			buf = buf[:pos]
+		}
+	}
+	return buf, nil
+}
+
+// decodeFields decodes the fields encoded in data into v, skipping unknown
+// fields and fields whose type has changed.
+func (sc *structCodec) decodeFields(data []byte, v reflect.Value) error {
+	for len(data) > 0 {
+		key, rest, err := readUvarint(data)
+		if err != nil {
+			return err
+		}
+		if f, ok := sc.byTag[key>>3]; ok && f.key == key {
+			data, err = f.codec.decode(rest, v.Field(f.index))
+		} else {
+			data, err = skipValue(rest, key&7)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldTag returns the tag for a struct field with the given name.
+// Tags are limited to 21 bits, so keys are encoded in at most 4 bytes.
+func fieldTag(name string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return uint64(h.Sum32() & (1<<21 - 1))
+}
+
+func encodeBinaryMarshaler(buf []byte, v reflect.Value) ([]byte, error) {
+	b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return appendBytes(buf, b), nil
+}
+
+func decodeBinaryUnmarshaler(data []byte, v reflect.Value) ([]byte, error) {
+	b, data, err := readBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return data, v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+}
+
+// appendAny appends the encoding of a dynamically typed value to buf.
+// Only the types produced by decoding JSON into an interface value,
+// and int64, are supported.
+func appendAny(buf []byte, x interface{}) ([]byte, error) {
+	switch x := x.(type) {
+	case nil:
+		return append(buf, anyNil), nil
+	case bool:
+		var b byte
+		if x {
+			b = 1
+		}
+		return append(buf, anyBool, b), nil
+	case string:
+		return appendString(append(buf, anyString), x), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(buf, anyFloat64), math.Float64bits(x)), nil
+	case int:
+		return binary.AppendVarint(append(buf, anyInt64), int64(x)), nil
+	case int64:
+		return binary.AppendVarint(append(buf, anyInt64), x), nil
+	case json.Number:
+		return appendString(append(buf, anyNumber), string(x)), nil
+	case []interface{}:
+		buf = binary.AppendUvarint(append(buf, anySlice), uint64(len(x)))
+		for _, elem := range x {
+			var err error
+			if buf, err = appendAny(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		buf = binary.AppendUvarint(append(buf, anyMap), uint64(len(x)))
+		for k, elem := range x {
+			buf = appendString(buf, k)
+			var err error
+			if buf, err = appendAny(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("cannot encode value of type %T", x)
+}
+
+// readAny decodes a value encoded by appendAny, returning it and the
+// remaining data.
+func readAny(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errMalformed
+	}
+	typ, data := data[0], data[1:]
+	switch typ {
+	case anyNil:
+		return nil, data, nil
+	case anyBool:
+		if len(data) == 0 {
+			return nil, nil, errMalformed
+		}
+		return data[0] != 0, data[1:], nil
+	case anyString, anyNumber:
+		b, data, err := readBytes(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if typ == anyNumber {
+			return json.Number(b), data, nil
+		}
+		return string(b), data, nil
+	case anyFloat64:
+		if len(data) < 8 {
+			return nil, nil, errMalformed
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), data[8:], nil
+	case anyInt64:
+		x, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, nil, errMalformed
+		}
+		return x, data[n:], nil
+	case anySlice:
+		n, data, err := readUvarint(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n > uint64(len(data)) {
+			return nil, nil, errMalformed
+		}
+		s := make([]interface{}, n)
+		for i := range s {
+			if s[i], data, err = readAny(data); err != nil {
+				return nil, nil, err
+			}
+		}
+		return s, data, nil
+	case anyMap:
+		n, data, err := readUvarint(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n > uint64(len(data)) {
+			return nil, nil, errMalformed
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < int(n); i++ {
+			var k []byte
+			if k, data, err = readBytes(data); err != nil {
+				return nil, nil, err
+			}
+			var elem interface{}
+			if elem, data, err = readAny(data); err != nil {
+				return nil, nil, err
+			}
+			m[string(k)] = elem
+		}
+		return m, data, nil
+	}
+	return nil, nil, errMalformed
+}
+
+// beginLengthPrefixed reserves a byte in buf for a length prefix, returning
+// the extended buffer and the offset at which the value should be appended.
+// The value's length is filled in by endLengthPrefixed.
+func beginLengthPrefixed(buf []byte) ([]byte, int) {
+	buf = append(buf, 0)
+	return buf, len(buf)
+}
+
+// endLengthPrefixed fills in the length prefix of the value appended to buf
+// from the offset start, shifting the value if the length does not fit in
+// the single reserved byte.
+func endLengthPrefixed(buf []byte, start int) []byte {
+	n := len(buf) - start
+	if n < 0x80 {
+		buf[start-1] = byte(n)
+		return buf
+	}
+	var prefix [binary.MaxVarintLen64]byte
+	m := binary.PutUvarint(prefix[:], uint64(n))
+	buf = append(buf, prefix[1:m]...)
+	copy(buf[start+m-1:], buf[start:start+n])
+	copy(buf[start-1:], prefix[:m])
+	return buf
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func readUvarint(data []byte) (uint64, []byte, error) {
+	x, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, errMalformed
+	}
+	return x, data[n:], nil
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	n, data, err := readUvarint(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n > uint64(len(data)) {
+		return nil, nil, errMalformed
+	}
+	return data[:n], data[n:], nil
+}
+
+func skipValue(data []byte, wireType uint64) ([]byte, error) {
+	switch wireType {
+	case wireVarint:
+		_, data, err := readUvarint(data)
+		return data, err
+	case wireFixed64:
+		if len(data) < 8 {
+			return nil, errMalformed
+		}
+		return data[8:], nil
+	case wireBytes:
+		_, data, err := readBytes(data)
+		return data, err
+	}
+	return nil, errMalformed
+}
@@ -0,0 +1,142 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package eventstorage_test
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-data/model"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+)
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	traceID := "0102030405060708090a0b0c0d0e0f10"
+	for name, event := range map[string]*model.APMEvent{
+		"empty":             {},
+		"empty_transaction": {Transaction: &model.Transaction{}},
+		"transaction":       makeTransaction("0102030405060708", traceID),
+		"error":             makeError(traceID),
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := eventstorage.BinaryCodec{}.EncodeEvent(event)
+			require.NoError(t, err)
+
+			var decoded model.APMEvent
+			require.NoError(t, eventstorage.BinaryCodec{}.DecodeEvent(data, &decoded))
+			assert.Equal(t, *event, decoded)
+
+			// Events encoded with BinaryCodec can be decoded by JSONCodec,
+			// for changing the configured codec back to JSON.
+			decoded = model.APMEvent{}
+			require.NoError(t, eventstorage.JSONCodec{}.DecodeEvent(data, &decoded))
+			assert.Equal(t, *event, decoded)
+		})
+	}
+}
+
+func TestBinaryCodecDecodeJSON(t *testing.T) {
+	// Events encoded with JSONCodec have no header, and
+	// must remain decodable by BinaryCodec after upgrading.
+	event := makeTransaction("0102030405060708", "0102030405060708090a0b0c0d0e0f10")
+	data, err := eventstorage.JSONCodec{}.EncodeEvent(event)
+	require.NoError(t, err)
+
+	var decoded model.APMEvent
+	require.NoError(t, eventstorage.BinaryCodec{}.DecodeEvent(data, &decoded))
+	assert.Equal(t, *event, decoded)
+}
+
+func TestBinaryCodecSize(t *testing.T) {
+	event := makeTransaction("0102030405060708", "0102030405060708090a0b0c0d0e0f10")
+	jsonData, err := eventstorage.JSONCodec{}.EncodeEvent(event)
+	require.NoError(t, err)
+	binaryData, err := eventstorage.BinaryCodec{}.EncodeEvent(event)
+	require.NoError(t, err)
+	assert.Less(t, len(binaryData)*2, len(jsonData))
+}
+
+func TestBinaryCodecUnknownFields(t *testing.T) {
+	data, err := eventstorage.BinaryCodec{}.EncodeEvent(&model.APMEvent{Message: "hello"})
+	require.NoError(t, err)
+
+	// Insert fields unknown to this version after the header: a varint,
+	// a fixed64, and a length-prefixed value. These must be skipped.
+	header, fields := data[:2], data[2:]
+	unknown := []byte{
+		0xf8, 0xff, 0xff, 0x07, 0x01, // varint
+		0xf9, 0xff, 0xff, 0x07, 0, 0, 0, 0, 0, 0, 0, 0, // fixed64
+		0xfa, 0xff, 0xff, 0x07, 0x03, 'a', 'b', 'c', // bytes
+	}
+	data = append(append(append([]byte{}, header...), unknown...), fields...)
+
+	var decoded model.APMEvent
+	require.NoError(t, eventstorage.BinaryCodec{}.DecodeEvent(data, &decoded))
+	assert.Equal(t, model.APMEvent{Message: "hello"}, decoded)
+}
+
+func TestBinaryCodecDecodeErrors(t *testing.T) {
+	data, err := eventstorage.BinaryCodec{}.EncodeEvent(makeError("0102030405060708090a0b0c0d0e0f10"))
+	require.NoError(t, err)
+
+	var decoded model.APMEvent
+	err = eventstorage.BinaryCodec{}.DecodeEvent(data[:len(data)-1], &decoded)
+	assert.EqualError(t, err, "malformed binary-encoded event")
+
+	err = eventstorage.BinaryCodec{}.DecodeEvent([]byte{0xff, 99}, &decoded)
+	assert.EqualError(t, err, "unsupported event encoding version 99")
+}
+
+func TestBinaryCodecUnsupportedInterfaceValue(t *testing.T) {
+	_, err := eventstorage.BinaryCodec{}.EncodeEvent(&model.APMEvent{
+		Error: &model.Error{Custom: map[string]interface{}{"x": struct{}{}}},
+	})
+	assert.EqualError(t, err, "cannot encode value of type struct {}")
+}
+
+func makeError(traceID string) *model.APMEvent {
+	return &model.APMEvent{
+		Timestamp: time.Date(2023, 5, 1, 12, 30, 0, 123456789, time.UTC),
+		Processor: model.ErrorProcessor,
+		Trace:     model.Trace{ID: traceID},
+		Event:     model.Event{Duration: 123 * time.Millisecond, Outcome: "failure"},
+		Client:    model.Client{IP: netip.MustParseAddr("192.168.0.1")},
+		Host:      model.Host{IP: []netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("10.0.0.1")}},
+		NumericLabels: model.NumericLabels{
+			"n": model.NumericLabelValue{Value: -1.5},
+		},
+		Error: &model.Error{
+			ID: "error_id",
+			Custom: map[string]interface{}{
+				"string": "value",
+				"number": json.Number("1.25"),
+				"float":  2.5,
+				"int":    int64(-3),
+				"bool":   true,
+				"null":   nil,
+				"array":  []interface{}{"a", 1.0, false},
+				"object": map[string]interface{}{"nested": "value"},
+			},
+			Exception: &model.Exception{
+				Message: "outer",
+				Handled: newBoolP(false),
+				Cause:   []model.Exception{{Message: "inner"}},
+				Stacktrace: model.Stacktrace{
+					{Filename: "main.go", Lineno: newIntP(42)},
+					{Function: "main"},
+				},
+			},
+		},
+	}
+}
+
+func newBoolP(b bool) *bool {
+	return &b
+}
@@ -18,8 +18,12 @@ import (
 type JSONCodec struct{}
 
 // DecodeEvent decodes data as JSON into event.
+//
+// If data has a versioned header, e.g. because it was encoded by BinaryCodec
+// before the configured codec was changed, it is decoded according to the
+// header.
 func (JSONCodec) DecodeEvent(data []byte, event *model.APMEvent) error {
-	return jsoniter.ConfigFastest.Unmarshal(data, (*apmEventUnderlying)(event))
+	return decodeEvent(data, event)
 }
 
 // EncodeEvent encodes event as JSON.
@@ -27,6 +31,10 @@ func (JSONCodec) EncodeEvent(event *model.APMEvent) ([]byte, error) {
 	return json.Marshal((*apmEventUnderlying)(event))
 }
 
+func decodeJSON(data []byte, event *model.APMEvent) error {
+	return jsoniter.ConfigFastest.Unmarshal(data, (*apmEventUnderlying)(event))
+}
+
 // We type-assert to the underlying struct type in order to drop
 // model.APMEvent's MarshalJSON method, as there is no corresponding
 // UnmarshalJSON method, and the marshalled structure does not match
@@ -60,6 +60,10 @@ func BenchmarkWriteTransaction(b *testing.B) {
 			name:  "json_codec",
 			codec: eventstorage.JSONCodec{},
 		},
+		{
+			name:  "binary_codec",
+			codec: eventstorage.BinaryCodec{},
+		},
 		{
 			// This tests the eventstorage performance without
 			// JSON encoding. This would be the theoretical
@@ -142,6 +146,10 @@ func BenchmarkReadEvents(b *testing.B) {
 			name:  "json_codec",
 			codec: eventstorage.JSONCodec{},
 		},
+		{
+			name:  "binary_codec",
+			codec: eventstorage.BinaryCodec{},
+		},
 		{
 			// This tests the eventstorage performance without
 			// JSON encoding. This would be the theoretical
@@ -161,6 +169,44 @@ func BenchmarkReadEvents(b *testing.B) {
 	}
 }
 
+func BenchmarkCodec(b *testing.B) {
+	event := makeTransaction(
+		hex.EncodeToString([]byte{1, 2, 3, 4, 5, 6, 7, 8}),
+		hex.EncodeToString([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}),
+	)
+	for _, tc := range []struct {
+		name  string
+		codec eventstorage.Codec
+	}{
+		{name: "json_codec", codec: eventstorage.JSONCodec{}},
+		{name: "binary_codec", codec: eventstorage.BinaryCodec{}},
+	} {
+		data, err := tc.codec.EncodeEvent(event)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(tc.name+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := tc.codec.EncodeEvent(event); err != nil {
+					b.Fatal(err)
+				}
+			}
+			// Report the encoded size, to compare storage requirements.
+			b.ReportMetric(float64(len(data)), "bytes/event")
+		})
+		b.Run(tc.name+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var decoded model.APMEvent
+				if err := tc.codec.DecodeEvent(data, &decoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkIsTraceSampled(b *testing.B) {
 	sampledTraceUUID := uuid.Must(uuid.NewV4())
 	unsampledTraceUUID := uuid.Must(uuid.NewV4())
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"math"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -43,20 +44,88 @@ type policyGroup struct {
 	dynamic map[string]*traceGroup // nil for static
 }
 
-func (g *policyGroup) match(transactionEvent *model.APMEvent) bool {
+// match reports whether the policy group matches the trace of the given
+// root transaction. Criteria on the root transaction are checked first,
+// so the trace's stored events are only read if those all match.
+func (g *policyGroup) match(transactionEvent *model.APMEvent, trace *traceEvents) (bool, error) {
 	if g.policy.ServiceName != "" && g.policy.ServiceName != transactionEvent.Service.Name {
-		return false
+		return false, nil
 	}
 	if g.policy.ServiceEnvironment != "" && g.policy.ServiceEnvironment != transactionEvent.Service.Environment {
-		return false
+		return false, nil
 	}
 	if g.policy.TraceOutcome != "" && g.policy.TraceOutcome != transactionEvent.Event.Outcome {
-		return false
+		return false, nil
 	}
 	if g.policy.TraceName != "" && g.policy.TraceName != transactionEvent.Transaction.Name {
-		return false
+		return false, nil
+	}
+	if g.policy.TraceType != "" && g.policy.TraceType != transactionEvent.Transaction.Type {
+		return false, nil
+	}
+	if g.policy.TraceMinDuration > 0 && transactionEvent.Event.Duration < g.policy.TraceMinDuration {
+		return false, nil
+	}
+	for k, v := range g.policy.TraceLabels {
+		if label, ok := transactionEvent.Labels[k]; !ok || label.Value != v {
+			return false, nil
+		}
+	}
+	if g.policy.TraceHTTPStatusCode != 0 {
+		response := transactionEvent.HTTP.Response
+		if response == nil || response.StatusCode != g.policy.TraceHTTPStatusCode {
+			return false, nil
+		}
+	}
+	if g.policy.TraceAnySpanFailure {
+		anyFailure, err := trace.anyFailure()
+		if err != nil || !anyFailure {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// traceEvents provides lazy access to the locally stored events of a
+// trace, for evaluating trace-level policy criteria. The events are read
+// at most once, and only if a policy with trace-level criteria is checked.
+type traceEvents struct {
+	// read reads the trace's stored events into the given batch.
+	// If read is nil, the trace is treated as having no stored events.
+	read func(*model.Batch) error
+
+	loaded  bool
+	failure bool
+}
+
+func (t *traceEvents) load() error {
+	if t.loaded {
+		return nil
+	}
+	t.loaded = true
+	if t.read == nil {
+		return nil
+	}
+	var events model.Batch
+	if err := t.read(&events); err != nil {
+		return err
+	}
+	for _, event := range events {
+		if event.Event.Outcome == "failure" {
+			t.failure = true
+			break
+		}
 	}
-	return true
+	return nil
+}
+
+// anyFailure reports whether any of the trace's stored events has the
+// outcome "failure".
+func (t *traceEvents) anyFailure() (bool, error) {
+	if err := t.load(); err != nil {
+		return false, err
+	}
+	return t.failure, nil
 }
 
 func newTraceGroups(
@@ -116,20 +185,31 @@ func newTraceGroup(samplingFraction float64) *traceGroup {
 // sampleTrace will return true if the root transaction is admitted to
 // the in-memory sampling reservoir, and false otherwise.
 //
+// readTraceEvents is used for reading the trace's locally stored events,
+// if required for evaluating trace-level policy criteria. It may be nil,
+// in which case the trace is treated as having no stored events.
+//
 // If the transaction is not admitted due to the transaction group limit
 // having been reached, sampleTrace will return errTooManyTraceGroups.
-func (g *traceGroups) sampleTrace(transactionEvent *model.APMEvent) (bool, error) {
-	group, err := g.getTraceGroup(transactionEvent)
+func (g *traceGroups) sampleTrace(
+	transactionEvent *model.APMEvent,
+	readTraceEvents func(*model.Batch) error,
+) (bool, error) {
+	group, err := g.getTraceGroup(transactionEvent, &traceEvents{read: readTraceEvents})
 	if err != nil {
 		return false, err
 	}
 	return group.sampleTrace(transactionEvent)
 }
 
-func (g *traceGroups) getTraceGroup(transactionEvent *model.APMEvent) (*traceGroup, error) {
+func (g *traceGroups) getTraceGroup(transactionEvent *model.APMEvent, trace *traceEvents) (*traceGroup, error) {
 	var pg *policyGroup
 	for i := range g.policyGroups {
-		if g.policyGroups[i].match(transactionEvent) {
+		match, err := g.policyGroups[i].match(transactionEvent, trace)
+		if err != nil {
+			return nil, err
+		}
+		if match {
 			pg = &g.policyGroups[i]
 			break
 		}
@@ -232,3 +312,58 @@ func (g *traceGroup) finalizeSampledTraces(traceIDs []string, ingestRateDecayFac
 	g.reservoir.Resize(newReservoirSize)
 	return traceIDs
 }
+
+// GroupStats holds statistics for a trace group.
+type GroupStats struct {
+	// PolicyIndex holds the index of the trace group's policy.
+	PolicyIndex int
+
+	// ServiceName holds the service name of a trace group created
+	// dynamically for a policy that does not specify a service name.
+	ServiceName string
+
+	// SampleRate holds the policy's configured sample rate.
+	SampleRate float64
+
+	// ReservoirSize holds the current size of the sampling reservoir.
+	ReservoirSize int
+
+	// IngestRate holds the exponentially weighted moving average number
+	// of root transactions observed per tail sampling interval.
+	IngestRate float64
+}
+
+// stats returns statistics for each trace group, ordered by policy
+// index and then by service name.
+func (g *traceGroups) stats() []GroupStats {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var stats []GroupStats
+	for i, pg := range g.policyGroups {
+		if pg.g != nil {
+			stats = append(stats, pg.g.stats(i, ""))
+			continue
+		}
+		n := len(stats)
+		for serviceName, group := range pg.dynamic {
+			stats = append(stats, group.stats(i, serviceName))
+		}
+		dynamic := stats[n:]
+		sort.Slice(dynamic, func(i, j int) bool {
+			return dynamic[i].ServiceName < dynamic[j].ServiceName
+		})
+	}
+	return stats
+}
+
+func (g *traceGroup) stats(policyIndex int, serviceName string) GroupStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return GroupStats{
+		PolicyIndex:   policyIndex,
+		ServiceName:   serviceName,
+		SampleRate:    g.samplingFraction,
+		ReservoirSize: g.reservoir.Size(),
+		IngestRate:    g.ingestRate,
+	}
+}
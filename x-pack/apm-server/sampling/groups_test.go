@@ -5,6 +5,7 @@
 package sampling
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -69,7 +70,7 @@ func TestTraceGroupsPolicies(t *testing.T) {
 		tx := makeTransaction(serviceName, serviceEnvironment, traceOutcome, traceName)
 		const N = 1000
 		for i := 0; i < N; i++ {
-			if _, err := groups.sampleTrace(tx); err != nil {
+			if _, err := groups.sampleTrace(tx, nil); err != nil {
 				t.Fatal(err)
 			}
 		}
@@ -85,6 +86,87 @@ func TestTraceGroupsPolicies(t *testing.T) {
 	}
 }
 
+func TestTraceGroupsPolicyCriteria(t *testing.T) {
+	newTransaction := func() *model.APMEvent {
+		return &model.APMEvent{
+			Service:   model.Service{Name: "service"},
+			Processor: model.TransactionProcessor,
+			Trace:     model.Trace{ID: uuid.Must(uuid.NewV4()).String()},
+			Event:     model.Event{Duration: time.Second},
+			Labels:    model.Labels{"tier": {Value: "gold"}},
+			HTTP:      model.HTTP{Response: &model.HTTPResponse{StatusCode: 500}},
+			Transaction: &model.Transaction{
+				ID:   uuid.Must(uuid.NewV4()).String(),
+				Name: "GET /",
+				Type: "request",
+			},
+		}
+	}
+	failedSpan := func(out *model.Batch) error {
+		*out = append(*out, model.APMEvent{
+			Processor: model.SpanProcessor,
+			Event:     model.Event{Outcome: "failure"},
+			Span:      &model.Span{ID: "span"},
+		})
+		return nil
+	}
+
+	for name, tc := range map[string]struct {
+		criteria        PolicyCriteria
+		readTraceEvents func(*model.Batch) error
+		expectMatch     bool
+	}{
+		"type_match":           {criteria: PolicyCriteria{TraceType: "request"}, expectMatch: true},
+		"type_mismatch":        {criteria: PolicyCriteria{TraceType: "messaging"}},
+		"min_duration_match":   {criteria: PolicyCriteria{TraceMinDuration: time.Second}, expectMatch: true},
+		"min_duration_too_low": {criteria: PolicyCriteria{TraceMinDuration: 2 * time.Second}},
+		"labels_match":         {criteria: PolicyCriteria{TraceLabels: map[string]string{"tier": "gold"}}, expectMatch: true},
+		"labels_mismatch":      {criteria: PolicyCriteria{TraceLabels: map[string]string{"tier": "silver"}}},
+		"labels_missing":       {criteria: PolicyCriteria{TraceLabels: map[string]string{"tier": "gold", "region": "eu"}}},
+		"status_code_match":    {criteria: PolicyCriteria{TraceHTTPStatusCode: 500}, expectMatch: true},
+		"status_code_mismatch": {criteria: PolicyCriteria{TraceHTTPStatusCode: 200}},
+		"any_span_failure_match": {
+			criteria:        PolicyCriteria{TraceAnySpanFailure: true},
+			readTraceEvents: failedSpan,
+			expectMatch:     true,
+		},
+		"any_span_failure_no_events": {
+			criteria: PolicyCriteria{TraceAnySpanFailure: true},
+		},
+		"any_span_failure_after_mismatch": {
+			criteria: PolicyCriteria{TraceType: "messaging", TraceAnySpanFailure: true},
+			readTraceEvents: func(out *model.Batch) error {
+				t.Fatal("trace events should not be read if other criteria do not match")
+				return nil
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			groups := newTraceGroups([]Policy{
+				{PolicyCriteria: tc.criteria, SampleRate: 1.0},
+				{SampleRate: 0},
+			}, 1000, 1.0)
+			sampled, err := groups.sampleTrace(newTransaction(), tc.readTraceEvents)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectMatch, sampled)
+		})
+	}
+}
+
+func TestTraceGroupsReadTraceEventsError(t *testing.T) {
+	groups := newTraceGroups([]Policy{
+		{PolicyCriteria: PolicyCriteria{TraceAnySpanFailure: true}, SampleRate: 1.0},
+		{SampleRate: 1.0},
+	}, 1000, 1.0)
+	_, err := groups.sampleTrace(&model.APMEvent{
+		Processor:   model.TransactionProcessor,
+		Transaction: &model.Transaction{},
+	}, func(*model.Batch) error {
+		return errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+}
+
 func TestTraceGroupsMax(t *testing.T) {
 	const (
 		maxDynamicServices    = 100
@@ -106,7 +188,7 @@ func TestTraceGroupsMax(t *testing.T) {
 					Name: "whatever",
 					ID:   uuid.Must(uuid.NewV4()).String(),
 				},
-			})
+			}, nil)
 			require.NoError(t, err)
 			assert.True(t, admitted)
 		}
@@ -119,7 +201,7 @@ func TestTraceGroupsMax(t *testing.T) {
 			Name: "overflow",
 			ID:   uuid.Must(uuid.NewV4()).String(),
 		},
-	})
+	}, nil)
 	assert.Equal(t, errTooManyTraceGroups, err)
 	assert.False(t, admitted)
 }
@@ -138,7 +220,7 @@ func TestTraceGroupReservoirResize(t *testing.T) {
 				Processor:   model.TransactionProcessor,
 				Trace:       model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
 				Transaction: &model.Transaction{ID: "0102030405060708"},
-			})
+			}, nil)
 		}
 	}
 
@@ -177,7 +259,7 @@ func TestTraceGroupReservoirResizeMinimum(t *testing.T) {
 				Processor:   model.TransactionProcessor,
 				Trace:       model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
 				Transaction: &model.Transaction{ID: "0102030405060708"},
-			})
+			}, nil)
 		}
 	}
 
@@ -193,6 +275,35 @@ func TestTraceGroupReservoirResizeMinimum(t *testing.T) {
 	assert.Len(t, groups.finalizeSampledTraces(nil), 1000) // min reservoir size
 }
 
+func TestTraceGroupsStats(t *testing.T) {
+	const (
+		maxDynamicServices    = 10
+		ingestRateCoefficient = 1.0
+	)
+	policies := []Policy{
+		{PolicyCriteria: PolicyCriteria{ServiceName: "defined"}, SampleRate: 0.5},
+		{SampleRate: 0.1},
+	}
+	groups := newTraceGroups(policies, maxDynamicServices, ingestRateCoefficient)
+
+	for _, serviceName := range []string{"defined", "b", "a", "b"} {
+		_, err := groups.sampleTrace(&model.APMEvent{
+			Service:     model.Service{Name: serviceName},
+			Processor:   model.TransactionProcessor,
+			Trace:       model.Trace{ID: uuid.Must(uuid.NewV4()).String()},
+			Transaction: &model.Transaction{ID: uuid.Must(uuid.NewV4()).String()},
+		}, nil)
+		require.NoError(t, err)
+	}
+	groups.finalizeSampledTraces(nil)
+
+	assert.Equal(t, []GroupStats{
+		{PolicyIndex: 0, SampleRate: 0.5, ReservoirSize: minReservoirSize, IngestRate: 1},
+		{PolicyIndex: 1, ServiceName: "a", SampleRate: 0.1, ReservoirSize: minReservoirSize, IngestRate: 1},
+		{PolicyIndex: 1, ServiceName: "b", SampleRate: 0.1, ReservoirSize: minReservoirSize, IngestRate: 2},
+	}, groups.stats())
+}
+
 func TestTraceGroupsRemoval(t *testing.T) {
 	const (
 		maxDynamicServices    = 2
@@ -210,21 +321,21 @@ func TestTraceGroupsRemoval(t *testing.T) {
 			Service:     model.Service{Name: "many"},
 			Processor:   model.TransactionProcessor,
 			Transaction: &model.Transaction{},
-		})
+		}, nil)
 		assert.NoError(t, err)
 	}
 	_, err := groups.sampleTrace(&model.APMEvent{
 		Service:     model.Service{Name: "few"},
 		Processor:   model.TransactionProcessor,
 		Transaction: &model.Transaction{},
-	})
+	}, nil)
 	assert.NoError(t, err)
 
 	_, err = groups.sampleTrace(&model.APMEvent{
 		Service:     model.Service{Name: "another"},
 		Processor:   model.TransactionProcessor,
 		Transaction: &model.Transaction{},
-	})
+	}, nil)
 	assert.Equal(t, errTooManyTraceGroups, err)
 
 	// When there is a policy with an explicitly defined service name, that
@@ -233,7 +344,7 @@ func TestTraceGroupsRemoval(t *testing.T) {
 		Service:     model.Service{Name: "defined"},
 		Processor:   model.TransactionProcessor,
 		Transaction: &model.Transaction{},
-	})
+	}, nil)
 	assert.NoError(t, err)
 
 	// ...unless the policy with an explicitly defined service name comes after
@@ -242,7 +353,7 @@ func TestTraceGroupsRemoval(t *testing.T) {
 		Service:     model.Service{Name: "defined_later"},
 		Processor:   model.TransactionProcessor,
 		Transaction: &model.Transaction{},
-	})
+	}, nil)
 	assert.Equal(t, errTooManyTraceGroups, err)
 
 	// Finalizing should remove the "few" trace group, since its reservoir
@@ -254,7 +365,7 @@ func TestTraceGroupsRemoval(t *testing.T) {
 		Service:     model.Service{Name: "another"},
 		Processor:   model.TransactionProcessor,
 		Transaction: &model.Transaction{},
-	})
+	}, nil)
 	assert.NoError(t, err)
 }
 
@@ -280,7 +391,7 @@ func BenchmarkTraceGroups(b *testing.B) {
 			},
 		}
 		for pb.Next() {
-			groups.sampleTrace(&tx)
+			groups.sampleTrace(&tx, nil)
 			tx.Event.Duration += time.Second
 		}
 	})
@@ -0,0 +1,71 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package peers
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// Config holds configuration for Pubsub.
+type Config struct {
+	// Peers holds the addresses of the other APM Servers with which
+	// sampled trace IDs are shared, in host:port form.
+	Peers []string
+
+	// GRPCServer holds the gRPC server with which the sampled traces service
+	// will be registered, for receiving sampled trace IDs from peers.
+	//
+	// The service must be registered before the server starts serving,
+	// so Pubsub must be created before then.
+	GRPCServer *grpc.Server
+
+	// DialOptions holds additional options for dialing peers, such as
+	// transport credentials. If DialOptions does not include transport
+	// credentials, peers will be dialed without TLS.
+	DialOptions []grpc.DialOption
+
+	// Authorization holds an optional Authorization header value to send
+	// to peers, e.g. "Bearer <secret_token>" or "ApiKey <api_key>".
+	Authorization string
+
+	// ServerID holds the APM Server's unique ID, used for ignoring sampled
+	// trace IDs published by the same server. ServerID may be ephemeral.
+	ServerID string
+
+	// FlushInterval holds the maximum amount of time to buffer sampled
+	// trace IDs before sending them to peers.
+	//
+	// This adds some delay to how long it takes for other servers to become
+	// aware of locally sampled trace IDs, and so should be at most seconds.
+	FlushInterval time.Duration
+
+	// Logger is used for logging publish and subscribe operations -- particularly
+	// errors that occur asynchronously.
+	//
+	// If Logger is nil, a new logger will be constructed.
+	Logger *logp.Logger
+}
+
+// Validate validates the configuration.
+func (config Config) Validate() error {
+	if len(config.Peers) == 0 {
+		return errors.New("Peers unspecified")
+	}
+	if config.GRPCServer == nil {
+		return errors.New("GRPCServer unspecified")
+	}
+	if config.ServerID == "" {
+		return errors.New("ServerID unspecified")
+	}
+	if config.FlushInterval <= 0 {
+		return errors.New("FlushInterval unspecified or negative")
+	}
+	return nil
+}
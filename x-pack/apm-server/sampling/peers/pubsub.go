@@ -0,0 +1,294 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package peers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/interceptors"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub"
+)
+
+const (
+	serviceName   = "elastic.apm.sampling.v1.SampledTraces"
+	publishMethod = "/" + serviceName + "/Publish"
+
+	// serverIDMetadataKey is the gRPC metadata key used for identifying
+	// the server which published sampled trace IDs.
+	serverIDMetadataKey = "x-elastic-apm-server-id"
+
+	// maxBatchSize is the maximum number of trace IDs sent to a peer
+	// in a single request.
+	maxBatchSize = 1000
+
+	// peerQueueSize is the maximum number of batches queued for each peer.
+	// Batches are dropped when a peer's queue is full, e.g. because the
+	// peer is unavailable.
+	peerQueueSize = 100
+
+	// requestTimeout is the maximum amount of time to wait for a peer to
+	// accept a batch of sampled trace IDs.
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	gRPCRegistry      = monitoring.Default.NewRegistry("apm-server.sampling_peers.grpc")
+	gRPCMonitoringMap = request.MonitoringMapForRegistry(gRPCRegistry, append(request.DefaultResultIDs,
+		request.IDResponseErrorsRateLimit,
+		request.IDResponseErrorsTimeout,
+		request.IDResponseErrorsUnauthorized,
+	))
+
+	serviceDesc = grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*sampledTracesServer)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Publish",
+			Handler:    publishHandler,
+		}},
+	}
+)
+
+func init() {
+	interceptors.RegisterMethodUnaryRequestMetrics(publishMethod, gRPCMonitoringMap)
+}
+
+// Pubsub provides a means of publishing and subscribing to sampled trace IDs,
+// by sending them directly to a static list of peer APM Servers over gRPC.
+//
+// Unlike pubsub.Pubsub, sampled trace IDs are not persisted: a server only
+// receives sampled trace IDs published while it is running.
+type Pubsub struct {
+	config   Config
+	received chan []string
+}
+
+// New returns a new Pubsub which can publish and subscribe sampled trace IDs,
+// sending them directly to peers. New registers a gRPC service with
+// config.GRPCServer for receiving sampled trace IDs from peers.
+func New(config Config) (*Pubsub, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid peers pubsub config")
+	}
+	if config.Logger == nil {
+		config.Logger = logp.NewLogger(logs.Sampling)
+	}
+	p := &Pubsub{config: config, received: make(chan []string)}
+	config.GRPCServer.RegisterService(&serviceDesc, p)
+	return p, nil
+}
+
+// PublishSampledTraceIDs receives trace IDs from the traceIDs channel, sending
+// them to all peers in batches. PublishSampledTraceIDs returns when traceIDs
+// is closed, or when ctx is cancelled.
+//
+// Trace IDs are sent to peers on a best-effort basis: if a peer is unavailable
+// or is not keeping up, trace IDs destined for that peer will be dropped.
+func (p *Pubsub) PublishSampledTraceIDs(ctx context.Context, traceIDs <-chan string) error {
+	dialOptions := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, p.config.DialOptions...)
+
+	var g errgroup.Group
+	queues := make([]chan []string, 0, len(p.config.Peers))
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		g.Wait()
+	}()
+	for _, addr := range p.config.Peers {
+		conn, err := grpc.DialContext(ctx, addr, dialOptions...)
+		if err != nil {
+			return fmt.Errorf("failed to dial peer %q: %w", addr, err)
+		}
+		addr, queue := addr, make(chan []string, peerQueueSize)
+		queues = append(queues, queue)
+		g.Go(func() error {
+			defer conn.Close()
+			for batch := range queue {
+				if err := p.send(ctx, conn, batch); err != nil {
+					p.config.Logger.With(logp.Error(err)).Warnf(
+						"failed to send %d sampled trace IDs to peer %q", len(batch), addr,
+					)
+				}
+			}
+			return nil
+		})
+	}
+
+	ticker := time.NewTicker(p.config.FlushInterval)
+	defer ticker.Stop()
+	var batch []string
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		for i, queue := range queues {
+			select {
+			case queue <- batch:
+			default:
+				p.config.Logger.Warnf(
+					"queue full, dropping %d sampled trace IDs for peer %q",
+					len(batch), p.config.Peers[i],
+				)
+			}
+		}
+		// Batches are shared by peers, so allocate a new one.
+		batch = nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			if err := ctx.Err(); err != context.Canceled {
+				return err
+			}
+			return nil
+		case <-ticker.C:
+			flush()
+		case id, ok := <-traceIDs:
+			if !ok {
+				flush()
+				return nil
+			}
+			batch = append(batch, id)
+			if len(batch) >= maxBatchSize {
+				flush()
+			}
+		}
+	}
+}
+
+func (p *Pubsub) send(ctx context.Context, conn *grpc.ClientConn, traceIDs []string) error {
+	values := make([]*structpb.Value, len(traceIDs))
+	for i, traceID := range traceIDs {
+		values[i] = structpb.NewStringValue(traceID)
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, serverIDMetadataKey, p.config.ServerID)
+	if p.config.Authorization != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, headers.Authorization, p.config.Authorization)
+	}
+	return conn.Invoke(ctx, publishMethod, &structpb.ListValue{Values: values}, &emptypb.Empty{})
+}
+
+// SubscribeSampledTraceIDs sends trace IDs received from peers to the traceIDs
+// channel, until ctx is cancelled.
+//
+// Sampled trace IDs received from peers are not persisted, so pos is ignored,
+// and nothing will be sent to the positions channel.
+func (p *Pubsub) SubscribeSampledTraceIDs(
+	ctx context.Context,
+	pos pubsub.SubscriberPosition,
+	traceIDs chan<- string,
+	positions chan<- pubsub.SubscriberPosition,
+) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case batch := <-p.received:
+			for _, traceID := range batch {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case traceIDs <- traceID:
+				}
+			}
+		}
+	}
+}
+
+// AuthenticateUnaryCall implements the interceptors.UnaryAuthenticator
+// interface, authenticating peers using the Authorization metadata.
+// Anonymous access is not permitted.
+func (p *Pubsub) AuthenticateUnaryCall(
+	ctx context.Context,
+	req interface{},
+	fullMethod string,
+	authenticator *auth.Authenticator,
+) (auth.AuthenticationDetails, auth.Authorizer, error) {
+	details, authz, err := interceptors.AuthorizationMetadataAuthenticator{}.AuthenticateUnaryCall(
+		ctx, req, fullMethod, authenticator,
+	)
+	if err != nil {
+		return auth.AuthenticationDetails{}, nil, err
+	}
+	if details.Method == auth.MethodAnonymous {
+		return auth.AuthenticationDetails{}, nil, fmt.Errorf(
+			"%w: anonymous access not permitted for sampled trace IDs", auth.ErrAuthFailed,
+		)
+	}
+	return details, authz, nil
+}
+
+// sampledTracesServer is the interface implemented by Pubsub for serving
+// the sampled traces gRPC service.
+type sampledTracesServer interface {
+	publish(context.Context, *structpb.ListValue) (*emptypb.Empty, error)
+}
+
+func (p *Pubsub) publish(ctx context.Context, req *structpb.ListValue) (*emptypb.Empty, error) {
+	if err := auth.Authorize(ctx, auth.ActionSampledTracesPublish, auth.Resource{}); err != nil {
+		return nil, err
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(serverIDMetadataKey); len(ids) > 0 && ids[0] == p.config.ServerID {
+			// Ignore our own sampled trace IDs, e.g. if this
+			// server is included in its list of peers.
+			return &emptypb.Empty{}, nil
+		}
+	}
+	traceIDs := make([]string, 0, len(req.Values))
+	for _, value := range req.Values {
+		if traceID := value.GetStringValue(); traceID != "" {
+			traceIDs = append(traceIDs, traceID)
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case p.received <- traceIDs:
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func publishHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(structpb.ListValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(sampledTracesServer).publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: publishMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(sampledTracesServer).publish(ctx, req.(*structpb.ListValue))
+	}
+	return interceptor(ctx, in, info, handler)
+}
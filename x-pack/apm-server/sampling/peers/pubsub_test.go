@@ -0,0 +1,144 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package peers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/interceptors"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub"
+)
+
+const secretToken = "abc123"
+
+func TestPubsubPeers(t *testing.T) {
+	// Start three servers, each configured with all servers as peers,
+	// including itself. Servers should ignore their own trace IDs.
+	listeners := make([]net.Listener, 3)
+	addrs := make([]string, len(listeners))
+	for i := range listeners {
+		lis, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		listeners[i] = lis
+		addrs[i] = lis.Addr().String()
+	}
+	pubsubs := make([]*Pubsub, len(listeners))
+	for i, lis := range listeners {
+		srv := newGRPCServer(t)
+		ps, err := New(Config{
+			Peers:         addrs,
+			GRPCServer:    srv,
+			Authorization: "Bearer " + secretToken,
+			ServerID:      fmt.Sprintf("server_%d", i),
+			FlushInterval: 10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		pubsubs[i] = ps
+		go srv.Serve(lis)
+		t.Cleanup(srv.Stop)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make([]chan string, len(pubsubs))
+	for i, ps := range pubsubs {
+		ch := make(chan string)
+		received[i] = ch
+		go ps.SubscribeSampledTraceIDs(ctx, pubsub.SubscriberPosition{}, ch, nil)
+	}
+
+	published := make(chan string)
+	publishErr := make(chan error, 1)
+	go func() { publishErr <- pubsubs[0].PublishSampledTraceIDs(ctx, published) }()
+	traceIDs := []string{"trace_1", "trace_2", "trace_3"}
+	for _, traceID := range traceIDs {
+		published <- traceID
+	}
+	close(published)
+	assert.NoError(t, <-publishErr)
+
+	for i, ch := range received[1:] {
+		var got []string
+		for range traceIDs {
+			select {
+			case traceID := <-ch:
+				got = append(got, traceID)
+			case <-time.After(10 * time.Second):
+				t.Fatalf("timed out waiting for trace IDs on server %d", i+1)
+			}
+		}
+		sort.Strings(got)
+		assert.Equal(t, traceIDs, got)
+	}
+	select {
+	case traceID := <-received[0]:
+		t.Fatalf("publishing server received its own trace ID %q", traceID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPubsubUnauthenticated(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	srv := newGRPCServer(t)
+	ps, err := New(Config{
+		Peers:         []string{lis.Addr().String()},
+		GRPCServer:    srv,
+		ServerID:      "server",
+		FlushInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = ps.send(context.Background(), conn, []string{"trace_1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestConfigInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		config Config
+		err    string
+	}{{
+		config: Config{},
+		err:    "Peers unspecified",
+	}, {
+		config: Config{Peers: []string{"localhost:8200"}},
+		err:    "GRPCServer unspecified",
+	}, {
+		config: Config{Peers: []string{"localhost:8200"}, GRPCServer: grpc.NewServer()},
+		err:    "ServerID unspecified",
+	}, {
+		config: Config{Peers: []string{"localhost:8200"}, GRPCServer: grpc.NewServer(), ServerID: "server"},
+		err:    "FlushInterval unspecified or negative",
+	}} {
+		_, err := New(tc.config)
+		assert.EqualError(t, err, "invalid peers pubsub config: "+tc.err)
+	}
+}
+
+func newGRPCServer(t testing.TB) *grpc.Server {
+	authenticator, err := auth.NewAuthenticator(config.AgentAuth{SecretToken: secretToken})
+	require.NoError(t, err)
+	return grpc.NewServer(grpc.UnaryInterceptor(interceptors.Auth(authenticator)))
+}
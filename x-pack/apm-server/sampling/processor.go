@@ -39,6 +39,8 @@ const (
 	shutdownGracePeriod = 5 * time.Second
 )
 
+var errStopping = errors.New("tail-sampling processor is stopping")
+
 // Processor is a tail-sampling event processor.
 type Processor struct {
 	config            Config
@@ -49,6 +51,10 @@ type Processor struct {
 	eventStore   *wrappedRW
 	eventMetrics *eventMetrics // heap-allocated for 64-bit alignment
 
+	// keepTraceIDs holds trace IDs which have been
+	// forcibly sampled by calling KeepTrace.
+	keepTraceIDs chan string
+
 	stopMu   sync.Mutex
 	stopping chan struct{}
 	stopped  chan struct{}
@@ -79,6 +85,7 @@ func NewProcessor(config Config) (*Processor, error) {
 		groups:            newTraceGroups(config.Policies, config.MaxDynamicServices, config.IngestRateDecayFactor),
 		eventStore:        newWrappedRW(config.Storage, config.TTL, int64(config.StorageLimit)),
 		eventMetrics:      &eventMetrics{},
+		keepTraceIDs:      make(chan string),
 		stopping:          make(chan struct{}),
 		stopped:           make(chan struct{}),
 		// NOTE(marclop) This behavior should be configurable so users who
@@ -240,7 +247,9 @@ func (p *Processor) processTransaction(event *model.APMEvent) (report, stored bo
 	// TODO(axw) we should skip reservoir sampling when the matching
 	// policy's sampling rate is 100%, immediately index the event
 	// and record the trace sampling decision.
-	reservoirSampled, err := p.groups.sampleTrace(event)
+	reservoirSampled, err := p.groups.sampleTrace(event, func(out *model.Batch) error {
+		return p.eventStore.ReadTraceEvents(event.Trace.ID, out)
+	})
 	if err == errTooManyTraceGroups {
 		// Too many trace groups, drop the transaction.
 		p.rateLimitedLogger.Warn(`
@@ -285,6 +294,71 @@ func (p *Processor) processSpan(event *model.APMEvent) (report, stored bool, _ e
 	return traceSampled, false, nil
 }
 
+// TraceStatus holds the tail-sampling status of a trace in local storage.
+type TraceStatus struct {
+	// Decided reports whether a sampling decision has been recorded
+	// for the trace.
+	Decided bool
+
+	// Sampled reports whether the trace has been sampled. Sampled is
+	// only meaningful if Decided is true.
+	Sampled bool
+
+	// BufferedEvents holds the number of the trace's events in local storage.
+	BufferedEvents int
+}
+
+// TraceStatus returns the tail-sampling status of the trace with the given ID,
+// as recorded in local storage.
+func (p *Processor) TraceStatus(traceID string) (TraceStatus, error) {
+	var status TraceStatus
+	sampled, err := p.eventStore.IsTraceSampled(traceID)
+	switch err {
+	case nil:
+		status.Decided = true
+		status.Sampled = sampled
+	case eventstorage.ErrNotFound:
+	default:
+		return TraceStatus{}, err
+	}
+	var events model.Batch
+	if err := p.eventStore.ReadTraceEvents(traceID, &events); err != nil {
+		return TraceStatus{}, err
+	}
+	status.BufferedEvents = len(events)
+	return status, nil
+}
+
+// KeepTrace forces the trace with the given ID to be sampled, regardless
+// of any sampling decision already made for it. The decision is treated
+// like a local sampling decision: it is published to other servers, and
+// the trace's events are read from local storage and reported.
+//
+// KeepTrace returns once the decision has been handed over for publication,
+// which requires Run to be running. If the trace is already known to be
+// sampled, KeepTrace returns immediately.
+func (p *Processor) KeepTrace(ctx context.Context, traceID string) error {
+	if sampled, err := p.eventStore.IsTraceSampled(traceID); err == nil && sampled {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.stopping:
+		return errStopping
+	case <-p.stopped:
+		return errStopping
+	case p.keepTraceIDs <- traceID:
+		return nil
+	}
+}
+
+// GroupStats returns statistics for each of the processor's trace groups,
+// ordered by policy and then by service name.
+func (p *Processor) GroupStats() []GroupStats {
+	return p.groups.stats()
+}
+
 // Stop stops the processor, flushing event storage. Note that the underlying
 // badger.DB must be closed independently to ensure writes are synced to disk.
 func (p *Processor) Stop(ctx context.Context) error {
@@ -342,20 +416,24 @@ func (p *Processor) Run() error {
 		return err
 	}
 	subscriberPositions := make(chan pubsub.SubscriberPosition)
-	pubsub, err := pubsub.New(pubsub.Config{
-		ServerID:   p.config.UUID,
-		Client:     p.config.Elasticsearch,
-		DataStream: pubsub.DataStreamConfig(p.config.SampledTracesDataStream),
-		Logger:     p.logger,
-
-		// Issue pubsub subscriber search requests at twice the frequency
-		// of publishing, so each server observes each other's sampled
-		// trace IDs soon after they are published.
-		SearchInterval: p.config.FlushInterval / 2,
-		FlushInterval:  bulkIndexerFlushInterval,
-	})
-	if err != nil {
-		return err
+	ps := p.config.Pubsub
+	if ps == nil {
+		esPubsub, err := pubsub.New(pubsub.Config{
+			ServerID:   p.config.UUID,
+			Client:     p.config.Elasticsearch,
+			DataStream: pubsub.DataStreamConfig(p.config.SampledTracesDataStream),
+			Logger:     p.logger,
+
+			// Issue pubsub subscriber search requests at twice the frequency
+			// of publishing, so each server observes each other's sampled
+			// trace IDs soon after they are published.
+			SearchInterval: p.config.FlushInterval / 2,
+			FlushInterval:  bulkIndexerFlushInterval,
+		})
+		if err != nil {
+			return err
+		}
+		ps = esPubsub
 	}
 
 	remoteSampledTraceIDs := make(chan string)
@@ -423,14 +501,14 @@ func (p *Processor) Run() error {
 			}
 
 		}()
-		return pubsub.SubscribeSampledTraceIDs(
+		return ps.SubscribeSampledTraceIDs(
 			ctx, initialSubscriberPosition, remoteSampledTraceIDs, subscriberPositions,
 		)
 	})
 	g.Go(func() error {
-		// Publish locally sampled trace IDs to Elasticsearch. This is cancelled when
+		// Publish locally sampled trace IDs to other servers. This is cancelled when
 		// publishSampledTraceIDs is closed, after the final reservoir flush.
-		return pubsub.PublishSampledTraceIDs(gracefulContext, publishSampledTraceIDs)
+		return ps.PublishSampledTraceIDs(gracefulContext, publishSampledTraceIDs)
 	})
 	g.Go(func() error {
 		ticker := time.NewTicker(p.config.FlushInterval)
@@ -444,16 +522,19 @@ func (p *Processor) Run() error {
 		defer close(publishSampledTraceIDs)
 		defer close(localSampledTraceIDs)
 
+		sendDecisions := func(traceIDs []string) error {
+			var g errgroup.Group
+			g.Go(func() error { return sendTraceIDs(gracefulContext, publishSampledTraceIDs, traceIDs) })
+			g.Go(func() error { return sendTraceIDs(gracefulContext, localSampledTraceIDs, traceIDs) })
+			return g.Wait()
+		}
 		publishDecisions := func() error {
 			p.logger.Debug("finalizing local sampling reservoirs")
 			traceIDs = p.groups.finalizeSampledTraces(traceIDs)
 			if len(traceIDs) == 0 {
 				return nil
 			}
-			var g errgroup.Group
-			g.Go(func() error { return sendTraceIDs(gracefulContext, publishSampledTraceIDs, traceIDs) })
-			g.Go(func() error { return sendTraceIDs(gracefulContext, localSampledTraceIDs, traceIDs) })
-			if err := g.Wait(); err != nil {
+			if err := sendDecisions(traceIDs); err != nil {
 				return err
 			}
 			traceIDs = traceIDs[:0]
@@ -468,6 +549,13 @@ func (p *Processor) Run() error {
 				if err := publishDecisions(); err != nil {
 					return err
 				}
+			case traceID := <-p.keepTraceIDs:
+				// Forced decisions bypass the reservoirs,
+				// and are sent without waiting for a flush.
+				p.logger.Debug("forcing trace to be sampled")
+				if err := sendDecisions([]string{traceID}); err != nil {
+					return err
+				}
 			}
 		}
 	})
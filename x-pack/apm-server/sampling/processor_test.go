@@ -25,6 +25,7 @@ import (
 	"github.com/elastic/apm-data/model"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub/pubsubtest"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 )
@@ -396,6 +397,74 @@ func TestProcessLocalTailSamplingPolicyOrder(t *testing.T) {
 	}
 }
 
+func TestProcessLocalTailSamplingAnySpanFailure(t *testing.T) {
+	config := newTempdirConfig(t)
+	config.Policies = []sampling.Policy{{
+		PolicyCriteria: sampling.PolicyCriteria{TraceAnySpanFailure: true},
+		SampleRate:     1,
+	}, {
+		SampleRate: 0,
+	}}
+	config.FlushInterval = 10 * time.Millisecond
+	published := make(chan string)
+	config.Elasticsearch = pubsubtest.Client(pubsubtest.PublisherChan(published), nil)
+
+	processor, err := sampling.NewProcessor(config)
+	require.NoError(t, err)
+
+	trace1 := model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"}
+	trace2 := model.Trace{ID: "0102030405060708090a0b0c0d0e0f11"}
+	newSpan := func(trace model.Trace, id, outcome string) model.APMEvent {
+		return model.APMEvent{
+			Processor: model.SpanProcessor,
+			Trace:     trace,
+			Event:     model.Event{Duration: 123 * time.Millisecond, Outcome: outcome},
+			Span:      &model.Span{ID: id},
+		}
+	}
+	newTransaction := func(trace model.Trace, id string) model.APMEvent {
+		return model.APMEvent{
+			Processor: model.TransactionProcessor,
+			Trace:     trace,
+			Event:     model.Event{Duration: 123 * time.Millisecond, Outcome: "success"},
+			Transaction: &model.Transaction{
+				ID:      id,
+				Sampled: true,
+			},
+		}
+	}
+
+	// Spans are received before their root transactions, as is
+	// typically the case since child spans end before their parents.
+	events := model.Batch{
+		newSpan(trace1, "0102030405060709", "failure"),
+		newSpan(trace2, "0102030405060711", "success"),
+	}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &events))
+	events = model.Batch{
+		newTransaction(trace1, "0102030405060708"),
+		newTransaction(trace2, "0102030405060710"),
+	}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &events))
+	assert.Empty(t, events)
+
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	// Only the trace with a failed span should be sampled.
+	select {
+	case traceID := <-published:
+		assert.Equal(t, trace1.ID, traceID)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for publication")
+	}
+	select {
+	case traceID := <-published:
+		t.Fatalf("unexpected publication of %s", traceID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
 func TestProcessRemoteTailSampling(t *testing.T) {
 	config := newTempdirConfig(t)
 	config.Policies = []sampling.Policy{{SampleRate: 0.5}}
@@ -499,6 +568,207 @@ func TestProcessRemoteTailSampling(t *testing.T) {
 	assert.Empty(t, batch)
 }
 
+func TestProcessRemoteTailSamplingPubsub(t *testing.T) {
+	config := newTempdirConfig(t)
+	config.Policies = []sampling.Policy{{SampleRate: 1}}
+	config.FlushInterval = 10 * time.Millisecond
+	config.Elasticsearch = nil
+	config.SampledTracesDataStream = sampling.DataStreamConfig{}
+
+	ps := &chanPubsub{
+		published:  make(chan string),
+		subscribed: make(chan string),
+	}
+	config.Pubsub = ps
+
+	reported := make(chan model.Batch)
+	config.BatchProcessor = model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case reported <- *batch:
+			return nil
+		}
+	})
+
+	processor, err := sampling.NewProcessor(config)
+	require.NoError(t, err)
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	localTransaction := model.APMEvent{
+		Processor: model.TransactionProcessor,
+		Trace:     model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
+		Event:     model.Event{Duration: 123 * time.Millisecond},
+		Transaction: &model.Transaction{
+			ID:      "0102030405060708",
+			Sampled: true,
+		},
+	}
+	remoteTransaction := model.APMEvent{
+		Processor: model.TransactionProcessor,
+		Trace:     model.Trace{ID: "0102030405060708090a0b0c0d0e0f11"},
+		Parent:    model.Parent{ID: "0102030405060708"},
+		Event:     model.Event{Duration: 123 * time.Millisecond},
+		Transaction: &model.Transaction{
+			ID:      "0102030405060709",
+			Sampled: true,
+		},
+	}
+	batch := model.Batch{localTransaction, remoteTransaction}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+	assert.Empty(t, batch)
+
+	// Local sampling decisions should be published with the configured Pubsub.
+	select {
+	case traceID := <-ps.published:
+		assert.Equal(t, localTransaction.Trace.ID, traceID)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for publication")
+	}
+
+	// Remote sampling decisions should be received from the configured Pubsub.
+	ps.subscribed <- remoteTransaction.Trace.ID
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case batch := <-reported:
+			if len(batch) == 1 && batch[0].Trace.ID == remoteTransaction.Trace.ID {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for remotely sampled events to be reported")
+		}
+	}
+}
+
+// chanPubsub is a sampling.Pubsub which publishes to and subscribes
+// from channels.
+type chanPubsub struct {
+	published  chan string
+	subscribed chan string
+}
+
+func (p *chanPubsub) PublishSampledTraceIDs(ctx context.Context, traceIDs <-chan string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case traceID, ok := <-traceIDs:
+			if !ok {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case p.published <- traceID:
+			}
+		}
+	}
+}
+
+func (p *chanPubsub) SubscribeSampledTraceIDs(
+	ctx context.Context,
+	pos pubsub.SubscriberPosition,
+	traceIDs chan<- string,
+	positions chan<- pubsub.SubscriberPosition,
+) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case traceID := <-p.subscribed:
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case traceIDs <- traceID:
+			}
+		}
+	}
+}
+
+func TestProcessKeepTrace(t *testing.T) {
+	config := newTempdirConfig(t)
+	config.Policies = []sampling.Policy{{SampleRate: 0}}
+	config.FlushInterval = time.Minute
+	published := make(chan string)
+	config.Elasticsearch = pubsubtest.Client(pubsubtest.PublisherChan(published), nil)
+
+	reported := make(chan model.Batch)
+	config.BatchProcessor = model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case reported <- *batch:
+			return nil
+		}
+	})
+
+	processor, err := sampling.NewProcessor(config)
+	require.NoError(t, err)
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	trace := model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"}
+	batch := model.Batch{{
+		Processor: model.SpanProcessor,
+		Trace:     trace,
+		Event:     model.Event{Duration: 123 * time.Millisecond},
+		Span:      &model.Span{ID: "0102030405060709"},
+	}}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+	assert.Empty(t, batch)
+
+	status, err := processor.TraceStatus(trace.ID)
+	require.NoError(t, err)
+	assert.Equal(t, sampling.TraceStatus{BufferedEvents: 1}, status)
+
+	status, err = processor.TraceStatus("0102030405060708090a0b0c0d0e0f11")
+	require.NoError(t, err)
+	assert.Equal(t, sampling.TraceStatus{}, status)
+
+	// Forcing the trace to be sampled should publish the decision
+	// and report the buffered events, without waiting for a flush.
+	require.NoError(t, processor.KeepTrace(context.Background(), trace.ID))
+	select {
+	case traceID := <-published:
+		assert.Equal(t, trace.ID, traceID)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for publication")
+	}
+	select {
+	case batch := <-reported:
+		require.Len(t, batch, 1)
+		assert.Equal(t, trace.ID, batch[0].Trace.ID)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for events to be reported")
+	}
+
+	assert.Eventually(t, func() bool {
+		status, err := processor.TraceStatus(trace.ID)
+		return err == nil && status.Decided && status.Sampled
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// Keeping an already sampled trace is a no-op.
+	require.NoError(t, processor.KeepTrace(context.Background(), trace.ID))
+	select {
+	case traceID := <-published:
+		t.Fatalf("unexpected publication of %s", traceID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProcessKeepTraceStopped(t *testing.T) {
+	config := newTempdirConfig(t)
+	processor, err := sampling.NewProcessor(config)
+	require.NoError(t, err)
+	go processor.Run()
+	require.NoError(t, processor.Stop(context.Background()))
+
+	err = processor.KeepTrace(context.Background(), "0102030405060708090a0b0c0d0e0f10")
+	assert.EqualError(t, err, "tail-sampling processor is stopping")
+}
+
 func TestGroupsMonitoring(t *testing.T) {
 	config := newTempdirConfig(t)
 	config.MaxDynamicServices = 5